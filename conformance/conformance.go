@@ -0,0 +1,219 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package conformance provides the cases every backend which filters
+// TestFiltering messages with AIP-160 (https://google.aip.dev/160) filters
+// must agree on.
+package conformance
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+// Backend is an implementation of filtering under test.
+type Backend interface {
+	// Load replaces the stored documents with the provided documents.
+	Load(ctx context.Context, docs map[string]*test.TestFiltering) error
+	// List returns every stored document matching the filter, in any order.
+	List(ctx context.Context, filter string) ([]*test.TestFiltering, error)
+}
+
+// Case is a filter, and the documents it matches.
+type Case struct {
+	Name   string
+	Filter string
+	// The IDs of the Documents matching the filter.
+	Want []string
+	// The code of the error returned for the filter, or OK.
+	Code codes.Code
+}
+
+// Documents returns the documents every case is run against, by ID.
+func Documents() map[string]*test.TestFiltering {
+	return map[string]*test.TestFiltering{
+		"a": {
+			FilterablePrimitive:  "a",
+			DefaultFloat:         1,
+			DefaultBool:          true,
+			DefaultEnum:          test.TestFiltering_VALUE_1,
+			FilterableSubmessage: &test.TestFiltering_SubMessage{FilterablePrimitive: 1},
+		},
+		"b": {
+			FilterablePrimitive:  "b",
+			DefaultFloat:         2.5,
+			FilterableSubmessage: &test.TestFiltering_SubMessage{FilterablePrimitive: 2},
+		},
+		"c": {
+			FilterablePrimitive: "c",
+			DefaultFloat:        -1,
+		},
+	}
+}
+
+// Cases returns the cases covering every supported operator.
+func Cases() []Case {
+	return []Case{
+		{Name: "empty", Filter: "", Want: []string{"a", "b", "c"}},
+		{Name: "equals", Filter: `test_filtering.filterable_primitive = "a"`, Want: []string{"a"}},
+		{Name: "equals none", Filter: `test_filtering.filterable_primitive = "z"`},
+		{Name: "not equals", Filter: `test_filtering.filterable_primitive != "a"`, Want: []string{"b", "c"}},
+		{Name: "less than", Filter: `test_filtering.default_float < 0.0`, Want: []string{"c"}},
+		{Name: "less equals", Filter: `test_filtering.filterable_primitive <= "b"`, Want: []string{"a", "b"}},
+		{Name: "greater than", Filter: `test_filtering.filterable_primitive > "a"`, Want: []string{"b", "c"}},
+		{Name: "greater equals", Filter: `test_filtering.default_float >= 1.0`, Want: []string{"a", "b"}},
+		{Name: "nested field", Filter: `test_filtering.filterable_submessage.filterable_primitive = 2`, Want: []string{"b"}},
+		{Name: "and", Filter: `test_filtering.filterable_primitive = "b" AND test_filtering.default_float > 0.0`, Want: []string{"b"}},
+		{Name: "contradictory and", Filter: `test_filtering.filterable_primitive = "a" AND test_filtering.filterable_primitive = "b"`},
+		{Name: "or", Filter: `test_filtering.filterable_primitive = "a" OR test_filtering.filterable_primitive = "c"`, Want: []string{"a", "c"}},
+		{Name: "not", Filter: `NOT test_filtering.filterable_primitive = "a"`, Want: []string{"b", "c"}},
+		{Name: "not or", Filter: `NOT (test_filtering.filterable_primitive = "a" OR test_filtering.filterable_primitive = "b")`, Want: []string{"c"}},
+		{Name: "has", Filter: `test_filtering:filterable_submessage`, Want: []string{"a", "b"}},
+		{Name: "enum", Filter: `test_filtering.default_enum = VALUE_1`, Want: []string{"a"}},
+		{Name: "enum name", Filter: `test_filtering.default_enum = "VALUE_0"`, Want: []string{"b", "c"}},
+		{Name: "bool", Filter: `test_filtering.default_bool`, Want: []string{"a"}},
+		{Name: "not bool", Filter: `NOT test_filtering.default_bool`, Want: []string{"b", "c"}},
+		{Name: "null", Filter: `test_filtering.filterable_submessage = null`, Want: []string{"c"}},
+		{Name: "not null", Filter: `test_filtering.filterable_submessage != null`, Want: []string{"a", "b"}},
+		{Name: "starts with", Filter: `starts_with(test_filtering.filterable_primitive, "b")`, Want: []string{"b"}},
+		{Name: "unfilterable field", Filter: `test_filtering.unfilterable_primitive = 1`, Code: codes.InvalidArgument},
+		{Name: "unknown field", Filter: `test_filtering.missing = 1`, Code: codes.InvalidArgument},
+		{Name: "mismatched type", Filter: `test_filtering.filterable_primitive = 1`, Code: codes.InvalidArgument},
+	}
+}
+
+// ExtendedBackend is an implementation of filtering under test over messages
+// with the fields of ExtendedDocuments, which TestFiltering does not have.
+type ExtendedBackend interface {
+	// Load replaces the stored documents with the provided documents.
+	Load(ctx context.Context, docs map[string]proto.Message) error
+	// List returns every stored document matching the filter, in any order.
+	List(ctx context.Context, filter string) ([]proto.Message, error)
+}
+
+// The documents of the ExtendedCases, in the JSON form of protobuf.
+var extendedDocuments = map[string]string{
+	"a": `{"filterablePrimitive": "a", "tags": ["x", "y"], "labels": {"env": "prod"}, "createTime": "2022-01-01T00:00:00Z"}`,
+	"b": `{"filterablePrimitive": "b", "tags": ["y"], "labels": {"env": "dev"}, "createTime": "2022-06-01T00:00:00Z"}`,
+	"c": `{"filterablePrimitive": "c"}`,
+}
+
+// ExtendedDocuments returns the documents every extended case is run against,
+// by ID, as messages of the descriptor. In addition to the fields of
+// TestFiltering, the descriptor must have the fields:
+//
+//	repeated string tags = ...;
+//	map<string, string> labels = ...;
+//	google.protobuf.Timestamp create_time = ...;
+func ExtendedDocuments(desc protoreflect.MessageDescriptor) (map[string]proto.Message, error) {
+	docs := map[string]proto.Message{}
+	for id, doc := range extendedDocuments {
+		msg := dynamicpb.NewMessage(desc)
+		if err := protojson.Unmarshal([]byte(doc), msg); err != nil {
+			return nil, err
+		}
+		docs[id] = msg
+	}
+	return docs, nil
+}
+
+// ExtendedCases returns the cases covering repeated, map and timestamp
+// fields, which are run against the ExtendedDocuments.
+func ExtendedCases() []Case {
+	return []Case{
+		{Name: "repeated has", Filter: `test_filtering.tags:"y"`, Want: []string{"a", "b"}},
+		{Name: "repeated any", Filter: `test_filtering.tags:any(["x", "z"])`, Want: []string{"a"}},
+		{Name: "map value", Filter: `test_filtering.labels.env = "prod"`, Want: []string{"a"}},
+		{Name: "map has key", Filter: `test_filtering.labels:"env"`, Want: []string{"a", "b"}},
+		{Name: "timestamp string", Filter: `test_filtering.create_time > "2022-03-01T00:00:00Z"`, Want: []string{"b"}},
+		{Name: "timestamp", Filter: `test_filtering.create_time < timestamp("2022-03-01T00:00:00Z")`, Want: []string{"a"}},
+		{Name: "timestamp null", Filter: `test_filtering.create_time = null`, Want: []string{"c"}},
+	}
+}
+
+// Run loads the Documents into the backend, and runs every case against it.
+func Run(t *testing.T, b Backend) {
+	t.Helper()
+	docs := Documents()
+	if err := b.Load(context.Background(), docs); err != nil {
+		t.Fatalf("Load() err = %v, want <nil>", err)
+	}
+	run(t, Cases(), docs, b.List)
+}
+
+// RunExtended loads the ExtendedDocuments, as messages of the descriptor, into
+// the backend, and runs every extended case against it.
+func RunExtended(t *testing.T, b ExtendedBackend, desc protoreflect.MessageDescriptor) {
+	t.Helper()
+	docs, err := ExtendedDocuments(desc)
+	if err != nil {
+		t.Fatalf("ExtendedDocuments() err = %v, want <nil>", err)
+	}
+	if err := b.Load(context.Background(), docs); err != nil {
+		t.Fatalf("Load() err = %v, want <nil>", err)
+	}
+	run(t, ExtendedCases(), docs, b.List)
+}
+
+// Runs every case against the documents listed by list.
+func run[M proto.Message](t *testing.T, cases []Case, docs map[string]M, list func(context.Context, string) ([]M, error)) {
+	t.Helper()
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			got, err := list(context.Background(), tc.Filter)
+			if code := status.Code(err); code != tc.Code {
+				t.Fatalf("List(%q) err = %v, want code %v", tc.Filter, err, tc.Code)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.Want, ids(t, docs, got)); diff != "" {
+				t.Errorf("List(%q) diff (-want +got):\n%s", tc.Filter, diff)
+			}
+		})
+	}
+}
+
+// Returns the sorted IDs of the documents, which must be in docs.
+func ids[M proto.Message](t *testing.T, docs map[string]M, got []M) []string {
+	t.Helper()
+	var ids []string
+	for _, msg := range got {
+		id := ""
+		for k, doc := range docs {
+			if proto.Equal(doc, msg) {
+				id = k
+			}
+		}
+		if id == "" {
+			t.Errorf("List() returned unknown document %v", msg)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
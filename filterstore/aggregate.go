@@ -0,0 +1,165 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"go.einride.tech/aip/ordering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Aggregation is a sum or average of a numeric field over the documents
+// matching a filter.
+type Aggregation struct {
+	// The key of the aggregation in the AggregationResult.
+	Alias string
+	// The dot separated path of the field, such as `submessage.value`.
+	Field string
+	avg   bool
+}
+
+// Sum aggregates the sum of the field, aliased as `sum_<field>`.
+func Sum(field string) Aggregation {
+	return Aggregation{Alias: "sum_" + field, Field: field}
+}
+
+// Average aggregates the mean of the field, aliased as `avg_<field>`.
+func Average(field string) Aggregation {
+	return Aggregation{Alias: "avg_" + field, Field: field, avg: true}
+}
+
+// As returns the aggregation with the provided alias.
+func (a Aggregation) As(alias string) Aggregation {
+	a.Alias = alias
+	return a
+}
+
+// AggregationResult maps the alias of each aggregation to its value.
+// As in Firestore, a sum is an int64 if every summed value is an integer and
+// the sum does not overflow, otherwise a float64. An average is a float64, or
+// nil if no document has a numeric value for the field.
+type AggregationResult map[string]interface{}
+
+// Aggregate computes the aggregations over the documents in the collection of
+// the parent of the request which match its filter. The order_by, page size
+// and page token of the request are ignored. Values which are not numeric are
+// ignored, as they are by Firestore.
+//
+// The aggregations are emulated, as described in Emulated Queries in the
+// package documentation, by a query which only reads the aggregated fields.
+func (t *Transpiler[T]) Aggregate(ctx context.Context, req protoexpr.ListRequest, aggs []Aggregation, opts ...CallOption) (AggregationResult, error) {
+	msg := t.emptyMessage.ProtoReflect().Descriptor()
+	accs := make([]*accumulator, len(aggs))
+	paths := make([]firestore.FieldPath, len(aggs))
+	for i, a := range aggs {
+		path, fd, err := namedPath(msg, t.names, "aggregation", a.Field)
+		if err != nil {
+			return nil, err
+		}
+		if !numeric(fd) {
+			return nil, status.Errorf(codes.InvalidArgument, "aggregation field %q is not numeric", a.Field)
+		}
+		accs[i] = &accumulator{Aggregation: a, path: path}
+		paths[i] = path
+	}
+	o := t.callOptions(opts)
+	o.orderBy = &ordering.OrderBy{}
+	r, err := t.listRequest(ctx, firstPageRequest{req}, o)
+	if err != nil {
+		return nil, err
+	}
+	var n int64
+	err = eachMatching(ctx, t.reader(o).Collection(fmt.Sprintf("%s/%s", r.parent, t.collection)).SelectPaths(paths...), r, func(doc *firestore.DocumentSnapshot) error {
+		n++
+		for _, acc := range accs {
+			if v, err := doc.DataAtPath(acc.path); err == nil {
+				acc.add(v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := t.resultPolicy(ctx).checkAggregation(n); err != nil {
+		return nil, err
+	}
+	res := AggregationResult{}
+	for _, acc := range accs {
+		res[acc.Alias] = acc.result()
+	}
+	return res, nil
+}
+
+// Returns whether the field is stored as a Firestore number.
+func numeric(fd protoreflect.FieldDescriptor) bool {
+	switch fd.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.FloatKind, protoreflect.DoubleKind:
+		return true
+	}
+	return false
+}
+
+// Accumulates the values of a single aggregation.
+type accumulator struct {
+	Aggregation
+	path    firestore.FieldPath
+	n       int64
+	ints    int64
+	floats  float64
+	isFloat bool
+}
+
+func (a *accumulator) add(v interface{}) {
+	switch v := v.(type) {
+	case int64:
+		if !a.isFloat {
+			if sum := a.ints + v; (v > 0 && sum < a.ints) || (v < 0 && sum > a.ints) {
+				a.isFloat = true
+			} else {
+				a.ints = sum
+			}
+		}
+		a.floats += float64(v)
+	case float64:
+		a.isFloat = true
+		a.floats += v
+	default:
+		return
+	}
+	a.n++
+}
+
+func (a *accumulator) result() interface{} {
+	if a.avg {
+		if a.n == 0 {
+			return nil
+		}
+		return a.floats / float64(a.n)
+	}
+	if a.isFloat {
+		return a.floats
+	}
+	return a.ints
+}
@@ -0,0 +1,157 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestAccumulator(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		agg    Aggregation
+		values []interface{}
+		want   interface{}
+	}{
+		{name: "empty sum", agg: Sum("f"), want: int64(0)},
+		{name: "empty average", agg: Average("f"), want: nil},
+		{name: "integer sum", agg: Sum("f"), values: []interface{}{int64(1), int64(2), "a", nil}, want: int64(3)},
+		{name: "float sum", agg: Sum("f"), values: []interface{}{int64(1), 1.5}, want: 2.5},
+		{name: "overflow", agg: Sum("f"), values: []interface{}{int64(math.MaxInt64), int64(1)}, want: float64(math.MaxInt64) + 1},
+		{name: "average", agg: Average("f"), values: []interface{}{int64(1), 2.0, true}, want: 1.5},
+	} {
+		acc := &accumulator{Aggregation: tc.agg}
+		for _, v := range tc.values {
+			acc.add(v)
+		}
+		if diff := cmp.Diff(tc.want, acc.result()); diff != "" {
+			t.Errorf("%s: result() diff (-want +got):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestAggregationAlias(t *testing.T) {
+	if got := Sum("default_float").Alias; got != "sum_default_float" {
+		t.Errorf("Sum().Alias = %q, want %q", got, "sum_default_float")
+	}
+	if got := Average("default_float").As("mean").Alias; got != "mean" {
+		t.Errorf("Average().As().Alias = %q, want %q", got, "mean")
+	}
+}
+
+func TestAggregateInvalid(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	for _, agg := range []Aggregation{
+		Sum("missing"),
+		Sum("filterable_primitive"),
+		Average("default_bool"),
+		Sum("filterable_submessage.missing"),
+	} {
+		if _, err := transpiler.Aggregate(context.Background(), &test.ListTestRequest{}, []Aggregation{agg}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Aggregate(%q) err = %v, want code %v", agg.Field, err, codes.InvalidArgument)
+		}
+	}
+	if _, err := transpiler.Aggregate(context.Background(), &test.ListTestRequest{Filter: "test_filtering.missing = 1"}, []Aggregation{Sum("default_float")}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Aggregate(invalid filter) err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	_, client := newFakeFirestore(t)
+	transpiler := newTestTranspiler(t, client)
+	ctx := context.Background()
+	for _, msg := range []*test.TestFiltering{
+		{FilterablePrimitive: "a", UnfilterablePrimitive: 1, DefaultFloat: 0.5},
+		{FilterablePrimitive: "b", UnfilterablePrimitive: 2, DefaultFloat: 1.5},
+	} {
+		if err := transpiler.Set(ctx, "parents/p", msg.FilterablePrimitive, msg); err != nil {
+			t.Fatalf("Set(%s) err = %v, want <nil>", msg.FilterablePrimitive, err)
+		}
+	}
+	// Values which are not numeric are ignored.
+	if _, err := client.Doc("parents/p/tests/c").Set(ctx, map[string]interface{}{"FilterablePrimitive": "c", "UnfilterablePrimitive": "x", "DefaultFloat": true}); err != nil {
+		t.Fatalf("Set(c) err = %v, want <nil>", err)
+	}
+	if n, err := transpiler.Count(ctx, &test.ListTestRequest{Parent: "parents/p", Filter: `test_filtering.filterable_primitive = "c"`}); n != 1 || err != nil {
+		t.Fatalf("Count(c) = %d, %v, want 1, <nil>", n, err)
+	}
+	aggs := []Aggregation{Sum("unfilterable_primitive"), Sum("default_float"), Average("unfilterable_primitive"), Average("default_float")}
+	for _, tc := range []struct {
+		name   string
+		filter string
+		want   AggregationResult
+	}{
+		{
+			name: "all",
+			want: AggregationResult{"sum_unfilterable_primitive": int64(3), "sum_default_float": 2.0, "avg_unfilterable_primitive": 1.5, "avg_default_float": 1.0},
+		},
+		{
+			name:   "filtered",
+			filter: `test_filtering.filterable_primitive = "b"`,
+			want:   AggregationResult{"sum_unfilterable_primitive": int64(2), "sum_default_float": 1.5, "avg_unfilterable_primitive": 2.0, "avg_default_float": 1.5},
+		},
+		{
+			name:   "not numeric",
+			filter: `test_filtering.filterable_primitive = "c"`,
+			want:   AggregationResult{"sum_unfilterable_primitive": int64(0), "sum_default_float": int64(0), "avg_unfilterable_primitive": nil, "avg_default_float": nil},
+		},
+		{
+			name:   "empty",
+			filter: `test_filtering.filterable_primitive = "x"`,
+			want:   AggregationResult{"sum_unfilterable_primitive": int64(0), "sum_default_float": int64(0), "avg_unfilterable_primitive": nil, "avg_default_float": nil},
+		},
+	} {
+		got, err := transpiler.Aggregate(ctx, &test.ListTestRequest{Parent: "parents/p", Filter: tc.filter}, aggs)
+		if err != nil {
+			t.Fatalf("%s: Aggregate() err = %v, want <nil>", tc.name, err)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: Aggregate() diff (-want +got):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestAggregateCallOptions(t *testing.T) {
+	_, replica := newFakeFirestore(t)
+	transpiler, _, _ := fakeTranspiler(t, 0, WithReadReplica(replica))
+	ctx := context.Background()
+	if err := transpiler.Set(ctx, "parents/p", "a", &test.TestFiltering{UnfilterablePrimitive: 1}); err != nil {
+		t.Fatalf("Set(a) err = %v, want <nil>", err)
+	}
+	for _, tc := range []struct {
+		name string
+		opts []CallOption
+		want int64
+	}{
+		{name: "replica", want: 0},
+		{name: "primary", opts: []CallOption{ReadPrimary()}, want: 1},
+	} {
+		got, err := transpiler.Aggregate(ctx, &test.ListTestRequest{Parent: "parents/p"}, []Aggregation{Sum("unfilterable_primitive")}, tc.opts...)
+		if err != nil {
+			t.Fatalf("%s: Aggregate() err = %v, want <nil>", tc.name, err)
+		}
+		if got := got["sum_unfilterable_primitive"]; got != tc.want {
+			t.Errorf("%s: Aggregate() sum = %v, want %d", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// The function which filters a repeated message field to documents with an
+// element matching a filter of its fields, such as `any((items.sku = "x"))`.
+// Compared to a field, it instead lists the values the field may have, such as
+// `state = any(["ACTIVE", "PENDING"])`.
+const functionAny = "any"
+
+// The function which filters a repeated message field to documents with every
+// element matching a filter of its fields, such as `all((items.quantity > 0))`,
+// including documents without elements.
+// Firestore cannot check every element of an array, so all() is always
+// evaluated against each document once it is retrieved. Every document
+// matching the rest of the filter is read, including those all() excludes,
+// so it should be joined to filters which Firestore can evaluate.
+const functionAll = "all"
+
+// The methods which filter a repeated message field like the functions they
+// name, naming the element with a variable, such as
+// `items.exists(i, (i.sku = "x"))` or `items.all(i, (i.quantity > 0))`.
+var elementMethods = map[string]string{
+	"exists":    functionAny,
+	functionAll: functionAll,
+}
+
+// Returns the declarations of any() and all().
+func declareAny() []filtering.DeclarationOption {
+	var decls []filtering.DeclarationOption
+	for _, fn := range []string{functionAny, functionAll} {
+		decls = append(decls, filtering.DeclareFunction(fn, filtering.NewFunctionOverload(
+			fn+"_bool", filtering.TypeBool, filtering.TypeBool,
+		)))
+	}
+	return decls
+}
+
+// WithDenormalizedValues stores the values of the fields of the messages in
+// repeated message fields at the provided paths, such as "items.sku", in an
+// array under the provided names, such as "item_skus", so that any() of the
+// field, such as `any((items.sku = "x"))`, is transpiled to array-contains.
+// The arrays must be maintained by writing documents with Set.
+// Any other any() is evaluated against each document once it is retrieved,
+// so can only be used to list documents.
+func WithDenormalizedValues(fields map[string]string) Option {
+	return func(o *options) {
+		if o.denormalized == nil {
+			o.denormalized = map[string]string{}
+		}
+		for path, name := range fields {
+			o.denormalized[path] = name
+		}
+	}
+}
+
+// Checks that each path of WithDenormalizedValues is a singular scalar field of
+// the messages of a repeated message field of the message.
+func denormalizedFields(msg protoreflect.MessageDescriptor, fields map[string]string) error {
+	for path, name := range fields {
+		field, rest, _ := strings.Cut(path, ".")
+		fd := msg.Fields().ByName(protoreflect.Name(field))
+		if fd == nil || !fd.IsList() || fd.Message() == nil || rest == "" {
+			return fmt.Errorf("invalid denormalized field %q: not a field of a repeated message field of %s", path, msg.FullName())
+		}
+		_, sub, err := resolvePath(fd.Message(), fieldNames{}, "denormalized", rest)
+		if err != nil {
+			return fmt.Errorf("invalid denormalized field %q: %w", path, err)
+		}
+		if sub.IsList() || sub.IsMap() || (sub.Message() != nil && !timestampField(sub)) {
+			return fmt.Errorf("invalid denormalized field %q: not a scalar", path)
+		}
+		if name == "" {
+			return fmt.Errorf("invalid denormalized name for field %q: must not be empty", path)
+		}
+	}
+	return nil
+}
+
+// Returns the denormalized values of the message, by the name they are stored
+// under.
+func (n fieldNames) denormalizedValues(m protoreflect.Message) map[string]interface{} {
+	values := map[string]interface{}{}
+	for path, name := range n.denormalized {
+		field, rest, _ := strings.Cut(path, ".")
+		list := m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(field))).List()
+		stored := []interface{}{}
+		for i := 0; i < list.Len(); i++ {
+			v, fd := list.Get(i), protoreflect.FieldDescriptor(nil)
+			for _, segment := range strings.Split(rest, ".") {
+				fd = v.Message().Descriptor().Fields().ByName(protoreflect.Name(segment))
+				v = v.Message().Get(fd)
+			}
+			if fd.Enum() != nil {
+				if value := fd.Enum().Values().ByNumber(v.Enum()); value != nil {
+					stored = append(stored, n.storedEnum(fd, value))
+					continue
+				}
+			}
+			stored = append(stored, scalar(v, fd))
+		}
+		values[name] = stored
+	}
+	return values
+}
+
+// Rewrites every exists() and all() method of the expression as any() and
+// all(), such as `items.exists(i, (i.sku = "x"))` as `any((items.sku = "x"))`,
+// as filters cannot declare variables. New expressions are given IDs by id.
+func expandMethods(e *expr.Expr, id func(*expr.Expr) *expr.Expr) error {
+	call := e.GetCallExpr()
+	if call == nil {
+		return nil
+	}
+	for _, arg := range call.Args {
+		if err := expandMethods(arg, id); err != nil {
+			return err
+		}
+	}
+	i := strings.LastIndex(call.Function, ".")
+	if i < 0 {
+		return nil
+	}
+	field, function := call.Function[:i], elementMethods[call.Function[i+1:]]
+	if function == "" {
+		return nil
+	}
+	if len(call.Args) != 2 || call.Args[0].GetIdentExpr() == nil {
+		return status.Errorf(codes.InvalidArgument, "%s requires a variable and a filter of it, such as %s(i, (i.a = 1))", call.Function, call.Function)
+	}
+	bindVariable(call.Args[1], call.Args[0].GetIdentExpr().GetName(), field, id)
+	call.Function = function
+	call.Args = call.Args[1:]
+	return nil
+}
+
+// Replaces every reference to the variable in the expression with the field
+// it names, such as "test_filtering.items".
+func bindVariable(e *expr.Expr, variable, field string, id func(*expr.Expr) *expr.Expr) {
+	switch kind := e.GetExprKind().(type) {
+	case *expr.Expr_IdentExpr:
+		if kind.IdentExpr.GetName() != variable {
+			return
+		}
+		segments := strings.Split(field, ".")
+		e.ExprKind = &expr.Expr_IdentExpr{IdentExpr: &expr.Expr_Ident{Name: segments[0]}}
+		for _, segment := range segments[1:] {
+			operand := id(&expr.Expr{ExprKind: e.ExprKind})
+			e.ExprKind = &expr.Expr_SelectExpr{SelectExpr: &expr.Expr_Select{Operand: operand, Field: segment}}
+		}
+	case *expr.Expr_SelectExpr:
+		bindVariable(kind.SelectExpr.GetOperand(), variable, field, id)
+	case *expr.Expr_CallExpr:
+		for _, arg := range kind.CallExpr.GetArgs() {
+			bindVariable(arg, variable, field, id)
+		}
+	}
+}
+
+// Returns the filter with the brackets of every list of values passed to any(),
+// such as `any([1, 2])`, replaced with spaces, as filters cannot contain lists.
+// The values are then arguments of any(), and the positions of the rest of the
+// filter are unchanged.
+func unbracketValueLists(filter string) string {
+	b := []byte(filter)
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\'', '"':
+			// Strings cannot contain their quote, so end at the next one.
+			end := strings.IndexByte(filter[i+1:], b[i])
+			if end < 0 {
+				return filter
+			}
+			i += end + 1
+		case '[':
+			if !strings.HasSuffix(strings.TrimRight(filter[:i], " \t\r\n"), functionAny+"(") {
+				continue
+			}
+			end := listEnd(filter, i+1)
+			if end < 0 || !strings.HasPrefix(strings.TrimLeft(filter[end+1:], " \t\r\n"), ")") {
+				continue
+			}
+			b[i], b[end] = ' ', ' '
+			i = end
+		}
+	}
+	return string(b)
+}
+
+// Returns the index of the bracket which closes the list starting at i, or -1
+// if there is none.
+func listEnd(filter string, i int) int {
+	for ; i < len(filter); i++ {
+		switch filter[i] {
+		case '\'', '"':
+			end := strings.IndexByte(filter[i+1:], filter[i])
+			if end < 0 {
+				return -1
+			}
+			i += end + 1
+		case '[':
+			return -1
+		case ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// Rewrites every comparison of a field to any() of a list of values as a
+// comparison to each value, such as `a = any([1, 2])` as `a = 1 OR a = 2`,
+// which is transpiled to `in`, split across queries if there are more values
+// than Firestore allows in one. `:` is rewritten in the same way, and `!=` as
+// `a != 1 AND a != 2`, which is transpiled to `not-in`.
+// New expressions are given IDs by id.
+func expandValueLists(e *expr.Expr, id func(*expr.Expr) *expr.Expr) error {
+	call := e.GetCallExpr()
+	if call == nil {
+		return nil
+	}
+	for _, arg := range call.Args {
+		if err := expandValueLists(arg, id); err != nil {
+			return err
+		}
+	}
+	join := filtering.FunctionOr
+	switch call.Function {
+	case filtering.FunctionEquals, filtering.FunctionHas:
+	case filtering.FunctionNotEquals:
+		join = filtering.FunctionAnd
+	default:
+		return nil
+	}
+	if len(call.Args) != 2 || call.Args[1].GetCallExpr().GetFunction() != functionAny {
+		return nil
+	}
+	values := call.Args[1].GetCallExpr().GetArgs()
+	if len(values) == 0 {
+		return status.Errorf(codes.InvalidArgument, "%s() compared to %s requires at least one value", functionAny, canonical(call.Args[0], false))
+	}
+	for _, v := range values {
+		if !constantArg(v) {
+			return status.Errorf(codes.InvalidArgument, "%s() compared to %s requires a list of values, such as %s([1, 2])", functionAny, canonical(call.Args[0], false), functionAny)
+		}
+	}
+	var result *expr.Expr
+	for _, v := range values {
+		field := proto.Clone(call.Args[0]).(*expr.Expr)
+		filtering.Walk(func(e, _ *expr.Expr) bool {
+			id(e)
+			return true
+		}, field)
+		comparison := id(&expr.Expr{ExprKind: &expr.Expr_CallExpr{CallExpr: &expr.Expr_Call{Function: call.Function, Args: []*expr.Expr{field, v}}}})
+		if result == nil {
+			result = comparison
+			continue
+		}
+		result = id(&expr.Expr{ExprKind: &expr.Expr_CallExpr{CallExpr: &expr.Expr_Call{Function: join, Args: []*expr.Expr{result, comparison}}}})
+	}
+	e.ExprKind = result.ExprKind
+	return nil
+}
+
+// Returns the name of the repeated message field filtered by the any() or all()
+// call,
+// and a copy of its filter with every field relative to the element, such as
+// `test_filtering.sku = "x"` for `any((test_filtering.items.sku = "x"))`.
+// Returns false unless the filter selects fields of a single field.
+func anyElement(call *expr.Expr_Call) (string, *expr.Expr, bool) {
+	if len(call.GetArgs()) != 1 {
+		return "", nil, false
+	}
+	filter := proto.Clone(call.Args[0]).(*expr.Expr)
+	var root, field string
+	ok := true
+	filtering.Walk(func(e, _ *expr.Expr) bool {
+		sel := e.GetSelectExpr()
+		ident := sel.GetOperand().GetIdentExpr()
+		if ident == nil {
+			return ok
+		}
+		if root != "" && (ident.GetName() != root || sel.GetField() != field) {
+			ok = false
+		}
+		root, field = ident.GetName(), sel.GetField()
+		e.ExprKind = sel.GetOperand().GetExprKind()
+		return false
+	}, filter)
+	return field, filter, ok && field != ""
+}
+
+// Returns the denormalized path and the stored values of an any() call which
+// is a comparison of a denormalized field with `=`, or a disjunction of them,
+// such as `any((items.sku = "a" OR items.sku = "b"))`.
+// Returns false if the call is not such a comparison.
+func (q *query) denormalizedValues(call *expr.Expr_Call) (firestore.FieldPath, []interface{}, bool) {
+	field, filter, ok := anyElement(call)
+	if !ok {
+		return nil, nil, false
+	}
+	fd := q.msg.Fields().ByName(protoreflect.Name(field))
+	if fd == nil || !fd.IsList() || fd.Message() == nil {
+		return nil, nil, false
+	}
+	// The filter is transpiled against the message of the element.
+	element := &query{msg: fd.Message(), names: q.names, types: q.types, caps: q.caps}
+	path, values, ok := element.valueSet(&expr.Expr_Call{Function: filtering.FunctionOr, Args: []*expr.Expr{filter}}, filtering.FunctionOr, filtering.FunctionEquals)
+	if !ok {
+		return nil, nil, false
+	}
+	// Every value is compared to the same field, which is named by the first.
+	var compared string
+	filtering.Walk(func(e, _ *expr.Expr) bool {
+		if name, ok := selectName(e); ok {
+			_, compared, _ = strings.Cut(name, ".")
+		}
+		return compared == ""
+	}, filter)
+	name, ok := q.names.denormalized[field+"."+compared]
+	if !ok || len(path) == 0 {
+		return nil, nil, false
+	}
+	return firestore.FieldPath{name}, values, true
+}
+
+// Transpiles an any() call of a denormalized field to array-contains, or to
+// array-contains-any for a disjunction of values.
+func (q *query) transpileAny(e *expr.Expr_Call, not bool) error {
+	path, values, ok := q.denormalizedValues(e)
+	switch {
+	case !ok:
+		return status.Errorf(codes.InvalidArgument, "%s() must compare a denormalized field with =, or be joined to the rest of the filter with AND, and can only be used to list documents", functionAny)
+	case not:
+		return status.Errorf(codes.InvalidArgument, "NOT cannot be used with %s() of a denormalized field", functionAny)
+	case len(values) == 1:
+		q.setValue(path)
+		q.q = q.q.WherePath(path, "array-contains", values[0])
+		return nil
+	}
+	return q.transpileArrayContainsAny(path, values)
+}
@@ -0,0 +1,314 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	fspb "google.golang.org/genproto/googleapis/firestore/v1"
+)
+
+func TestExpandMethods(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		want   string
+	}{
+		{
+			filter: `test_filtering.items.exists(i, (i.filterable_primitive = 1 AND i.filterable_primitive != 2))`,
+			want:   `any((test_filtering.items.filterable_primitive != 2 AND test_filtering.items.filterable_primitive = 1))`,
+		},
+		{
+			filter: `test_filtering.items.all(item, (item.filterable_primitive > 0))`,
+			want:   `all(test_filtering.items.filterable_primitive > 0)`,
+		},
+	} {
+		checked, err := parseFilter(tc.filter, testDeclarations(t), testDescriptor, nil, false)
+		if err != nil {
+			t.Fatalf("parseFilter(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if got := canonical(checked.GetExpr(), false); got != tc.want {
+			t.Errorf("parseFilter(%q) = %s, want %s", tc.filter, got, tc.want)
+		}
+	}
+	for _, filter := range []string{
+		`test_filtering.items.exists(i)`,
+		`test_filtering.items.exists(1, test_filtering.default_bool)`,
+	} {
+		if _, err := parseFilter(filter, testDeclarations(t), testDescriptor, nil, false); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("parseFilter(%q) err = %v, want code %v", filter, err, codes.InvalidArgument)
+		}
+	}
+}
+
+func TestTranspileAny(t *testing.T) {
+	names := fieldNames{denormalized: map[string]string{"items.filterable_primitive": "item_primitives"}}
+	integer := func(i int64) *fspb.Value { return &fspb.Value{ValueType: &fspb.Value_IntegerValue{IntegerValue: i}} }
+	for _, tc := range []struct {
+		filter string
+		want   *fspb.StructuredQuery_Filter
+	}{
+		{
+			filter: `any((test_filtering.items.filterable_primitive = 1))`,
+			want:   fieldFilter("item_primitives", fspb.StructuredQuery_FieldFilter_ARRAY_CONTAINS, integer(1)),
+		},
+		{
+			filter: `any((test_filtering.items.filterable_primitive = 1 OR test_filtering.items.filterable_primitive = 2))`,
+			want:   fieldFilter("item_primitives", fspb.StructuredQuery_FieldFilter_ARRAY_CONTAINS_ANY, arrayValue(integer(1), integer(2))),
+		},
+	} {
+		q, err := newQuery(testClient(t).Collection("tests").Query, testDescriptor, names, parse(t, tc.filter), capabilities{})
+		if err != nil {
+			t.Fatalf("newQuery(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		qs, err := q.build()
+		if err != nil {
+			t.Fatalf("build(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if diff := cmp.Diff([]*fspb.StructuredQuery_Filter{tc.want}, wheres(serialize(t, qs)), protocmp.Transform()); diff != "" {
+			t.Errorf("newQuery(%q) where diff (-want +got):\n%s", tc.filter, diff)
+		}
+	}
+	for _, filter := range []string{
+		`any((test_filtering.items.filterable_primitive > 1))`,
+		`any((test_filtering.items.filterable_primitive = 1 AND test_filtering.items.filterable_primitive = 2))`,
+		`NOT any((test_filtering.items.filterable_primitive = 1))`,
+	} {
+		if _, err := newQuery(testClient(t).Collection("tests").Query, testDescriptor, names, parse(t, filter), capabilities{}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("newQuery(%q) err = %v, want code %v", filter, err, codes.InvalidArgument)
+		}
+	}
+	// Without denormalized values, any() is evaluated once documents are
+	// retrieved.
+	filter := `any((test_filtering.items.filterable_primitive = 1))`
+	if _, err := transpile(t, filter); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("transpile(%q) err = %v, want code %v", filter, err, codes.InvalidArgument)
+	}
+}
+
+// Returns a message of the test descriptor with an item of each value.
+func itemsMessage(values ...int64) protoreflect.Message {
+	msg := dynamicpb.NewMessage(testDescriptor)
+	items := msg.Mutable(testDescriptor.Fields().ByName("items")).List()
+	for _, v := range values {
+		item := items.NewElement()
+		item.Message().Set(item.Message().Descriptor().Fields().ByName("filterable_primitive"), protoreflect.ValueOfInt64(v))
+		items.Append(item)
+	}
+	return msg
+}
+
+func TestEvaluateAny(t *testing.T) {
+	msg := itemsMessage(1, 2)
+	for _, tc := range []struct {
+		filter string
+		want   bool
+	}{
+		{`any((test_filtering.items.filterable_primitive = 1))`, true},
+		{`any((test_filtering.items.filterable_primitive > 2))`, false},
+		// Every term must match the same element.
+		{`any((test_filtering.items.filterable_primitive = 1 AND test_filtering.items.filterable_primitive = 2))`, false},
+		{`any((test_filtering.items.filterable_primitive = 1)) AND any((test_filtering.items.filterable_primitive = 2))`, true},
+		{`NOT any((test_filtering.items.filterable_primitive < 1))`, true},
+	} {
+		got, err := Evaluate(filtering.Filter{CheckedExpr: parse(t, tc.filter)}, msg.Interface())
+		if err != nil {
+			t.Fatalf("Evaluate(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if got != tc.want {
+			t.Errorf("Evaluate(%q) = %t, want %t", tc.filter, got, tc.want)
+		}
+	}
+	if got, err := Evaluate(filtering.Filter{CheckedExpr: parse(t, `any((test_filtering.items.filterable_primitive = 1))`)}, itemsMessage().Interface()); err != nil || got {
+		t.Errorf("Evaluate() without items = %t, %v, want false, <nil>", got, err)
+	}
+}
+
+func TestEvaluateAll(t *testing.T) {
+	for _, tc := range []struct {
+		msg  protoreflect.Message
+		want bool
+	}{
+		{itemsMessage(1, 2), true},
+		{itemsMessage(1, 0), false},
+		// Documents without elements match vacuously.
+		{itemsMessage(), true},
+	} {
+		got, err := Evaluate(filtering.Filter{CheckedExpr: parse(t, `all((test_filtering.items.filterable_primitive > 0))`)}, tc.msg.Interface())
+		if err != nil {
+			t.Fatalf("Evaluate() err = %v, want <nil>", err)
+		}
+		if got != tc.want {
+			t.Errorf("Evaluate(%v) = %t, want %t", tc.msg, got, tc.want)
+		}
+	}
+}
+
+func TestSplitAll(t *testing.T) {
+	// all() is split even though Firestore could evaluate the rest of the
+	// filter.
+	filter := parse(t, `all((test_filtering.items.filterable_primitive > 0)) AND test_filtering.default_float > 1.5`)
+	rest, post, err := splitPostFilters(filter, false, NotEqualsExcludeMissing, func(*expr.CheckedExpr) bool { return true })
+	if err != nil {
+		t.Fatalf("splitPostFilters() err = %v, want <nil>", err)
+	}
+	if got, want := canonical(rest.GetExpr(), false), `test_filtering.default_float > 1.5`; got != want {
+		t.Errorf("splitPostFilters() rest = %s, want %s", got, want)
+	}
+	if post == nil || !post.matches(itemsMessage(1)) || post.matches(itemsMessage(0)) {
+		t.Errorf("splitPostFilters() post = %v, want an evaluator of all()", post)
+	}
+	filter = parse(t, `all((test_filtering.items.filterable_primitive > 0)) OR test_filtering.default_float > 1.5`)
+	if _, err := newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{}, filter, capabilities{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("newQuery() err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestSplitAny(t *testing.T) {
+	filter := parse(t, `any((test_filtering.items.filterable_primitive > 1)) AND test_filtering.default_float > 1.5`)
+	rest, post, err := splitPostFilters(filter, false, NotEqualsExcludeMissing, func(filter *expr.CheckedExpr) bool {
+		_, err := newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{}, filter, capabilities{})
+		return err == nil
+	})
+	if err != nil {
+		t.Fatalf("splitPostFilters() err = %v, want <nil>", err)
+	}
+	if got, want := canonical(rest.GetExpr(), false), `test_filtering.default_float > 1.5`; got != want {
+		t.Errorf("splitPostFilters() rest = %s, want %s", got, want)
+	}
+	if post == nil || !post.matches(itemsMessage(2)) || post.matches(itemsMessage(1)) {
+		t.Errorf("splitPostFilters() post = %v, want an evaluator of any()", post)
+	}
+}
+
+func TestDenormalizedFields(t *testing.T) {
+	if err := denormalizedFields(testDescriptor, map[string]string{"items.filterable_primitive": "item_primitives"}); err != nil {
+		t.Errorf("denormalizedFields() err = %v, want <nil>", err)
+	}
+	for _, fields := range []map[string]string{
+		{"items": "items"},
+		{"items.missing": "missing"},
+		{"default_submessage.filterable_primitive": "primitives"},
+		{"items.filterable_primitive": ""},
+	} {
+		if err := denormalizedFields(testDescriptor, fields); err == nil {
+			t.Errorf("denormalizedFields(%v) err = <nil>, want error", fields)
+		}
+	}
+	names := fieldNames{denormalized: map[string]string{"items.filterable_primitive": "item_primitives"}}
+	want := map[string]interface{}{"item_primitives": []interface{}{int64(1), int64(2)}}
+	if diff := cmp.Diff(want, names.denormalizedValues(itemsMessage(1, 2))); diff != "" {
+		t.Errorf("denormalizedValues() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestUnbracketValueLists(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		want   string
+	}{
+		{`a = any([1, 2])`, `a = any( 1, 2 )`},
+		{`a = any( ["]", 'x'] ) AND b = any([3])`, `a = any(  "]", 'x'  ) AND b = any( 3 )`},
+		// Brackets elsewhere are left to be rejected by the parser.
+		{`matches(a, "[0-9]") AND a = [1]`, `matches(a, "[0-9]") AND a = [1]`},
+		{`a = any([1, [2]])`, `a = any([1, [2]])`},
+	} {
+		if got := unbracketValueLists(tc.filter); got != tc.want {
+			t.Errorf("unbracketValueLists(%q) = %q, want %q", tc.filter, got, tc.want)
+		}
+	}
+}
+
+func TestExpandValueLists(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		want   string
+	}{
+		{
+			filter: `test_filtering.default_float = any([1.5, 2.5, 3.5])`,
+			want:   `(test_filtering.default_float = 1.5 OR test_filtering.default_float = 2.5 OR test_filtering.default_float = 3.5)`,
+		},
+		{
+			filter: `test_filtering.tags:any(["a"])`,
+			want:   `test_filtering.tags:"a"`,
+		},
+		{
+			filter: `test_filtering.default_float != any([1.5, 2.5])`,
+			want:   `(test_filtering.default_float != 1.5 AND test_filtering.default_float != 2.5)`,
+		},
+	} {
+		checked, err := parseFilter(tc.filter, testDeclarations(t), testDescriptor, nil, false)
+		if err != nil {
+			t.Fatalf("parseFilter(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if got := canonical(checked.GetExpr(), false); got != tc.want {
+			t.Errorf("parseFilter(%q) = %s, want %s", tc.filter, got, tc.want)
+		}
+	}
+	for _, filter := range []string{
+		`test_filtering.default_float = any([])`,
+		`test_filtering.default_float = any([test_filtering.default_float])`,
+	} {
+		if _, err := parseFilter(filter, testDeclarations(t), testDescriptor, nil, false); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("parseFilter(%q) err = %v, want code %v", filter, err, codes.InvalidArgument)
+		}
+	}
+}
+
+func TestTranspileValueLists(t *testing.T) {
+	double := func(f float64) *fspb.Value { return &fspb.Value{ValueType: &fspb.Value_DoubleValue{DoubleValue: f}} }
+	filter := `test_filtering.default_float = any([1.5, 2.5])`
+	checked, err := parseFilter(filter, testDeclarations(t), testDescriptor, nil, false)
+	if err != nil {
+		t.Fatalf("parseFilter(%q) err = %v, want <nil>", filter, err)
+	}
+	q, err := newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{}, checked, capabilities{})
+	if err != nil {
+		t.Fatalf("newQuery(%q) err = %v, want <nil>", filter, err)
+	}
+	qs, err := q.build()
+	if err != nil {
+		t.Fatalf("build(%q) err = %v, want <nil>", filter, err)
+	}
+	want := []*fspb.StructuredQuery_Filter{fieldFilter("DefaultFloat", fspb.StructuredQuery_FieldFilter_IN, arrayValue(double(1.5), double(2.5)))}
+	if diff := cmp.Diff(want, wheres(serialize(t, qs)), protocmp.Transform()); diff != "" {
+		t.Errorf("newQuery(%q) where diff (-want +got):\n%s", filter, diff)
+	}
+	// Values past Firestore's limit are split across queries.
+	var values []string
+	for i := 0; i < maxDisjunctions+1; i++ {
+		values = append(values, fmt.Sprintf("%q", fmt.Sprintf("v%d", i)))
+	}
+	filter = fmt.Sprintf("test_filtering.tags:any([%s])", strings.Join(values, ", "))
+	if checked, err = parseFilter(filter, testDeclarations(t), testDescriptor, nil, false); err != nil {
+		t.Fatalf("parseFilter(%q) err = %v, want <nil>", filter, err)
+	}
+	if q, err = newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{}, checked, capabilities{}); err != nil {
+		t.Fatalf("newQuery(%q) err = %v, want <nil>", filter, err)
+	}
+	if got, want := q.fanOutQueries(), 2; got != want {
+		t.Errorf("newQuery(%q) fan-out = %d queries, want %d", filter, got, want)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	firestoreapi "cloud.google.com/go/firestore/apiv1"
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"go.einride.tech/aip/ordering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	fspb "google.golang.org/genproto/googleapis/firestore/v1"
+)
+
+// AuditRecord describes a List call, so that the page it returned can be
+// reproduced with Replay.
+type AuditRecord struct {
+	Parent string
+	// The canonical filter, which is not redacted.
+	Filter    string
+	OrderBy   string
+	PageSize  int32
+	PageToken string
+	// A hash of the queries the filter was planned as (see SavedFilter), or
+	// empty if it could not be planned.
+	PlanHash string
+	// The time the call started. Documents were read no earlier than this.
+	ReadTime time.Time
+}
+
+// WithAuditLog calls log with an AuditRecord of each successful List call.
+// Filters are recorded verbatim, so the log may contain sensitive values.
+func WithAuditLog(log func(context.Context, AuditRecord)) Option {
+	return func(o *options) {
+		o.audit = log
+	}
+}
+
+// Returns the AuditRecord of a List call which started at start.
+func (t *Transpiler[T]) auditRecord(req protoexpr.ListRequest, r *listRequest, o callOptions, start time.Time) AuditRecord {
+	rec := AuditRecord{
+		Parent:    req.GetParent(),
+		Filter:    canonical(r.filter.GetExpr(), false),
+		PageSize:  req.GetPageSize(),
+		PageToken: req.GetPageToken(),
+		// Firestore reads at microsecond precision.
+		ReadTime: start.Truncate(time.Microsecond),
+	}
+	if req, ok := req.(ordering.Request); ok {
+		rec.OrderBy = req.GetOrderBy()
+	}
+	if o.orderBy != nil {
+		rec.OrderBy = formatOrderBy(*o.orderBy)
+	}
+	if plan, err := t.Plan(planRequest{ListRequest: req, filter: rec.Filter}); err == nil {
+		rec.PlanHash = planHash(plan)
+	}
+	return rec
+}
+
+// ReplayResult is the page of documents an AuditRecord reproduced.
+type ReplayResult struct {
+	// The documents read by the replayed query, as stored in Firestore.
+	Documents []*fspb.Document
+	Warnings  []Warning
+}
+
+// Replay reruns the query of an AuditRecord, reading documents as they were at
+// its ReadTime, to reproduce the page a past List call returned, such as when
+// investigating an incident. The ReadTime must be within the version retention
+// period of the database.
+// If the filter would now be planned differently, a WarningPlanChanged warning
+// is added to the result. Filters on indexed fields, and text search terms,
+// are resolved as they are now, rather than at the ReadTime.
+// Returns an INVALID_ARGUMENT error if the filter cannot be run as a single
+// query (see Build).
+func (t *Transpiler[T]) Replay(ctx context.Context, c *firestoreapi.Client, rec AuditRecord) (*ReplayResult, error) {
+	req, warnings, err := t.replayQuery(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := c.RunQuery(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	result := &ReplayResult{Warnings: warnings}
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if res.GetDocument() != nil {
+			result.Documents = append(result.Documents, res.GetDocument())
+		}
+	}
+}
+
+// Returns the query of an AuditRecord, which reads documents at its ReadTime.
+func (t *Transpiler[T]) replayQuery(ctx context.Context, rec AuditRecord) (*fspb.RunQueryRequest, []Warning, error) {
+	req := replayRequest{Empty: &emptypb.Empty{}, rec: rec}
+	var warnings []Warning
+	plan, err := t.Plan(planRequest{ListRequest: req, filter: rec.Filter})
+	if err != nil {
+		return nil, nil, err
+	}
+	if planHash(plan) != rec.PlanHash {
+		warnings = append(warnings, Warning{
+			Kind:    WarningPlanChanged,
+			Message: fmt.Sprintf("filter is planned differently than when the record was created at %v", rec.ReadTime),
+		})
+	}
+	built, err := t.Build(ctx, req, ReadPrimary())
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := built.Query.Serialize()
+	if err != nil {
+		return nil, nil, err
+	}
+	run := &fspb.RunQueryRequest{}
+	if err := proto.Unmarshal(b, run); err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "serialized query is invalid: %v", err)
+	}
+	run.ConsistencySelector = &fspb.RunQueryRequest_ReadTime{ReadTime: timestamppb.New(rec.ReadTime)}
+	return run, append(warnings, built.Warnings...), nil
+}
+
+// replayRequest is the request of an AuditRecord.
+type replayRequest struct {
+	*emptypb.Empty
+	rec AuditRecord
+}
+
+func (r replayRequest) GetParent() string    { return r.rec.Parent }
+func (r replayRequest) GetFilter() string    { return r.rec.Filter }
+func (r replayRequest) GetOrderBy() string   { return r.rec.OrderBy }
+func (r replayRequest) GetPageSize() int32   { return r.rec.PageSize }
+func (r replayRequest) GetPageToken() string { return r.rec.PageToken }
@@ -0,0 +1,76 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	fspb "google.golang.org/genproto/googleapis/firestore/v1"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestReplayQuery(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	req := &test.ListTestRequest{Parent: "parents/p", PageSize: 5, Filter: `test_filtering.filterable_primitive="a"`}
+	o := transpiler.callOptions(nil)
+	r, err := transpiler.parseListRequest(req, o)
+	if err != nil {
+		t.Fatalf("parseListRequest() err = %v, want <nil>", err)
+	}
+	start := time.Date(2022, 1, 2, 3, 4, 5, 6007, time.UTC)
+	rec := transpiler.auditRecord(req, r, o, start)
+	want := AuditRecord{
+		Parent:   "parents/p",
+		Filter:   `test_filtering.filterable_primitive = "a"`,
+		PageSize: 5,
+		PlanHash: rec.PlanHash,
+		ReadTime: time.Date(2022, 1, 2, 3, 4, 5, 6000, time.UTC),
+	}
+	if diff := cmp.Diff(want, rec); diff != "" {
+		t.Errorf("auditRecord() diff (-want +got):\n%s", diff)
+	}
+	if rec.PlanHash == "" {
+		t.Errorf("auditRecord() PlanHash = %q, want hash", rec.PlanHash)
+	}
+	run, warnings, err := transpiler.replayQuery(context.Background(), rec)
+	if err != nil {
+		t.Fatalf("replayQuery() err = %v, want <nil>", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("replayQuery() warnings = %v, want none", warnings)
+	}
+	if got := run.GetReadTime().AsTime(); !got.Equal(rec.ReadTime) {
+		t.Errorf("replayQuery() read time = %v, want %v", got, rec.ReadTime)
+	}
+	if diff := cmp.Diff(fieldFilter("FilterablePrimitive", fspb.StructuredQuery_FieldFilter_EQUAL, stringValue("a")), run.GetStructuredQuery().GetWhere(), protocmp.Transform()); diff != "" {
+		t.Errorf("replayQuery() where diff (-want +got):\n%s", diff)
+	}
+	if got := run.GetStructuredQuery().GetLimit().GetValue(); got != 5 {
+		t.Errorf("replayQuery() limit = %d, want 5", got)
+	}
+	rec.PlanHash = "00000000"
+	if _, warnings, err = transpiler.replayQuery(context.Background(), rec); err != nil {
+		t.Fatalf("replayQuery() err = %v, want <nil>", err)
+	}
+	if len(warnings) != 1 || warnings[0].Kind != WarningPlanChanged {
+		t.Errorf("replayQuery() of changed plan warnings = %v, want %s", warnings, WarningPlanChanged)
+	}
+}
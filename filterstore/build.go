@@ -0,0 +1,101 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BuiltQuery is the Firestore query of a request, which has not been run.
+type BuiltQuery struct {
+	// The query, which is filtered, ordered, positioned by the page token of
+	// the request, and limited to its page size. If the page token is for a
+	// previous page, the query is run in reverse from its cursor, so documents
+	// are retrieved in the opposite order to the EffectiveOrderBy.
+	Query firestore.Query
+	// The order_by (https://google.aip.dev/132#ordering) equivalent of the
+	// orderings of the query.
+	EffectiveOrderBy []string
+	Warnings         []Warning
+	tokens           pageTokens
+	// The orders of the cursors of page tokens.
+	orders   []fieldOrder
+	checksum uint32
+}
+
+// PageToken returns a token for the page after the document, which must have
+// been retrieved by the query, so that the rest of the documents matching the
+// request can be retrieved by List, or by another call to Build.
+func (b *BuiltQuery) PageToken(doc *firestore.DocumentSnapshot) (string, error) {
+	return encodeCursor(b.tokens, doc, b.orders, false, b.checksum)
+}
+
+// Build transpiles the request into a Firestore query without running it, so
+// that it can be refined, such as with Select or Limit, or run in a
+// transaction. Filters on indexed fields, and text search terms, are resolved
+// before the query is returned. If the Unbounded option is provided, the
+// query is not limited.
+// Returns an INVALID_ARGUMENT error if the filter cannot be expressed as a
+// single query, such as an OR of different fields, or a filter evaluated in
+// memory.
+func (t *Transpiler[T]) Build(ctx context.Context, req protoexpr.ListRequest, opts ...CallOption) (*BuiltQuery, error) {
+	o := t.callOptions(opts)
+	pageSize, err := t.pageSize(req, o)
+	if err == nil {
+		pageSize, err = t.resultPolicy(ctx).limitPageSize(pageSize, o)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r, err := t.listRequest(ctx, req, o)
+	if err != nil {
+		return nil, err
+	}
+	limit := int(pageSize)
+	if o.unbounded {
+		if r.token != nil && r.token.Before {
+			return nil, status.Errorf(codes.InvalidArgument, "an unbounded query cannot be built from a previous page token")
+		}
+		limit = 0
+	}
+	q, err := listQuery(t.reader(o), t.collection, r, limit)
+	if err != nil {
+		return nil, err
+	}
+	qs, err := q.build()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case q.none:
+		return nil, status.Error(codes.InvalidArgument, "filter cannot match any documents, so no query is required")
+	case len(qs) != 1:
+		return nil, status.Errorf(codes.InvalidArgument, "filter requires %d queries, so cannot be built as one query", len(qs))
+	}
+	orders := tokenOrders(q.orders)
+	return &BuiltQuery{
+		Query:            qs[0],
+		EffectiveOrderBy: q.orderBy,
+		Warnings:         append(r.warnings[:len(r.warnings):len(r.warnings)], q.warnings...),
+		tokens:           t.tokens,
+		orders:           orders,
+		checksum:         r.checksum,
+	}, nil
+}
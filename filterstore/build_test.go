@@ -0,0 +1,60 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	fspb "google.golang.org/genproto/googleapis/firestore/v1"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestBuild(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	req := &test.ListTestRequest{Parent: "parents/p", PageSize: 5, Filter: `test_filtering.filterable_primitive = "a"`}
+	built, err := transpiler.Build(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Build() err = %v, want <nil>", err)
+	}
+	sq := serialize(t, []firestore.Query{built.Query})[0]
+	if diff := cmp.Diff(fieldFilter("FilterablePrimitive", fspb.StructuredQuery_FieldFilter_EQUAL, stringValue("a")), sq.GetWhere(), protocmp.Transform()); diff != "" {
+		t.Errorf("Build() where diff (-want +got):\n%s", diff)
+	}
+	if got := sq.GetLimit().GetValue(); got != 5 {
+		t.Errorf("Build() limit = %d, want 5", got)
+	}
+	if built, err = transpiler.Build(context.Background(), req, Unbounded()); err != nil {
+		t.Fatalf("Build(Unbounded()) err = %v, want <nil>", err)
+	}
+	if sq := serialize(t, []firestore.Query{built.Query})[0]; sq.GetLimit() != nil {
+		t.Errorf("Build(Unbounded()) limit = %v, want none", sq.GetLimit())
+	}
+	for _, filter := range []string{
+		`test_filtering.filterable_primitive = "a" OR test_filtering.default_float > 1.5`,
+		`contains(test_filtering.filterable_primitive, "a")`,
+	} {
+		if _, err := transpiler.Build(context.Background(), &test.ListTestRequest{Parent: "parents/p", Filter: filter}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Build(%q) err = %v, want code %v", filter, err, codes.InvalidArgument)
+		}
+	}
+}
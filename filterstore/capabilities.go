@@ -0,0 +1,130 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The collection ProbeCapabilities queries. It need not exist.
+const probeCollection = "filterstore_probe"
+
+// Capabilities are the features of a Firestore database which the planner
+// may use. The zero value uses the limits of a current Standard edition
+// database.
+//
+// Aggregate and FindNearest are always evaluated once documents are
+// retrieved, as this version of the Firestore client cannot request them,
+// so they are not included.
+type Capabilities struct {
+	// Whether inequalities may be used on multiple fields.
+	MultipleInequalities bool
+	// Whether `!=` and `not-in` filters must not be used. When set, such
+	// filters are rejected, or evaluated in memory with WithResidualFiltering.
+	NoNotEquals bool
+	// The number of values allowed in an `in` or `array-contains-any` filter.
+	// Comparisons to more values are split across multiple queries.
+	// If 0, Firestore's documented limit of 30 is used.
+	MaxDisjunctions int
+	// The number of values allowed in a `not-in` filter.
+	// If 0, Firestore's documented limit of 10 is used.
+	MaxNotIn int
+}
+
+func (c Capabilities) capabilities() capabilities {
+	return capabilities{
+		multipleInequalities: c.MultipleInequalities,
+		noNotEquals:          c.NoNotEquals,
+		disjunctions:         c.MaxDisjunctions,
+		notIn:                c.MaxNotIn,
+	}
+}
+
+// WithCapabilities plans queries using only the provided features of the
+// database, such as those returned by ProbeCapabilities.
+func WithCapabilities(c Capabilities) Option {
+	return func(o *options) {
+		o.caps = c.capabilities()
+	}
+}
+
+// ProbeCapabilities determines the Capabilities of the database by running
+// queries which use each feature against an empty collection.
+// Queries which would require an index are considered supported.
+func ProbeCapabilities(ctx context.Context, c *firestore.Client) (Capabilities, error) {
+	var caps Capabilities
+	col := c.Collection(probeCollection)
+	ok, err := probe(ctx, col.Where("a", ">", 0).Where("b", ">", 0))
+	if err != nil {
+		return caps, err
+	}
+	caps.MultipleInequalities = ok
+	if ok, err = probe(ctx, col.Where("a", "!=", 0)); err != nil {
+		return caps, err
+	}
+	caps.NoNotEquals = !ok
+	if caps.MaxDisjunctions, err = probeLimit(ctx, col, "in", maxDisjunctions, 10); err != nil {
+		return caps, err
+	}
+	if !caps.NoNotEquals {
+		if caps.MaxNotIn, err = probeLimit(ctx, col, "not-in", maxNotIn); err != nil {
+			return caps, err
+		}
+	}
+	return caps, nil
+}
+
+// Returns the first of the limits which the operator accepts, or an error
+// if none are accepted.
+func probeLimit(ctx context.Context, col *firestore.CollectionRef, op string, limits ...int) (int, error) {
+	for _, limit := range limits {
+		values := make([]interface{}, limit)
+		for i := range values {
+			values[i] = i
+		}
+		ok, err := probe(ctx, col.Where("a", op, values))
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return limit, nil
+		}
+	}
+	return 0, status.Errorf(codes.FailedPrecondition, "database does not support %s with %d values", op, limits[len(limits)-1])
+}
+
+// Reports whether the database accepts the query.
+func probe(ctx context.Context, q firestore.Query) (bool, error) {
+	it := q.Limit(1).Documents(ctx)
+	defer it.Stop()
+	_, err := it.Next()
+	if err == iterator.Done {
+		return true, nil
+	}
+	switch status.Code(err) {
+	case codes.OK, codes.FailedPrecondition:
+		// FailedPrecondition indicates a missing index, not a missing feature.
+		return true, nil
+	case codes.InvalidArgument, codes.Unimplemented:
+		return false, nil
+	}
+	return false, fmt.Errorf("probing capabilities: %w", err)
+}
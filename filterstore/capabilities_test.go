@@ -0,0 +1,109 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWithCapabilities(t *testing.T) {
+	caps := Capabilities{MultipleInequalities: true, NoNotEquals: true, MaxDisjunctions: 10, MaxNotIn: 5}
+	transpiler := newTestTranspiler(t, testClient(t), WithCapabilities(caps))
+	want := capabilities{multipleInequalities: true, noNotEquals: true, disjunctions: 10, notIn: 5}
+	if transpiler.caps != want {
+		t.Errorf("New(WithCapabilities(%+v)) capabilities = %+v, want %+v", caps, transpiler.caps, want)
+	}
+}
+
+func TestCapabilityLimits(t *testing.T) {
+	base := testClient(t).Collection("tests").Query
+	for _, tc := range []struct {
+		name    string
+		caps    capabilities
+		filter  string
+		queries int
+		code    codes.Code
+	}{
+		{
+			name:    "default disjunctions",
+			filter:  disjunction("test_filtering.filterable_primitive", " = ", 11),
+			queries: 1,
+		},
+		{
+			name:    "fewer disjunctions",
+			caps:    capabilities{disjunctions: 10},
+			filter:  disjunction("test_filtering.filterable_primitive", " = ", 11),
+			queries: 2,
+		},
+		{
+			name:   "fewer not-in",
+			caps:   capabilities{notIn: 2},
+			filter: "NOT " + disjunction("test_filtering.filterable_primitive", " = ", 3),
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "no not-in",
+			caps:   capabilities{noNotEquals: true},
+			filter: "NOT " + disjunction("test_filtering.filterable_primitive", " = ", 2),
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "no not equals",
+			caps:   capabilities{noNotEquals: true},
+			filter: `test_filtering.filterable_primitive != "a"`,
+			code:   codes.InvalidArgument,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := newQuery(base, testDescriptor, fieldNames{}, parse(t, tc.filter), tc.caps)
+			var qs []firestore.Query
+			if err == nil {
+				qs, err = q.build()
+			}
+			if status.Code(err) != tc.code {
+				t.Fatalf("transpile(%q) err = %v, want code %v", tc.filter, err, tc.code)
+			}
+			if len(qs) != tc.queries {
+				t.Errorf("transpile(%q) returned %d queries, want %d", tc.filter, len(qs), tc.queries)
+			}
+		})
+	}
+}
+
+// Probes the Firestore emulator, if one is configured, which supports every
+// feature.
+func TestProbeCapabilities(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	c, err := firestore.NewClient(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("firestore.NewClient() err = %v, want <nil>", err)
+	}
+	defer c.Close()
+	got, err := ProbeCapabilities(context.Background(), c)
+	if err != nil {
+		t.Fatalf("ProbeCapabilities() err = %v, want <nil>", err)
+	}
+	if got.NoNotEquals || got.MaxDisjunctions != maxDisjunctions || got.MaxNotIn != maxNotIn {
+		t.Errorf("ProbeCapabilities() = %+v, want != and documented limits supported", got)
+	}
+}
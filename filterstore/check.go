@@ -0,0 +1,246 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"math"
+
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// FieldTypeError is returned when a filter compares a field to a constant
+// which no value of the field can equal, such as a negative number for an
+// unsigned field.
+type FieldTypeError struct {
+	// The field, as it was written in the filter.
+	Field string
+	// The kind of the field in the collection message.
+	Kind protoreflect.Kind
+	// The constant the field was compared to.
+	Value interface{}
+}
+
+func (e *FieldTypeError) Error() string {
+	return fmt.Sprintf("%s (%s) cannot be compared to %#v", e.Field, e.Kind, e.Value)
+}
+
+// GRPCStatus returns the INVALID_ARGUMENT status of the error.
+func (e *FieldTypeError) GRPCStatus() *status.Status {
+	return status.New(codes.InvalidArgument, e.Error())
+}
+
+// CrossFieldError is returned when a filter compares two fields, such as
+// `spent > budget`, as Firestore can only compare fields to constants, unless
+// such comparisons are evaluated with WithResidualFiltering.
+type CrossFieldError struct {
+	// The fields, as they were written in the filter.
+	Field, Other string
+	// The comparison function.
+	Function string
+}
+
+func (e *CrossFieldError) Error() string {
+	return fmt.Sprintf("%s %s %s compares two fields, but fields can only be compared to constants", e.Field, e.Function, e.Other)
+}
+
+// GRPCStatus returns the INVALID_ARGUMENT status of the error.
+func (e *CrossFieldError) GRPCStatus() *status.Status {
+	return status.New(codes.InvalidArgument, e.Error())
+}
+
+// Returns the name of the field selected by the expression, as it was written
+// in the filter.
+// Returns false if the expression does not select a field.
+func selectName(e *expr.Expr) (string, bool) {
+	sel := e.GetSelectExpr()
+	if sel == nil {
+		return "", false
+	}
+	if ident := sel.GetOperand().GetIdentExpr(); ident != nil {
+		return ident.GetName() + "." + sel.GetField(), true
+	}
+	name, ok := selectName(sel.GetOperand())
+	return name + "." + sel.GetField(), ok
+}
+
+// Returns the descriptor of the field selected by the expression, along with
+// its name as it was written in the filter.
+// If the expression selects a key of a map, the map value is returned.
+// Returns false if the expression does not select a field of the message.
+func resolveField(msg protoreflect.MessageDescriptor, e *expr.Expr) (protoreflect.FieldDescriptor, string, bool) {
+	sel := e.GetSelectExpr()
+	if sel == nil {
+		return nil, "", false
+	}
+	if ident := sel.GetOperand().GetIdentExpr(); ident != nil {
+		fd := msg.Fields().ByName(protoreflect.Name(sel.GetField()))
+		return fd, ident.GetName() + "." + sel.GetField(), fd != nil
+	}
+	parent, name, ok := resolveField(msg, sel.GetOperand())
+	if !ok {
+		return nil, "", false
+	}
+	name += "." + sel.GetField()
+	switch {
+	case parent.IsMap():
+		return parent.MapValue(), name, true
+	case parent.IsList() || parent.Message() == nil:
+		return nil, "", false
+	}
+	fd := parent.Message().Fields().ByName(protoreflect.Name(sel.GetField()))
+	return fd, name, fd != nil
+}
+
+// Reports whether a value of the provided kind could equal the constant.
+// Message kinds are left to the filter checker.
+func compatible(kind protoreflect.Kind, c *expr.Constant) bool {
+	switch kind {
+	case protoreflect.BoolKind:
+		_, ok := c.GetConstantKind().(*expr.Constant_BoolValue)
+		return ok
+	case protoreflect.StringKind:
+		_, ok := c.GetConstantKind().(*expr.Constant_StringValue)
+		return ok
+	case protoreflect.BytesKind:
+		switch c.GetConstantKind().(type) {
+		case *expr.Constant_BytesValue, *expr.Constant_StringValue:
+			return true
+		}
+		return false
+	case protoreflect.FloatKind:
+		v, ok := c.GetConstantKind().(*expr.Constant_DoubleValue)
+		return ok && (math.IsInf(v.DoubleValue, 0) || math.IsNaN(v.DoubleValue) || math.Abs(v.DoubleValue) <= math.MaxFloat32)
+	case protoreflect.DoubleKind:
+		_, ok := c.GetConstantKind().(*expr.Constant_DoubleValue)
+		return ok
+	case protoreflect.EnumKind:
+		// Enum values are idents, rather than constants.
+		return false
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return true
+	}
+	var min, max int64
+	switch kind {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		min, max = math.MinInt32, math.MaxInt32
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		min, max = 0, math.MaxUint32
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		min, max = math.MinInt64, math.MaxInt64
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		// Every uint64 constant fits, but int64 constants must not be negative.
+		if _, ok := c.GetConstantKind().(*expr.Constant_Uint64Value); ok {
+			return true
+		}
+		min, max = 0, math.MaxInt64
+	default:
+		return false
+	}
+	switch v := c.GetConstantKind().(type) {
+	case *expr.Constant_Int64Value:
+		return v.Int64Value >= min && v.Int64Value <= max
+	case *expr.Constant_Uint64Value:
+		return max >= 0 && v.Uint64Value <= uint64(max)
+	}
+	return false
+}
+
+// Checks that every constant in the filter is compatible with the field of
+// the message it is compared to, so that filters which could never match are
+// rejected rather than silently returning no documents.
+// Comparisons between two fields are also rejected, unless fieldComparisons is
+// set, as they are then evaluated once documents are retrieved.
+func checkTypes(msg protoreflect.MessageDescriptor, e *expr.Expr, fieldComparisons bool) error {
+	call := e.GetCallExpr()
+	if call == nil {
+		return nil
+	}
+	for _, arg := range call.GetArgs() {
+		if err := checkTypes(msg, arg, fieldComparisons); err != nil {
+			return err
+		}
+	}
+	if len(call.GetArgs()) != 2 {
+		return nil
+	}
+	switch call.GetFunction() {
+	case filtering.FunctionEquals, filtering.FunctionNotEquals,
+		filtering.FunctionLessThan, filtering.FunctionLessEquals,
+		filtering.FunctionGreaterThan, filtering.FunctionGreaterEquals:
+		if left, ok := selectName(call.Args[0]); ok {
+			if right, ok := selectName(call.Args[1]); ok {
+				if fieldComparisons {
+					return nil
+				}
+				return &CrossFieldError{Field: left, Other: right, Function: call.GetFunction()}
+			}
+		}
+		if timeCall(call.Args[1]) {
+			_, err := timeConstant(call.Args[1])
+			return err
+		}
+		if nullArg(call.Args[1]) {
+			return nil
+		}
+		field, c := call.Args[0], call.Args[1].GetConstExpr()
+		if c == nil {
+			field, c = call.Args[1], call.Args[0].GetConstExpr()
+		}
+		fd, name, ok := resolveField(msg, field)
+		if !ok || c == nil || fd.IsList() || fd.IsMap() {
+			return nil
+		}
+		if wrapperField(fd) {
+			fd = fd.Message().Fields().ByName(wrapperValueName)
+		}
+		if timestampField(fd) {
+			_, err := timestampConstant(c)
+			return err
+		}
+		if fd.Enum() != nil {
+			// Enum values may also be named by strings.
+			if enumValue(fd, &expr.Expr{ExprKind: &expr.Expr_ConstExpr{ConstExpr: c}}) == nil {
+				return &FieldTypeError{Field: name, Kind: fd.Kind(), Value: unwrapConst(c)}
+			}
+			return nil
+		}
+		if !compatible(fd.Kind(), c) {
+			return &FieldTypeError{Field: name, Kind: fd.Kind(), Value: unwrapConst(c)}
+		}
+	case functionMatches:
+		// Invalid patterns are rejected before any documents are retrieved.
+		if c := call.Args[1].GetConstExpr(); c != nil {
+			if _, err := compilePattern(c.GetStringValue()); err != nil {
+				return err
+			}
+		}
+	case filtering.FunctionHas:
+		fd, name, ok := resolveField(msg, call.Args[0])
+		c := call.Args[1].GetConstExpr()
+		if !ok || c == nil || !fd.IsList() {
+			return nil
+		}
+		if !compatible(fd.Kind(), c) {
+			return &FieldTypeError{Field: name, Kind: fd.Kind(), Value: unwrapConst(c)}
+		}
+	}
+	return nil
+}
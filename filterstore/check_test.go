@@ -0,0 +1,101 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+func TestCompatible(t *testing.T) {
+	for _, tc := range []struct {
+		kind protoreflect.Kind
+		c    *expr.Constant
+		want bool
+	}{
+		{protoreflect.BoolKind, &expr.Constant{ConstantKind: &expr.Constant_BoolValue{BoolValue: true}}, true},
+		{protoreflect.BoolKind, &expr.Constant{ConstantKind: &expr.Constant_Int64Value{Int64Value: 1}}, false},
+		{protoreflect.StringKind, &expr.Constant{ConstantKind: &expr.Constant_StringValue{StringValue: "a"}}, true},
+		{protoreflect.BytesKind, &expr.Constant{ConstantKind: &expr.Constant_StringValue{StringValue: "a"}}, true},
+		{protoreflect.Int32Kind, &expr.Constant{ConstantKind: &expr.Constant_Int64Value{Int64Value: math.MaxInt32}}, true},
+		{protoreflect.Int32Kind, &expr.Constant{ConstantKind: &expr.Constant_Int64Value{Int64Value: math.MaxInt32 + 1}}, false},
+		{protoreflect.Uint32Kind, &expr.Constant{ConstantKind: &expr.Constant_Int64Value{Int64Value: -1}}, false},
+		{protoreflect.Uint64Kind, &expr.Constant{ConstantKind: &expr.Constant_Uint64Value{Uint64Value: math.MaxUint64}}, true},
+		{protoreflect.Int64Kind, &expr.Constant{ConstantKind: &expr.Constant_Uint64Value{Uint64Value: math.MaxUint64}}, false},
+		{protoreflect.Int64Kind, &expr.Constant{ConstantKind: &expr.Constant_StringValue{StringValue: "1"}}, false},
+		{protoreflect.FloatKind, &expr.Constant{ConstantKind: &expr.Constant_DoubleValue{DoubleValue: 1.5}}, true},
+		{protoreflect.FloatKind, &expr.Constant{ConstantKind: &expr.Constant_DoubleValue{DoubleValue: 1e39}}, false},
+		{protoreflect.EnumKind, &expr.Constant{ConstantKind: &expr.Constant_Int64Value{Int64Value: 1}}, false},
+	} {
+		if got := compatible(tc.kind, tc.c); got != tc.want {
+			t.Errorf("compatible(%v, %v) = %t, want %t", tc.kind, tc.c, got, tc.want)
+		}
+	}
+}
+
+func TestCheckTypes(t *testing.T) {
+	transpiler := newTestTranspiler(t, nil)
+	if _, err := transpiler.parse(filterRequest("test_filtering.default_float = 1.5")); err != nil {
+		t.Errorf("parse() err = %v, want <nil>", err)
+	}
+	_, err := transpiler.parse(filterRequest("test_filtering.default_float = 1000000000000000000000000000000000000000.0"))
+	var typeErr *FieldTypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatalf("parse() err = %v, want *FieldTypeError", err)
+	}
+	if typeErr.Field != "test_filtering.default_float" || typeErr.Kind != protoreflect.FloatKind {
+		t.Errorf("parse() err = %+v, want field test_filtering.default_float of kind float", typeErr)
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("status.Code(%v) = %v, want %v", err, status.Code(err), codes.InvalidArgument)
+	}
+}
+
+func TestCheckPattern(t *testing.T) {
+	transpiler := newTestTranspiler(t, nil)
+	if _, err := transpiler.parse(filterRequest(`matches(test_filtering.filterable_primitive, "^a[0-9]+$")`)); err != nil {
+		t.Errorf("parse() err = %v, want <nil>", err)
+	}
+	// Patterns are compiled even where matches() cannot be used.
+	if _, err := transpiler.parse(filterRequest(`NOT matches(test_filtering.filterable_primitive, "a(")`)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("parse(invalid pattern) err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestCheckCrossField(t *testing.T) {
+	transpiler := newTestTranspiler(t, nil)
+	_, err := transpiler.parse(filterRequest("test_filtering.filterable_submessage.filterable_primitive < test_filtering.default_submessage.filterable_primitive"))
+	var crossErr *CrossFieldError
+	if !errors.As(err, &crossErr) {
+		t.Fatalf("parse() err = %v, want *CrossFieldError", err)
+	}
+	want := CrossFieldError{Field: "test_filtering.filterable_submessage.filterable_primitive", Other: "test_filtering.default_submessage.filterable_primitive", Function: "<"}
+	if *crossErr != want {
+		t.Errorf("parse() err = %+v, want %+v", *crossErr, want)
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("status.Code(%v) = %v, want %v", err, status.Code(err), codes.InvalidArgument)
+	}
+	if _, err := transpiler.parse(filterRequest("test_filtering.default_enum = VALUE_1")); err != nil {
+		t.Errorf("parse(enum) err = %v, want <nil>", err)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ChangeKind is the kind of a FilterChange.
+type ChangeKind string
+
+const (
+	// A filterable field was removed, or is no longer filterable.
+	ChangeRemoved ChangeKind = "REMOVED"
+	// A filterable field was renamed, keeping its field number.
+	ChangeRenamed ChangeKind = "RENAMED"
+	// The type a field is filtered as changed, such as from a string to an
+	// int, or from a singular field to a repeated field.
+	ChangeTypeChanged ChangeKind = "TYPE_CHANGED"
+	// A value of a filterable enum field was removed.
+	ChangeEnumValueRemoved ChangeKind = "ENUM_VALUE_REMOVED"
+	// A filterable field was added.
+	ChangeAdded ChangeKind = "ADDED"
+)
+
+// FilterChange is a change to the fields a message can be filtered on.
+type FilterChange struct {
+	Kind ChangeKind
+	// The dot separated path of the field in the old message, such as
+	// `submessage.value`, or in the new message if it was added.
+	Field   string
+	Message string
+}
+
+// Breaking reports whether filters valid for the old message may be invalid,
+// or match differently, for the new message.
+func (c FilterChange) Breaking() bool {
+	return c.Kind != ChangeAdded
+}
+
+func (c FilterChange) String() string {
+	return fmt.Sprintf("%s(%s): %s", c.Kind, c.Field, c.Message)
+}
+
+// CompareFilterFields reports the changes to the filterable fields of a
+// message between two versions of its descriptor, such as before and after a
+// proto change, so that changes which would break existing filters, such as
+// saved filters, can be caught before they are rolled out.
+// Changes are ordered by field.
+func CompareFilterFields(old, new protoreflect.MessageDescriptor) []FilterChange {
+	before := filterFields(old, "", "", map[protoreflect.FullName]bool{})
+	after := filterFields(new, "", "", map[protoreflect.FullName]bool{})
+	var changes []FilterChange
+	for path, f := range before {
+		g, ok := after[path]
+		if !ok {
+			if renamed, ok := numbersOf(after)[f.numbers]; ok {
+				changes = append(changes, FilterChange{Kind: ChangeRenamed, Field: path, Message: fmt.Sprintf("renamed to %s", renamed)})
+			} else {
+				changes = append(changes, FilterChange{Kind: ChangeRemoved, Field: path, Message: "no longer filterable"})
+			}
+			continue
+		}
+		if f.typ != g.typ {
+			changes = append(changes, FilterChange{Kind: ChangeTypeChanged, Field: path, Message: fmt.Sprintf("changed from %s to %s", f.typ, g.typ)})
+			continue
+		}
+		for _, value := range f.enumValues {
+			if !contains(g.enumValues, value) {
+				changes = append(changes, FilterChange{Kind: ChangeEnumValueRemoved, Field: path, Message: fmt.Sprintf("value %s was removed", value)})
+			}
+		}
+	}
+	for path, g := range after {
+		if _, ok := before[path]; !ok {
+			if _, renamed := numbersOf(before)[g.numbers]; !renamed {
+				changes = append(changes, FilterChange{Kind: ChangeAdded, Field: path, Message: fmt.Sprintf("filterable as %s", g.typ)})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Field != changes[j].Field {
+			return changes[i].Field < changes[j].Field
+		}
+		return changes[i].Kind < changes[j].Kind
+	})
+	return changes
+}
+
+// A filterable field of a message.
+type filterField struct {
+	// The dot separated field numbers of the path, which identify the field
+	// across renames.
+	numbers string
+	// The type the field is filtered as.
+	typ        string
+	enumValues []string
+}
+
+// Returns the filterable fields of the message, and of the messages it
+// contains, by their dot separated path.
+func filterFields(msg protoreflect.MessageDescriptor, prefix, numbers string, seen map[protoreflect.FullName]bool) map[string]filterField {
+	fields := map[string]filterField{}
+	if seen[msg.FullName()] {
+		return fields
+	}
+	seen[msg.FullName()] = true
+	defer delete(seen, msg.FullName())
+	for i := 0; i < msg.Fields().Len(); i++ {
+		fd := msg.Fields().Get(i)
+		if !filterable(fd) {
+			continue
+		}
+		path := prefix + string(fd.Name())
+		number := fmt.Sprintf("%s%d", numbers, fd.Number())
+		f := filterField{numbers: number, typ: filterTypeName(fd)}
+		if e := fd.Enum(); e != nil {
+			for j := 0; j < e.Values().Len(); j++ {
+				f.enumValues = append(f.enumValues, string(e.Values().Get(j).Name()))
+			}
+		}
+		fields[path] = f
+		if fd.Message() != nil && !fd.IsList() && !fd.IsMap() {
+			for p, f := range filterFields(fd.Message(), path+".", number+".", seen) {
+				fields[p] = f
+			}
+		}
+	}
+	return fields
+}
+
+// Returns the name of the type a field is filtered as.
+func filterTypeName(fd protoreflect.FieldDescriptor) string {
+	switch {
+	case fd.IsMap():
+		return fmt.Sprintf("map<%s, %s>", filterTypeName(fd.MapKey()), filterTypeName(fd.MapValue()))
+	case fd.IsList():
+		return fmt.Sprintf("list<%s>", elementTypeName(fd))
+	}
+	return elementTypeName(fd)
+}
+
+// Returns the name of the type of a field, ignoring whether it is repeated.
+func elementTypeName(fd protoreflect.FieldDescriptor) string {
+	switch {
+	case fd.Enum() != nil:
+		return string(fd.Enum().FullName())
+	case fd.Message() != nil:
+		return string(fd.Message().FullName())
+	}
+	return typeName(elementType(fd))
+}
+
+// Returns the paths of the fields by their field numbers.
+func numbersOf(fields map[string]filterField) map[string]string {
+	paths := map[string]string{}
+	for path, f := range fields {
+		paths[f.numbers] = path
+	}
+	return paths
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Returns the Item message of a file with the provided fields and enum values.
+func itemDescriptor(t *testing.T, fields []*descriptorpb.FieldDescriptorProto, values ...string) protoreflect.MessageDescriptor {
+	t.Helper()
+	enum := &descriptorpb.EnumDescriptorProto{Name: proto.String("Status")}
+	for i, v := range values {
+		enum.Value = append(enum.Value, &descriptorpb.EnumValueDescriptorProto{Name: proto.String(v), Number: proto.Int32(int32(i))})
+	}
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:     proto.String("item.proto"),
+		Package:  proto.String("test"),
+		Syntax:   proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{enum},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Item"), Field: fields},
+			{Name: proto.String("Sub"), Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("value"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()},
+			}},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("protodesc.NewFile() err = %v, want <nil>", err)
+	}
+	return fd.Messages().ByName("Item")
+}
+
+func fieldProto(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+	f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: typ.Enum()}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	if repeated {
+		f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	}
+	return f
+}
+
+func TestCompareFilterFields(t *testing.T) {
+	old := itemDescriptor(t, []*descriptorpb.FieldDescriptorProto{
+		fieldProto("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+		fieldProto("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, "", false),
+		fieldProto("status", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.Status", false),
+		fieldProto("sub", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Sub", false),
+		fieldProto("tags", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", true),
+	}, "UNKNOWN", "ACTIVE", "DELETED")
+	new := itemDescriptor(t, []*descriptorpb.FieldDescriptorProto{
+		fieldProto("title", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+		fieldProto("count", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+		fieldProto("status", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.Status", false),
+		fieldProto("sub", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Sub", false),
+		fieldProto("tags", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+		fieldProto("size", 6, descriptorpb.FieldDescriptorProto_TYPE_INT64, "", false),
+	}, "UNKNOWN", "ACTIVE")
+	want := []FilterChange{
+		{Kind: ChangeTypeChanged, Field: "count", Message: "changed from int64 to string"},
+		{Kind: ChangeRenamed, Field: "name", Message: "renamed to title"},
+		{Kind: ChangeAdded, Field: "size", Message: "filterable as int64"},
+		{Kind: ChangeEnumValueRemoved, Field: "status", Message: "value DELETED was removed"},
+		{Kind: ChangeTypeChanged, Field: "tags", Message: "changed from list<string> to string"},
+	}
+	got := CompareFilterFields(old, new)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("CompareFilterFields() diff (-want +got):\n%s", diff)
+	}
+	for _, c := range got {
+		if c.Breaking() != (c.Kind != ChangeAdded) {
+			t.Errorf("%v.Breaking() = %t, want %t", c, c.Breaking(), c.Kind != ChangeAdded)
+		}
+	}
+	if got := CompareFilterFields(old, old); len(got) != 0 {
+		t.Errorf("CompareFilterFields(old, old) = %v, want none", got)
+	}
+	removed := itemDescriptor(t, []*descriptorpb.FieldDescriptorProto{
+		fieldProto("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+	}, "UNKNOWN")
+	want = []FilterChange{
+		{Kind: ChangeRemoved, Field: "count", Message: "no longer filterable"},
+		{Kind: ChangeRemoved, Field: "status", Message: "no longer filterable"},
+		{Kind: ChangeRemoved, Field: "sub", Message: "no longer filterable"},
+		{Kind: ChangeRemoved, Field: "sub.value", Message: "no longer filterable"},
+		{Kind: ChangeRemoved, Field: "tags", Message: "no longer filterable"},
+	}
+	if diff := cmp.Diff(want, CompareFilterFields(old, removed)); diff != "" {
+		t.Errorf("CompareFilterFields(removed) diff (-want +got):\n%s", diff)
+	}
+}
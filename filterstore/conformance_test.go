@@ -0,0 +1,215 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kagadar/go_firestore_filtering/conformance"
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+// conformanceBackend stores the conformance documents under a parent unique
+// to the run, so that runs do not observe each other.
+type conformanceBackend struct {
+	transpiler *Transpiler[*test.TestFiltering]
+	parent     string
+}
+
+func (b *conformanceBackend) Load(ctx context.Context, docs map[string]*test.TestFiltering) error {
+	for id, doc := range docs {
+		if err := b.transpiler.Set(ctx, b.parent, id, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *conformanceBackend) List(ctx context.Context, filter string) ([]*test.TestFiltering, error) {
+	req := &test.ListTestRequest{Parent: b.parent, Filter: filter}
+	page, err := b.transpiler.List(ctx, req, Unbounded())
+	if err != nil {
+		return nil, err
+	}
+	// The size of the first page, and the total size, must agree.
+	req.PageSize = 1
+	first, err := b.transpiler.List(ctx, req, TotalSize())
+	if err != nil {
+		return nil, err
+	}
+	if want := int64(len(page.Items)); first.TotalSize == nil || *first.TotalSize != want {
+		return nil, status.Errorf(codes.Internal, "List(TotalSize()) total size = %v, want %d", first.TotalSize, want)
+	}
+	// A count only request must agree, without retrieving any documents.
+	req.PageSize = 0
+	counted, err := b.transpiler.List(ctx, req, TotalSize(), CountOnly())
+	if err != nil {
+		return nil, err
+	}
+	if want := int64(len(page.Items)); len(counted.Items) != 0 || counted.TotalSize == nil || *counted.TotalSize != want {
+		return nil, status.Errorf(codes.Internal, "List(TotalSize(), CountOnly()) = %d items with total size %v, want 0 items with total size %d", len(counted.Items), counted.TotalSize, want)
+	}
+	return page.Items, nil
+}
+
+// memoryBackend evaluates filters in memory with Evaluate, so that its results
+// can be compared to those of Firestore.
+type memoryBackend struct {
+	transpiler *Transpiler[*test.TestFiltering]
+	docs       map[string]*test.TestFiltering
+}
+
+func (b *memoryBackend) Load(ctx context.Context, docs map[string]*test.TestFiltering) error {
+	b.docs = docs
+	return nil
+}
+
+func (b *memoryBackend) List(ctx context.Context, filter string) ([]*test.TestFiltering, error) {
+	f, err := b.transpiler.parse(&test.ListTestRequest{Filter: filter})
+	if err != nil {
+		return nil, err
+	}
+	var matches []*test.TestFiltering
+	for _, doc := range b.docs {
+		ok, err := Evaluate(f, doc)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, doc)
+		}
+	}
+	return matches, nil
+}
+
+// extendedBackend evaluates filters in memory with Evaluate, checking them
+// against the descriptor of the test filters, which has the fields of the
+// extended conformance documents.
+type extendedBackend struct {
+	t    *testing.T
+	docs map[string]proto.Message
+}
+
+func (b *extendedBackend) Load(ctx context.Context, docs map[string]proto.Message) error {
+	b.docs = docs
+	return nil
+}
+
+func (b *extendedBackend) List(ctx context.Context, filter string) ([]proto.Message, error) {
+	checked, err := parseFilter(filter, testDeclarations(b.t), testDescriptor, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	var matches []proto.Message
+	for _, doc := range b.docs {
+		ok, err := Evaluate(filtering.Filter{CheckedExpr: checked}, doc)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, doc)
+		}
+	}
+	return matches, nil
+}
+
+// Runs the conformance suite against the Firestore emulator, if one is
+// configured.
+func TestConformance(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	c, err := firestore.NewClient(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("firestore.NewClient() err = %v, want <nil>", err)
+	}
+	defer c.Close()
+	conformance.Run(t, &conformanceBackend{
+		transpiler: newTestTranspiler(t, c),
+		parent:     fmt.Sprintf("runs/%d", time.Now().UnixNano()),
+	})
+}
+
+// Runs the conformance suite against Evaluate, which must agree with
+// Firestore.
+func TestConformanceEvaluate(t *testing.T) {
+	conformance.Run(t, &memoryBackend{transpiler: newTestTranspiler(t, nil)})
+}
+
+// Runs the extended conformance suite against Evaluate. The Transpiler of the
+// test service only stores TestFiltering messages, so the extended cases are
+// only transpiled, by TestConformanceTranspileExtended.
+func TestConformanceEvaluateExtended(t *testing.T) {
+	conformance.RunExtended(t, &extendedBackend{t: t}, testDescriptor)
+}
+
+// Checks that every extended conformance case transpiles, or fails with the
+// expected code.
+func TestConformanceTranspileExtended(t *testing.T) {
+	for _, tc := range conformance.ExtendedCases() {
+		t.Run(tc.Name, func(t *testing.T) {
+			checked, err := parseFilter(tc.Filter, testDeclarations(t), testDescriptor, nil, false)
+			if err == nil {
+				var q *query
+				if q, err = newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{}, checked, capabilities{}); err == nil {
+					var qs []firestore.Query
+					if qs, err = q.build(); err == nil {
+						serialize(t, qs)
+					}
+				}
+			}
+			if code := status.Code(err); code != tc.Code {
+				t.Errorf("transpile(%q) err = %v, want code %v", tc.Filter, err, tc.Code)
+			}
+		})
+	}
+}
+
+// Checks that every conformance case transpiles, or fails with the expected
+// code, without a Firestore backend.
+func TestConformanceTranspile(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	for _, tc := range conformance.Cases() {
+		t.Run(tc.Name, func(t *testing.T) {
+			r, err := transpiler.listRequest(context.Background(), &test.ListTestRequest{Parent: "parents/p", Filter: tc.Filter}, callOptions{})
+			if err == nil {
+				var q *query
+				if q, err = listQuery(transpiler.client, transpiler.collection, r, 0); err == nil {
+					var qs []firestore.Query
+					qs, err = q.build()
+					// Serializing validates the cursors against the orderings.
+					for _, q := range qs {
+						if _, serr := q.Serialize(); serr != nil {
+							t.Fatalf("transpile(%q).Serialize() err = %v, want <nil>", tc.Filter, serr)
+						}
+					}
+				}
+			}
+			if code := status.Code(err); code != tc.Code {
+				t.Errorf("transpile(%q) err = %v, want code %v", tc.Filter, err, tc.Code)
+			}
+		})
+	}
+}
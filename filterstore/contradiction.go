@@ -0,0 +1,202 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"go.einride.tech/aip/filtering"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// The values of a field allowed by the terms of a conjunction.
+type valueRange struct {
+	// The values the field is restricted to by `=` or `in`, if restricted is
+	// set.
+	values     []interface{}
+	restricted bool
+	excluded   []interface{}
+	// The bounds of the field, which are nil if it is unbounded.
+	lower, upper                   interface{}
+	lowerInclusive, upperInclusive bool
+	// Whether values which cannot be compared were compared, in which case
+	// nothing is concluded.
+	incomparable bool
+}
+
+// Restricts the range to the values it already allows which are also in
+// values.
+func (r *valueRange) restrict(values []interface{}) {
+	if !r.restricted {
+		r.values, r.restricted = values, true
+		return
+	}
+	var kept []interface{}
+	for _, v := range r.values {
+		for _, other := range values {
+			c, ok := compareValues(v, other)
+			r.incomparable = r.incomparable || !ok
+			if ok && c == 0 {
+				kept = append(kept, v)
+				break
+			}
+		}
+	}
+	r.values = kept
+}
+
+// Raises the lower bound of the range to v, if it is higher.
+func (r *valueRange) raise(v interface{}, inclusive bool) {
+	if r.lower != nil {
+		c, ok := compareValues(v, r.lower)
+		r.incomparable = r.incomparable || !ok
+		if !ok || c < 0 || (c == 0 && inclusive) {
+			return
+		}
+	}
+	r.lower, r.lowerInclusive = v, inclusive
+}
+
+// Lowers the upper bound of the range to v, if it is lower.
+func (r *valueRange) cap(v interface{}, inclusive bool) {
+	if r.upper != nil {
+		c, ok := compareValues(v, r.upper)
+		r.incomparable = r.incomparable || !ok
+		if !ok || c > 0 || (c == 0 && inclusive) {
+			return
+		}
+	}
+	r.upper, r.upperInclusive = v, inclusive
+}
+
+// Reports whether the range allows v. Values which cannot be compared to the
+// bounds are allowed.
+func (r *valueRange) allows(v interface{}) bool {
+	if r.lower != nil {
+		if c, ok := compareValues(v, r.lower); ok && (c < 0 || (c == 0 && !r.lowerInclusive)) {
+			return false
+		}
+	}
+	if r.upper != nil {
+		if c, ok := compareValues(v, r.upper); ok && (c > 0 || (c == 0 && !r.upperInclusive)) {
+			return false
+		}
+	}
+	for _, excluded := range r.excluded {
+		if c, ok := compareValues(v, excluded); ok && c == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether the range certainly allows no values.
+func (r *valueRange) empty() bool {
+	if r.incomparable {
+		return false
+	}
+	if r.lower != nil && r.upper != nil {
+		c, ok := compareValues(r.lower, r.upper)
+		if ok && (c > 0 || (c == 0 && !(r.lowerInclusive && r.upperInclusive))) {
+			return true
+		}
+	}
+	if !r.restricted {
+		return false
+	}
+	for _, v := range r.values {
+		if r.allows(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the path of the field and the stored value of a comparison of a
+// singular field with a constant, such as `a = 1` or `a > 1`.
+// Returns false if the call is not such a comparison.
+func (q *query) comparison(call *expr.Expr_Call) (firestore.FieldPath, interface{}, bool) {
+	if _, ok := negatedComparisons[call.GetFunction()]; !ok {
+		return nil, nil, false
+	}
+	// Trailing wildcards match prefixes, rather than the string.
+	if len(call.GetArgs()) != 2 || !constantArg(call.Args[1]) || nullArg(call.Args[1]) || strings.HasSuffix(call.Args[1].GetConstExpr().GetStringValue(), "*") {
+		return nil, nil, false
+	}
+	if _, list := q.types[call.Args[0].Id].GetTypeKind().(*expr.Type_ListType_); list {
+		return nil, nil, false
+	}
+	path, err := q.fieldPath(call.Args[0])
+	if err != nil || len(path) == 0 {
+		return nil, nil, false
+	}
+	v, err := q.value(call.Args[0], call.Args[1])
+	if err != nil {
+		return nil, nil, false
+	}
+	return path, v, true
+}
+
+// Returns the path of a field contradicted by the terms of a conjunction,
+// which restrict it to values which do not overlap, such as
+// `state = "A" AND state = "B"` or `x > 5 AND x < 2`.
+// Only comparisons of a field with a constant, and disjunctions of `=`, such
+// as `state = "A" OR state = "B"`, are considered.
+// Returns false if no contradiction is found.
+func (q *query) contradiction(terms []*expr.Expr) (firestore.FieldPath, bool) {
+	var paths []firestore.FieldPath
+	ranges := map[string]*valueRange{}
+	valueRange := func(path firestore.FieldPath) *valueRange {
+		r, ok := ranges[pathString(path)]
+		if !ok {
+			r = &valueRange{}
+			ranges[pathString(path)] = r
+			paths = append(paths, path)
+		}
+		return r
+	}
+	for _, term := range terms {
+		call := term.GetCallExpr()
+		if call.GetFunction() == filtering.FunctionOr {
+			if path, values, ok := q.valueSet(call, filtering.FunctionOr, filtering.FunctionEquals); ok {
+				valueRange(path).restrict(values)
+			}
+			continue
+		}
+		path, v, ok := q.comparison(call)
+		if !ok {
+			continue
+		}
+		switch call.Function {
+		case filtering.FunctionEquals:
+			valueRange(path).restrict([]interface{}{v})
+		case filtering.FunctionNotEquals:
+			r := valueRange(path)
+			r.excluded = append(r.excluded, v)
+		case filtering.FunctionGreaterThan, filtering.FunctionGreaterEquals:
+			valueRange(path).raise(v, call.Function == filtering.FunctionGreaterEquals)
+		case filtering.FunctionLessThan, filtering.FunctionLessEquals:
+			valueRange(path).cap(v, call.Function == filtering.FunctionLessEquals)
+		}
+	}
+	for _, path := range paths {
+		if ranges[pathString(path)].empty() {
+			return path, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestContradiction(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		want   string
+	}{
+		{`test_filtering.filterable_primitive = "a" AND test_filtering.filterable_primitive = "b"`, "FilterablePrimitive"},
+		{`test_filtering.default_float > 5.0 AND test_filtering.default_float < 2.0`, "DefaultFloat"},
+		{`test_filtering.default_float > 2.0 AND test_filtering.default_float <= 2.0`, "DefaultFloat"},
+		{`test_filtering.default_float = 1.5 AND test_filtering.default_float != 1.5`, "DefaultFloat"},
+		{`(test_filtering.filterable_primitive = "a" OR test_filtering.filterable_primitive = "b") AND test_filtering.filterable_primitive = "c"`, "FilterablePrimitive"},
+		{`test_filtering.filterable_primitive > "b" AND (test_filtering.filterable_primitive = "a" OR test_filtering.filterable_primitive = "b")`, "FilterablePrimitive"},
+		{`test_filtering.default_float >= 2.0 AND test_filtering.default_float <= 2.0`, ""},
+		{`test_filtering.filterable_primitive = "a" AND test_filtering.default_float = 1.5`, ""},
+		{`test_filtering.filterable_primitive = "a*" AND test_filtering.filterable_primitive = "ab"`, ""},
+		{`(test_filtering.filterable_primitive = "a" OR test_filtering.filterable_primitive = "b") AND test_filtering.filterable_primitive != "a"`, ""},
+	} {
+		filter := parse(t, tc.filter)
+		q := &query{msg: testDescriptor, types: filter.GetTypeMap()}
+		path, ok := q.contradiction(conjuncts(filter.GetExpr()))
+		if got := pathString(path); got != tc.want || ok != (tc.want != "") {
+			t.Errorf("contradiction(%q) = %q, %t, want %q, %t", tc.filter, got, ok, tc.want, tc.want != "")
+		}
+	}
+}
+
+func TestTranspileContradiction(t *testing.T) {
+	filter := `test_filtering.filterable_primitive = "a" AND test_filtering.filterable_primitive = "b"`
+	q, err := newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{}, parse(t, filter), capabilities{})
+	if err != nil {
+		t.Fatalf("newQuery(%q) err = %v, want <nil>", filter, err)
+	}
+	qs, err := q.build()
+	if err != nil || len(qs) != 0 {
+		t.Errorf("build(%q) = %d queries, %v, want 0 queries, <nil>", filter, len(qs), err)
+	}
+	want := []Warning{{Kind: WarningContradiction, Field: "FilterablePrimitive", Message: "filtered to values which do not overlap, so no documents can match"}}
+	if diff := cmp.Diff(want, q.warnings); diff != "" {
+		t.Errorf("newQuery(%q) warnings diff (-want +got):\n%s", filter, diff)
+	}
+}
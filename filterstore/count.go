@@ -0,0 +1,92 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"go.einride.tech/aip/ordering"
+)
+
+// Count returns the number of documents in the collection of the parent of
+// the request which match its filter. The order_by, page size and page token
+// of the request are ignored.
+//
+// The count is emulated, as described in Emulated Queries in the package
+// documentation, by a query which only reads the names of the documents.
+func (t *Transpiler[T]) Count(ctx context.Context, req protoexpr.ListRequest, opts ...CallOption) (int64, error) {
+	start := t.options.now()
+	n, err := t.countRequest(ctx, req, opts)
+	t.recordQuery(ctx, "Count", start, nil, err)
+	return n, err
+}
+
+// Counts the documents matching the request, for Count.
+func (t *Transpiler[T]) countRequest(ctx context.Context, req protoexpr.ListRequest, opts []CallOption) (int64, error) {
+	o := t.callOptions(opts)
+	// Documents are not ordered, so that documents without a value for an
+	// ordered field are counted.
+	o.orderBy = &ordering.OrderBy{}
+	r, err := t.listRequest(ctx, firstPageRequest{req}, o)
+	if err != nil {
+		return 0, err
+	}
+	n, err := count(ctx, t.reader(o), t.collection, r)
+	if err != nil {
+		return 0, err
+	}
+	if err := t.resultPolicy(ctx).checkAggregation(n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Counts the documents matching the request, ignoring its order_by and page
+// token.
+func count(ctx context.Context, client *firestore.Client, collection string, r *listRequest) (int64, error) {
+	var n int64
+	err := eachMatching(ctx, client.Collection(fmt.Sprintf("%s/%s", r.parent, collection)).Select(), r, func(*firestore.DocumentSnapshot) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Calls fn with each document of the base query which matches the request,
+// ignoring its order_by and page token. The documents are streamed, so only
+// the document being passed to fn is held in memory, but every matching
+// document is still read.
+func eachMatching(ctx context.Context, base firestore.Query, r *listRequest, fn func(*firestore.DocumentSnapshot) error) error {
+	q, err := newQuery(base, r.msg, r.names, r.rest, r.caps)
+	if err != nil {
+		return err
+	}
+	if r.refs != nil {
+		if err := q.transpileDocuments(r.refs); err != nil {
+			return err
+		}
+	}
+	qs, err := q.build()
+	if err != nil {
+		return err
+	}
+	return forEach(ctx, qs, fn)
+}
@@ -0,0 +1,65 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/go-cmp/cmp"
+	fspb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestCountInvalidFilter(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	if _, err := transpiler.Count(context.Background(), &test.ListTestRequest{Filter: "test_filtering.missing = 1"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Count() err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestCount(t *testing.T) {
+	transpiler, fake, _ := fakeTranspiler(t, 10)
+	for _, tc := range []struct {
+		filter string
+		want   int64
+	}{
+		{"", 10},
+		{`test_filtering.filterable_primitive > "d06"`, 3},
+		{`test_filtering.filterable_primitive = "d02" OR test_filtering.filterable_primitive = "d04"`, 2},
+		{`test_filtering.filterable_primitive = "x"`, 0},
+	} {
+		// The page size is ignored.
+		got, err := transpiler.Count(context.Background(), &test.ListTestRequest{Parent: "parents/p", PageSize: 2, Filter: tc.filter})
+		if err != nil {
+			t.Fatalf("Count(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if got != tc.want {
+			t.Errorf("Count(%q) = %d, want %d", tc.filter, got, tc.want)
+		}
+	}
+	// Only the names of the documents are read.
+	for _, q := range fake.queries() {
+		want := &fspb.StructuredQuery_Projection{Fields: []*fspb.StructuredQuery_FieldReference{{FieldPath: firestore.DocumentID}}}
+		if diff := cmp.Diff(want, q.GetSelect(), protocmp.Transform()); diff != "" {
+			t.Errorf("Count() projection diff (-want +got):\n%s", diff)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"github.com/kagadar/go_proto_expression/protoexpr"
+)
+
+// Cursor is a position within the documents matching a request, in the form
+// used by the cursors of native Firestore queries, such as Query.StartAfter.
+// It allows pagination to be handed off between transpiled and native
+// queries of the same collection.
+type Cursor struct {
+	// The values of the fields the documents are ordered by, in the order of
+	// the EffectiveOrderBy, excluding the document ID.
+	Values []interface{}
+	// The ID of the document the position is relative to.
+	ID string
+	// Whether the position is before the document, rather than after it.
+	Before bool
+}
+
+// Args returns the cursor values of a native Firestore query ordered by the
+// EffectiveOrderBy: the Values, followed by the ID.
+// The position is used with Query.StartAfter, or Query.EndBefore if Before is
+// set.
+func (c Cursor) Args() []interface{} {
+	return append(c.Values[:len(c.Values):len(c.Values)], c.ID)
+}
+
+// ExportCursor returns the position of the page token of the request, or nil
+// if the request has no page token.
+func (t *Transpiler[T]) ExportCursor(req protoexpr.ListRequest) (*Cursor, error) {
+	r, err := t.parseListRequest(req, callOptions{})
+	if err != nil || r.token == nil {
+		return nil, err
+	}
+	c := &Cursor{ID: r.token.Cursor, Before: r.token.Before}
+	for _, v := range r.token.Values {
+		c.Values = append(c.Values, v.value())
+	}
+	return c, nil
+}
+
+// ImportCursor returns a page token for the request, positioned at the cursor.
+// The page token of the request is ignored.
+func (t *Transpiler[T]) ImportCursor(req protoexpr.ListRequest, c Cursor) (string, error) {
+	r, err := t.parseListRequest(firstPageRequest{req}, callOptions{})
+	if err != nil {
+		return "", err
+	}
+	q, err := listQuery(t.client, t.collection, r, 1)
+	if err != nil {
+		return "", err
+	}
+	// The query may be ordered by the document ID last, which is not a value.
+	if values := len(tokenOrders(q.orders)); len(c.Values) != values {
+		return "", invalidArgument(ErrInvalidCursor, "cursor has %d values, but the documents are ordered by %v", len(c.Values), q.orderBy)
+	}
+	token := &pageToken{Cursor: c.ID, Before: c.Before, Checksum: r.checksum}
+	for i, v := range c.Values {
+		value, err := newCursorValue(v)
+		if err != nil {
+			return "", invalidArgument(ErrInvalidCursor, "cursor value %d: %v", i, err)
+		}
+		token.Values = append(token.Values, value)
+	}
+	return t.tokens.encode(token)
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	req := &test.ListTestRequest{Parent: "parents/p", Filter: "test_filtering.default_float > 1.0"}
+	if c, err := transpiler.ExportCursor(req); err != nil || c != nil {
+		t.Errorf("ExportCursor() without page token = %v, %v, want <nil>, <nil>", c, err)
+	}
+	want := Cursor{Values: []interface{}{1.5}, ID: "b", Before: true}
+	token, err := transpiler.ImportCursor(req, Cursor{Values: []interface{}{float32(1.5)}, ID: "b", Before: true})
+	if err != nil {
+		t.Fatalf("ImportCursor() err = %v, want <nil>", err)
+	}
+	req.PageToken = token
+	got, err := transpiler.ExportCursor(req)
+	if err != nil {
+		t.Fatalf("ExportCursor() err = %v, want <nil>", err)
+	}
+	if diff := cmp.Diff(&want, got); diff != "" {
+		t.Errorf("ExportCursor() diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]interface{}{1.5, "b"}, got.Args()); diff != "" {
+		t.Errorf("Args() diff (-want +got):\n%s", diff)
+	}
+	// The page token is only valid for the request it was imported for.
+	req.Filter = "test_filtering.default_float > 2.0"
+	if _, err := transpiler.ExportCursor(req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ExportCursor() with different filter err = %v, want code %v", err, codes.InvalidArgument)
+	}
+	for _, c := range []Cursor{
+		{ID: "b"},
+		{Values: []interface{}{1.5, 2.5}, ID: "b"},
+		{Values: []interface{}{struct{}{}}, ID: "b"},
+	} {
+		if _, err := transpiler.ImportCursor(req, c); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("ImportCursor(%v) err = %v, want code %v", c, err, codes.InvalidArgument)
+		}
+	}
+}
+
+func TestImportCursorPresence(t *testing.T) {
+	transpiler := newMessageTranspiler(t, testClient(t), &descriptorpb.EnumValueDescriptorProto{})
+	// Without a page token, a check that a field is set orders the documents by
+	// the field alone.
+	req := &test.ListTestRequest{Parent: "parents/p", Filter: "enum_value_descriptor_proto.number:*"}
+	token, err := transpiler.ImportCursor(req, Cursor{Values: []interface{}{2}, ID: "b"})
+	if err != nil {
+		t.Fatalf("ImportCursor() err = %v, want <nil>", err)
+	}
+	req.PageToken = token
+	got, err := transpiler.ExportCursor(req)
+	if err != nil {
+		t.Fatalf("ExportCursor() err = %v, want <nil>", err)
+	}
+	if diff := cmp.Diff(&Cursor{Values: []interface{}{int64(2)}, ID: "b"}, got); diff != "" {
+		t.Errorf("ExportCursor() diff (-want +got):\n%s", diff)
+	}
+	if _, err := transpiler.ImportCursor(req, Cursor{ID: "b"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ImportCursor() without values err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+)
+
+// DebugState is a snapshot of the configuration and counters of a Transpiler.
+type DebugState struct {
+	// The full name of the message in the collection.
+	Message string
+	// The name of the collection.
+	Collection      string
+	DefaultPageSize int32
+	MaxPageSize     int32
+	// Whether a SlowQueryLog was provided.
+	SlowQueryLog bool
+	// The number of List calls, and how many of them failed.
+	Lists  int64
+	Errors int64
+	// The number of documents returned by List calls.
+	Documents int64
+	// The number of requests in the plan cache, and how many lookups found
+	// and did not find a cached request.
+	PlanCacheSize   int
+	PlanCacheHits   int64
+	PlanCacheMisses int64
+}
+
+// Counters for a Transpiler, which are safe for concurrent use.
+type counters struct {
+	lists     int64
+	errors    int64
+	documents int64
+}
+
+// Records the outcome of a List call.
+func (c *counters) list(documents int, err error) {
+	atomic.AddInt64(&c.lists, 1)
+	if err != nil {
+		atomic.AddInt64(&c.errors, 1)
+	}
+	atomic.AddInt64(&c.documents, int64(documents))
+}
+
+// DebugState returns a snapshot of the configuration and counters of the
+// Transpiler.
+func (t *Transpiler[T]) DebugState() DebugState {
+	size, hits, misses := t.plans.stats()
+	return DebugState{
+		Message:         string(t.emptyMessage.ProtoReflect().Descriptor().FullName()),
+		Collection:      t.collection,
+		DefaultPageSize: t.defaultPageSize,
+		MaxPageSize:     t.maxPageSize,
+		SlowQueryLog:    t.options.slowQueries != nil,
+		Lists:           atomic.LoadInt64(&t.counters.lists),
+		Errors:          atomic.LoadInt64(&t.counters.errors),
+		Documents:       atomic.LoadInt64(&t.counters.documents),
+		PlanCacheSize:   size,
+		PlanCacheHits:   hits,
+		PlanCacheMisses: misses,
+	}
+}
+
+// Debuggable is implemented by every Transpiler, regardless of its type.
+type Debuggable interface {
+	DebugState() DebugState
+}
+
+// DebugVars publishes the DebugState of registered Transpilers.
+//
+// DebugVars implements expvar.Var, so it can be published with expvar.Publish
+// or served by a debug handler.
+type DebugVars struct {
+	mu          sync.Mutex
+	transpilers map[string]Debuggable
+}
+
+// Register adds the Transpiler to the published state under the provided
+// name, replacing any Transpiler previously registered with that name.
+func (v *DebugVars) Register(name string, t Debuggable) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.transpilers == nil {
+		v.transpilers = map[string]Debuggable{}
+	}
+	v.transpilers[name] = t
+}
+
+// States returns the DebugState of every registered Transpiler, by name.
+func (v *DebugVars) States() map[string]DebugState {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	states := make(map[string]DebugState, len(v.transpilers))
+	for name, t := range v.transpilers {
+		states[name] = t.DebugState()
+	}
+	return states
+}
+
+// String returns the DebugState of every registered Transpiler as JSON.
+func (v *DebugVars) String() string {
+	b, err := json.Marshal(v.States())
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
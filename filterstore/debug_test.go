@@ -0,0 +1,46 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestDebugVars(t *testing.T) {
+	transpiler := newTestTranspiler(t, nil, WithSlowQueryLog(NewSlowQueryLog(1)))
+	transpiler.counters.list(3, nil)
+	transpiler.counters.list(0, errors.New("failed"))
+	var v DebugVars
+	v.Register("tests", transpiler)
+	var got map[string]DebugState
+	if err := json.Unmarshal([]byte(v.String()), &got); err != nil {
+		t.Fatalf("json.Unmarshal(String()) err = %v, want <nil>", err)
+	}
+	want := DebugState{
+		Message:         "kagadar.protoexpr.options.TestFiltering",
+		Collection:      "tests",
+		DefaultPageSize: 1000,
+		MaxPageSize:     10000,
+		SlowQueryLog:    true,
+		Lists:           2,
+		Errors:          1,
+		Documents:       3,
+	}
+	if got["tests"] != want {
+		t.Errorf("String()[tests] = %+v, want %+v", got["tests"], want)
+	}
+}
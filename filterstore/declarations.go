@@ -0,0 +1,280 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iancoleman/strcase"
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+
+	opb "github.com/kagadar/go_proto_expression/genproto/options"
+)
+
+// NewDeclarations returns the declarations of every filterable field of the
+// message, along with every function a Transpiler supports:
+//   - the einride standard functions: comparisons, `:`, AND, OR, NOT,
+//     timestamp() and duration()
+//   - `:` on repeated scalar fields, such as `tags:"a"` or `scores:1`
+//   - `:` on map fields with string keys, such as `labels:"env"`
+//   - `:*` on any field, such as `count:*`, which checks whether it is set
+//   - comparisons between timestamp fields and RFC 3339 strings, such as
+//     `create_time > "2024-01-01T00:00:00Z"`, and with timestamp() and
+//     duration(), such as `ttl < duration("24h")`, where durations are stored
+//     as a number of seconds
+//   - now(), and add() and sub() of a timestamp and a duration, such as
+//     `create_time > sub(now(), duration("24h"))`, which are resolved to a
+//     timestamp when the filter is transpiled
+//   - add(), sub(), mul() and div() of ints or floats, and concat() of
+//     strings, such as `size > add(10, 5)`, which are resolved to a constant
+//     when the filter is transpiled
+//   - comparisons between the keys of google.protobuf.Struct fields and
+//     constants, such as `metadata.owner = "x"` or `metadata.a.b > 1`
+//   - comparisons between wrapper fields, such as google.protobuf.Int64Value,
+//     and the type they wrap, such as `count > 1`
+//   - comparisons between two fields, such as `spent > budget`, which are
+//     evaluated once documents are retrieved with WithResidualFiltering
+//   - `=` and `!=` between fields and null, such as `parent = null`, which
+//     match fields stored as null, and fields stored with any other value,
+//     respectively; as in Firestore, neither matches documents without the
+//     field, such as those stored by other means
+//   - `=` and `!=` between enum fields and the names of their values, such as
+//     `state = "ACTIVE"`, as well as enum constants, such as `state = ACTIVE`
+//   - starts_with() on string fields, such as `starts_with(name, "abc")`
+//   - contains() on string fields, such as `contains(name, "abc")`, which is
+//     evaluated once documents are retrieved
+//   - matches() on string fields, with an RE2 regular expression, such as
+//     `matches(name, "^abc[0-9]+$")`, which is evaluated once documents are
+//     retrieved
+//   - any() of a filter of the fields of a repeated message field, which
+//     matches documents with an element matching the filter, such as
+//     `any((items.sku = "x" AND items.quantity > 1))`, also written as
+//     `items.exists(i, (i.sku = "x" AND i.quantity > 1))`
+//   - all() of a filter of the fields of a repeated message field, which
+//     matches documents with every element matching the filter, such as
+//     `all((items.quantity > 0))`, also written as
+//     `items.all(i, (i.quantity > 0))`, which is evaluated once documents are
+//     retrieved
+//   - `=`, `:` and `!=` between fields and any() of a list of values, such as
+//     `state = any(["ACTIVE", "PENDING"])`, which are transpiled to `in`,
+//     `array-contains-any` and `not-in`, respectively
+//
+// Additional declarations, such as custom functions, are applied last.
+func NewDeclarations(msg protoreflect.MessageDescriptor, opts ...filtering.DeclarationOption) (*filtering.Declarations, error) {
+	decls := []filtering.DeclarationOption{
+		filtering.DeclareStandardFunctions(),
+		filtering.DeclareFunction(functionStartsWith, filtering.NewFunctionOverload(
+			functionStartsWith+"_string_string", filtering.TypeBool, filtering.TypeString, filtering.TypeString,
+		)),
+		filtering.DeclareFunction(functionContains, filtering.NewFunctionOverload(
+			functionContains+"_string_string", filtering.TypeBool, filtering.TypeString, filtering.TypeString,
+		)),
+		filtering.DeclareFunction(functionMatches, filtering.NewFunctionOverload(
+			functionMatches+"_string_string", filtering.TypeBool, filtering.TypeString, filtering.TypeString,
+		)),
+	}
+	decls = append(decls, declareTimestampStrings()...)
+	decls = append(decls, declareRelativeTimes()...)
+	decls = append(decls, declareArithmetic()...)
+	decls = append(decls, structComparisons()...)
+	decls = append(decls, declareNull(msg)...)
+	decls = append(decls, declareAny()...)
+	decls = append(decls, declareMessage(msg)...)
+	decls = append(decls, hasOverloads(msg, map[protoreflect.FullName]bool{})...)
+	return filtering.NewDeclarations(append(decls, opts...)...)
+}
+
+// Returns the declarations of the message and its fields, as
+// protoexpr.Declare does, except that google.protobuf.Struct fields are
+// declared as maps of google.protobuf.Value, rather than traversed, as Structs
+// are recursive, as are google.protobuf.Value and ListValue.
+func declareMessage(msg protoreflect.MessageDescriptor) []filtering.DeclarationOption {
+	return declareFields(strcase.ToSnake(string(msg.Name())), msg, &expr.Type{TypeKind: &expr.Type_MessageType{MessageType: string(msg.FullName())}})
+}
+
+// Declares the message at the path, its enums, and its filterable fields.
+func declareFields(path string, msg protoreflect.MessageDescriptor, msgType *expr.Type) []filtering.DeclarationOption {
+	decls := []filtering.DeclarationOption{
+		filtering.DeclareIdent(path, msgType),
+		filtering.DeclareFunction(filtering.FunctionHas, filtering.NewFunctionOverload(
+			fmt.Sprintf("%s_%s", filtering.FunctionHas, path), filtering.TypeBool, msgType, filtering.TypeString,
+		)),
+	}
+	for i := 0; i < msg.Enums().Len(); i++ {
+		enum := msg.Enums().Get(i)
+		decls = append(decls, filtering.DeclareEnumIdent(strcase.ToSnake(string(enum.Name())), dynamicpb.NewEnumType(enum)))
+	}
+	for i := 0; i < msg.Fields().Len(); i++ {
+		fd := msg.Fields().Get(i)
+		if !filterable(fd) {
+			continue
+		}
+		name := fmt.Sprintf("%s.%s", path, fd.Name())
+		if structField(fd) {
+			decls = append(decls, filtering.DeclareIdent(name, structType))
+			continue
+		}
+		var t *expr.Type
+		switch {
+		case fd.IsMap():
+			if k := declaredType(fd.MapKey()); k != nil {
+				if v := declaredType(fd.MapValue()); v != nil {
+					t = filtering.TypeMap(k, v)
+				}
+			}
+		case fd.IsList():
+			if e := declaredType(fd); e != nil {
+				t = filtering.TypeList(e)
+			}
+		default:
+			t = declaredType(fd)
+		}
+		switch {
+		case t == nil:
+		case fd.Message() != nil && !dynamicValue(fd.Message()):
+			decls = append(decls, declareFields(name, fd.Message(), t)...)
+		default:
+			decls = append(decls, filtering.DeclareIdent(name, t))
+		}
+	}
+	return decls
+}
+
+// Returns the declared type of a field, ignoring whether it is repeated, with
+// timestamps and durations as their well-known types.
+func declaredType(fd protoreflect.FieldDescriptor) *expr.Type {
+	switch {
+	case fd.Enum() != nil:
+		return filtering.TypeEnum(dynamicpb.NewEnumType(fd.Enum()))
+	case timestampField(fd):
+		return filtering.TypeTimestamp
+	case durationField(fd):
+		return filtering.TypeDuration
+	case fd.Message() != nil:
+		return &expr.Type{TypeKind: &expr.Type_MessageType{MessageType: string(fd.Message().FullName())}}
+	}
+	return elementType(fd)
+}
+
+// Returns the `:` overloads of the scalar and map fields of the message, and
+// of the messages it contains.
+func hasOverloads(msg protoreflect.MessageDescriptor, seen map[protoreflect.FullName]bool) []filtering.DeclarationOption {
+	if seen[msg.FullName()] {
+		return nil
+	}
+	seen[msg.FullName()] = true
+	var decls []filtering.DeclarationOption
+	for i := 0; i < msg.Fields().Len(); i++ {
+		fd := msg.Fields().Get(i)
+		if !filterable(fd) {
+			continue
+		}
+		switch {
+		case fd.IsMap():
+			if fd.MapKey().Kind() != protoreflect.StringKind {
+				// Firestore map keys are always strings.
+				continue
+			}
+			if v := elementType(fd.MapValue()); v != nil {
+				decls = append(decls, filtering.DeclareFunction(filtering.FunctionHas, filtering.NewFunctionOverload(
+					fmt.Sprintf("%s_map_string_%s", filtering.FunctionHas, typeName(v)),
+					filtering.TypeBool, filtering.TypeMap(filtering.TypeString, v), filtering.TypeString,
+				)))
+			}
+			if fd.MapValue().Message() != nil {
+				decls = append(decls, hasOverloads(fd.MapValue().Message(), seen)...)
+			}
+		case wrapperField(fd):
+			decls = append(decls, wrapperComparisons(fd)...)
+		case fd.Message() != nil:
+			decls = append(decls, hasOverloads(fd.Message(), seen)...)
+		case fd.IsList():
+			if t := elementType(fd); t != nil {
+				decls = append(decls, filtering.DeclareFunction(filtering.FunctionHas, filtering.NewFunctionOverload(
+					fmt.Sprintf("%s_list_%s", filtering.FunctionHas, typeName(t)),
+					filtering.TypeBool, filtering.TypeList(t), t,
+				)))
+			}
+		case fd.Enum() != nil:
+			// Enum values may be named by strings, such as `state = "ACTIVE"`, as
+			// well as by enum constants.
+			t := filtering.TypeEnum(dynamicpb.NewEnumType(fd.Enum()))
+			for _, fn := range []string{filtering.FunctionEquals, filtering.FunctionNotEquals} {
+				decls = append(decls, filtering.DeclareFunction(fn, filtering.NewFunctionOverload(
+					fmt.Sprintf("%s_%s_string", fn, fd.Enum().FullName()),
+					filtering.TypeBool, t, filtering.TypeString,
+				)))
+			}
+		default:
+			// Whether a scalar is set, such as `count:*`. Strings are covered by
+			// the standard overload.
+			if t := elementType(fd); t != nil && t != filtering.TypeString {
+				decls = append(decls, filtering.DeclareFunction(filtering.FunctionHas, filtering.NewFunctionOverload(
+					fmt.Sprintf("%s_%s_string", filtering.FunctionHas, typeName(t)),
+					filtering.TypeBool, t, filtering.TypeString,
+				)))
+			}
+		}
+	}
+	return decls
+}
+
+// Reports whether the field may be filtered, which is the default.
+func filterable(fd protoreflect.FieldDescriptor) bool {
+	if !proto.HasExtension(fd.Options(), opb.E_Filtering) {
+		return true
+	}
+	opts := proto.GetExtension(fd.Options(), opb.E_Filtering).(*opb.FieldFilteringOptions)
+	return opts.Filterable == nil || opts.GetFilterable()
+}
+
+// Reports whether the field is annotated as searchable, which is not the
+// default.
+func searchable(fd protoreflect.FieldDescriptor) bool {
+	if !proto.HasExtension(fd.Options(), opb.E_Filtering) {
+		return false
+	}
+	return proto.GetExtension(fd.Options(), opb.E_Filtering).(*opb.FieldFilteringOptions).GetSearchable()
+}
+
+// Returns the type of a scalar field, ignoring whether it is repeated, or nil
+// if it is a message.
+func elementType(fd protoreflect.FieldDescriptor) *expr.Type {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return filtering.TypeBool
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Uint32Kind,
+		protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Uint64Kind,
+		protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind:
+		return filtering.TypeInt
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return filtering.TypeFloat
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		return filtering.TypeString
+	}
+	return nil
+}
+
+// Returns the name of a scalar type, for use in overload IDs.
+func typeName(t *expr.Type) string {
+	return strings.ToLower(t.GetPrimitive().String())
+}
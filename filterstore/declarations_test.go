@@ -0,0 +1,78 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Returns the descriptor of a Profile message, with repeated scalar and map
+// fields.
+func profileDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("profile.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Profile"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("scores"), Number: proto.Int32(1), Label: repeated, Type: descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()},
+				{Name: proto.String("weights"), Number: proto.Int32(2), Label: repeated, Type: descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum()},
+				{Name: proto.String("counts"), Number: proto.Int32(3), Label: repeated, Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".test.Profile.CountsEntry")},
+			},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("CountsEntry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("key"), Number: proto.Int32(1), Label: optional, Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+					{Name: proto.String("value"), Number: proto.Int32(2), Label: optional, Type: descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()},
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			}},
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("protodesc.NewFile() err = %v, want <nil>", err)
+	}
+	return fd.Messages().ByName("Profile")
+}
+
+func TestNewDeclarations(t *testing.T) {
+	decls, err := NewDeclarations(profileDescriptor(t))
+	if err != nil {
+		t.Fatalf("NewDeclarations() err = %v, want <nil>", err)
+	}
+	for _, filter := range []string{
+		`profile.scores:1`,
+		`profile.weights:1.5`,
+		`profile.counts:"a"`,
+		`profile.counts.a > 1`,
+	} {
+		if _, err := filtering.ParseFilter(filterRequest(filter), decls); err != nil {
+			t.Errorf("filtering.ParseFilter(%q) err = %v, want <nil>", filter, err)
+		}
+	}
+	if _, err := filtering.ParseFilter(filterRequest(`profile.scores:"a"`), decls); err == nil {
+		t.Errorf("filtering.ParseFilter(%q) err = <nil>, want error", `profile.scores:"a"`)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"strings"
+
+	"go.einride.tech/aip/filtering"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Catalog holds the phrases used by Describe, as fmt templates keyed by the
+// filter function they describe, such as `=` or `AND`.
+// Fields are described by their name, with underscores replaced by spaces,
+// unless labelled by a key of the form `field:<path>`, such as
+// `field:filterable_submessage.filterable_primitive`.
+// Keys missing from a Catalog fall back to EnglishCatalog.
+type Catalog map[string]string
+
+// EnglishCatalog is the Catalog used when none is provided.
+var EnglishCatalog = Catalog{
+	filtering.FunctionAnd:           "%s and %s",
+	filtering.FunctionOr:            "%s or %s",
+	filtering.FunctionNot:           "not %s",
+	filtering.FunctionHas:           "%s has %s",
+	filtering.FunctionEquals:        "%s is %s",
+	filtering.FunctionNotEquals:     "%s is not %s",
+	filtering.FunctionLessThan:      "%s is less than %s",
+	filtering.FunctionLessEquals:    "%s is at most %s",
+	filtering.FunctionGreaterThan:   "%s is greater than %s",
+	filtering.FunctionGreaterEquals: "%s is at least %s",
+	functionStartsWith:              "%s starts with %s",
+	functionContains:                "%s contains %s",
+	functionMatches:                 "%s matches %s",
+	// Wraps a conjunction or disjunction nested in another.
+	"group": "(%s)",
+	// Describes a filter which matches every document.
+	"all": "all documents",
+}
+
+// Returns the template for the key, falling back to EnglishCatalog.
+func (c Catalog) phrase(key string) (string, bool) {
+	if p, ok := c[key]; ok {
+		return p, true
+	}
+	p, ok := EnglishCatalog[key]
+	return p, ok
+}
+
+// Describe renders the filter of the plan as a sentence, such as
+// `filterable primitive is "a" and default float is greater than 1`, using
+// the phrases of the catalog, or EnglishCatalog if it is nil.
+func (p *Plan) Describe(c Catalog) string {
+	if p.expr == nil {
+		all, _ := c.phrase("all")
+		return all
+	}
+	return c.describe(p.expr)
+}
+
+func (c Catalog) describe(e *expr.Expr) string {
+	switch e.GetExprKind().(type) {
+	case *expr.Expr_ConstExpr:
+		return formatConst(e.GetConstExpr())
+	case *expr.Expr_IdentExpr, *expr.Expr_SelectExpr:
+		return c.label(e)
+	}
+	call := e.GetCallExpr()
+	template, ok := c.phrase(call.GetFunction())
+	if !ok {
+		return canonical(e, false)
+	}
+	args := make([]interface{}, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = c.describe(arg)
+		// Nested logical calls are grouped, unless they chain the same function.
+		switch arg.GetCallExpr().GetFunction() {
+		case filtering.FunctionAnd, filtering.FunctionOr:
+			if arg.GetCallExpr().GetFunction() != call.GetFunction() {
+				group, _ := c.phrase("group")
+				args[i] = fmt.Sprintf(group, args[i])
+			}
+		}
+	}
+	switch call.GetFunction() {
+	case filtering.FunctionAnd, filtering.FunctionOr:
+		// Calls of any arity are described as a chain of binary calls.
+		described := args[0]
+		for _, arg := range args[1:] {
+			described = fmt.Sprintf(template, described, arg)
+		}
+		return described.(string)
+	}
+	return fmt.Sprintf(template, args...)
+}
+
+// Returns the label of the field, relative to the collection message.
+func (c Catalog) label(e *expr.Expr) string {
+	var segments []string
+	for e.GetSelectExpr() != nil {
+		segments = append([]string{e.GetSelectExpr().GetField()}, segments...)
+		e = e.GetSelectExpr().GetOperand()
+	}
+	if len(segments) == 0 {
+		segments = []string{e.GetIdentExpr().GetName()}
+	}
+	path := strings.Join(segments, ".")
+	if l, ok := c["field:"+path]; ok {
+		return l
+	}
+	return strings.ReplaceAll(strings.Join(segments, " "), "_", " ")
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestDescribe(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	french := Catalog{
+		"AND":                        "%s et %s",
+		"=":                          "%s est %s",
+		"field:filterable_primitive": "le primitif",
+		"field:filterable_submessage.filterable_primitive": "le sous-primitif",
+	}
+	for _, tc := range []struct {
+		filter  string
+		catalog Catalog
+		want    string
+	}{
+		{
+			filter: "",
+			want:   "all documents",
+		},
+		{
+			filter: `test_filtering.filterable_primitive = "a" AND test_filtering.default_float > 1.5`,
+			want:   `filterable primitive is "a" and default float is greater than 1.5`,
+		},
+		{
+			filter: `test_filtering.default_float >= 3.0 AND (test_filtering.filterable_primitive = "a" OR test_filtering.filterable_primitive = "b")`,
+			want:   `default float is at least 3 and (filterable primitive is "a" or filterable primitive is "b")`,
+		},
+		{
+			filter: `NOT (test_filtering.filterable_primitive = "a" OR test_filtering.filterable_primitive = "b")`,
+			want:   `not (filterable primitive is "a" or filterable primitive is "b")`,
+		},
+		{
+			filter:  `test_filtering.filterable_primitive = "a" AND test_filtering.filterable_submessage.filterable_primitive = 1 AND test_filtering.default_float < 2.0`,
+			catalog: french,
+			want:    `le primitif est "a" et le sous-primitif est 1 et default float is less than 2`,
+		},
+	} {
+		plan, err := transpiler.Plan(&test.ListTestRequest{Parent: "parents/p", Filter: tc.filter})
+		if err != nil {
+			t.Fatalf("Plan(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if got := plan.Describe(tc.catalog); got != tc.want {
+			t.Errorf("Plan(%q).Describe() = %q, want %q", tc.filter, got, tc.want)
+		}
+	}
+}
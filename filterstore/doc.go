@@ -0,0 +1,35 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package filterstore transpiles AIP-160 filters (https://google.aip.dev/160)
+// of AIP-132 List requests (https://google.aip.dev/132) onto Firestore
+// queries.
+//
+// # Emulated Queries
+//
+// The Firestore client does not yet support aggregation queries, such as
+// count(), sum() and avg(), or vector queries, so Transpiler.Count,
+// Transpiler.Aggregate and Transpiler.FindNearest emulate them. The filter is
+// run as a query which only reads the fields the call needs, and the result is
+// computed as the matching documents are streamed, so only the documents which
+// contribute to it are held in memory. Unlike an aggregation query, which is
+// billed as one read per batch of up to 1000 documents, each matching document
+// is billed as a document read.
+//
+// Nor does the client support query explain, so Transpiler.Explain runs List
+// with the Stats call option and pairs its reads with the Plan of the request.
+// The Plan shows the fields each query filters and orders on, which
+// determine the index Firestore uses, in place of the indexes reported by
+// Firestore.
+package filterstore
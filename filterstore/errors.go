@@ -0,0 +1,170 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Errors wrapped by the INVALID_ARGUMENT errors returned for requests, so
+// that callers can distinguish their causes with errors.Is, such as
+// `errors.Is(err, filterstore.ErrUnknownField)`.
+var (
+	// ErrUnsupportedFunction is wrapped when a filter uses a function which
+	// cannot be transpiled, or evaluated in memory.
+	ErrUnsupportedFunction = errors.New("unsupported function")
+	// ErrMultipleInequalities is wrapped when a filter uses inequalities on
+	// more fields than the database supports.
+	ErrMultipleInequalities = errors.New("inequalities on multiple fields")
+	// ErrUnknownField is wrapped when a filter or order_by refers to a field
+	// which does not exist.
+	ErrUnknownField = errors.New("unknown field")
+	// ErrInvalidPageToken is wrapped when a page token cannot be decoded, or
+	// was produced by a different request.
+	ErrInvalidPageToken = errors.New("invalid page token")
+	// ErrTooManyQueries is wrapped when a filter requires more queries than
+	// are allowed.
+	ErrTooManyQueries = errors.New("too many queries")
+	// ErrUnsupportedFilter is wrapped when a valid filter cannot be run by a
+	// call, such as a filter of an indexed repeated field across parents.
+	ErrUnsupportedFilter = errors.New("unsupported filter")
+	// ErrInvalidPageSize is wrapped when the page size of a request is
+	// negative.
+	ErrInvalidPageSize = errors.New("invalid page size")
+	// ErrInvalidOrderBy is wrapped when an order_by cannot be parsed.
+	ErrInvalidOrderBy = errors.New("invalid order_by")
+	// ErrRepeatedField is wrapped when an order_by or aggregation refers to a
+	// repeated or map field, which has no single value.
+	ErrRepeatedField = errors.New("repeated field")
+	// ErrInvalidCursor is wrapped when an imported Cursor does not match the
+	// order of the request.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrInvalidCallOption is wrapped when a CallOption has an invalid value,
+	// or cannot be used for the request.
+	ErrInvalidCallOption = errors.New("invalid call option")
+)
+
+// Returns an INVALID_ARGUMENT error with the formatted message, which wraps
+// the provided cause.
+func invalidArgument(cause error, format string, a ...interface{}) error {
+	return &causedError{cause: cause, status: status.Newf(codes.InvalidArgument, format, a...)}
+}
+
+// An error with a status, which wraps one of the exported causes.
+type causedError struct {
+	cause  error
+	status *status.Status
+}
+
+func (e *causedError) Error() string {
+	return e.status.Err().Error()
+}
+
+// GRPCStatus returns the status of the error.
+func (e *causedError) GRPCStatus() *status.Status {
+	return e.status
+}
+
+func (e *causedError) Unwrap() error {
+	return e.cause
+}
+
+// PositionError is returned when part of a filter cannot be transpiled, and
+// locates that part within the filter, such as
+// `inequality can only be used on a single field at offset 24: 'price < 5'`.
+// Its status has the code of the error it wraps.
+type PositionError struct {
+	// The offset of the part from the start of the filter.
+	Offset int32
+	// The part of the filter, as it was transpiled.
+	Expr string
+	Err  error
+}
+
+func (e *PositionError) Error() string {
+	return fmt.Sprintf("%s at offset %d: '%s'", status.Convert(e.Err).Message(), e.Offset, e.Expr)
+}
+
+// GRPCStatus returns the status of the error, with the code of the error it
+// wraps.
+func (e *PositionError) GRPCStatus() *status.Status {
+	return status.New(status.Code(e.Err), e.Error())
+}
+
+func (e *PositionError) Unwrap() error {
+	return e.Err
+}
+
+// Returns the error located at the expression, unless it is already located.
+// The error is returned as is if the position of the expression is unknown,
+// such as for expressions created after the filter was parsed, which have an
+// ID of 0 or none in the source info.
+func (q *query) locate(err error, e *expr.Expr) error {
+	var located *PositionError
+	if errors.As(err, &located) || e.GetId() == 0 {
+		return err
+	}
+	offset, ok := q.positions[e.GetId()]
+	if !ok {
+		return err
+	}
+	return &PositionError{Offset: offset, Expr: canonical(e, false), Err: err}
+}
+
+// Returns the error as a violation of the field of the request, if it is an
+// INVALID_ARGUMENT error whose status has no details, so that its status
+// carries a google.rpc.BadRequest detail describing the violation.
+// The error it wraps remains available to errors.As.
+func invalidField(field string, err error) error {
+	s, ok := status.FromError(err)
+	if !ok || s.Code() != codes.InvalidArgument || len(s.Details()) > 0 {
+		return err
+	}
+	return &fieldViolationError{field: field, err: err}
+}
+
+// Wraps an INVALID_ARGUMENT error caused by a field of the request.
+type fieldViolationError struct {
+	field string
+	err   error
+}
+
+func (e *fieldViolationError) Error() string {
+	return e.err.Error()
+}
+
+// GRPCStatus returns the status of the wrapped error, with a
+// google.rpc.BadRequest detail describing the violation of the field.
+func (e *fieldViolationError) GRPCStatus() *status.Status {
+	s := status.Convert(e.err)
+	detailed, err := s.WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: e.field, Description: s.Message()}},
+	})
+	if err != nil {
+		return s
+	}
+	return detailed
+}
+
+func (e *fieldViolationError) Unwrap() error {
+	return e.err
+}
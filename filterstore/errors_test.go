@@ -0,0 +1,147 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/genproto/googleapis/api/serviceconfig"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestPositionError(t *testing.T) {
+	filter := `test_filtering.filterable_primitive > "a" AND test_filtering.default_float < 5.0`
+	checked, err := parseFilter(filter, testDeclarations(t), testDescriptor, nil, false)
+	if err != nil {
+		t.Fatalf("parseFilter(%q) err = %v, want <nil>", filter, err)
+	}
+	_, err = newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{}, checked, capabilities{})
+	var got *PositionError
+	if !errors.As(err, &got) {
+		t.Fatalf("newQuery(%q) err = %v, want *PositionError", filter, err)
+	}
+	if diff := cmp.Diff(PositionError{Offset: 46, Expr: "test_filtering.default_float < 5"}, *got, cmp.FilterPath(func(p cmp.Path) bool { return p.String() == "Err" }, cmp.Ignore())); diff != "" {
+		t.Errorf("newQuery(%q) err diff (-want +got):\n%s", filter, diff)
+	}
+	s := status.Convert(err)
+	if s.Code() != codes.InvalidArgument {
+		t.Errorf("newQuery(%q) err code = %v, want %v", filter, s.Code(), codes.InvalidArgument)
+	}
+	if want := "inequality can only be used on a single field at offset 46: 'test_filtering.default_float < 5'"; s.Message() != want {
+		t.Errorf("newQuery(%q) err message = %q, want %q", filter, s.Message(), want)
+	}
+}
+
+func TestInvalidField(t *testing.T) {
+	filter := `test_filtering.filterable_primitive > "a" AND test_filtering.default_float < 5.0`
+	checked, err := parseFilter(filter, testDeclarations(t), testDescriptor, nil, false)
+	if err != nil {
+		t.Fatalf("parseFilter(%q) err = %v, want <nil>", filter, err)
+	}
+	_, err = newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{}, checked, capabilities{})
+	var located *PositionError
+	if !errors.As(err, &located) {
+		t.Errorf("newQuery(%q) err = %v, want *PositionError", filter, err)
+	}
+	want := []interface{}{&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+			Field:       "filter",
+			Description: "inequality can only be used on a single field at offset 46: 'test_filtering.default_float < 5'",
+		}},
+	}}
+	if diff := cmp.Diff(want, status.Convert(err).Details(), protocmp.Transform()); diff != "" {
+		t.Errorf("newQuery(%q) err details diff (-want +got):\n%s", filter, diff)
+	}
+
+	for _, tc := range []struct {
+		name string
+		err  error
+	}{
+		{name: "not invalid argument", err: status.Error(codes.NotFound, "not found")},
+		{name: "not a status", err: errors.New("failed")},
+		{name: "already detailed", err: invalidField("page_token", status.Error(codes.InvalidArgument, "invalid page token"))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := invalidField("filter", tc.err); got != tc.err {
+				t.Errorf("invalidField(%v) = %v, want the error unchanged", tc.err, got)
+			}
+		})
+	}
+}
+
+func TestErrorCauses(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		want   error
+	}{
+		{filter: `test_filtering.missing = 1`, want: ErrUnknownField},
+		{filter: `test_filtering.filterable_submessage.missing = 1`, want: ErrUnknownField},
+		{filter: `missing(test_filtering.size)`, want: ErrUnsupportedFunction},
+		{filter: `test_filtering.size > 1 AND test_filtering.priority < 5`, want: ErrMultipleInequalities},
+	} {
+		t.Run(tc.filter, func(t *testing.T) {
+			checked, err := parseFilter(tc.filter, testDeclarations(t), testDescriptor, nil, false)
+			if err == nil {
+				_, err = newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{}, checked, capabilities{})
+			}
+			if !errors.Is(err, tc.want) {
+				t.Errorf("transpiling %q err = %v, want %v", tc.filter, err, tc.want)
+			}
+			if got := status.Code(err); got != codes.InvalidArgument {
+				t.Errorf("transpiling %q err code = %v, want %v", tc.filter, got, codes.InvalidArgument)
+			}
+		})
+	}
+	if _, err := (pageTokens{key: []byte("key")}).decode("tampered", 1); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("decode(%q) err = %v, want %v", "tampered", err, ErrInvalidPageToken)
+	}
+}
+
+func TestRequestErrorCauses(t *testing.T) {
+	ctx := context.Background()
+	transpiler := newTestTranspiler(t, testClient(t))
+	quotas := newMessageTranspiler(t, testClient(t), &serviceconfig.Quota{})
+	_, orderErr := parseOrderBy(orderedRequest{orderBy: "filterable_primitive asc desc"}, nil)
+	_, pageSizeErr := transpiler.List(ctx, &test.ListTestRequest{Parent: "parents/p", PageSize: -1})
+	_, repeatedErr := quotas.Aggregate(ctx, &test.ListTestRequest{Parent: "parents/p"}, []Aggregation{Sum("limits")})
+	_, cursorErr := transpiler.ImportCursor(&test.ListTestRequest{Parent: "parents/p"}, Cursor{Values: []interface{}{"a"}, ID: "a"})
+	_, prefetchErr := transpiler.Iterate(ctx, &test.ListTestRequest{Parent: "parents/p"}, Prefetch(-1)).Next()
+	for _, tc := range []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "order_by", err: orderErr, want: ErrInvalidOrderBy},
+		{name: "page size", err: pageSizeErr, want: ErrInvalidPageSize},
+		{name: "repeated aggregation", err: repeatedErr, want: ErrRepeatedField},
+		{name: "cursor", err: cursorErr, want: ErrInvalidCursor},
+		{name: "prefetch", err: prefetchErr, want: ErrInvalidCallOption},
+	} {
+		if !errors.Is(tc.err, tc.want) {
+			t.Errorf("%s: err = %v, want %v", tc.name, tc.err, tc.want)
+		}
+		if got := status.Code(tc.err); got != codes.InvalidArgument {
+			t.Errorf("%s: err code = %v, want %v", tc.name, got, codes.InvalidArgument)
+		}
+	}
+}
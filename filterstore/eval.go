@@ -0,0 +1,514 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Evaluates a filter against messages in memory, for the terms of a filter
+// which Firestore cannot evaluate.
+//
+// Values are compared as they are decoded into the message, so unset fields
+// have their default value, unlike Firestore, which excludes documents without
+// a value for a compared field.
+type evaluator struct {
+	e     *expr.Expr
+	types map[int64]*expr.Type
+	// The compiled patterns of matches() calls, by the ID of the call.
+	patterns map[int64]*regexp.Regexp
+	// The filters of any() calls, by the ID of the call.
+	elements map[int64]elementFilter
+}
+
+// The filter of an any() call, relative to the elements of its field.
+type elementFilter struct {
+	field  protoreflect.Name
+	filter *expr.Expr
+}
+
+// Evaluate reports whether the message matches the filter, evaluating it in
+// memory, such as to verify that the documents retrieved by a Transpiler for
+// the same filter match it. An empty filter matches every message.
+//
+// Fields are compared as they are decoded into the message, so unset fields
+// have their default value, whereas Firestore excludes documents without a
+// value for a compared field.
+// Returns an INVALID_ARGUMENT error if the filter uses a function which cannot
+// be evaluated in memory.
+func Evaluate(filter filtering.Filter, msg proto.Message) (bool, error) {
+	checked, err := resolveRelativeTimes(filter.CheckedExpr, time.Now())
+	if err != nil {
+		return false, err
+	}
+	ev, err := newEvaluator(checked)
+	if err != nil {
+		return false, invalidField("filter", err)
+	}
+	return ev.matches(msg.ProtoReflect()), nil
+}
+
+// Returns an evaluator of the filter, or an INVALID_ARGUMENT error if it uses
+// a function which cannot be evaluated in memory.
+func newEvaluator(filter *expr.CheckedExpr) (*evaluator, error) {
+	ev := &evaluator{e: filter.GetExpr(), types: filter.GetTypeMap(), patterns: map[int64]*regexp.Regexp{}, elements: map[int64]elementFilter{}}
+	if ev.e == nil {
+		return ev, nil
+	}
+	if err := ev.check(ev.e); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// Checks that the expression can be evaluated, compiling its patterns.
+func (ev *evaluator) check(e *expr.Expr) error {
+	call := e.GetCallExpr()
+	if e.GetSelectExpr() != nil {
+		// A bool field used as a truth value.
+		return nil
+	}
+	if call == nil {
+		return status.Error(codes.InvalidArgument, "invalid filter expression")
+	}
+	switch call.GetFunction() {
+	case filtering.FunctionAnd, filtering.FunctionOr, filtering.FunctionNot:
+		for _, arg := range call.GetArgs() {
+			if err := ev.check(arg); err != nil {
+				return err
+			}
+		}
+		return nil
+	case functionAny, functionAll:
+		if field, filter, ok := anyElement(call); ok {
+			ev.elements[e.GetId()] = elementFilter{field: protoreflect.Name(field), filter: filter}
+			return ev.check(filter)
+		}
+	case filtering.FunctionEquals, filtering.FunctionNotEquals,
+		filtering.FunctionLessThan, filtering.FunctionLessEquals,
+		filtering.FunctionGreaterThan, filtering.FunctionGreaterEquals,
+		filtering.FunctionHas:
+		if len(call.GetArgs()) == 2 && (call.Args[0].GetSelectExpr() != nil || call.Args[0].GetIdentExpr() != nil) && constantArg(call.Args[1]) {
+			return nil
+		}
+		if fieldComparison(call) {
+			return nil
+		}
+	case functionStartsWith, functionContains, functionMatches:
+		if len(call.GetArgs()) == 2 && call.Args[0].GetSelectExpr() != nil && call.Args[1].GetConstExpr() != nil {
+			if call.GetFunction() == functionMatches {
+				re, err := compilePattern(call.Args[1].GetConstExpr().GetStringValue())
+				if err != nil {
+					return err
+				}
+				ev.patterns[e.GetId()] = re
+			}
+			return nil
+		}
+	}
+	return invalidArgument(ErrUnsupportedFunction, "%s cannot be evaluated in memory", call.GetFunction())
+}
+
+// Reports whether the message matches the filter.
+func (ev *evaluator) matches(msg protoreflect.Message) bool {
+	if ev == nil || ev.e == nil {
+		return true
+	}
+	return ev.eval(ev.e, msg)
+}
+
+func (ev *evaluator) eval(e *expr.Expr, msg protoreflect.Message) bool {
+	if e.GetSelectExpr() != nil {
+		v, fd, ok := ev.field(e, msg)
+		b, _ := scalar(v, fd).(bool)
+		return ok && b
+	}
+	call := e.GetCallExpr()
+	switch call.GetFunction() {
+	case filtering.FunctionAnd:
+		for _, arg := range call.GetArgs() {
+			if !ev.eval(arg, msg) {
+				return false
+			}
+		}
+		return true
+	case filtering.FunctionOr:
+		for _, arg := range call.GetArgs() {
+			if ev.eval(arg, msg) {
+				return true
+			}
+		}
+		return false
+	case filtering.FunctionNot:
+		return !ev.eval(call.Args[0], msg)
+	case filtering.FunctionHas:
+		return ev.has(call, msg)
+	case functionAny:
+		return ev.any(e, msg)
+	case functionAll:
+		return ev.all(e, msg)
+	}
+	if nullArg(call.Args[1]) {
+		null, ok := ev.null(call.Args[0], msg)
+		return ok && null == (call.GetFunction() == filtering.FunctionEquals)
+	}
+	v, fd, ok := ev.field(call.Args[0], msg)
+	if !ok {
+		return false
+	}
+	switch call.GetFunction() {
+	case functionStartsWith, functionContains, functionMatches:
+		s, ok := v.Interface().(string)
+		if !ok {
+			return false
+		}
+		arg := call.Args[1].GetConstExpr().GetStringValue()
+		switch call.GetFunction() {
+		case functionStartsWith:
+			return strings.HasPrefix(s, arg)
+		case functionContains:
+			return strings.Contains(s, arg)
+		default:
+			return ev.patterns[e.GetId()].MatchString(s)
+		}
+	}
+	// A trailing wildcard matches any string with the preceding prefix.
+	if s, ok := v.Interface().(string); ok && call.GetFunction() == filtering.FunctionEquals {
+		if arg := call.Args[1].GetConstExpr().GetStringValue(); strings.HasSuffix(arg, "*") {
+			return strings.HasPrefix(s, strings.TrimSuffix(arg, "*"))
+		}
+	}
+	var other interface{}
+	if call.Args[1].GetSelectExpr() != nil {
+		// A comparison of two fields, such as `spent > budget`.
+		w, wfd, ok := ev.field(call.Args[1], msg)
+		if !ok {
+			return false
+		}
+		other = scalar(w, wfd)
+	} else {
+		other = constant(call.Args[1], fd)
+	}
+	c, ok := compareValues(scalar(v, fd), other)
+	if !ok {
+		return false
+	}
+	switch call.GetFunction() {
+	case filtering.FunctionEquals:
+		return c == 0
+	case filtering.FunctionNotEquals:
+		return c != 0
+	case filtering.FunctionLessThan:
+		return c < 0
+	case filtering.FunctionLessEquals:
+		return c <= 0
+	case filtering.FunctionGreaterThan:
+		return c > 0
+	case filtering.FunctionGreaterEquals:
+		return c >= 0
+	}
+	return false
+}
+
+// Evaluates any(), which checks for an element of a repeated message field
+// matching its filter.
+func (ev *evaluator) any(e *expr.Expr, msg protoreflect.Message) bool {
+	element := ev.elements[e.GetId()]
+	fd := msg.Descriptor().Fields().ByName(element.field)
+	if fd == nil || !fd.IsList() || fd.Message() == nil {
+		return false
+	}
+	list := msg.Get(fd).List()
+	for i := 0; i < list.Len(); i++ {
+		if ev.eval(element.filter, list.Get(i).Message()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluates all(), which checks that every element of a repeated message field
+// matches its filter.
+func (ev *evaluator) all(e *expr.Expr, msg protoreflect.Message) bool {
+	element := ev.elements[e.GetId()]
+	fd := msg.Descriptor().Fields().ByName(element.field)
+	if fd == nil || !fd.IsList() || fd.Message() == nil {
+		return false
+	}
+	list := msg.Get(fd).List()
+	for i := 0; i < list.Len(); i++ {
+		if !ev.eval(element.filter, list.Get(i).Message()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Evaluates `:`, which checks for a field of a message, a key of a map or an
+// element of a list, or whether a field is set.
+func (ev *evaluator) has(call *expr.Expr_Call, msg protoreflect.Message) bool {
+	v, fd, ok := ev.field(call.Args[0], msg)
+	if !ok {
+		return false
+	}
+	arg := call.Args[1].GetConstExpr()
+	if arg.GetStringValue() == presenceWildcard && fd != nil {
+		return ev.set(call.Args[0].GetSelectExpr(), msg)
+	}
+	switch {
+	case fd != nil && fd.IsList():
+		list := v.List()
+		for i := 0; i < list.Len(); i++ {
+			if c, ok := compareValues(scalar(list.Get(i), fd), constant(call.Args[1], fd)); ok && c == 0 {
+				return true
+			}
+		}
+		return false
+	case fd != nil && fd.IsMap():
+		return v.Map().Has(protoreflect.ValueOfString(arg.GetStringValue()).MapKey())
+	case structField(fd) || structValue(fd):
+		_, ok := structKey(v.Message(), arg.GetStringValue())
+		return ok
+	}
+	if m, ok := v.Interface().(protoreflect.Message); ok {
+		field := m.Descriptor().Fields().ByName(protoreflect.Name(arg.GetStringValue()))
+		return field != nil && m.Has(field)
+	}
+	c, ok := compareValues(scalar(v, fd), constant(call.Args[1], fd))
+	return ok && c == 0
+}
+
+// Reports whether the selected field would be stored as null, which is only
+// the case for unset fields with explicit presence, and for null values of
+// Structs. Returns false if the field would not be stored at all.
+func (ev *evaluator) null(e *expr.Expr, msg protoreflect.Message) (bool, bool) {
+	v, fd, ok := ev.field(e, msg)
+	switch {
+	case !ok || fd == nil:
+		return false, false
+	case structValue(fd):
+		m := v.Message()
+		return m.Has(m.Descriptor().Fields().ByName("null_value")), true
+	case fd.IsList() || fd.IsMap() || fd.ContainingMessage().IsMapEntry():
+		return false, true
+	case fd.HasPresence():
+		return !ev.set(e.GetSelectExpr(), msg), true
+	}
+	return false, true
+}
+
+// Reports whether the selected field is set, by its kind of presence.
+func (ev *evaluator) set(sel *expr.Expr_Select, msg protoreflect.Message) bool {
+	v, fd, ok := ev.field(sel.GetOperand(), msg)
+	if !ok {
+		return false
+	}
+	if fd != nil && (fd.IsMap() || structField(fd) || structValue(fd)) {
+		// The key was found by the caller.
+		return true
+	}
+	m := v.Message()
+	return m.Has(m.Descriptor().Fields().ByName(protoreflect.Name(sel.GetField())))
+}
+
+// Returns the value selected by the expression from the message, along with
+// its field, which is the value field of a map for map values.
+// Returns false if the expression does not select a value.
+func (ev *evaluator) field(e *expr.Expr, msg protoreflect.Message) (protoreflect.Value, protoreflect.FieldDescriptor, bool) {
+	sel := e.GetSelectExpr()
+	if sel == nil {
+		return protoreflect.ValueOfMessage(msg), nil, e.GetIdentExpr() != nil
+	}
+	v, fd, ok := ev.field(sel.GetOperand(), msg)
+	if !ok {
+		return protoreflect.Value{}, nil, false
+	}
+	if fd != nil && fd.IsMap() {
+		value := v.Map().Get(protoreflect.ValueOfString(sel.GetField()).MapKey())
+		return value, fd.MapValue(), value.IsValid()
+	}
+	if structField(fd) || structValue(fd) {
+		value, ok := structKey(v.Message(), sel.GetField())
+		return value, structValueField, ok
+	}
+	m, ok := v.Interface().(protoreflect.Message)
+	if !ok || (fd != nil && fd.IsList()) {
+		return protoreflect.Value{}, nil, false
+	}
+	field := m.Descriptor().Fields().ByName(protoreflect.Name(sel.GetField()))
+	if field == nil {
+		return protoreflect.Value{}, nil, false
+	}
+	return m.Get(field), field, true
+}
+
+// Returns the Go value of a scalar value of the field, with every integer as
+// an int64 or uint64, every float as a float64, and enums as their number.
+func scalar(v protoreflect.Value, fd protoreflect.FieldDescriptor) interface{} {
+	if fd == nil {
+		return nil
+	}
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		return int64(v.Enum())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return v.Uint()
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BytesKind:
+		return v.Bytes()
+	case protoreflect.MessageKind:
+		switch {
+		case !v.Message().IsValid():
+		case timestampField(fd):
+			return timestampValue(v.Message())
+		case durationField(fd):
+			return durationValue(v.Message())
+		case structValue(fd):
+			return structScalar(v.Message())
+		case wrapperField(fd):
+			value := fd.Message().Fields().ByName(wrapperValueName)
+			return scalar(v.Message().Get(value), value)
+		}
+	}
+	return nil
+}
+
+// Returns the Go value of a constant compared to the field, resolving enum
+// values by name.
+func constant(e *expr.Expr, fd protoreflect.FieldDescriptor) interface{} {
+	if timeCall(e) {
+		v, err := timeConstant(e)
+		if err != nil {
+			return nil
+		}
+		return v
+	}
+	if timestampField(fd) {
+		t, err := timestampConstant(e.GetConstExpr())
+		if err != nil {
+			return nil
+		}
+		return t
+	}
+	if fd != nil && fd.Enum() != nil {
+		if value := enumValue(fd, e); value != nil {
+			return int64(value.Number())
+		}
+		return nil
+	}
+	return unwrapConst(e.GetConstExpr())
+}
+
+// Compares two scalar values, returning false if they cannot be compared.
+func compareValues(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case []byte:
+		switch b := b.(type) {
+		case []byte:
+			return bytes.Compare(a, b), true
+		case string:
+			return bytes.Compare(a, []byte(b)), true
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			switch {
+			case a.Before(b):
+				return -1, true
+			case a.After(b):
+				return 1, true
+			}
+			return 0, true
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, true
+			case b:
+				return -1, true
+			}
+			return 1, true
+		}
+	case int64, uint64, float64:
+		// Integers are compared exactly, as they may not fit in a float64.
+		if x, ok := a.(int64); ok {
+			if y, ok := b.(int64); ok {
+				return compareInts(x, y), true
+			}
+		}
+		x, ok := number(a)
+		if !ok {
+			return 0, false
+		}
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func compareInts(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// Returns the numeric value as a float64.
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,163 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+	"time"
+
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestEvaluator(t *testing.T) {
+	transpiler := newTestTranspiler(t, nil)
+	msg := (&test.TestFiltering{
+		FilterablePrimitive:  "Hello, World",
+		FilterableSubmessage: &test.TestFiltering_SubMessage{FilterablePrimitive: 5},
+		DefaultFloat:         2.5,
+		DefaultEnum:          test.TestFiltering_VALUE_1,
+	}).ProtoReflect()
+	for _, tc := range []struct {
+		filter string
+		want   bool
+	}{
+		{`test_filtering.filterable_primitive = "Hello, World"`, true},
+		{`test_filtering.filterable_primitive = "Hello*"`, true},
+		{`test_filtering.filterable_primitive != "Hello, World"`, false},
+		{`test_filtering.filterable_primitive < "I"`, true},
+		{`test_filtering.filterable_submessage.filterable_primitive >= 5`, true},
+		{`test_filtering.filterable_submessage.filterable_primitive > 5`, false},
+		{`test_filtering.default_float > 2.0`, true},
+		{`test_filtering.default_float <= 2.4`, false},
+		{`test_filtering.default_enum = VALUE_1`, true},
+		{`test_filtering.default_enum = VALUE_0`, false},
+		{`test_filtering.default_enum = "VALUE_1"`, true},
+		{`test_filtering:filterable_submessage`, true},
+		{`test_filtering:default_submessage`, false},
+		{`test_filtering.filterable_primitive:*`, true},
+		{`test_filtering.default_bool:*`, false},
+		{`test_filtering.default_bool`, false},
+		{`NOT test_filtering.default_bool AND test_filtering.default_float > 2.0`, true},
+		{`test_filtering.filterable_submessage:*`, true},
+		{`test_filtering.default_submessage:*`, false},
+		{`starts_with(test_filtering.filterable_primitive, "Hello")`, true},
+		{`contains(test_filtering.filterable_primitive, "o, W")`, true},
+		{`contains(test_filtering.filterable_primitive, "world")`, false},
+		{`matches(test_filtering.filterable_primitive, "^Hello, [A-Z]")`, true},
+		{`test_filtering.default_float > 3.0 OR contains(test_filtering.filterable_primitive, "World")`, true},
+		{`NOT test_filtering.default_float > 2.0 AND test_filtering.filterable_primitive = "Hello, World"`, false},
+	} {
+		filter, err := transpiler.parse(filterRequest(tc.filter))
+		if err != nil {
+			t.Fatalf("parse(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		ev, err := newEvaluator(filter.CheckedExpr)
+		if err != nil {
+			t.Fatalf("newEvaluator(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if got := ev.matches(msg); got != tc.want {
+			t.Errorf("newEvaluator(%q).matches() = %t, want %t", tc.filter, got, tc.want)
+		}
+	}
+}
+
+func TestEvaluateFieldComparison(t *testing.T) {
+	msg := &test.TestFiltering{
+		FilterableSubmessage: &test.TestFiltering_SubMessage{FilterablePrimitive: 5},
+		DefaultSubmessage:    &test.TestFiltering_SubMessage{FilterablePrimitive: 3},
+	}
+	for _, tc := range []struct {
+		filter string
+		want   bool
+	}{
+		{`test_filtering.filterable_submessage.filterable_primitive > test_filtering.default_submessage.filterable_primitive`, true},
+		{`test_filtering.filterable_submessage.filterable_primitive = test_filtering.default_submessage.filterable_primitive`, false},
+		{`test_filtering.default_submessage.filterable_primitive != test_filtering.filterable_submessage.filterable_primitive`, true},
+	} {
+		got, err := Evaluate(filtering.Filter{CheckedExpr: parse(t, tc.filter)}, msg)
+		if err != nil {
+			t.Fatalf("Evaluate(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if got != tc.want {
+			t.Errorf("Evaluate(%q) = %t, want %t", tc.filter, got, tc.want)
+		}
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	for _, tc := range []struct {
+		a, b   interface{}
+		want   int
+		wantOK bool
+	}{
+		{int64(1<<62 + 1), int64(1 << 62), 1, true},
+		{int64(1), 1.5, -1, true},
+		{uint64(2), int64(2), 0, true},
+		{[]byte("b"), "a", 1, true},
+		{false, true, -1, true},
+		{time.Unix(2, 0), time.Unix(1, 0), 1, true},
+		{"1", int64(1), 0, false},
+		{nil, nil, 0, false},
+	} {
+		if got, ok := compareValues(tc.a, tc.b); got != tc.want || ok != tc.wantOK {
+			t.Errorf("compareValues(%#v, %#v) = %d, %t, want %d, %t", tc.a, tc.b, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	msg := &test.TestFiltering{FilterablePrimitive: "a"}
+	if ok, err := Evaluate(filtering.Filter{}, msg); err != nil || !ok {
+		t.Errorf("Evaluate(empty) = %t, %v, want true, <nil>", ok, err)
+	}
+	filter := filtering.Filter{CheckedExpr: parse(t, `test_filtering.filterable_primitive = "b"`)}
+	if ok, err := Evaluate(filter, msg); err != nil || ok {
+		t.Errorf("Evaluate(%v) = %t, %v, want false, <nil>", filter, ok, err)
+	}
+	filter = filtering.Filter{CheckedExpr: &expr.CheckedExpr{Expr: &expr.Expr{ExprKind: &expr.Expr_CallExpr{CallExpr: &expr.Expr_Call{Function: "unknown"}}}}}
+	if _, err := Evaluate(filter, msg); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Evaluate(unknown function) err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestEvaluateTime(t *testing.T) {
+	msg := dynamicpb.NewMessage(testDescriptor)
+	msg.Set(testDescriptor.Fields().ByName("create_time"), protoreflect.ValueOfMessage(tspb.New(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)).ProtoReflect()))
+	msg.Set(testDescriptor.Fields().ByName("ttl"), protoreflect.ValueOfMessage(dpb.New(90*time.Second).ProtoReflect()))
+	for _, tc := range []struct {
+		filter string
+		want   bool
+	}{
+		{`test_filtering.create_time > "2024-01-01T00:00:00Z"`, true},
+		{`test_filtering.create_time > timestamp("2024-07-01T00:00:00Z")`, false},
+		{`test_filtering.ttl >= duration("90s")`, true},
+		{`test_filtering.ttl < duration("1m")`, false},
+	} {
+		ok, err := Evaluate(filtering.Filter{CheckedExpr: parse(t, tc.filter)}, msg)
+		if err != nil || ok != tc.want {
+			t.Errorf("Evaluate(%q) = %t, %v, want %t, <nil>", tc.filter, ok, err, tc.want)
+		}
+	}
+}
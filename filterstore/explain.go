@@ -0,0 +1,60 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/kagadar/go_proto_expression/protoexpr"
+)
+
+// Explanation describes how a List call was run, in place of the plan
+// summary and execution stats of Firestore's query explain.
+type Explanation struct {
+	// The queries run for the request.
+	Plan *Plan
+	// The reads of the queries. Each document fetched is billed as a document
+	// read.
+	Stats *ListStats
+	// The number of items returned on the page.
+	ResultsReturned int64
+	// The time taken to run the queries and decode the page.
+	Duration time.Duration
+}
+
+// Explain runs List for the request, returning the queries it ran and their
+// reads rather than the page.
+//
+// The explanation is emulated, as described in Emulated Queries in the
+// package documentation, from the Plan of the request and the ListStats of
+// the call.
+func (t *Transpiler[T]) Explain(ctx context.Context, req protoexpr.ListRequest, opts ...CallOption) (*Explanation, error) {
+	plan, err := t.Plan(req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	start := t.options.now()
+	page, err := t.List(ctx, req, append(opts[:len(opts):len(opts)], Stats())...)
+	if err != nil {
+		return nil, err
+	}
+	return &Explanation{
+		Plan:            plan,
+		Stats:           page.Stats,
+		ResultsReturned: int64(len(page.Items)),
+		Duration:        t.options.now().Sub(start),
+	}, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestExplain(t *testing.T) {
+	transpiler, fake, _ := fakeTranspiler(t, 10)
+	got, err := transpiler.Explain(context.Background(), &test.ListTestRequest{Parent: "parents/p", PageSize: 2, Filter: `test_filtering.filterable_primitive > "d04"`})
+	if err != nil {
+		t.Fatalf("Explain() err = %v, want <nil>", err)
+	}
+	if got.ResultsReturned != 2 {
+		t.Errorf("Explain().ResultsReturned = %d, want 2", got.ResultsReturned)
+	}
+	// One more document than the page size is fetched to find the next page.
+	if diff := cmp.Diff(&ListStats{DocumentsFetched: 3, Queries: 1}, got.Stats); diff != "" {
+		t.Errorf("Explain().Stats diff (-want +got):\n%s", diff)
+	}
+	if n := len(fake.queries()); len(got.Plan.Queries) != n {
+		t.Errorf("len(Explain().Plan.Queries) = %d, want %d queries run", len(got.Plan.Queries), n)
+	}
+	if got.Duration < 0 {
+		t.Errorf("Explain().Duration = %v, want >= 0", got.Duration)
+	}
+}
+
+func TestExplainInvalidFilter(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	if _, err := transpiler.Explain(context.Background(), &test.ListTestRequest{Filter: "test_filtering.missing = 1"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Explain() err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
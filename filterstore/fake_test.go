@@ -0,0 +1,530 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/option"
+	fspb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The path of the documents of the database of the fake.
+const fakeDocuments = "projects/test/databases/(default)/documents"
+
+// A fake Firestore which stores documents in memory, for tests of calls which
+// write documents or run queries. Queries support the filters, orderings,
+// projections and cursors the Transpiler builds, but transactions are not
+// isolated from other calls.
+type fakeFirestore struct {
+	fspb.UnimplementedFirestoreServer
+	// The indexes of the RunQuery calls which fail with Unavailable once they
+	// have sent a document.
+	failures map[int]bool
+	// The code the RunQuery calls with the indexes fail with before sending any
+	// document.
+	errors map[int]codes.Code
+
+	mu sync.Mutex
+	// The documents, by name.
+	docs     map[string]*fspb.Document
+	requests []*fspb.StructuredQuery
+}
+
+// Starts a fake Firestore without any documents, returning a client connected
+// to it.
+func newFakeFirestore(t *testing.T) (*fakeFirestore, *firestore.Client) {
+	t.Helper()
+	f := &fakeFirestore{failures: map[int]bool{}, errors: map[int]codes.Code{}, docs: map[string]*fspb.Document{}}
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	fspb.RegisterFirestoreServer(srv, f)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.Dial() err = %v, want <nil>", err)
+	}
+	client, err := firestore.NewClient(context.Background(), "test", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatalf("firestore.NewClient() err = %v, want <nil>", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return f, client
+}
+
+// Returns the structured queries run so far.
+func (f *fakeFirestore) queries() []*fspb.StructuredQuery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*fspb.StructuredQuery(nil), f.requests...)
+}
+
+// Returns the paths of the stored documents, relative to the database, in
+// order.
+func (f *fakeFirestore) paths() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var paths []string
+	for name := range f.docs {
+		paths = append(paths, strings.TrimPrefix(name, fakeDocuments+"/"))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (f *fakeFirestore) BeginTransaction(context.Context, *fspb.BeginTransactionRequest) (*fspb.BeginTransactionResponse, error) {
+	return &fspb.BeginTransactionResponse{Transaction: []byte("transaction")}, nil
+}
+
+func (f *fakeFirestore) Rollback(context.Context, *fspb.RollbackRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (f *fakeFirestore) Commit(_ context.Context, req *fspb.CommitRequest) (*fspb.CommitResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := timestamppb.Now()
+	resp := &fspb.CommitResponse{CommitTime: now}
+	for _, w := range req.GetWrites() {
+		switch op := w.GetOperation().(type) {
+		case *fspb.Write_Delete:
+			delete(f.docs, op.Delete)
+		case *fspb.Write_Update:
+			doc := proto.Clone(op.Update).(*fspb.Document)
+			doc.CreateTime, doc.UpdateTime = now, now
+			if old, ok := f.docs[doc.GetName()]; ok && w.GetUpdateMask() != nil {
+				// Only the masked paths are replaced.
+				merged := proto.Clone(old).(*fspb.Document)
+				merged.UpdateTime = now
+				for _, path := range w.GetUpdateMask().GetFieldPaths() {
+					setField(merged.Fields, splitFieldPath(path), fieldValue(doc.Fields, splitFieldPath(path)))
+				}
+				doc = merged
+			} else if ok {
+				doc.CreateTime = old.GetCreateTime()
+			}
+			if doc.Fields == nil {
+				doc.Fields = map[string]*fspb.Value{}
+			}
+			f.docs[doc.GetName()] = doc
+		default:
+			return nil, status.Errorf(codes.Unimplemented, "fake cannot write %T", op)
+		}
+		resp.WriteResults = append(resp.WriteResults, &fspb.WriteResult{UpdateTime: now})
+	}
+	return resp, nil
+}
+
+func (f *fakeFirestore) ListDocuments(_ context.Context, req *fspb.ListDocumentsRequest) (*fspb.ListDocumentsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &fspb.ListDocumentsResponse{}
+	for _, doc := range f.collection(req.GetParent(), req.GetCollectionId(), false) {
+		resp.Documents = append(resp.Documents, &fspb.Document{Name: doc.GetName()})
+	}
+	return resp, nil
+}
+
+// Returns the documents of the collection with the ID under the parent, or
+// of every collection with the ID if allDescendants is set, in name order.
+func (f *fakeFirestore) collection(parent, id string, allDescendants bool) []*fspb.Document {
+	var docs []*fspb.Document
+	for name, doc := range f.docs {
+		if !strings.HasPrefix(name, parent+"/") {
+			continue
+		}
+		segments := strings.Split(strings.TrimPrefix(name, parent+"/"), "/")
+		if segments[len(segments)-2] == id && (allDescendants || len(segments) == 2) {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].GetName() < docs[j].GetName() })
+	return docs
+}
+
+func (f *fakeFirestore) RunQuery(req *fspb.RunQueryRequest, stream fspb.Firestore_RunQueryServer) error {
+	q := req.GetStructuredQuery()
+	f.mu.Lock()
+	call := len(f.requests)
+	f.requests = append(f.requests, q)
+	if code, ok := f.errors[call]; ok {
+		f.mu.Unlock()
+		return status.Error(code, "fake error")
+	}
+	from := q.GetFrom()[0]
+	candidates := f.collection(req.GetParent(), from.GetCollectionId(), from.GetAllDescendants())
+	f.mu.Unlock()
+	// Documents are ordered by name last, in the direction of the last
+	// ordering.
+	orders := q.GetOrderBy()
+	if n := len(orders); n == 0 || orders[n-1].GetField().GetFieldPath() != firestore.DocumentID {
+		dir := fspb.StructuredQuery_ASCENDING
+		if n > 0 {
+			dir = orders[n-1].GetDirection()
+		}
+		orders = append(orders[:n:n], &fspb.StructuredQuery_Order{
+			Field:     &fspb.StructuredQuery_FieldReference{FieldPath: firestore.DocumentID},
+			Direction: dir,
+		})
+	}
+	type match struct {
+		doc  *fspb.Document
+		keys []*fspb.Value
+	}
+	var matches []match
+	for _, doc := range candidates {
+		if q.GetWhere() != nil && !matchesFilter(doc, q.GetWhere()) {
+			continue
+		}
+		// Documents without a value of an ordered field are excluded.
+		m := match{doc: doc}
+		for _, o := range orders {
+			v := documentValue(doc, o.GetField().GetFieldPath())
+			if v == nil {
+				break
+			}
+			m.keys = append(m.keys, v)
+		}
+		if len(m.keys) == len(orders) {
+			matches = append(matches, m)
+		}
+	}
+	// Compares the keys to the values of a cursor, in the order of the query.
+	compare := func(keys, values []*fspb.Value) int {
+		for i, v := range values {
+			c := compareStored(keys[i], v)
+			if orders[i].GetDirection() == fspb.StructuredQuery_DESCENDING {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return compare(matches[i].keys, matches[j].keys) < 0 })
+	var docs []*fspb.Document
+	for _, m := range matches {
+		if c := q.GetStartAt(); c != nil {
+			cmp := compare(m.keys, c.GetValues())
+			if cmp < 0 || (cmp == 0 && !c.GetBefore()) {
+				continue
+			}
+		}
+		if c := q.GetEndAt(); c != nil {
+			cmp := compare(m.keys, c.GetValues())
+			if cmp > 0 || (cmp == 0 && c.GetBefore()) {
+				continue
+			}
+		}
+		docs = append(docs, project(m.doc, q.GetSelect()))
+	}
+	if offset := int(q.GetOffset()); offset < len(docs) {
+		docs = docs[offset:]
+	} else {
+		docs = nil
+	}
+	if q.GetLimit() != nil && int(q.GetLimit().GetValue()) < len(docs) {
+		docs = docs[:q.GetLimit().GetValue()]
+	}
+	for i, doc := range docs {
+		if i == 1 && f.failures[call] {
+			return status.Error(codes.Unavailable, "fake failure")
+		}
+		if err := stream.Send(&fspb.RunQueryResponse{Document: doc, ReadTime: timestamppb.Now()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns a copy of the document with only the fields of the projection, if
+// there is one.
+func project(doc *fspb.Document, projection *fspb.StructuredQuery_Projection) *fspb.Document {
+	doc = proto.Clone(doc).(*fspb.Document)
+	if projection == nil {
+		return doc
+	}
+	fields := map[string]*fspb.Value{}
+	for _, ref := range projection.GetFields() {
+		path := splitFieldPath(ref.GetFieldPath())
+		if v := fieldValue(doc.Fields, path); v != nil {
+			setField(fields, path, v)
+		}
+	}
+	doc.Fields = fields
+	return doc
+}
+
+// Splits the field path into its segments, unquoting those quoted with
+// backticks.
+func splitFieldPath(path string) []string {
+	var segments []string
+	for path != "" {
+		var segment string
+		if strings.HasPrefix(path, "`") {
+			end := strings.Index(path[1:], "`") + 1
+			segment, path = strings.ReplaceAll(path[1:end], "\\`", "`"), path[end+1:]
+		} else if i := strings.IndexByte(path, '.'); i >= 0 {
+			segment, path = path[:i], path[i:]
+		} else {
+			segment, path = path, ""
+		}
+		segments = append(segments, segment)
+		path = strings.TrimPrefix(path, ".")
+	}
+	return segments
+}
+
+// Returns the value at the path of the fields, or nil if there is none.
+func fieldValue(fields map[string]*fspb.Value, path []string) *fspb.Value {
+	v := fields[path[0]]
+	if len(path) == 1 || v.GetMapValue() == nil {
+		if len(path) > 1 {
+			return nil
+		}
+		return v
+	}
+	return fieldValue(v.GetMapValue().GetFields(), path[1:])
+}
+
+// Sets the value at the path of the fields, creating maps as needed, or
+// deletes it if the value is nil.
+func setField(fields map[string]*fspb.Value, path []string, v *fspb.Value) {
+	if len(path) == 1 {
+		if v == nil {
+			delete(fields, path[0])
+		} else {
+			fields[path[0]] = v
+		}
+		return
+	}
+	if fields[path[0]].GetMapValue() == nil {
+		fields[path[0]] = &fspb.Value{ValueType: &fspb.Value_MapValue{MapValue: &fspb.MapValue{Fields: map[string]*fspb.Value{}}}}
+	}
+	setField(fields[path[0]].GetMapValue().Fields, path[1:], v)
+}
+
+// Returns the value of the field path of the document, which is a reference
+// to the document for its name, or nil if there is none.
+func documentValue(doc *fspb.Document, path string) *fspb.Value {
+	if path == firestore.DocumentID {
+		return &fspb.Value{ValueType: &fspb.Value_ReferenceValue{ReferenceValue: doc.GetName()}}
+	}
+	return fieldValue(doc.GetFields(), splitFieldPath(path))
+}
+
+// Reports whether the document matches the filter.
+func matchesFilter(doc *fspb.Document, filter *fspb.StructuredQuery_Filter) bool {
+	switch f := filter.GetFilterType().(type) {
+	case *fspb.StructuredQuery_Filter_CompositeFilter:
+		// Filters can only be joined with AND.
+		for _, sub := range f.CompositeFilter.GetFilters() {
+			if !matchesFilter(doc, sub) {
+				return false
+			}
+		}
+		return true
+	case *fspb.StructuredQuery_Filter_UnaryFilter:
+		v := documentValue(doc, f.UnaryFilter.GetField().GetFieldPath())
+		if v == nil {
+			return false
+		}
+		_, null := v.GetValueType().(*fspb.Value_NullValue)
+		nan := math.IsNaN(v.GetDoubleValue())
+		switch f.UnaryFilter.GetOp() {
+		case fspb.StructuredQuery_UnaryFilter_IS_NULL:
+			return null
+		case fspb.StructuredQuery_UnaryFilter_IS_NOT_NULL:
+			return !null
+		case fspb.StructuredQuery_UnaryFilter_IS_NAN:
+			return nan
+		case fspb.StructuredQuery_UnaryFilter_IS_NOT_NAN:
+			return !nan
+		}
+	case *fspb.StructuredQuery_Filter_FieldFilter:
+		v := documentValue(doc, f.FieldFilter.GetField().GetFieldPath())
+		if v == nil {
+			return false
+		}
+		want := f.FieldFilter.GetValue()
+		_, null := v.GetValueType().(*fspb.Value_NullValue)
+		contains := func(values []*fspb.Value, v *fspb.Value) bool {
+			for _, value := range values {
+				if compareStored(v, value) == 0 {
+					return true
+				}
+			}
+			return false
+		}
+		// Inequalities only match values of the same type.
+		c, comparable := compareStored(v, want), storedRank(v) == storedRank(want)
+		switch f.FieldFilter.GetOp() {
+		case fspb.StructuredQuery_FieldFilter_EQUAL:
+			return c == 0
+		case fspb.StructuredQuery_FieldFilter_NOT_EQUAL:
+			return !null && c != 0
+		case fspb.StructuredQuery_FieldFilter_LESS_THAN:
+			return comparable && c < 0
+		case fspb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL:
+			return comparable && c <= 0
+		case fspb.StructuredQuery_FieldFilter_GREATER_THAN:
+			return comparable && c > 0
+		case fspb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL:
+			return comparable && c >= 0
+		case fspb.StructuredQuery_FieldFilter_IN:
+			return contains(want.GetArrayValue().GetValues(), v)
+		case fspb.StructuredQuery_FieldFilter_NOT_IN:
+			return !null && !contains(want.GetArrayValue().GetValues(), v)
+		case fspb.StructuredQuery_FieldFilter_ARRAY_CONTAINS:
+			return contains(v.GetArrayValue().GetValues(), want)
+		case fspb.StructuredQuery_FieldFilter_ARRAY_CONTAINS_ANY:
+			for _, value := range want.GetArrayValue().GetValues() {
+				if contains(v.GetArrayValue().GetValues(), value) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Returns the rank of the type of the value in the order of values of
+// different types:
+// https://firebase.google.com/docs/firestore/manage-data/data-types#value_type_ordering
+func storedRank(v *fspb.Value) int {
+	switch v.GetValueType().(type) {
+	case *fspb.Value_NullValue:
+		return 0
+	case *fspb.Value_BooleanValue:
+		return 1
+	case *fspb.Value_IntegerValue, *fspb.Value_DoubleValue:
+		return 2
+	case *fspb.Value_TimestampValue:
+		return 3
+	case *fspb.Value_StringValue:
+		return 4
+	case *fspb.Value_BytesValue:
+		return 5
+	case *fspb.Value_ReferenceValue:
+		return 6
+	case *fspb.Value_GeoPointValue:
+		return 7
+	case *fspb.Value_ArrayValue:
+		return 8
+	}
+	return 9
+}
+
+// Compares the values in the order Firestore sorts them. NaN is equal to
+// itself, and less than every other number.
+func compareStored(a, b *fspb.Value) int {
+	if ra, rb := storedRank(a), storedRank(b); ra != rb {
+		return ra - rb
+	}
+	switch a.GetValueType().(type) {
+	case *fspb.Value_BooleanValue:
+		return compareOrdered(boolRank(a.GetBooleanValue()), boolRank(b.GetBooleanValue()))
+	case *fspb.Value_IntegerValue, *fspb.Value_DoubleValue:
+		_, ai := a.GetValueType().(*fspb.Value_IntegerValue)
+		_, bi := b.GetValueType().(*fspb.Value_IntegerValue)
+		if ai && bi {
+			return compareOrdered(a.GetIntegerValue(), b.GetIntegerValue())
+		}
+		x, y := a.GetDoubleValue(), b.GetDoubleValue()
+		if ai {
+			x = float64(a.GetIntegerValue())
+		}
+		if bi {
+			y = float64(b.GetIntegerValue())
+		}
+		if xn, yn := math.IsNaN(x), math.IsNaN(y); xn || yn {
+			return compareOrdered(boolRank(!xn), boolRank(!yn))
+		}
+		return compareOrdered(x, y)
+	case *fspb.Value_TimestampValue:
+		return compareOrdered(a.GetTimestampValue().AsTime().UnixNano(), b.GetTimestampValue().AsTime().UnixNano())
+	case *fspb.Value_StringValue:
+		return strings.Compare(a.GetStringValue(), b.GetStringValue())
+	case *fspb.Value_BytesValue:
+		return strings.Compare(string(a.GetBytesValue()), string(b.GetBytesValue()))
+	case *fspb.Value_ReferenceValue:
+		return strings.Compare(a.GetReferenceValue(), b.GetReferenceValue())
+	case *fspb.Value_ArrayValue:
+		x, y := a.GetArrayValue().GetValues(), b.GetArrayValue().GetValues()
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareStored(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return compareOrdered(len(x), len(y))
+	case *fspb.Value_MapValue:
+		x, y := a.GetMapValue().GetFields(), b.GetMapValue().GetFields()
+		keys := func(fields map[string]*fspb.Value) []string {
+			var keys []string
+			for k := range fields {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return keys
+		}
+		xk, yk := keys(x), keys(y)
+		for i := 0; i < len(xk) && i < len(yk); i++ {
+			if c := strings.Compare(xk[i], yk[i]); c != 0 {
+				return c
+			}
+			if c := compareStored(x[xk[i]], y[yk[i]]); c != 0 {
+				return c
+			}
+		}
+		return compareOrdered(len(xk), len(yk))
+	}
+	return 0
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func compareOrdered[V int | int64 | float64](a, b V) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
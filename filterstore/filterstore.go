@@ -15,56 +15,19 @@
 package filterstore
 
 import (
-	"context"
 	"fmt"
-	"log"
+	"regexp"
 	"strings"
 
 	"cloud.google.com/go/firestore"
-	"github.com/iancoleman/strcase"
-	"github.com/kagadar/go_proto_expression/protoexpr"
 	"go.einride.tech/aip/filtering"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 
 	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
 )
 
-type transpiler[T proto.Message] struct {
-	client *firestore.Client
-}
-
-func (t transpiler[T]) Transpile(ctx context.Context, factory func() T, parent, collection, pageToken string, pageSize int32, filter *expr.CheckedExpr) ([]T, string, error) {
-	q := &query{q: t.client.Collection(fmt.Sprintf("%s/%s", parent, collection)).Limit(int(pageSize)), types: filter.GetTypeMap()}
-	if err := q.transpile(filter.GetExpr(), false); err != nil {
-		return nil, "", err
-	}
-	if pageToken != "" {
-		q.q = q.q.OrderBy(firestore.DocumentID, firestore.Asc)
-		q.startAfter = append(q.startAfter, pageToken)
-	}
-	if len(q.startAfter) > 0 {
-		q.q = q.q.StartAfter(q.startAfter...)
-	}
-	docs, err := q.q.Documents(ctx).GetAll()
-	if err != nil {
-		return nil, "", err
-	}
-	data := make([]T, len(docs))
-	for i, doc := range docs {
-		data[i] = factory()
-		doc.DataTo(data[i])
-	}
-	return data, "", nil
-}
-
-// Creates a new Firestore transpiler for requests to the specified List method.
-func New[T proto.Message](client *firestore.Client, mtd protoreflect.MethodDescriptor, msg T) (protoexpr.Transpiler[T], error) {
-	return protoexpr.New[T](transpiler[T]{client: client}, mtd, msg)
-}
-
 // Returns the appropriate firestore operator for the specified function.
 func operator(function string, not bool) (string, error) {
 	switch function {
@@ -103,22 +66,111 @@ func operator(function string, not bool) (string, error) {
 	if not {
 		notStr = "NOT "
 	}
-	return "", status.Errorf(codes.InvalidArgument, "no Firestore operator for %s%s", notStr, function)
+	return "", invalidArgument(ErrUnsupportedFunction, "no Firestore operator for %s%s", notStr, function)
 }
 
-// Returns the Firestore path for the provided Expr.
-func toPath(e *expr.Expr) (string, error) {
-	switch e.GetExprKind().(type) {
-	case *expr.Expr_SelectExpr:
-		p, err := toPath(e.GetSelectExpr().GetOperand())
-		if err != nil {
-			return "", err
+// The comparison which matches the same values with its operands swapped.
+var swappedComparisons = map[string]string{
+	filtering.FunctionEquals:        filtering.FunctionEquals,
+	filtering.FunctionNotEquals:     filtering.FunctionNotEquals,
+	filtering.FunctionLessThan:      filtering.FunctionGreaterThan,
+	filtering.FunctionLessEquals:    filtering.FunctionGreaterEquals,
+	filtering.FunctionGreaterThan:   filtering.FunctionLessThan,
+	filtering.FunctionGreaterEquals: filtering.FunctionLessEquals,
+}
+
+// Reports whether the call compares two fields, such as `spent > budget`,
+// which Firestore cannot evaluate.
+func fieldComparison(call *expr.Expr_Call) bool {
+	_, ok := swappedComparisons[call.GetFunction()]
+	return ok && len(call.Args) == 2 && call.Args[0].GetSelectExpr() != nil && call.Args[1].GetSelectExpr() != nil
+}
+
+// Swaps the operands of every comparison within the expression which has a
+// field on the right and none on the left, such as `5 < priority`, which is
+// rewritten as `priority > 5`, as fields are compared on the left.
+func orientComparisons(e *expr.Expr) {
+	call := e.GetCallExpr()
+	for _, arg := range call.GetArgs() {
+		orientComparisons(arg)
+	}
+	swapped, ok := swappedComparisons[call.GetFunction()]
+	if !ok || len(call.Args) != 2 || call.Args[0].GetSelectExpr() != nil || call.Args[1].GetSelectExpr() == nil {
+		return
+	}
+	call.Function = swapped
+	call.Args[0], call.Args[1] = call.Args[1], call.Args[0]
+}
+
+// Matches path segments which do not need to be quoted.
+var simpleSegment = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z_0-9]*$`)
+
+// Returns the string form of the provided path, quoting any segments which
+// contain special characters, as Firestore does:
+// https://firebase.google.com/docs/firestore/quotas#collections_documents_and_fields
+func pathString(path firestore.FieldPath) string {
+	segments := make([]string, len(path))
+	for i, segment := range path {
+		if simpleSegment.MatchString(segment) {
+			segments[i] = segment
+		} else {
+			segments[i] = "`" + strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(segment) + "`"
 		}
-		return fmt.Sprintf("%s.%s", p, strcase.ToCamel(e.GetSelectExpr().GetField())), nil
-	case *expr.Expr_IdentExpr:
-		return strcase.ToCamel(e.GetIdentExpr().GetName()), nil
 	}
-	return "", status.Errorf(codes.InvalidArgument, "unable to get path for expression: %v", e)
+	return strings.Join(segments, ".")
+}
+
+// Checks that the map key can be used as a segment of a Firestore path. Keys
+// may contain any character, such as . or `, as segments are quoted as needed,
+// but must not be empty.
+func checkMapKey(key string) error {
+	if key == "" {
+		return status.Error(codes.InvalidArgument, "map keys must not be empty")
+	}
+	return nil
+}
+
+// Reports whether the argument is a constant, an enum value, which is an
+// ident, or a call of timestamp() or duration(), as opposed to a field.
+func constantArg(e *expr.Expr) bool {
+	return e.GetConstExpr() != nil || e.GetIdentExpr() != nil || timeCall(e)
+}
+
+// Returns the stored value of the constant compared to the field.
+// Enum values are resolved by name, and stored as set by WithEnumStorage.
+func (q *query) value(field, arg *expr.Expr) (interface{}, error) {
+	_, fd, err := q.resolvePath(field)
+	if err != nil {
+		return nil, err
+	}
+	if nullArg(arg) {
+		return nil, nil
+	}
+	if timeCall(arg) {
+		return timeConstant(arg)
+	}
+	if timestampField(fd) {
+		return timestampConstant(arg.GetConstExpr())
+	}
+	if fd == nil || fd.Enum() == nil {
+		return unwrapConst(arg.GetConstExpr()), nil
+	}
+	value := enumValue(fd, arg)
+	if value == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not a value of %s", canonical(arg, false), fd.Enum().FullName())
+	}
+	return q.names.storedEnum(fd, value), nil
+}
+
+// Returns the enum value compared to the enum field, which is named by an enum
+// constant, such as `state = ACTIVE`, or a string, such as `state = "ACTIVE"`,
+// or nil if the enum has no such value.
+func enumValue(fd protoreflect.FieldDescriptor, e *expr.Expr) protoreflect.EnumValueDescriptor {
+	name := e.GetConstExpr().GetStringValue()
+	if ident := e.GetIdentExpr(); ident != nil {
+		name = ident.GetName()
+	}
+	return fd.Enum().Values().ByName(protoreflect.Name(name))
 }
 
 func unwrapConst(c *expr.Constant) interface{} {
@@ -140,80 +192,855 @@ func unwrapConst(c *expr.Constant) interface{} {
 	}
 }
 
+// The features of Firestore which a query may use.
+type capabilities struct {
+	// Whether inequalities may be used on multiple fields:
+	// https://firebase.google.com/docs/firestore/query-data/multiple-range-fields
+	multipleInequalities bool
+	// Whether `!=` and `not-in` filters must not be used, as the database
+	// predates them.
+	noNotEquals bool
+	// The number of values allowed in an `in` or `array-contains-any` filter,
+	// or maxDisjunctions if 0.
+	disjunctions int
+	// The number of values allowed in a `not-in` filter, or maxNotIn if 0.
+	notIn int
+}
+
+// Returns the number of values allowed in an `in` or `array-contains-any`
+// filter.
+func (c capabilities) disjunctionLimit() int {
+	if c.disjunctions > 0 {
+		return c.disjunctions
+	}
+	return maxDisjunctions
+}
+
+// Returns the number of values allowed in a `not-in` filter.
+func (c capabilities) notInLimit() int {
+	if c.notIn > 0 {
+		return c.notIn
+	}
+	return maxNotIn
+}
+
+// Transpiles the provided filter of fields of the message onto the base query.
+// NOT is first pushed down to the filters it negates.
+func newQuery(base firestore.Query, msg protoreflect.MessageDescriptor, names fieldNames, filter *expr.CheckedExpr, caps capabilities) (*query, error) {
+	q := &query{q: base, msg: msg, names: names, caps: caps}
+	if err := q.transpileFilter(filter); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Transpiles the provided filter onto the query.
+func (q *query) transpileFilter(filter *expr.CheckedExpr) error {
+	q.types, q.positions = filter.GetTypeMap(), filter.GetSourceInfo().GetPositions()
+	if err := q.transpile(pushNegations(filter.GetExpr(), false), false); err != nil {
+		return invalidField("filter", err)
+	}
+	if err := q.transpileSet(); err != nil {
+		return invalidField("filter", err)
+	}
+	return nil
+}
+
+// Firestore limits the number of values that can be used in a disjunction:
+// https://firebase.google.com/docs/firestore/query-data/queries#limits_on_or_queries
+const maxDisjunctions = 30
+
+// Firestore limits the number of fields that can be used in inequalities:
+// https://firebase.google.com/docs/firestore/query-data/multiple-range-fields#limitations
+const maxInequalities = 10
+
+// Firestore limits the number of values that can be used in a `not-in` filter:
+// https://firebase.google.com/docs/firestore/query-data/queries#not-in
+const maxNotIn = 10
+
+// Bounds the number of queries a filter can be split into when it cannot be
+// expressed in a single query.
+const maxFanOutQueries = 100
+
+// Collects the values of a chain of comparisons on a single path, joined by
+// the same logical function, such as `a = 1 OR a = 2 OR a = 3`,
+// `a != 1 AND a != 2` or `a:1 OR a:2` (where a is a list).
+// Returns false if the call is not such a chain.
+func (q *query) valueSet(e *expr.Expr_Call, join, function string) (firestore.FieldPath, []interface{}, bool) {
+	var path firestore.FieldPath
+	var values []interface{}
+	for _, arg := range e.Args {
+		call := arg.GetCallExpr()
+		switch call.GetFunction() {
+		case join:
+			p, v, ok := q.valueSet(call, join, function)
+			if !ok || (path != nil && pathString(p) != pathString(path)) {
+				return nil, nil, false
+			}
+			path = p
+			values = append(values, v...)
+		case function:
+			// Null is compared by its own filters, rather than by `in`.
+			if len(call.Args) != 2 || !constantArg(call.Args[1]) || nullArg(call.Args[1]) {
+				return nil, nil, false
+			}
+			if _, ok := q.types[call.Args[0].Id].GetTypeKind().(*expr.Type_ListType_); function == filtering.FunctionHas && !ok {
+				return nil, nil, false
+			}
+			p, err := q.fieldPath(call.Args[0])
+			if err != nil || len(p) == 0 || (path != nil && pathString(p) != pathString(path)) {
+				return nil, nil, false
+			}
+			v, err := q.value(call.Args[0], call.Args[1])
+			if err != nil {
+				return nil, nil, false
+			}
+			path = p
+			values = append(values, v)
+		default:
+			return nil, nil, false
+		}
+	}
+	return path, values, path != nil
+}
+
+// A disjunction of filters which cannot be expressed in a single query, such
+// as a filter with more values than Firestore allows, so must be split across
+// multiple queries.
+type fanOut struct {
+	// The field named in errors, or the empty string if the filters are on
+	// different fields.
+	field   string
+	clauses []clause
+}
+
+// A single Firestore filter.
+type clause struct {
+	path  firestore.FieldPath
+	op    string
+	value interface{}
+}
+
+// Describes the fan-out in errors.
+func (f fanOut) describe() string {
+	if f.field == "" {
+		return "OR"
+	}
+	return f.field
+}
+
+// Returns the number of queries the fan-outs must be split into.
+func (q *query) fanOutQueries() int {
+	n := 1
+	for _, f := range q.fanOuts {
+		n *= len(f.clauses)
+	}
+	return n
+}
+
+// Adds the fan-out to the query, unless it requires too many queries.
+func (q *query) addFanOut(f fanOut) error {
+	q.fanOuts = append(q.fanOuts, f)
+	if n := q.fanOutQueries(); n > maxFanOutQueries {
+		return invalidArgument(ErrTooManyQueries, "filter requires %d queries, at most %d are allowed", n, maxFanOutQueries)
+	}
+	return nil
+}
+
+// The state of transpiling the filter of a single call, which is never shared
+// between calls. The only configuration of the Transpiler it holds is a copy
+// of the capabilities of the database.
 type query struct {
 	q          firestore.Query
 	subqueries []*query
-	types      map[int64]*expr.Type
-	// Firestore only allows one field to participate in inequality:
+	// The message of the documents, which every field path must exist in.
+	msg protoreflect.MessageDescriptor
+	// The repeated message field of msg whose documents each hold one of its
+	// elements, such as index documents, so paths may select the fields of its
+	// message.
+	element protoreflect.FieldDescriptor
+	names   fieldNames
+	types   map[int64]*expr.Type
+	// The offsets of the expressions of the filter, by ID.
+	positions map[int64]int32
+	caps      capabilities
+	// Unless multipleInequalities is supported, Firestore only allows one field
+	// to participate in inequality:
 	// https://firebase.google.com/docs/firestore/query-data/queries#query_limitations
-	// If an inequality call is made on more than one field, reject the filter.
-	inequality string
+	// If an inequality call is made on more fields, reject the filter.
+	inequalities []firestore.FieldPath
+	// Firestore only allows one `!=` or `not-in` filter per query, so the path
+	// of that filter, if any.
+	excluded firestore.FieldPath
+	// Firestore does not allow a `not-in` filter in the same query as an `in`
+	// or `array-contains-any` filter, so the paths of those filters, if any.
+	notIn      firestore.FieldPath
+	anyOf      firestore.FieldPath
 	startAfter []interface{}
+	// The query starts after null to check that a field is set, so it cannot be
+	// run in reverse from a cursor, which would reach the documents where the
+	// field is null.
+	afterNull bool
+	// The query is run in reverse, to retrieve the documents before a cursor, so
+	// each ordering is applied in the opposite direction to the one reported.
+	reverse bool
+	// The order_by (https://google.aip.dev/132#ordering) equivalent of the
+	// orderings applied to the query.
+	orderBy []string
+	orders  []fieldOrder
+	fanOuts []fanOut
+	// The paths which must be set, and the paths already filtered to a value,
+	// which are therefore set.
+	set    []firestore.FieldPath
+	values []firestore.FieldPath
+	// The filter can never match, so no queries need to be run.
+	none     bool
+	warnings []Warning
+}
+
+// Returns the Firestore queries, positioned after any required cursors.
+// Multiple queries are only returned if a filter had to be fanned out, in which
+// case the documents of every query must be merged by name.
+func (q *query) build() ([]firestore.Query, error) {
+	if q.none {
+		return nil, nil
+	}
+	base := q.q
+	if len(q.startAfter) > 0 {
+		base = base.StartAfter(q.startAfter...)
+	}
+	if len(q.fanOuts) == 0 {
+		return []firestore.Query{base}, nil
+	}
+	// Merging by name is only possible if every query is ordered by name.
+	if len(q.inequalities) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s cannot be split across queries when an inequality is used", q.fanOuts[0].describe())
+	}
+	for _, path := range q.orderBy {
+		if path != firestore.DocumentID {
+			return nil, status.Errorf(codes.InvalidArgument, "%s cannot be split across queries when ordered by %s", q.fanOuts[0].describe(), path)
+		}
+	}
+	// Every clause of each fan-out is combined with every clause of the others.
+	qs := []firestore.Query{base}
+	for _, f := range q.fanOuts {
+		var next []firestore.Query
+		for _, c := range f.clauses {
+			for _, q := range qs {
+				next = append(next, q.WherePath(c.path, c.op, c.value))
+			}
+		}
+		qs = next
+	}
+	return qs, nil
+}
+
+// Orders the query by the specified path, unless it is already ordered by it.
+func (q *query) order(path firestore.FieldPath, dir firestore.Direction) {
+	for _, o := range q.orders {
+		if pathString(o.path) == pathString(path) {
+			return
+		}
+	}
+	q.orders = append(q.orders, fieldOrder{path: path, dir: dir})
+	run := dir
+	if q.reverse && dir == firestore.Asc {
+		run = firestore.Desc
+	} else if q.reverse {
+		run = firestore.Asc
+	}
+	q.q = q.q.OrderByPath(path, run)
+	orderBy := pathString(path)
+	if dir == firestore.Desc {
+		orderBy += " desc"
+	}
+	q.orderBy = append(q.orderBy, orderBy)
+}
+
+// Returns the Firestore path for the provided Expr, relative to the document.
+// The root Ident of every field is the collection message itself, which is
+// not part of the path.
+// Fields of a map are its keys, which are used verbatim.
+func (q *query) fieldPath(e *expr.Expr) (firestore.FieldPath, error) {
+	path, _, err := q.resolvePath(e)
+	return path, err
+}
+
+// Returns the Firestore path for the provided Expr, along with the descriptor
+// of the field it selects, which is nil for the collection message itself.
+// Wrapper fields are resolved to the value they wrap.
+// Returns an INVALID_ARGUMENT error if the field does not exist in the
+// message, so that misspelled fields are not silently queried.
+func (q *query) resolvePath(e *expr.Expr) (firestore.FieldPath, protoreflect.FieldDescriptor, error) {
+	switch e.GetExprKind().(type) {
+	case *expr.Expr_SelectExpr:
+		sel := e.GetSelectExpr()
+		path, parent, err := q.resolvePath(sel.GetOperand())
+		if err != nil {
+			return nil, nil, err
+		}
+		path = path[:len(path):len(path)]
+		msg := q.msg
+		switch {
+		case parent == nil:
+		case parent.IsMap():
+			if err := checkMapKey(sel.GetField()); err != nil {
+				return nil, nil, err
+			}
+			return append(path, sel.GetField()), parent.MapValue(), nil
+		case structField(parent) || structValue(parent):
+			// Structs are stored as nested maps.
+			if err := checkMapKey(sel.GetField()); err != nil {
+				return nil, nil, err
+			}
+			return append(path, sel.GetField()), structValueField, nil
+		case wrapperValue(parent) && sel.GetField() == string(wrapperValueName):
+			// Wrappers are already resolved to their value.
+			return path, parent, nil
+		case parent == q.element:
+			// Each document holds a single element, stored under the name of the
+			// repeated field.
+			msg = parent.Message()
+		case parent.IsList() || parent.Message() == nil:
+			name, _ := selectName(e)
+			return nil, nil, invalidArgument(ErrUnknownField, "%s is not a field, as %s is not a message", name, parent.Name())
+		default:
+			msg = parent.Message()
+		}
+		fd := msg.Fields().ByName(protoreflect.Name(sel.GetField()))
+		if fd == nil {
+			name, _ := selectName(e)
+			return nil, nil, invalidArgument(ErrUnknownField, "%s is not a field of %s", name, msg.FullName())
+		}
+		path, fd = q.names.unwrap(append(path, q.names.name(fd)), fd)
+		return path, fd, nil
+	case *expr.Expr_IdentExpr:
+		return nil, nil, nil
+	}
+	return nil, nil, status.Errorf(codes.InvalidArgument, "unable to get path for expression: %v", e)
 }
 
 // Checks if an inequality has already been set in this query.
 // If set to a path other than the one provided, the query is invalid.
-func (q *query) setInequality(path string) error {
-	if q.inequality == "" {
-		q.inequality = path
-	} else if q.inequality != path {
-		return status.Error(codes.InvalidArgument, "inequality can only be used on a single field")
+func (q *query) setInequality(path firestore.FieldPath) error {
+	if containsPath(q.inequalities, path) {
+		return nil
+	}
+	switch {
+	case len(q.inequalities) > 0 && !q.caps.multipleInequalities:
+		return invalidArgument(ErrMultipleInequalities, "inequality can only be used on a single field")
+	case len(q.inequalities) == maxInequalities:
+		return invalidArgument(ErrMultipleInequalities, "inequality can only be used on %d fields", maxInequalities)
+	}
+	q.inequalities = append(q.inequalities, path)
+	return nil
+}
+
+// Records a `!=` or `not-in` filter of the path, of which Firestore only
+// allows one per query.
+func (q *query) setExcluded(path firestore.FieldPath) error {
+	if q.excluded != nil {
+		return invalidArgument(ErrMultipleInequalities, "only one field can be compared with != or excluded from values, got %s and %s, so one of them must be evaluated once documents are retrieved with WithResidualFiltering", pathString(q.excluded), pathString(path))
 	}
+	q.excluded = path
 	return nil
 }
 
+// Rejects a `not-in` filter in the same query as an `in` or
+// `array-contains-any` filter.
+func (q *query) checkNotIn() error {
+	if q.notIn != nil && q.anyOf != nil {
+		return invalidArgument(ErrUnsupportedFunction, "%s cannot be excluded from values when %s is compared to a list of values, so one of them must be evaluated once documents are retrieved with WithResidualFiltering", pathString(q.notIn), pathString(q.anyOf))
+	}
+	return nil
+}
+
+// The argument of `:` which checks that a field is set, such as `a:*`.
+const presenceWildcard = "*"
+
 // Checks if the specified field has a value.
 func (q *query) transpileHas(e *expr.Expr_Call, not bool) error {
 	if len(e.Args) != 2 {
 		return status.Error(codes.InvalidArgument, ": requires two arguments")
 	}
+	path, fd, err := q.resolvePath(e.Args[0])
+	if err != nil {
+		return err
+	}
+	if e.Args[1].GetConstExpr().GetStringValue() == presenceWildcard && fd != nil {
+		return q.transpileFieldPresence(path, fd, not)
+	}
 	switch q.types[e.Args[0].Id].GetTypeKind().(type) {
 	case *expr.Type_MessageType:
-		path, err := toPath(e.Args[0])
-		if err != nil {
-			return err
+		field := e.Args[1].GetConstExpr().GetStringValue()
+		if structValue(fd) {
+			if err := checkMapKey(field); err != nil {
+				return err
+			}
+			return q.transpilePresence(append(path, field), not)
+		}
+		msg := q.msg
+		if fd != nil {
+			msg = fd.Message()
 		}
-		path = fmt.Sprintf("%s.%s", path, strcase.ToCamel(e.Args[1].GetConstExpr().GetStringValue()))
-		path = path[strings.Index(path, ".")+1:]
+		sub := msg.Fields().ByName(protoreflect.Name(field))
+		if sub == nil {
+			return invalidArgument(ErrUnknownField, "%s is not a field of %s", field, msg.FullName())
+		}
+		return q.transpileFieldPresence(append(path, q.names.name(sub)), sub, not)
+	case *expr.Type_ListType_:
 		if not {
-			q.q = q.q.Where(path, "==", nil)
-			return nil
+			return status.Error(codes.InvalidArgument, "NOT cannot be used with : on a list")
 		}
-		if err := q.setInequality(path); err != nil {
+		value, err := q.value(e.Args[0], e.Args[1])
+		if err != nil {
 			return err
 		}
-		q.startAfter = append(q.startAfter, nil)
-		q.q = q.q.OrderBy(path, firestore.Asc)
+		q.q = q.q.WherePath(path, "array-contains", value)
 		return nil
-	case *expr.Type_ListType_:
-		// TODO(kagadar): Use `array-contains`
 	case *expr.Type_MapType_:
-		// TODO(kagadar): map differs from message maybe?
+		// Map keys are used verbatim, rather than as field names.
+		key := e.Args[1].GetConstExpr().GetStringValue()
+		if err := checkMapKey(key); err != nil {
+			return err
+		}
+		return q.transpilePresence(append(path, key), not)
 	}
 	return status.Error(codes.InvalidArgument, ": must be used on a message, map or list")
 }
 
+// Checks if the field at the specified path is set, by its kind of presence
+// (https://protobuf.dev/programming-guides/field_presence/), as messages are
+// stored by Firestore:
+//   - fields with explicit presence, such as messages, proto3 optional fields
+//     and fields of editions with explicit presence, are stored as null when
+//     unset, so are set if they are not null, as are the values of wrappers
+//   - scalar fields with implicit presence are always stored, so are set if
+//     they are not their zero value, such as "" or 0
+//   - members of a oneof are stored alongside the other fields of the message,
+//     as protojson stores them, but only while they are set, so are set if
+//     they are stored; as Firestore cannot match documents without a field,
+//     whether they are unset cannot be filtered
+//
+// Repeated and map fields are stored as arrays and maps, which Firestore
+// cannot check for emptiness.
+func (q *query) transpileFieldPresence(path firestore.FieldPath, fd protoreflect.FieldDescriptor, not bool) error {
+	switch {
+	case fd.IsList() || fd.IsMap():
+		return status.Errorf(codes.InvalidArgument, "%s is repeated, so whether it is set cannot be filtered", pathString(path))
+	case not && oneofMember(fd):
+		return status.Errorf(codes.InvalidArgument, "%s is a member of oneof %s, so whether it is unset cannot be filtered", pathString(path), fd.ContainingOneof().Name())
+	case fd.HasPresence():
+		return q.transpilePresence(path, not)
+	case wrapperValue(fd):
+		// Unset wrappers are stored as null, rather than as a null value.
+		if q.names.wrappers == WrappersNested {
+			path = path[:len(path)-1]
+		}
+		return q.transpilePresence(path, not)
+	}
+	if not {
+		q.q = q.q.WherePath(path, "==", zeroValue(fd))
+		return nil
+	}
+	if q.caps.noNotEquals {
+		return status.Errorf(codes.InvalidArgument, "whether %s is set cannot be filtered, as the database does not support !=", pathString(path))
+	}
+	if err := q.setInequality(path); err != nil {
+		return err
+	}
+	if err := q.setExcluded(path); err != nil {
+		return err
+	}
+	q.q = q.q.WherePath(path, "!=", zeroValue(fd))
+	return nil
+}
+
+// Reports whether the field is a member of a oneof, other than the synthetic
+// oneof of a proto3 optional field.
+// The Firestore client cannot store messages with a oneof set, so members are
+// stored as protojson stores them, as fields of the message which are omitted
+// while unset.
+func oneofMember(fd protoreflect.FieldDescriptor) bool {
+	od := fd.ContainingOneof()
+	return od != nil && !od.IsSynthetic()
+}
+
+// Returns the stored zero value of a scalar field with implicit presence.
+func zeroValue(fd protoreflect.FieldDescriptor) interface{} {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return false
+	case protoreflect.StringKind:
+		return ""
+	case protoreflect.BytesKind:
+		return []byte{}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return float64(0)
+	}
+	// Integers and enums.
+	return int64(0)
+}
+
+// Checks if the specified path has a value.
+// Firestore cannot filter a path to values other than null, so a path which
+// is not null is only checked once the rest of the filter is transpiled, in
+// case it is already filtered to a value.
+func (q *query) transpilePresence(path firestore.FieldPath, not bool) error {
+	if not {
+		q.q = q.q.WherePath(path, "==", nil)
+		return nil
+	}
+	q.set = append(q.set, path)
+	return nil
+}
+
+// Records that the path is filtered to a value, which can only be matched by
+// documents where it is set.
+func (q *query) setValue(path firestore.FieldPath) {
+	q.values = append(q.values, path)
+}
+
+// Filters the paths which must be set to values after null, by ordering the
+// query by them and starting after null, unless they are already filtered to a
+// value.
+// Cursors compare every ordered value at once, so only a single path can be
+// checked this way.
+func (q *query) transpileSet() error {
+	var unfiltered []firestore.FieldPath
+	for _, path := range q.set {
+		if !containsPath(q.values, path) && !containsPath(unfiltered, path) {
+			unfiltered = append(unfiltered, path)
+		}
+	}
+	switch len(unfiltered) {
+	case 0:
+		return nil
+	case 1:
+	default:
+		return status.Errorf(codes.InvalidArgument, "only one field can be checked for a value, unless the others are compared to a value, got %s and %s", pathString(unfiltered[0]), pathString(unfiltered[1]))
+	}
+	path := unfiltered[0]
+	if err := q.setInequality(path); err != nil {
+		return err
+	}
+	q.startAfter = append(q.startAfter, nil)
+	q.afterNull = true
+	q.order(path, firestore.Asc)
+	return nil
+}
+
+// Reports whether the path is one of the paths.
+func containsPath(paths []firestore.FieldPath, path firestore.FieldPath) bool {
+	for _, p := range paths {
+		if pathString(p) == pathString(path) {
+			return true
+		}
+	}
+	return false
+}
+
 func (q *query) transpileEquality(e *expr.Expr_Call, not bool) error {
 	if len(e.Args) != 2 {
 		return status.Errorf(codes.InvalidArgument, "%s requires two arguments", e.Function)
 	}
+	if fieldComparison(e) {
+		return status.Errorf(codes.InvalidArgument, "%s compares two fields, which Firestore cannot evaluate, so must be joined to the rest of the filter with AND and evaluated once documents are retrieved with WithResidualFiltering", canonical(&expr.Expr{ExprKind: &expr.Expr_CallExpr{CallExpr: e}}, false))
+	}
 	op, err := operator(e.Function, not)
 	if err != nil {
 		return err
 	}
-	path, err := toPath(e.Args[0])
+	path, fd, err := q.resolvePath(e.Args[0])
+	if err != nil {
+		return err
+	}
+	// A trailing wildcard matches any string with the preceding prefix:
+	// https://google.aip.dev/160#wildcards
+	if v := e.Args[1].GetConstExpr().GetStringValue(); e.Function == filtering.FunctionEquals && strings.HasSuffix(v, "*") && (fd == nil || (fd.Enum() == nil && !timestampField(fd))) {
+		return q.transpileStartsWith(path, strings.TrimSuffix(v, "*"), not)
+	}
+	if nullArg(e.Args[1]) {
+		// The Firestore client only allows null to be compared with ==, so a
+		// field which is not null is one with any value.
+		if op == "!=" {
+			return q.transpilePresence(path, false)
+		}
+		if fd != nil && oneofMember(fd) {
+			return status.Errorf(codes.InvalidArgument, "%s is a member of oneof %s, so is never stored as null", pathString(path), fd.ContainingOneof().Name())
+		}
+		q.q = q.q.WherePath(path, "==", nil)
+		return nil
+	}
+	value, err := q.value(e.Args[0], e.Args[1])
 	if err != nil {
 		return err
 	}
+	if op == "!=" && q.caps.noNotEquals {
+		return status.Errorf(codes.InvalidArgument, "%s cannot be compared with !=, as the database does not support it", pathString(path))
+	}
 	if op != "==" {
 		if err := q.setInequality(path); err != nil {
 			return err
 		}
 	}
-	q.q = q.q.Where(path, op, unwrapConst(e.Args[1].GetConstExpr()))
+	if op == "!=" {
+		if err := q.setExcluded(path); err != nil {
+			return err
+		}
+	} else {
+		q.setValue(path)
+	}
+	q.q = q.q.WherePath(path, op, value)
+	return nil
+}
+
+// Returns the path of a bool field used as a truth value, such as `active` in
+// `active AND size > 10`.
+// Returns an INVALID_ARGUMENT error if the expression is not a bool field.
+func (q *query) boolPath(e *expr.Expr) (firestore.FieldPath, error) {
+	path, fd, err := q.resolvePath(e)
+	if err != nil {
+		return nil, err
+	}
+	if fd == nil || fd.Kind() != protoreflect.BoolKind || fd.IsList() {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not a bool field", canonical(e, false))
+	}
+	return path, nil
+}
+
+// Filters a bool field used as a truth value to true, or to false under NOT.
+// As with any equality, documents without the field never match.
+func (q *query) transpileBool(e *expr.Expr, not bool) error {
+	path, err := q.boolPath(e)
+	if err != nil {
+		return err
+	}
+	q.setValue(path)
+	q.q = q.q.WherePath(path, "==", !not)
+	return nil
+}
+
+// The function which filters a string field to values with a prefix, such as
+// `starts_with(a, "abc")`.
+const functionStartsWith = "starts_with"
+
+// Filters the path to strings which start with the prefix, with the range
+// `>= prefix AND < prefix + "\uf8ff"`, as there is no prefix operator:
+// https://firebase.google.com/docs/firestore/query-data/queries#query_operators
+func (q *query) transpileStartsWith(path firestore.FieldPath, prefix string, not bool) error {
+	if not {
+		return status.Errorf(codes.InvalidArgument, "NOT cannot be used with a prefix of %s", pathString(path))
+	}
+	if err := q.setInequality(path); err != nil {
+		return err
+	}
+	q.setValue(path)
+	q.q = q.q.WherePath(path, ">=", prefix).WherePath(path, "<", prefix+"\uf8ff")
+	return nil
+}
+
+// Filters the path to documents equal to any of the provided values.
+func (q *query) transpileIn(path firestore.FieldPath, values []interface{}) error {
+	q.setValue(path)
+	return q.transpileChunked(path, "in", values)
+}
+
+// Filters the path to documents not equal to any of the provided values.
+func (q *query) transpileNotIn(path firestore.FieldPath, values []interface{}) error {
+	if q.caps.noNotEquals {
+		return status.Errorf(codes.InvalidArgument, "%s cannot be excluded from values, as the database does not support not-in", pathString(path))
+	}
+	if limit := q.caps.notInLimit(); len(values) > limit {
+		return status.Errorf(codes.InvalidArgument, "%s can be excluded from at most %d values, got %d", pathString(path), limit, len(values))
+	}
+	if err := q.setInequality(path); err != nil {
+		return err
+	}
+	if err := q.setExcluded(path); err != nil {
+		return err
+	}
+	q.notIn = path
+	if err := q.checkNotIn(); err != nil {
+		return err
+	}
+	q.q = q.q.WherePath(path, "not-in", values)
+	return nil
+}
+
+// Filters the list at the path to documents containing any of the provided
+// values.
+func (q *query) transpileArrayContainsAny(path firestore.FieldPath, values []interface{}) error {
+	return q.transpileChunked(path, "array-contains-any", values)
+}
+
+// Restricts the query to the provided documents.
+func (q *query) transpileDocuments(refs []interface{}) error {
+	if len(refs) == 0 {
+		q.none = true
+		return nil
+	}
+	return q.transpileChunked(firestore.FieldPath{firestore.DocumentID}, "in", refs)
+}
+
+// Filters the path with the provided operator, splitting the values across
+// multiple queries if there are more than Firestore allows in one.
+func (q *query) transpileChunked(path firestore.FieldPath, op string, values []interface{}) error {
+	q.anyOf = path
+	if err := q.checkNotIn(); err != nil {
+		return err
+	}
+	limit := q.caps.disjunctionLimit()
+	if len(values) > limit {
+		f := fanOut{field: pathString(path)}
+		for i := 0; i < len(values); i += limit {
+			end := i + limit
+			if end > len(values) {
+				end = len(values)
+			}
+			f.clauses = append(f.clauses, clause{path: path, op: op, value: values[i:end]})
+		}
+		if err := q.addFanOut(f); err != nil {
+			return err
+		}
+		q.warnings = append(q.warnings, Warning{
+			Kind:    WarningChunked,
+			Field:   pathString(path),
+			Message: fmt.Sprintf("compared to %d values, which requires %d queries", len(values), (len(values)+limit-1)/limit),
+		})
+		return nil
+	}
+	q.q = q.q.WherePath(path, op, values)
+	return nil
+}
+
+// Collects the equality filters of a chain of ORs on different fields, such as
+// `a = 1 OR b:2` (where b is a list), each of which is run as its own query.
+// Returns false if the call is not such a chain.
+func (q *query) disjunction(e *expr.Expr_Call) ([]clause, bool) {
+	var clauses []clause
+	for _, arg := range e.Args {
+		if arg.GetSelectExpr() != nil {
+			p, err := q.boolPath(arg)
+			if err != nil {
+				return nil, false
+			}
+			clauses = append(clauses, clause{path: p, op: "==", value: true})
+			continue
+		}
+		call := arg.GetCallExpr()
+		switch call.GetFunction() {
+		case filtering.FunctionOr:
+			c, ok := q.disjunction(call)
+			if !ok {
+				return nil, false
+			}
+			clauses = append(clauses, c...)
+		case filtering.FunctionEquals, filtering.FunctionHas:
+			if len(call.Args) != 2 || !constantArg(call.Args[1]) {
+				return nil, false
+			}
+			_, list := q.types[call.Args[0].Id].GetTypeKind().(*expr.Type_ListType_)
+			if list != (call.Function == filtering.FunctionHas) {
+				return nil, false
+			}
+			op := "=="
+			if list {
+				op = "array-contains"
+			}
+			p, err := q.fieldPath(call.Args[0])
+			if err != nil || len(p) == 0 {
+				return nil, false
+			}
+			v, err := q.value(call.Args[0], call.Args[1])
+			if err != nil {
+				return nil, false
+			}
+			clauses = append(clauses, clause{path: p, op: op, value: v})
+		default:
+			return nil, false
+		}
+	}
+	return clauses, true
+}
+
+// Transpiles a logical call as an `in`, `not-in` or `array-contains-any`
+// filter, if possible.
+// `a = 1 OR a = 2` is equivalent to `NOT (a != 1 AND a != 2)`, so both are
+// transpiled to `in`, and their negations to `not-in`.
+// Returns false if the call cannot be expressed this way.
+func (q *query) transpileValueSet(e *expr.Expr_Call, not bool) (bool, error) {
+	if e.Function == filtering.FunctionOr && !not {
+		if path, values, ok := q.valueSet(e, e.Function, filtering.FunctionHas); ok {
+			return true, q.transpileArrayContainsAny(path, values)
+		}
+	}
+	function := filtering.FunctionEquals
+	if e.Function == filtering.FunctionAnd {
+		function = filtering.FunctionNotEquals
+		not = !not
+	}
+	path, values, ok := q.valueSet(e, e.Function, function)
+	if !ok {
+		return false, nil
+	}
+	if not {
+		return true, q.transpileNotIn(path, values)
+	}
+	return true, q.transpileIn(path, values)
+}
+
+// Transpiles each of the terms of a flattened chain of ANDs which are not
+// implied by the others. If they contradict each other, the filter can never
+// match, so no queries are run.
+// Terms comparing the same field with `!=`, such as `a != 1 AND b = 2 AND
+// a != 3`, are transpiled together to `not-in`, as Firestore allows only one
+// `!=` or `not-in` filter per query.
+func (q *query) transpileConjunction(terms []*expr.Expr, not bool) error {
+	terms = q.simplify(terms)
+	excluded := map[string][]*expr.Expr{}
+	for _, term := range terms {
+		if path, ok := q.excludedPath(term); ok {
+			excluded[path] = append(excluded[path], term)
+		}
+	}
+	for _, term := range terms {
+		path, ok := q.excludedPath(term)
+		if !ok || len(excluded[path]) == 1 {
+			if err := q.transpile(term, not); err != nil {
+				return err
+			}
+			continue
+		}
+		// The terms are transpiled together where the first of them is.
+		if group := excluded[path]; group != nil {
+			delete(excluded, path)
+			if _, err := q.transpileValueSet(&expr.Expr_Call{Function: filtering.FunctionAnd, Args: group}, not); err != nil {
+				return err
+			}
+		}
+	}
+	if path, ok := q.contradiction(terms); ok {
+		q.none = true
+		q.warnings = append(q.warnings, Warning{
+			Kind:    WarningContradiction,
+			Field:   pathString(path),
+			Message: "filtered to values which do not overlap, so no documents can match",
+		})
+	}
 	return nil
 }
 
+// Returns the path of a term which compares a field with `!=`, such as
+// `a != 1`. Returns false if the term is not such a comparison.
+func (q *query) excludedPath(term *expr.Expr) (string, bool) {
+	path, _, ok := q.valueSet(&expr.Expr_Call{Function: filtering.FunctionAnd, Args: []*expr.Expr{term}}, filtering.FunctionAnd, filtering.FunctionNotEquals)
+	if !ok {
+		return "", false
+	}
+	return pathString(path), true
+}
+
 func (q *query) transpileCall(e *expr.Expr_Call, not bool) error {
 	if e.Function == filtering.FunctionNot {
 		if len(e.Args) != 1 {
@@ -222,38 +1049,85 @@ func (q *query) transpileCall(e *expr.Expr_Call, not bool) error {
 		return q.transpile(e.Args[0], !not)
 	}
 	switch e.Function {
+	case functionTextSearch:
+		return status.Error(codes.InvalidArgument, "search terms must be joined to the rest of the filter with AND")
+	case functionContains, functionMatches, functionAll:
+		return status.Errorf(codes.InvalidArgument, "%s() must be joined to the rest of the filter with AND, and can only be used to list documents", e.Function)
+	case functionStartsWith:
+		if len(e.Args) != 2 || e.Args[1].GetConstExpr() == nil {
+			return status.Errorf(codes.InvalidArgument, "%s requires a field and a prefix", functionStartsWith)
+		}
+		path, err := q.fieldPath(e.Args[0])
+		if err != nil {
+			return err
+		}
+		return q.transpileStartsWith(path, e.Args[1].GetConstExpr().GetStringValue(), not)
 	case filtering.FunctionHas:
 		return q.transpileHas(e, not)
+	case functionAny:
+		return q.transpileAny(e, not)
 	case filtering.FunctionEquals, filtering.FunctionNotEquals,
 		filtering.FunctionLessThan, filtering.FunctionLessEquals,
 		filtering.FunctionGreaterThan, filtering.FunctionGreaterEquals:
 		return q.transpileEquality(e, not)
 	case filtering.FunctionAnd:
-		if len(e.Args) != 2 {
-			return status.Error(codes.InvalidArgument, "AND requires two arguments")
+		if len(e.Args) < 2 {
+			return status.Error(codes.InvalidArgument, "AND requires at least two arguments")
 		}
-		if err := q.transpile(e.Args[0], not); err != nil {
+		if ok, err := q.transpileValueSet(e, not); ok {
 			return err
 		}
-		return q.transpile(e.Args[1], not)
+		var terms []*expr.Expr
+		for _, arg := range e.Args {
+			terms = append(terms, conjuncts(arg)...)
+		}
+		return q.transpileConjunction(terms, not)
 	case filtering.FunctionOr:
-		// TODO(kagadar): Split into two queries
+		if len(e.Args) != 2 {
+			return status.Error(codes.InvalidArgument, "OR requires two arguments")
+		}
+		if ok, err := q.transpileValueSet(e, not); ok {
+			return err
+		}
+		if clauses, ok := q.disjunction(e); ok {
+			if err := q.addFanOut(fanOut{clauses: clauses}); err != nil {
+				return err
+			}
+			q.warnings = append(q.warnings, Warning{
+				Kind:    WarningDisjunction,
+				Message: fmt.Sprintf("OR of different fields requires %d queries", len(clauses)),
+			})
+			return nil
+		}
+		// Such as a negated AND, once NOT is pushed down to its terms.
+		return invalidArgument(ErrUnsupportedFunction, "%s can only be split across queries if each of its terms is an equality, so must be joined to the rest of the filter with AND and evaluated once documents are retrieved with WithResidualFiltering", canonical(&expr.Expr{ExprKind: &expr.Expr_CallExpr{CallExpr: e}}, false))
 	}
-	return status.Errorf(codes.InvalidArgument, "unknown filter function %s", e.Function)
+	return invalidArgument(ErrUnsupportedFunction, "unknown filter function %s", e.Function)
 }
 
+// Transpiles the expression, locating any error at the innermost expression
+// of the filter with a known position.
 func (q *query) transpile(e *expr.Expr, not bool) error {
+	if err := q.transpileExpr(e, not); err != nil {
+		return q.locate(err, e)
+	}
+	return nil
+}
+
+func (q *query) transpileExpr(e *expr.Expr, not bool) error {
 	if e == nil {
 		return nil
 	}
 	switch e.GetExprKind().(type) {
 	case *expr.Expr_CallExpr:
 		return q.transpileCall(e.GetCallExpr(), not)
+	case *expr.Expr_IdentExpr, *expr.Expr_SelectExpr:
+		return q.transpileBool(e, not)
 	case *expr.Expr_ConstExpr:
-		// TODO(kagadar): search all searchable fields (FUZZY)
-	default:
-		// Unclear if other expressions can exist here.
-		log.Printf("unexpected expression: %v", e)
+		// Bare string terms are replaced with searches when the filter is parsed,
+		// so only other constants, such as numbers, are left.
+		return status.Error(codes.InvalidArgument, "invalid filter expression")
 	}
-	return status.Error(codes.InvalidArgument, "invalid filter expression")
+	// Unclear if other expressions can exist here.
+	return &unexpectedExprError{e: e}
 }
@@ -0,0 +1,158 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"math"
+
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// The functions of arithmetic on numbers, such as `size > add(10, 5)`, along
+// with add() and sub(), and of concatenating strings, such as
+// `name = concat("a", "b")`, as filters have no operators for them.
+// They are folded into the constant they evaluate to before the filter is
+// transpiled.
+const (
+	functionMul    = "mul"
+	functionDiv    = "div"
+	functionConcat = "concat"
+)
+
+// Returns the declarations of add(), sub(), mul() and div() of two ints or two
+// floats, and of concat() of two strings.
+func declareArithmetic() []filtering.DeclarationOption {
+	var decls []filtering.DeclarationOption
+	for _, fn := range []string{functionAdd, functionSub, functionMul, functionDiv} {
+		for _, t := range []*expr.Type{filtering.TypeInt, filtering.TypeFloat} {
+			decls = append(decls, filtering.DeclareFunction(fn, filtering.NewFunctionOverload(
+				fmt.Sprintf("%s_%s_%s", fn, typeName(t), typeName(t)), t, t, t,
+			)))
+		}
+	}
+	return append(decls, filtering.DeclareFunction(functionConcat, filtering.NewFunctionOverload(
+		functionConcat+"_string_string", filtering.TypeString, filtering.TypeString, filtering.TypeString,
+	)))
+}
+
+// Returns the constant an arithmetic or concat() call of constants evaluates
+// to, such as 15 for `add(10, 5)`.
+// Returns false if the expression is neither a constant nor such a call, such
+// as add() of a timestamp and a duration.
+// Returns an INVALID_ARGUMENT error if the call has a field as an argument,
+// divides by zero, or overflows.
+func foldConstant(e *expr.Expr) (*expr.Constant, bool, error) {
+	if c := e.GetConstExpr(); c != nil {
+		return c, true, nil
+	}
+	call := e.GetCallExpr()
+	switch call.GetFunction() {
+	case functionAdd, functionSub, functionMul, functionDiv, functionConcat:
+	default:
+		return nil, false, nil
+	}
+	if len(call.Args) != 2 {
+		return nil, false, status.Errorf(codes.InvalidArgument, "%s requires two arguments", call.Function)
+	}
+	var args [2]*expr.Constant
+	for i, arg := range call.Args {
+		if arg.GetSelectExpr() != nil || arg.GetIdentExpr() != nil {
+			return nil, false, status.Errorf(codes.InvalidArgument, "%s is not a constant, as %s() can only be applied to constants", canonical(arg, false), call.Function)
+		}
+		c, ok, err := foldConstant(arg)
+		if !ok || err != nil {
+			return nil, false, err
+		}
+		args[i] = c
+	}
+	switch x := args[0].ConstantKind.(type) {
+	case *expr.Constant_Int64Value:
+		if y, ok := args[1].ConstantKind.(*expr.Constant_Int64Value); ok {
+			v, err := foldInts(call.Function, x.Int64Value, y.Int64Value)
+			if err != nil {
+				return nil, false, err
+			}
+			return &expr.Constant{ConstantKind: &expr.Constant_Int64Value{Int64Value: v}}, true, nil
+		}
+	case *expr.Constant_DoubleValue:
+		if y, ok := args[1].ConstantKind.(*expr.Constant_DoubleValue); ok {
+			v, err := foldFloats(call.Function, x.DoubleValue, y.DoubleValue)
+			if err != nil {
+				return nil, false, err
+			}
+			return &expr.Constant{ConstantKind: &expr.Constant_DoubleValue{DoubleValue: v}}, true, nil
+		}
+	case *expr.Constant_StringValue:
+		if y, ok := args[1].ConstantKind.(*expr.Constant_StringValue); ok && call.Function == functionConcat {
+			return &expr.Constant{ConstantKind: &expr.Constant_StringValue{StringValue: x.StringValue + y.StringValue}}, true, nil
+		}
+	}
+	return nil, false, status.Errorf(codes.InvalidArgument, "%s cannot be evaluated", canonical(e, false))
+}
+
+// Applies the arithmetic function to two ints, which must not overflow.
+func foldInts(function string, x, y int64) (int64, error) {
+	var v int64
+	overflow := false
+	switch function {
+	case functionAdd:
+		v = x + y
+		overflow = (y > 0 && v < x) || (y < 0 && v > x)
+	case functionSub:
+		v = x - y
+		overflow = (y > 0 && v > x) || (y < 0 && v < x)
+	case functionMul:
+		v = x * y
+		overflow = x != 0 && (v/x != y || (x == -1 && y == math.MinInt64))
+	case functionDiv:
+		if y == 0 {
+			return 0, status.Errorf(codes.InvalidArgument, "%s(%d, %d) divides by zero", function, x, y)
+		}
+		overflow = x == math.MinInt64 && y == -1
+		v = x / y
+	}
+	if overflow {
+		return 0, status.Errorf(codes.InvalidArgument, "%s(%d, %d) overflows", function, x, y)
+	}
+	return v, nil
+}
+
+// Applies the arithmetic function to two floats, which must have a finite
+// result.
+func foldFloats(function string, x, y float64) (float64, error) {
+	var v float64
+	switch function {
+	case functionAdd:
+		v = x + y
+	case functionSub:
+		v = x - y
+	case functionMul:
+		v = x * y
+	case functionDiv:
+		if y == 0 {
+			return 0, status.Errorf(codes.InvalidArgument, "%s(%v, %v) divides by zero", function, x, y)
+		}
+		v = x / y
+	}
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, status.Errorf(codes.InvalidArgument, "%s(%v, %v) overflows", function, x, y)
+	}
+	return v, nil
+}
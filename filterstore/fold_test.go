@@ -0,0 +1,53 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFoldConstants(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		want   string
+	}{
+		{`test_filtering.size > add(10, 5)`, `test_filtering.size > 15`},
+		{`test_filtering.size = div(sub(7, -3), 3)`, `test_filtering.size = 3`},
+		{`test_filtering.default_float < mul(add(1.5, 0.5), 2.0)`, `test_filtering.default_float < 4`},
+		{`test_filtering.title = concat("a", concat("b", "c"))`, `test_filtering.title = "abc"`},
+	} {
+		resolved, err := resolveRelativeTimes(parse(t, tc.filter), time.Now())
+		if err != nil {
+			t.Fatalf("resolveRelativeTimes(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if got := canonical(resolved.GetExpr(), false); got != tc.want {
+			t.Errorf("resolveRelativeTimes(%q) = %s, want %s", tc.filter, got, tc.want)
+		}
+	}
+	for _, filter := range []string{
+		`test_filtering.size > div(1, 0)`,
+		`test_filtering.size > add(9223372036854775807, 1)`,
+		`test_filtering.size > mul(4611686018427387904, 2)`,
+		`test_filtering.size > add(test_filtering.size, 1)`,
+	} {
+		if _, err := resolveRelativeTimes(parse(t, filter), time.Now()); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("resolveRelativeTimes(%q) err = %v, want code %v", filter, err, codes.InvalidArgument)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.einride.tech/aip/filtering"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Returns a canonical filter string for the provided Expr.
+// Equivalent filters that differ only in whitespace, parentheses, or the order
+// of AND/OR operands produce the same string.
+// If redact is set, constants are replaced with `?`, so that filters which
+// differ only in their constants also produce the same string.
+func canonical(e *expr.Expr, redact bool) string {
+	switch e.GetExprKind().(type) {
+	case *expr.Expr_ConstExpr:
+		if redact {
+			return "?"
+		}
+		return formatConst(e.GetConstExpr())
+	case *expr.Expr_IdentExpr:
+		return e.GetIdentExpr().GetName()
+	case *expr.Expr_SelectExpr:
+		return fmt.Sprintf("%s.%s", canonical(e.GetSelectExpr().GetOperand(), redact), e.GetSelectExpr().GetField())
+	case *expr.Expr_CallExpr:
+		call := e.GetCallExpr()
+		switch call.Function {
+		case filtering.FunctionAnd, filtering.FunctionOr:
+			operands := canonicalOperands(e, call.Function, redact)
+			sort.Strings(operands)
+			return fmt.Sprintf("(%s)", strings.Join(operands, fmt.Sprintf(" %s ", call.Function)))
+		}
+		args := make([]string, len(call.Args))
+		for i, arg := range call.Args {
+			args[i] = canonical(arg, redact)
+		}
+		switch call.Function {
+		case filtering.FunctionNot:
+			if arg := strings.Join(args, ""); !strings.HasPrefix(arg, "(") {
+				return fmt.Sprintf("NOT (%s)", arg)
+			}
+			return fmt.Sprintf("NOT %s", strings.Join(args, ""))
+		case filtering.FunctionHas:
+			return strings.Join(args, ":")
+		case filtering.FunctionEquals, filtering.FunctionNotEquals,
+			filtering.FunctionLessThan, filtering.FunctionLessEquals,
+			filtering.FunctionGreaterThan, filtering.FunctionGreaterEquals:
+			return strings.Join(args, fmt.Sprintf(" %s ", call.Function))
+		}
+		return fmt.Sprintf("%s(%s)", call.Function, strings.Join(args, ", "))
+	}
+	return ""
+}
+
+// Returns the canonical operands of a chain of calls to the same function,
+// such as `a AND (b AND c)`.
+func canonicalOperands(e *expr.Expr, function string, redact bool) []string {
+	if call := e.GetCallExpr(); call.GetFunction() == function {
+		var operands []string
+		for _, arg := range call.Args {
+			operands = append(operands, canonicalOperands(arg, function, redact)...)
+		}
+		return operands
+	}
+	return []string{canonical(e, redact)}
+}
+
+// Returns the filter literal for the provided constant.
+func formatConst(c *expr.Constant) string {
+	switch c.ConstantKind.(type) {
+	case *expr.Constant_BoolValue:
+		return strconv.FormatBool(c.GetBoolValue())
+	case *expr.Constant_BytesValue:
+		return strconv.Quote(string(c.GetBytesValue()))
+	case *expr.Constant_DoubleValue:
+		return strconv.FormatFloat(c.GetDoubleValue(), 'g', -1, 64)
+	case *expr.Constant_Int64Value:
+		return strconv.FormatInt(c.GetInt64Value(), 10)
+	case *expr.Constant_StringValue:
+		return strconv.Quote(c.GetStringValue())
+	case *expr.Constant_Uint64Value:
+		return strconv.FormatUint(c.GetUint64Value(), 10)
+	}
+	return "null"
+}
@@ -0,0 +1,54 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import "testing"
+
+func TestCanonical(t *testing.T) {
+	for _, tc := range []struct {
+		filter     string
+		want       string
+		wantRedact string
+	}{
+		{
+			filter:     `test_filtering.filterable_primitive = "a"`,
+			want:       `test_filtering.filterable_primitive = "a"`,
+			wantRedact: `test_filtering.filterable_primitive = ?`,
+		},
+		{
+			filter:     `test_filtering.default_float > 2.5 AND (test_filtering.filterable_primitive = "b" AND test_filtering:default_bool)`,
+			want:       `(test_filtering.default_float > 2.5 AND test_filtering.filterable_primitive = "b" AND test_filtering:"default_bool")`,
+			wantRedact: `(test_filtering.default_float > ? AND test_filtering.filterable_primitive = ? AND test_filtering:?)`,
+		},
+		{
+			filter:     `NOT (test_filtering.tags:"x" OR test_filtering.tags:"a")`,
+			want:       `NOT (test_filtering.tags:"a" OR test_filtering.tags:"x")`,
+			wantRedact: `NOT (test_filtering.tags:? OR test_filtering.tags:?)`,
+		},
+		{
+			filter:     `-test_filtering.filterable_primitive = "a"`,
+			want:       `NOT (test_filtering.filterable_primitive = "a")`,
+			wantRedact: `NOT (test_filtering.filterable_primitive = ?)`,
+		},
+	} {
+		e := parse(t, tc.filter).GetExpr()
+		if got := canonical(e, false); got != tc.want {
+			t.Errorf("canonical(%q, false) = %q, want %q", tc.filter, got, tc.want)
+		}
+		if got := canonical(e, true); got != tc.wantRedact {
+			t.Errorf("canonical(%q, true) = %q, want %q", tc.filter, got, tc.wantRedact)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// The field of an index document which holds the path of the collection its
+// document belongs to, as collection group queries span every parent.
+const indexCollectionField = "_collection"
+
+// Returns the name of the subcollection which indexes the elements of the
+// repeated message field.
+func indexCollection(fd protoreflect.FieldDescriptor) string {
+	return string(fd.Name()) + "_index"
+}
+
+// Resolves the names of the repeated message fields to index.
+func indexedFields(msg protoreflect.MessageDescriptor, names []string) ([]protoreflect.FieldDescriptor, error) {
+	var fds []protoreflect.FieldDescriptor
+	for _, name := range names {
+		fd := msg.Fields().ByName(protoreflect.Name(name))
+		if fd == nil || !fd.IsList() || fd.Message() == nil {
+			return nil, fmt.Errorf("%s is not a repeated message field of %s", name, msg.FullName())
+		}
+		fds = append(fds, fd)
+	}
+	return fds, nil
+}
+
+// Set writes the message to the document with the provided ID in the
+// collection of the parent, along with the values of every field denormalized
+// with WithDenormalizedValues, and rewrites the index documents of every field
+// indexed with WithRepeatedIndex.
+func (t *Transpiler[T]) Set(ctx context.Context, parent, id string, msg T) error {
+	if err := t.lifecycle.check(); err != nil {
+		return err
+	}
+	collection := fmt.Sprintf("%s/%s", parent, t.collection)
+	ref := t.client.Collection(collection).Doc(id)
+	return t.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		stale, err := t.indexDocuments(tx, ref)
+		if err != nil {
+			return err
+		}
+		if err := tx.Set(ref, msg); err != nil {
+			return err
+		}
+		m := msg.ProtoReflect()
+		if len(t.names.denormalized) > 0 {
+			if err := tx.Set(ref, t.names.denormalizedValues(m), firestore.MergeAll); err != nil {
+				return err
+			}
+		}
+		for _, fd := range t.indexes {
+			list := m.Get(fd).List()
+			for i := 0; i < list.Len(); i++ {
+				index := ref.Collection(indexCollection(fd)).Doc(strconv.Itoa(i))
+				delete(stale, index.Path)
+				// The element is stored under the name of the repeated field, so that
+				// filters on the field have the same path in the index document.
+				if err := tx.Set(index, map[string]interface{}{
+					indexCollectionField: collection,
+					t.names.name(fd):     list.Get(i).Message().Interface(),
+				}); err != nil {
+					return err
+				}
+			}
+		}
+		for _, index := range stale {
+			if err := tx.Delete(index); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete deletes the document with the provided ID in the collection of the
+// parent, along with its index documents.
+func (t *Transpiler[T]) Delete(ctx context.Context, parent, id string) error {
+	if err := t.lifecycle.check(); err != nil {
+		return err
+	}
+	ref := t.client.Collection(fmt.Sprintf("%s/%s", parent, t.collection)).Doc(id)
+	return t.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		indexes, err := t.indexDocuments(tx, ref)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(ref); err != nil {
+			return err
+		}
+		for _, index := range indexes {
+			if err := tx.Delete(index); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Returns every existing index document of the document, by path.
+func (t *Transpiler[T]) indexDocuments(tx *firestore.Transaction, ref *firestore.DocumentRef) (map[string]*firestore.DocumentRef, error) {
+	indexes := map[string]*firestore.DocumentRef{}
+	for _, fd := range t.indexes {
+		refs, err := tx.DocumentRefs(ref.Collection(indexCollection(fd))).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range refs {
+			indexes[index.Path] = index
+		}
+	}
+	return indexes, nil
+}
+
+// Adds the top level fields referenced by the expression to fields.
+func referencedFields(e *expr.Expr, fields map[string]bool) {
+	switch e.GetExprKind().(type) {
+	case *expr.Expr_SelectExpr:
+		sel := e.GetSelectExpr()
+		if sel.GetOperand().GetIdentExpr() != nil {
+			fields[sel.GetField()] = true
+		}
+		referencedFields(sel.GetOperand(), fields)
+	case *expr.Expr_CallExpr:
+		for _, arg := range e.GetCallExpr().GetArgs() {
+			referencedFields(arg, fields)
+		}
+	}
+}
+
+// Returns the operands of a chain of ANDs.
+func conjuncts(e *expr.Expr) []*expr.Expr {
+	if call := e.GetCallExpr(); call.GetFunction() == filtering.FunctionAnd {
+		var terms []*expr.Expr
+		for _, arg := range call.GetArgs() {
+			terms = append(terms, conjuncts(arg)...)
+		}
+		return terms
+	}
+	return []*expr.Expr{e}
+}
+
+// Joins the terms with AND. Returns nil if there are no terms.
+func conjunction(terms []*expr.Expr) *expr.Expr {
+	if len(terms) == 0 {
+		return nil
+	}
+	e := terms[0]
+	for _, term := range terms[1:] {
+		e = &expr.Expr{ExprKind: &expr.Expr_CallExpr{CallExpr: &expr.Expr_Call{
+			Function: filtering.FunctionAnd,
+			Args:     []*expr.Expr{e, term},
+		}}}
+	}
+	return e
+}
+
+// Splits the filter into the terms on each indexed field, and the remaining
+// filter.
+// Terms on an indexed field must be joined to the rest of the filter by AND,
+// and cannot reference any other field.
+func (t *Transpiler[T]) splitIndexed(filter *expr.CheckedExpr) (*expr.CheckedExpr, map[protoreflect.FieldDescriptor][]*expr.Expr, error) {
+	if len(t.indexes) == 0 || filter.GetExpr() == nil {
+		return filter, nil, nil
+	}
+	indexed := map[protoreflect.FieldDescriptor][]*expr.Expr{}
+	var rest []*expr.Expr
+	for _, term := range conjuncts(filter.GetExpr()) {
+		fields := map[string]bool{}
+		referencedFields(term, fields)
+		var fd protoreflect.FieldDescriptor
+		for _, index := range t.indexes {
+			if fields[string(index.Name())] {
+				if len(fields) > 1 {
+					return nil, nil, status.Errorf(codes.InvalidArgument, "filters on %s must be joined to filters on other fields with AND", index.Name())
+				}
+				fd = index
+			}
+		}
+		switch {
+		case fd == nil || term.GetCallExpr().GetFunction() == functionAll:
+			// Index documents cannot check every element.
+			rest = append(rest, term)
+		case term.GetCallExpr().GetFunction() == functionAny:
+			// Index documents are elements, so any() of an indexed field is its
+			// filter.
+			indexed[fd] = append(indexed[fd], conjuncts(term.GetCallExpr().GetArgs()[0])...)
+		default:
+			indexed[fd] = append(indexed[fd], term)
+		}
+	}
+	if len(indexed) == 0 {
+		return filter, nil, nil
+	}
+	return &expr.CheckedExpr{Expr: conjunction(rest), TypeMap: filter.GetTypeMap(), SourceInfo: filter.GetSourceInfo()}, indexed, nil
+}
+
+// Transpiles the terms on the indexed field onto a query of the index
+// documents of the collection of the parent.
+// The paths of the terms select the fields of the element each index document
+// holds.
+func (t *Transpiler[T]) indexQuery(c *firestore.Client, parent string, fd protoreflect.FieldDescriptor, terms []*expr.Expr, types map[int64]*expr.Type) (*query, error) {
+	collection := fmt.Sprintf("%s/%s", parent, t.collection)
+	q := &query{
+		q:       c.CollectionGroup(indexCollection(fd)).Select().Where(indexCollectionField, "==", collection),
+		msg:     t.emptyMessage.ProtoReflect().Descriptor(),
+		element: fd,
+		names:   t.names,
+		caps:    t.caps,
+	}
+	if err := q.transpileFilter(&expr.CheckedExpr{Expr: conjunction(terms), TypeMap: types}); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Resolves the terms on indexed fields to the documents in the collection of
+// the parent with an element matching every term on each field.
+// Returns the remaining filter, and the documents it must be restricted to,
+// which are nil if no indexed field was filtered.
+func (t *Transpiler[T]) resolveIndexed(ctx context.Context, c *firestore.Client, parent string, filter *expr.CheckedExpr) (*expr.CheckedExpr, []interface{}, error) {
+	rest, indexed, err := t.splitIndexed(filter)
+	if err != nil || indexed == nil {
+		return rest, nil, err
+	}
+	var matches map[string]*firestore.DocumentRef
+	for _, fd := range t.indexes {
+		terms, ok := indexed[fd]
+		if !ok {
+			continue
+		}
+		q, err := t.indexQuery(c, parent, fd, terms, filter.GetTypeMap())
+		if err != nil {
+			return nil, nil, err
+		}
+		qs, err := q.build()
+		if err != nil {
+			return nil, nil, err
+		}
+		docs, err := getAll(ctx, qs, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		found := map[string]*firestore.DocumentRef{}
+		for _, doc := range docs {
+			// Documents must match the terms of every indexed field.
+			if ref := doc.Ref.Parent.Parent; matches == nil || matches[ref.Path] != nil {
+				found[ref.Path] = ref
+			}
+		}
+		matches = found
+	}
+	// The documents are sorted by path, so that the same filter always builds
+	// the same queries.
+	paths := make([]string, 0, len(matches))
+	for path := range matches {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	refs := []interface{}{}
+	for _, path := range paths {
+		refs = append(refs, matches[path])
+	}
+	return rest, refs, nil
+}
@@ -0,0 +1,343 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/genproto/googleapis/api/serviceconfig"
+	fspb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+// Returns the descriptor of an Order message, with a repeated Item field.
+// The protoexpr test message has no repeated message fields.
+func orderDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("order.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Order"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("items"), Number: proto.Int32(1), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".test.Order.Item")},
+				{Name: proto.String("name"), Number: proto.Int32(2), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+				{Name: proto.String("tags"), Number: proto.Int32(3), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+			},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Item"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("sku"), Number: proto.Int32(1), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+					{Name: proto.String("quantity"), Number: proto.Int32(2), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()},
+				},
+			}},
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("protodesc.NewFile() err = %v, want <nil>", err)
+	}
+	return fd.Messages().ByName("Order")
+}
+
+func TestIndexedFields(t *testing.T) {
+	msg := orderDescriptor(t)
+	if _, err := indexedFields(msg, []string{"items"}); err != nil {
+		t.Errorf("indexedFields(items) err = %v, want <nil>", err)
+	}
+	for _, name := range []string{"missing", "name", "tags"} {
+		if _, err := indexedFields(msg, []string{name}); err == nil {
+			t.Errorf("indexedFields(%s) err = <nil>, want error", name)
+		}
+	}
+}
+
+func TestSplitIndexed(t *testing.T) {
+	msg := orderDescriptor(t)
+	indexes, err := indexedFields(msg, []string{"items"})
+	if err != nil {
+		t.Fatalf("indexedFields() err = %v, want <nil>", err)
+	}
+	decls, err := filtering.NewDeclarations(append([]filtering.DeclarationOption{filtering.DeclareStandardFunctions()}, protoexpr.Declare(msg)...)...)
+	if err != nil {
+		t.Fatalf("filtering.NewDeclarations() err = %v, want <nil>", err)
+	}
+	transpiler := &Transpiler[*dynamicpb.Message]{decls: decls, emptyMessage: dynamicpb.NewMessage(msg), indexes: indexes}
+	for _, tc := range []struct {
+		filter, rest string
+		indexed      int
+	}{
+		{
+			filter: `order.name = "a"`,
+			rest:   `order.name = "a"`,
+		},
+		{
+			filter:  `order.items.sku = "x"`,
+			indexed: 1,
+		},
+		{
+			filter:  `order.items.quantity > 1 AND order.name = "a" AND order.items.sku = "x"`,
+			rest:    `order.name = "a"`,
+			indexed: 2,
+		},
+	} {
+		t.Run(tc.filter, func(t *testing.T) {
+			filter, err := transpiler.parse(filterRequest(tc.filter))
+			if err != nil {
+				t.Fatalf("parse() err = %v, want <nil>", err)
+			}
+			rest, indexed, err := transpiler.splitIndexed(filter.CheckedExpr)
+			if err != nil {
+				t.Fatalf("splitIndexed() err = %v, want <nil>", err)
+			}
+			if got := canonical(rest.GetExpr(), false); got != tc.rest {
+				t.Errorf("splitIndexed() rest = %q, want %q", got, tc.rest)
+			}
+			if got := len(indexed[indexes[0]]); got != tc.indexed {
+				t.Errorf("len(splitIndexed() indexed) = %d, want %d", got, tc.indexed)
+			}
+		})
+	}
+	filter, err := transpiler.parse(filterRequest(`order.items.sku = "x" OR order.name = "a"`))
+	if err != nil {
+		t.Fatalf("parse() err = %v, want <nil>", err)
+	}
+	if _, _, err := transpiler.splitIndexed(filter.CheckedExpr); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("splitIndexed() err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestIndexQuery(t *testing.T) {
+	msg := orderDescriptor(t)
+	indexes, err := indexedFields(msg, []string{"items"})
+	if err != nil {
+		t.Fatalf("indexedFields() err = %v, want <nil>", err)
+	}
+	decls, err := filtering.NewDeclarations(append([]filtering.DeclarationOption{filtering.DeclareStandardFunctions()}, protoexpr.Declare(msg)...)...)
+	if err != nil {
+		t.Fatalf("filtering.NewDeclarations() err = %v, want <nil>", err)
+	}
+	transpiler := &Transpiler[*dynamicpb.Message]{collection: "orders", decls: decls, emptyMessage: dynamicpb.NewMessage(msg), indexes: indexes}
+	filter, err := transpiler.parse(filterRequest(`order.items.sku = "x"`))
+	if err != nil {
+		t.Fatalf("parse() err = %v, want <nil>", err)
+	}
+	_, indexed, err := transpiler.splitIndexed(filter.CheckedExpr)
+	if err != nil {
+		t.Fatalf("splitIndexed() err = %v, want <nil>", err)
+	}
+	// The path of the term selects the field of the element in each index
+	// document.
+	q, err := transpiler.indexQuery(testClient(t), "parents/p", indexes[0], indexed[indexes[0]], filter.CheckedExpr.GetTypeMap())
+	if err != nil {
+		t.Fatalf("indexQuery() err = %v, want <nil>", err)
+	}
+	qs, err := q.build()
+	if err != nil {
+		t.Fatalf("build() err = %v, want <nil>", err)
+	}
+	want := []*fspb.StructuredQuery_Filter{{FilterType: &fspb.StructuredQuery_Filter_CompositeFilter{CompositeFilter: &fspb.StructuredQuery_CompositeFilter{
+		Op: fspb.StructuredQuery_CompositeFilter_AND,
+		Filters: []*fspb.StructuredQuery_Filter{
+			fieldFilter(indexCollectionField, fspb.StructuredQuery_FieldFilter_EQUAL, stringValue("parents/p/orders")),
+			fieldFilter("Items.Sku", fspb.StructuredQuery_FieldFilter_EQUAL, stringValue("x")),
+		},
+	}}}}
+	if diff := cmp.Diff(want, wheres(serialize(t, qs)), protocmp.Transform()); diff != "" {
+		t.Errorf("indexQuery() where diff (-want +got):\n%s", diff)
+	}
+	// Elsewhere, paths cannot select the fields of a repeated field.
+	if _, err := newQuery(testClient(t).Collection("orders").Query, msg, fieldNames{}, filter.CheckedExpr, capabilities{}); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("newQuery() err = %v, want %v", err, ErrUnknownField)
+	}
+}
+
+// Returns a Quota with limits of the names.
+func quotaLimits(names ...string) *serviceconfig.Quota {
+	q := &serviceconfig.Quota{}
+	for _, name := range names {
+		q.Limits = append(q.Limits, &serviceconfig.QuotaLimit{Name: name})
+	}
+	return q
+}
+
+func TestResolveIndexed(t *testing.T) {
+	fake, client := newFakeFirestore(t)
+	transpiler := newMessageTranspiler(t, client, &serviceconfig.Quota{}, WithRepeatedIndex("limits"))
+	ctx := context.Background()
+	for _, doc := range []struct {
+		parent, id string
+		quota      *serviceconfig.Quota
+	}{
+		{"parents/p", "a", quotaLimits("x", "y")},
+		{"parents/p", "b", quotaLimits("y")},
+		{"parents/p", "c", quotaLimits("z")},
+		{"parents/q", "d", quotaLimits("y")},
+	} {
+		if err := transpiler.Set(ctx, doc.parent, doc.id, doc.quota); err != nil {
+			t.Fatalf("Set(%s) err = %v, want <nil>", doc.id, err)
+		}
+	}
+	filter, err := transpiler.parse(filterRequest(`quota.limits.name = "y"`))
+	if err != nil {
+		t.Fatalf("parse() err = %v, want <nil>", err)
+	}
+	rest, refs, err := transpiler.resolveIndexed(ctx, client, "parents/p", filter.CheckedExpr)
+	if err != nil {
+		t.Fatalf("resolveIndexed() err = %v, want <nil>", err)
+	}
+	if rest.GetExpr() != nil {
+		t.Errorf("resolveIndexed() rest = %q, want none", canonical(rest.GetExpr(), false))
+	}
+	// Documents of other parents are not matched.
+	var got []string
+	for _, ref := range refs {
+		got = append(got, ref.(*firestore.DocumentRef).ID)
+	}
+	if diff := cmp.Diff([]string{"a", "b"}, got); diff != "" {
+		t.Errorf("resolveIndexed() documents diff (-want +got):\n%s", diff)
+	}
+	if qs := fake.queries(); len(qs) != 1 || !qs[0].GetFrom()[0].GetAllDescendants() {
+		t.Errorf("resolveIndexed() queries = %v, want one collection group query", qs)
+	}
+}
+
+func TestSetIndexDocuments(t *testing.T) {
+	fake, client := newFakeFirestore(t)
+	transpiler := newMessageTranspiler(t, client, &serviceconfig.Quota{}, WithRepeatedIndex("limits"))
+	ctx := context.Background()
+	if err := transpiler.Set(ctx, "parents/p", "a", quotaLimits("x", "y", "z")); err != nil {
+		t.Fatalf("Set() err = %v, want <nil>", err)
+	}
+	want := []string{"parents/p/items/a", "parents/p/items/a/limits_index/0", "parents/p/items/a/limits_index/1", "parents/p/items/a/limits_index/2"}
+	if diff := cmp.Diff(want, fake.paths()); diff != "" {
+		t.Errorf("Set() documents diff (-want +got):\n%s", diff)
+	}
+	// Each index document holds its element under the name of the field, along
+	// with the collection of the document.
+	fake.mu.Lock()
+	index := fake.docs[fakeDocuments+"/parents/p/items/a/limits_index/1"]
+	fake.mu.Unlock()
+	if got := index.GetFields()[indexCollectionField].GetStringValue(); got != "parents/p/items" {
+		t.Errorf("Set() index document %s = %q, want %q", indexCollectionField, got, "parents/p/items")
+	}
+	if got := index.GetFields()["Limits"].GetMapValue().GetFields()["Name"].GetStringValue(); got != "y" {
+		t.Errorf("Set() index document Limits.Name = %q, want %q", got, "y")
+	}
+	// The index documents of removed elements are deleted.
+	if err := transpiler.Set(ctx, "parents/p", "a", quotaLimits("x")); err != nil {
+		t.Fatalf("Set() err = %v, want <nil>", err)
+	}
+	if diff := cmp.Diff(want[:2], fake.paths()); diff != "" {
+		t.Errorf("Set() with fewer elements documents diff (-want +got):\n%s", diff)
+	}
+	if err := transpiler.Delete(ctx, "parents/p", "a"); err != nil {
+		t.Fatalf("Delete() err = %v, want <nil>", err)
+	}
+	if got := fake.paths(); len(got) != 0 {
+		t.Errorf("Delete() left documents %v, want none", got)
+	}
+}
+
+func TestResolveIndexedIntersection(t *testing.T) {
+	fake, client := newFakeFirestore(t)
+	transpiler := newMessageTranspiler(t, client, &serviceconfig.Quota{}, WithRepeatedIndex("limits", "metric_rules"))
+	ctx := context.Background()
+	for id, selector := range map[string]string{"a": "s", "b": "t", "c": "s"} {
+		quota := quotaLimits("x")
+		if id == "c" {
+			quota = quotaLimits("y")
+		}
+		quota.MetricRules = []*serviceconfig.MetricRule{{Selector: selector}}
+		if err := transpiler.Set(ctx, "parents/p", id, quota); err != nil {
+			t.Fatalf("Set(%s) err = %v, want <nil>", id, err)
+		}
+	}
+	filter, err := transpiler.parse(filterRequest(`quota.limits.name = "x" AND quota.metric_rules.selector = "s"`))
+	if err != nil {
+		t.Fatalf("parse() err = %v, want <nil>", err)
+	}
+	_, refs, err := transpiler.resolveIndexed(ctx, client, "parents/p", filter.CheckedExpr)
+	if err != nil {
+		t.Fatalf("resolveIndexed() err = %v, want <nil>", err)
+	}
+	// a is the only document found by the query of each field.
+	var got []string
+	for _, ref := range refs {
+		got = append(got, ref.(*firestore.DocumentRef).ID)
+	}
+	if diff := cmp.Diff([]string{"a"}, got); diff != "" {
+		t.Errorf("resolveIndexed() documents diff (-want +got):\n%s", diff)
+	}
+	if got := len(fake.queries()); got != 2 {
+		t.Errorf("resolveIndexed() ran %d queries, want 2", got)
+	}
+}
+
+func TestListIndexed(t *testing.T) {
+	_, client := newFakeFirestore(t)
+	transpiler := newMessageTranspiler(t, client, &serviceconfig.Quota{}, WithRepeatedIndex("limits"))
+	ctx := context.Background()
+	quotas := map[string]*serviceconfig.Quota{
+		"a": {Limits: []*serviceconfig.QuotaLimit{{Name: "x", DefaultLimit: 1}, {Name: "y", DefaultLimit: 10}}},
+		"b": {Limits: []*serviceconfig.QuotaLimit{{Name: "x", DefaultLimit: 10}}},
+		"c": {Limits: []*serviceconfig.QuotaLimit{{Name: "y", DefaultLimit: 10}}},
+	}
+	for id, quota := range quotas {
+		if err := transpiler.Set(ctx, "parents/p", id, quota); err != nil {
+			t.Fatalf("Set(%s) err = %v, want <nil>", id, err)
+		}
+	}
+	for _, tc := range []struct {
+		filter string
+		want   []string
+	}{
+		{`quota.limits.name = "x"`, []string{"a", "b"}},
+		{`any((quota.limits.name = "x"))`, []string{"a", "b"}},
+		// Every term must match the same element.
+		{`quota.limits.name = "x" AND quota.limits.default_limit > 5`, []string{"b"}},
+		{`quota.limits.name = "z"`, nil},
+	} {
+		t.Run(tc.filter, func(t *testing.T) {
+			page, err := transpiler.List(ctx, &test.ListTestRequest{Parent: "parents/p", Filter: tc.filter})
+			if err != nil {
+				t.Fatalf("List() err = %v, want <nil>", err)
+			}
+			want := []*serviceconfig.Quota{}
+			for _, id := range tc.want {
+				want = append(want, quotas[id])
+			}
+			if diff := cmp.Diff(want, page.Items, protocmp.Transform(), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("List() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// The maximum number of consecutive retryable errors an Iterator will resume
+// from before failing.
+const maxRetries = 5
+
+// The delay before an Iterator first resumes after a retryable error.
+// The delay doubles with each consecutive retry. It is only changed by tests.
+var initialRetryDelay = 100 * time.Millisecond
+
+// Iterator streams every document matching a filter, retrieving them from
+// Firestore in batches.
+//
+// If Firestore fails with a retryable error, such as contention or an
+// expired cursor, the Iterator transparently resumes after the last document
+// it retrieved.
+//
+// By default, a batch is only retrieved once every document of the previous
+// batch has been returned. With the Prefetch option, batches are retrieved in
+// the background, until the prefetch window is full.
+type Iterator[T proto.Message] struct {
+	ctx       context.Context
+	factory   func() T
+	batchSize int
+	// The queries for the first batch, positioned after any page token.
+	first []firestore.Query
+	// Whether the queries for the first batch are positioned at a cursor.
+	positioned bool
+	// The queries for subsequent batches, which will be positioned after the
+	// last retrieved document.
+	next    []firestore.Query
+	orderBy []string
+	// The orders of the cursors of page tokens, which exclude the document
+	// name.
+	orders   []fieldOrder
+	warnings []Warning
+	// The last document returned by Next.
+	last *firestore.DocumentSnapshot
+	// The last document retrieved from Firestore.
+	cursor  *firestore.DocumentSnapshot
+	batch   []*firestore.DocumentSnapshot
+	retries int
+	// The number of batches to retrieve ahead of Next.
+	prefetch   int
+	prefetched chan prefetchedBatch
+	// Tracks the prefetching, so that it is stopped when the Transpiler is
+	// closed.
+	lifecycle *lifecycle
+	stop      context.CancelFunc
+	done      bool
+	err       error
+}
+
+// A batch retrieved in the background by a prefetching Iterator.
+type prefetchedBatch struct {
+	docs []*firestore.DocumentSnapshot
+	err  error
+	// Whether this is the final batch.
+	last bool
+}
+
+// Next returns the next document matching the filter.
+// Returns iterator.Done once every document has been returned.
+func (it *Iterator[T]) Next() (T, error) {
+	var zero T
+	for len(it.batch) == 0 {
+		if it.err != nil {
+			return zero, it.err
+		}
+		if it.done {
+			return zero, iterator.Done
+		}
+		if it.prefetch > 0 {
+			it.receive()
+			continue
+		}
+		it.batch, it.err = it.fetch()
+		it.done = len(it.batch) < it.batchSize
+	}
+	doc := it.batch[0]
+	it.batch = it.batch[1:]
+	it.last = doc
+	msg := it.factory()
+	if err := doc.DataTo(msg); err != nil {
+		return zero, err
+	}
+	return msg, nil
+}
+
+// Warnings returns the warnings about the transpilation of the filter.
+func (it *Iterator[T]) Warnings() []Warning {
+	return it.warnings
+}
+
+// Stop releases the resources of the Iterator, including any background
+// retrieval of batches. Next returns an error once the Iterator is stopped.
+func (it *Iterator[T]) Stop() {
+	if it.stop != nil {
+		it.stop()
+	}
+	if it.err == nil && !it.done {
+		it.err = context.Canceled
+	}
+	it.batch = nil
+}
+
+// Receives the next prefetched batch, starting to prefetch if necessary.
+func (it *Iterator[T]) receive() {
+	if it.prefetched == nil {
+		ctx, release, err := it.lifecycle.start(it.ctx)
+		if err != nil {
+			it.err = err
+			return
+		}
+		it.ctx = ctx
+		it.prefetched = make(chan prefetchedBatch, it.prefetch)
+		go func() {
+			defer release()
+			it.prefetchBatches()
+		}()
+	}
+	b, ok := <-it.prefetched
+	if !ok {
+		// Prefetching only stops early if the context is done.
+		it.err = it.ctx.Err()
+		return
+	}
+	it.batch, it.err, it.done = b.docs, b.err, b.last
+}
+
+// Retrieves batches until the final batch, blocking while the prefetch window
+// is full so that slow consumers do not buffer every document.
+func (it *Iterator[T]) prefetchBatches() {
+	defer close(it.prefetched)
+	for {
+		docs, err := it.fetch()
+		b := prefetchedBatch{docs: docs, err: err, last: len(docs) < it.batchSize}
+		select {
+		case it.prefetched <- b:
+		case <-it.ctx.Done():
+			return
+		}
+		if b.err != nil || b.last {
+			return
+		}
+	}
+}
+
+// Retrieves the next batch of documents, resuming after retryable errors.
+func (it *Iterator[T]) fetch() ([]*firestore.DocumentSnapshot, error) {
+	for {
+		qs := it.queries()
+		if it.positioned || it.cursor != nil {
+			recordCursors(it.ctx, len(qs))
+		}
+		docs, err := getAll(it.ctx, qs, it.batchSize)
+		if err == nil {
+			it.retries = 0
+			if len(docs) > 0 {
+				it.cursor = docs[len(docs)-1]
+			}
+			return docs, nil
+		}
+		if err := it.retry(err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Returns the queries for the next batch of documents.
+func (it *Iterator[T]) queries() []firestore.Query {
+	if it.cursor == nil {
+		return it.first
+	}
+	qs := make([]firestore.Query, len(it.next))
+	for i, q := range it.next {
+		qs[i] = q.StartAfter(it.cursor)
+	}
+	return qs
+}
+
+// Waits to resume iteration after the provided error.
+// Returns the error if it cannot be retried.
+func (it *Iterator[T]) retry(err error) error {
+	if !retryable(err) || it.retries >= maxRetries {
+		return err
+	}
+	delay := initialRetryDelay << it.retries
+	it.retries++
+	select {
+	case <-it.ctx.Done():
+		return it.ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// Reports whether a query failing with the provided error can be resumed.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Aborted, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Unavailable:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{status.Error(codes.Aborted, "contention"), true},
+		{status.Error(codes.Unavailable, "unavailable"), true},
+		{status.Error(codes.InvalidArgument, "bad query"), false},
+		{errors.New("unknown"), false},
+	} {
+		if got := retryable(tc.err); got != tc.want {
+			t.Errorf("retryable(%v) = %t, want %t", tc.err, got, tc.want)
+		}
+	}
+}
+
+// Returns a Transpiler over the documents of the fake, with the IDs of the
+// documents it has written to the collection of parents/p. The
+// FilterablePrimitive field of each document is its ID.
+func fakeTranspiler(t *testing.T, n int, opts ...Option) (*Transpiler[*test.TestFiltering], *fakeFirestore, []string) {
+	t.Helper()
+	fake, client := newFakeFirestore(t)
+	transpiler := newTestTranspiler(t, client, opts...)
+	var ids []string
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("d%02d", i)
+		if err := transpiler.Set(context.Background(), "parents/p", id, &test.TestFiltering{FilterablePrimitive: id}); err != nil {
+			t.Fatalf("Set(%s) err = %v, want <nil>", id, err)
+		}
+		ids = append(ids, id)
+	}
+	return transpiler, fake, ids
+}
+
+// Returns the IDs of the remaining documents of the Iterator.
+func drain(t *testing.T, it *Iterator[*test.TestFiltering]) []string {
+	t.Helper()
+	var ids []string
+	for {
+		msg, err := it.Next()
+		if err == iterator.Done {
+			return ids
+		}
+		if err != nil {
+			t.Fatalf("Next() err = %v, want <nil>", err)
+		}
+		ids = append(ids, msg.GetFilterablePrimitive())
+	}
+}
+
+func TestIteratorResume(t *testing.T) {
+	transpiler, fake, want := fakeTranspiler(t, 10)
+	// The second batch fails once its first document has been sent.
+	fake.failures[1] = true
+	it := transpiler.Iterate(context.Background(), &test.ListTestRequest{Parent: "parents/p"}, BatchSize(3))
+	defer it.Stop()
+	if diff := cmp.Diff(want, drain(t, it)); diff != "" {
+		t.Errorf("Iterate() diff (-want +got):\n%s", diff)
+	}
+	// Four batches, and the retry of the second.
+	qs := fake.queries()
+	if len(qs) != 5 {
+		t.Fatalf("Iterate() ran %d queries, want 5", len(qs))
+	}
+	// The retry resumes after the last document of the first batch, not the
+	// document sent before the failure.
+	if diff := cmp.Diff(qs[1], qs[2], protocmp.Transform()); diff != "" {
+		t.Errorf("Iterate() retried query diff (-failed +retried):\n%s", diff)
+	}
+}
+
+// Shortens the delay before retries for the duration of the test.
+func shortRetryDelay(t *testing.T) {
+	delay := initialRetryDelay
+	initialRetryDelay = time.Millisecond
+	t.Cleanup(func() { initialRetryDelay = delay })
+}
+
+func TestIteratorRetry(t *testing.T) {
+	shortRetryDelay(t)
+	transpiler, fake, want := fakeTranspiler(t, 10)
+	// The first batch fails twice before any document is sent.
+	fake.errors[0] = codes.Aborted
+	fake.errors[1] = codes.Unavailable
+	it := transpiler.Iterate(context.Background(), &test.ListTestRequest{Parent: "parents/p"}, BatchSize(5))
+	defer it.Stop()
+	if diff := cmp.Diff(want, drain(t, it)); diff != "" {
+		t.Errorf("Iterate() diff (-want +got):\n%s", diff)
+	}
+	// The two failures, and three batches, the last of which is empty.
+	qs := fake.queries()
+	if len(qs) != 5 {
+		t.Fatalf("Iterate() ran %d queries, want 5", len(qs))
+	}
+	for i := 1; i < 3; i++ {
+		if diff := cmp.Diff(qs[0], qs[i], protocmp.Transform()); diff != "" {
+			t.Errorf("Iterate() retry %d query diff (-failed +retried):\n%s", i, diff)
+		}
+	}
+}
+
+func TestIteratorRetryLimit(t *testing.T) {
+	shortRetryDelay(t)
+	transpiler, fake, _ := fakeTranspiler(t, 10)
+	for i := 0; i <= maxRetries+1; i++ {
+		fake.errors[i] = codes.Unavailable
+	}
+	it := transpiler.Iterate(context.Background(), &test.ListTestRequest{Parent: "parents/p"}, BatchSize(5))
+	defer it.Stop()
+	start := time.Now()
+	if _, err := it.Next(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Next() err = %v, want code %v", err, codes.Unavailable)
+	}
+	// The first attempt, and every retry.
+	if got := len(fake.queries()); got != maxRetries+1 {
+		t.Errorf("Iterate() ran %d queries, want %d", got, maxRetries+1)
+	}
+	// The delay doubles with each retry: 1+2+4+8+16ms.
+	if elapsed, min := time.Since(start), (1<<maxRetries-1)*initialRetryDelay; elapsed < min {
+		t.Errorf("Iterate() gave up after %v, want at least %v", elapsed, min)
+	}
+	// The error is not retried by subsequent calls.
+	if _, err := it.Next(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Next() after failure err = %v, want code %v", err, codes.Unavailable)
+	}
+	if got := len(fake.queries()); got != maxRetries+1 {
+		t.Errorf("Iterate() ran %d queries after failure, want %d", got, maxRetries+1)
+	}
+	// Errors which cannot be retried fail immediately.
+	fake.errors[len(fake.queries())] = codes.InvalidArgument
+	it = transpiler.Iterate(context.Background(), &test.ListTestRequest{Parent: "parents/p"}, BatchSize(5))
+	defer it.Stop()
+	before := len(fake.queries())
+	if _, err := it.Next(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Next() err = %v, want code %v", err, codes.InvalidArgument)
+	}
+	if got := len(fake.queries()) - before; got != 1 {
+		t.Errorf("Iterate() ran %d queries for a non-retryable error, want 1", got)
+	}
+}
+
+func TestIteratorPrefetch(t *testing.T) {
+	transpiler, fake, want := fakeTranspiler(t, 10)
+	it := transpiler.Iterate(context.Background(), &test.ListTestRequest{Parent: "parents/p"}, BatchSize(1), Prefetch(2))
+	defer it.Stop()
+	first, err := it.Next()
+	if err != nil {
+		t.Fatalf("Next() err = %v, want <nil>", err)
+	}
+	// The batch returned by Next, the two batches of the prefetch window, and
+	// the batch waiting for room in the window.
+	const bound = 4
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fake.queries()) < bound && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := len(fake.queries()); got != bound {
+		t.Errorf("Iterate() prefetched %d batches while Next was not called, want %d", got, bound)
+	}
+	got := append([]string{first.GetFilterablePrimitive()}, drain(t, it)...)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Iterate() diff (-want +got):\n%s", diff)
+	}
+	it.Stop()
+	if _, err := it.Next(); err != iterator.Done {
+		t.Errorf("Next() after Stop() err = %v, want %v", err, iterator.Done)
+	}
+}
+
+func TestIteratorPrefetchCancel(t *testing.T) {
+	transpiler, fake, _ := fakeTranspiler(t, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	it := transpiler.Iterate(ctx, &test.ListTestRequest{Parent: "parents/p"}, BatchSize(1), Prefetch(1))
+	defer it.Stop()
+	if _, err := it.Next(); err != nil {
+		t.Fatalf("Next() err = %v, want <nil>", err)
+	}
+	cancel()
+	// Batches already prefetched may still be returned, but the Iterator fails
+	// once they are exhausted, with the error of the context, or of a query
+	// it cancelled.
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		_, err = it.Next()
+	}
+	if err != context.Canceled && status.Code(err) != codes.Canceled {
+		t.Errorf("Next() after cancellation err = %v, want %v", err, context.Canceled)
+	}
+	// Close waits for the prefetching to end, after which no more queries are
+	// sent.
+	done := make(chan struct{})
+	go func() {
+		transpiler.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() did not return once the context of the Iterator was done")
+	}
+	// A query sent before the cancellation may still reach the fake.
+	time.Sleep(50 * time.Millisecond)
+	n := len(fake.queries())
+	time.Sleep(50 * time.Millisecond)
+	if got := len(fake.queries()); got != n {
+		t.Errorf("Iterate() ran %d queries after prefetching ended, want 0", got-n)
+	}
+	if n >= 10 {
+		t.Errorf("Iterate() ran %d queries, want fewer than the 10 batches", n)
+	}
+}
+
+func TestIteratorStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	it := &Iterator[*test.TestFiltering]{ctx: ctx, batchSize: 10, prefetch: 2, stop: cancel}
+	it.Stop()
+	if _, err := it.Next(); err != context.Canceled {
+		t.Errorf("Next() after Stop() err = %v, want %v", err, context.Canceled)
+	}
+}
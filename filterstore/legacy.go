@@ -0,0 +1,82 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WithLegacyPageTokens accepts legacy page tokens, which are the raw ID of the
+// last document of the previous page, until the provided time, so that clients
+// paging through results while signed page tokens are deployed are not
+// interrupted. A legacy page token is upgraded by reading its document, to
+// recover the values it is ordered by, and the pages it retrieves have signed
+// page tokens.
+// Legacy page tokens are only accepted by List and Iterate, and are rejected
+// once the time has passed.
+func WithLegacyPageTokens(until time.Time) Option {
+	return func(o *options) {
+		o.legacyTokens = until
+	}
+}
+
+// Decodes the page token, which is nil if s is empty, accepting legacy page
+// tokens until the time of WithLegacyPageTokens.
+func (t *Transpiler[T]) decodePageToken(s string, checksum uint32) (*pageToken, error) {
+	token, err := t.tokens.decode(s, checksum)
+	if err == nil || !t.acceptsLegacyToken(s) {
+		return token, err
+	}
+	return &pageToken{Cursor: s, Checksum: checksum, legacy: true}, nil
+}
+
+// Reports whether s is a legacy page token which is still accepted. Tokens
+// signed with the key of the Transpiler are never legacy page tokens, even if
+// they were produced by a different request.
+func (t *Transpiler[T]) acceptsLegacyToken(s string) bool {
+	if !t.options.now().Before(t.options.legacyTokens) {
+		return false
+	}
+	if _, ok := t.tokens.verify(s); ok {
+		return false
+	}
+	// Document IDs cannot contain a slash, or be . or ..
+	return !strings.Contains(s, "/") && s != "." && s != ".."
+}
+
+// Reads the cursor document of a legacy page token of the request, so that
+// the query is positioned after its values.
+func (t *Transpiler[T]) readLegacyToken(ctx context.Context, client *firestore.Client, r *listRequest) error {
+	if r.token == nil || !r.token.legacy {
+		return nil
+	}
+	doc, err := client.Collection(fmt.Sprintf("%s/%s", r.parent, t.collection)).Doc(r.token.Cursor).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return invalidArgument(ErrInvalidPageToken, "page token refers to a document which no longer exists")
+	}
+	if err != nil {
+		return err
+	}
+	r.token.doc = doc
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestLegacyPageTokens(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	newTranspiler := func(opts ...Option) *Transpiler[*test.TestFiltering] {
+		transpiler := newTestTranspiler(t, testClient(t),
+			append(opts, WithPageTokenKey([]byte("key")), WithClock(func() time.Time { return now }))...)
+		return transpiler
+	}
+	req := &test.ListTestRequest{Parent: "parents/p", PageToken: "doc1"}
+
+	transpiler := newTranspiler(WithLegacyPageTokens(now.Add(time.Hour)))
+	r, err := transpiler.parseListRequest(req, callOptions{})
+	if err != nil {
+		t.Fatalf("parseListRequest() err = %v, want <nil>", err)
+	}
+	if !r.token.legacy || r.token.Cursor != "doc1" {
+		t.Errorf("parseListRequest().token = %+v, want a legacy token positioned at doc1", r.token)
+	}
+	// The document has not been read, so the token cannot position a query.
+	if _, err := transpiler.Plan(req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Plan() err = %v, want code %v", err, codes.InvalidArgument)
+	}
+	// A signed page token of another request is not mistaken for a legacy one.
+	signed, err := transpiler.tokens.encode(&pageToken{Cursor: "doc1", Checksum: r.checksum + 1})
+	if err != nil {
+		t.Fatalf("encode() err = %v, want <nil>", err)
+	}
+	if _, err := transpiler.parseListRequest(&test.ListTestRequest{Parent: "parents/p", PageToken: signed}, callOptions{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("parseListRequest(signed) err = %v, want code %v", err, codes.InvalidArgument)
+	}
+	if _, err := transpiler.parseListRequest(&test.ListTestRequest{Parent: "parents/p", PageToken: "a/b"}, callOptions{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("parseListRequest(%q) err = %v, want code %v", "a/b", err, codes.InvalidArgument)
+	}
+
+	for name, transpiler := range map[string]*Transpiler[*test.TestFiltering]{
+		"expired":  newTranspiler(WithLegacyPageTokens(now)),
+		"disabled": newTranspiler(),
+	} {
+		if _, err := transpiler.parseListRequest(req, callOptions{}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: parseListRequest() err = %v, want code %v", name, err, codes.InvalidArgument)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Tracks the background work of a Transpiler, such as prefetching batches or
+// streaming documents, so that Close can stop it.
+// The zero value is ready to use, and a nil lifecycle tracks nothing.
+type lifecycle struct {
+	mu      sync.Mutex
+	closed  bool
+	next    int
+	cancels map[int]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// Returns an error if the Transpiler has been closed.
+func (l *lifecycle) check() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return status.Error(codes.FailedPrecondition, "transpiler is closed")
+	}
+	return nil
+}
+
+// Starts background work, returning a context which is done once the provided
+// context is done or the Transpiler is closed, and a function which must be
+// called once the work ends.
+func (l *lifecycle) start(ctx context.Context) (context.Context, func(), error) {
+	ctx, cancel := context.WithCancel(ctx)
+	if l == nil {
+		return ctx, cancel, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		cancel()
+		return nil, nil, status.Error(codes.FailedPrecondition, "transpiler is closed")
+	}
+	if l.cancels == nil {
+		l.cancels = map[int]context.CancelFunc{}
+	}
+	id := l.next
+	l.next++
+	l.cancels[id] = cancel
+	l.wg.Add(1)
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			cancel()
+			l.mu.Lock()
+			delete(l.cancels, id)
+			l.mu.Unlock()
+			l.wg.Done()
+		})
+	}, nil
+}
+
+// Cancels all background work, and waits for it to end.
+func (l *lifecycle) close() {
+	l.mu.Lock()
+	l.closed = true
+	for _, cancel := range l.cancels {
+		cancel()
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+}
+
+// Close stops the background work of the Transpiler, such as the prefetching
+// of Iterators and the streams of TranspileChan, and waits for it to end.
+// Calls which are already running are allowed to finish, but subsequent calls
+// fail with FAILED_PRECONDITION. Close does not close the Firestore clients
+// of the Transpiler. Calling Close more than once has no effect.
+func (t *Transpiler[T]) Close() error {
+	t.lifecycle.close()
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestConcurrentPlans(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	defer transpiler.Close()
+	filters := []string{
+		`test_filtering.filterable_primitive = "a"`,
+		`test_filtering.default_float > 1.5`,
+		`test_filtering.filterable_primitive = "a" OR test_filtering.filterable_primitive = "b"`,
+	}
+	want := make([][]PlanQuery, len(filters))
+	for i, filter := range filters {
+		plan, err := transpiler.Plan(&test.ListTestRequest{Parent: "parents/p", Filter: filter})
+		if err != nil {
+			t.Fatalf("Plan(%q) err = %v, want <nil>", filter, err)
+		}
+		want[i] = plan.Queries
+	}
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		for i, filter := range filters {
+			wg.Add(1)
+			go func(i int, filter string) {
+				defer wg.Done()
+				plan, err := transpiler.Plan(&test.ListTestRequest{Parent: "parents/p", Filter: filter})
+				if err != nil {
+					t.Errorf("Plan(%q) err = %v, want <nil>", filter, err)
+					return
+				}
+				if diff := cmp.Diff(want[i], plan.Queries); diff != "" {
+					t.Errorf("Plan(%q) concurrently queries diff (-want +got):\n%s", filter, diff)
+				}
+			}(i, filter)
+		}
+	}
+	wg.Wait()
+}
+
+func TestClose(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	ctx, release, err := transpiler.lifecycle.start(context.Background())
+	if err != nil {
+		t.Fatalf("start() err = %v, want <nil>", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer release()
+		<-ctx.Done()
+		close(stopped)
+	}()
+	if err := transpiler.Close(); err != nil {
+		t.Fatalf("Close() err = %v, want <nil>", err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Errorf("Close() returned before background work stopped")
+	}
+	if err := transpiler.Close(); err != nil {
+		t.Errorf("Close() again err = %v, want <nil>", err)
+	}
+	req := &test.ListTestRequest{Parent: "parents/p"}
+	if _, err := transpiler.Plan(req); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Plan() after Close() err = %v, want code %v", err, codes.FailedPrecondition)
+	}
+	if _, err := transpiler.TranspileChan(context.Background(), req); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("TranspileChan() after Close() err = %v, want code %v", err, codes.FailedPrecondition)
+	}
+	if err := transpiler.Delete(context.Background(), "parents/p", "a"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Delete() after Close() err = %v, want code %v", err, codes.FailedPrecondition)
+	}
+	it := &Iterator[*test.TestFiltering]{ctx: context.Background(), batchSize: 10, prefetch: 2, lifecycle: &transpiler.lifecycle}
+	if _, err := it.Next(); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Next() of a prefetching Iterator after Close() err = %v, want code %v", err, codes.FailedPrecondition)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// LogLevel is the severity of a log. Its values match those of log/slog, so a
+// Logger can pass them to a *slog.Logger as a slog.Level.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = -4
+	LogInfo  LogLevel = 0
+	LogWarn  LogLevel = 4
+	LogError LogLevel = 8
+)
+
+// Logger receives structured logs from a Transpiler, as a message followed by
+// alternating keys and values, such as `"collection", "books"`.
+// A *slog.Logger can be adapted with:
+//
+//	func (l slogLogger) Log(ctx context.Context, level filterstore.LogLevel, msg string, keyvals ...interface{}) {
+//		l.Logger.Log(ctx, slog.Level(level), msg, keyvals...)
+//	}
+type Logger interface {
+	Log(ctx context.Context, level LogLevel, msg string, keyvals ...interface{})
+}
+
+// WithLogger sends logs of List calls to the provided Logger:
+//   - each execution, at LogDebug, with its redacted filter, document count
+//     and latency
+//   - each fallback strategy used, at LogInfo, such as a filter split across
+//     queries or evaluated in memory (see Warning), or a legacy page token
+//   - each unexpected expression in a filter, at LogWarn
+//   - each failure other than an invalid request, at LogError
+//
+// Nothing is logged by default.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// Logs the message, if the Transpiler has a Logger.
+func (t *Transpiler[T]) log(ctx context.Context, level LogLevel, msg string, keyvals ...interface{}) {
+	if t.options.logger != nil {
+		t.options.logger.Log(ctx, level, msg, keyvals...)
+	}
+}
+
+// Logs the outcome of a List call, which started at start.
+func (t *Transpiler[T]) logList(ctx context.Context, r *listRequest, page *ListPage[T], start time.Time, err error) {
+	if t.options.logger == nil {
+		return
+	}
+	var unexpected *unexpectedExprError
+	switch {
+	case errors.As(err, &unexpected):
+		t.log(ctx, LogWarn, "unexpected expression in filter", "collection", t.collection, "expr", unexpected.e.String())
+		return
+	case status.Code(err) == codes.InvalidArgument:
+		t.log(ctx, LogDebug, "list rejected", "collection", t.collection, "error", err)
+		return
+	case err != nil:
+		t.log(ctx, LogError, "list failed", "collection", t.collection, "error", err)
+		return
+	}
+	filter := canonical(r.filter.GetExpr(), true)
+	for _, w := range page.Warnings {
+		t.log(ctx, LogInfo, "filter fell back", "collection", t.collection, "filter", filter, "kind", string(w.Kind), "field", w.Field, "message", w.Message)
+	}
+	if r.token != nil && r.token.legacy {
+		t.log(ctx, LogInfo, "accepted legacy page token", "collection", t.collection, "filter", filter)
+	}
+	t.log(ctx, LogDebug, "listed documents", "collection", t.collection, "filter", filter, "documents", len(page.Items), "latency", t.options.now().Sub(start))
+}
+
+// Returned for an expression which should not exist in a checked filter.
+type unexpectedExprError struct {
+	e *expr.Expr
+}
+
+func (e *unexpectedExprError) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// GRPCStatus returns the INVALID_ARGUMENT status of the error.
+func (e *unexpectedExprError) GRPCStatus() *status.Status {
+	return status.New(codes.InvalidArgument, "invalid filter expression")
+}
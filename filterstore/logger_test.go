@@ -0,0 +1,94 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+type logRecord struct {
+	Level LogLevel
+	Msg   string
+}
+
+// Records the level and message of each log.
+type recordingLogger struct {
+	records []logRecord
+}
+
+func (l *recordingLogger) Log(_ context.Context, level LogLevel, msg string, _ ...interface{}) {
+	l.records = append(l.records, logRecord{Level: level, Msg: msg})
+}
+
+func TestLogList(t *testing.T) {
+	filter := parse(t, `test_filtering.filterable_primitive = "a"`)
+	for _, tc := range []struct {
+		name string
+		r    *listRequest
+		page *ListPage[*test.TestFiltering]
+		err  error
+		want []logRecord
+	}{
+		{
+			name: "listed",
+			r:    &listRequest{filter: filter},
+			page: &ListPage[*test.TestFiltering]{Items: []*test.TestFiltering{{}}},
+			want: []logRecord{{Level: LogDebug, Msg: "listed documents"}},
+		},
+		{
+			name: "fell back",
+			r:    &listRequest{filter: filter, token: &pageToken{legacy: true}},
+			page: &ListPage[*test.TestFiltering]{Warnings: []Warning{{Kind: WarningChunked}}},
+			want: []logRecord{
+				{Level: LogInfo, Msg: "filter fell back"},
+				{Level: LogInfo, Msg: "accepted legacy page token"},
+				{Level: LogDebug, Msg: "listed documents"},
+			},
+		},
+		{
+			name: "rejected",
+			err:  status.Error(codes.InvalidArgument, "invalid"),
+			want: []logRecord{{Level: LogDebug, Msg: "list rejected"}},
+		},
+		{
+			name: "unexpected expression",
+			err:  &PositionError{Err: &unexpectedExprError{e: &expr.Expr{}}},
+			want: []logRecord{{Level: LogWarn, Msg: "unexpected expression in filter"}},
+		},
+		{
+			name: "failed",
+			err:  status.Error(codes.Unavailable, "unavailable"),
+			want: []logRecord{{Level: LogError, Msg: "list failed"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &recordingLogger{}
+			transpiler := newTestTranspiler(t, nil, WithLogger(l))
+			transpiler.logList(context.Background(), tc.r, tc.page, time.Now(), tc.err)
+			if diff := cmp.Diff(tc.want, l.records); diff != "" {
+				t.Errorf("logList() logs diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
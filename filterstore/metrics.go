@@ -0,0 +1,72 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"time"
+)
+
+// QueryMetrics describes the outcome of a call of a Transpiler.
+type QueryMetrics struct {
+	// The collection that was queried.
+	Collection string
+	// The method that was called, such as "List" or "Count".
+	Method string
+	// The latency of the call.
+	Latency time.Duration
+	// The number of documents returned.
+	Documents int
+	// The number of documents returned which could not be fully decoded into
+	// the collection message, so are missing the fields which failed.
+	DecodeErrors int
+	// Whether terms of the filter were evaluated once documents were retrieved
+	// (see WarningClientFilter).
+	ClientFiltered bool
+	// The error returned by the call, or nil if it succeeded.
+	Err error
+}
+
+// Metrics records the outcome of each call of a Transpiler, such as to
+// OpenTelemetry or Prometheus instruments tagged by the collection and method
+// of the call.
+type Metrics interface {
+	RecordQuery(ctx context.Context, m QueryMetrics)
+}
+
+// WithMetrics records the outcome of each List and Count call with the
+// provided Metrics.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// Records the outcome of a call which started at start, if the Transpiler has
+// Metrics.
+func (t *Transpiler[T]) recordQuery(ctx context.Context, method string, start time.Time, page *ListPage[T], err error) {
+	if t.options.metrics == nil {
+		return
+	}
+	m := QueryMetrics{Collection: t.collection, Method: method, Latency: t.options.now().Sub(start), Err: err}
+	if page != nil {
+		m.Documents = len(page.Items)
+		m.DecodeErrors = page.decodeErrors
+		for _, w := range page.Warnings {
+			m.ClientFiltered = m.ClientFiltered || w.Kind == WarningClientFilter
+		}
+	}
+	t.options.metrics.RecordQuery(ctx, m)
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Records each QueryMetrics.
+type recordingMetrics struct {
+	queries []QueryMetrics
+}
+
+func (m *recordingMetrics) RecordQuery(_ context.Context, q QueryMetrics) {
+	m.queries = append(m.queries, q)
+}
+
+func TestRecordQuery(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	metrics := &recordingMetrics{}
+	transpiler := newTestTranspiler(t, testClient(t), WithMetrics(metrics), WithClock(func() time.Time { return now }))
+	page := &ListPage[*test.TestFiltering]{
+		Items:        []*test.TestFiltering{{}, {}},
+		Warnings:     []Warning{{Kind: WarningClientFilter}},
+		decodeErrors: 1,
+	}
+	transpiler.recordQuery(context.Background(), "List", now.Add(-time.Second), page, nil)
+	req := &test.ListTestRequest{Filter: "test_filtering.missing = 1"}
+	_, countErr := transpiler.Count(context.Background(), req)
+	if status.Code(countErr) != codes.InvalidArgument {
+		t.Fatalf("Count(%v) err = %v, want code %v", req, countErr, codes.InvalidArgument)
+	}
+	want := []QueryMetrics{
+		{Collection: "tests", Method: "List", Latency: time.Second, Documents: 2, DecodeErrors: 1, ClientFiltered: true},
+		{Collection: "tests", Method: "Count", Err: countErr},
+	}
+	if diff := cmp.Diff(want, metrics.queries, cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("recorded metrics diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// FieldNaming returns the name Firestore stores a field under.
+type FieldNaming func(protoreflect.FieldDescriptor) string
+
+var (
+	// CamelCaseNaming names fields by their Go struct fields, such as
+	// DisplayName, which is how the Firestore client stores messages. It is the
+	// default.
+	CamelCaseNaming FieldNaming = storedName
+	// JSONNaming names fields by their JSON names, such as displayName, which is
+	// how protojson stores messages by default.
+	JSONNaming FieldNaming = func(fd protoreflect.FieldDescriptor) string { return fd.JSONName() }
+	// SnakeCaseNaming names fields by their proto names, such as display_name,
+	// which is how protojson stores messages with UseProtoNames, as do the proto
+	// JSON libraries of many other languages.
+	SnakeCaseNaming FieldNaming = func(fd protoreflect.FieldDescriptor) string { return string(fd.Name()) }
+)
+
+// WithFieldNaming names the fields of stored documents with the provided
+// FieldNaming, such as SnakeCaseNaming, or a custom function, rather than
+// CamelCaseNaming, for documents written by other means than the Firestore
+// client, such as by other languages.
+// Set still writes messages with the Firestore client, so the documents it
+// writes are only matched with CamelCaseNaming.
+func WithFieldNaming(naming FieldNaming) Option {
+	return func(o *options) {
+		o.naming = naming
+	}
+}
+
+// WithStorageNames stores the fields at the provided paths, such as
+// "display_name" or "submessage.display_name", under the provided names, such
+// as "dn", rather than the names given by the FieldNaming, for documents
+// written with a pre-existing schema. The names are used by filters, order_by
+// and every other field of a request. A field is renamed wherever its message
+// is used.
+func WithStorageNames(names map[string]string) Option {
+	return func(o *options) {
+		if o.storageNames == nil {
+			o.storageNames = map[string]string{}
+		}
+		for path, name := range names {
+			o.storageNames[path] = name
+		}
+	}
+}
+
+// EnumStorage is how the values of enum fields are stored.
+type EnumStorage int
+
+const (
+	// EnumNumbers stores enum values as their numbers, such as 1, which is how
+	// the Firestore client stores messages. It is the default.
+	EnumNumbers EnumStorage = iota
+	// EnumNames stores enum values as their names, such as "ACTIVE", which is
+	// how protojson stores messages.
+	EnumNames
+)
+
+// WithEnumStorage stores the values of the enum fields at the provided paths,
+// such as "state" or "submessage.state", with the provided EnumStorage, or of
+// every enum field if no paths are provided. Fields named by later calls take
+// precedence.
+// Documents are ordered by the stored values, so enums stored as names are
+// ordered by name.
+func WithEnumStorage(storage EnumStorage, fields ...string) Option {
+	return func(o *options) {
+		if len(fields) == 0 {
+			o.enumStorage = storage
+			return
+		}
+		if o.enumFields == nil {
+			o.enumFields = map[string]EnumStorage{}
+		}
+		for _, field := range fields {
+			o.enumFields[field] = storage
+		}
+	}
+}
+
+// fieldNames describes how the fields of messages are stored: the names of the
+// fields, and how their enum and wrapper values are stored. The zero value
+// names every field by CamelCaseNaming, stores enum values as numbers, and
+// nests wrappers.
+type fieldNames struct {
+	naming FieldNaming
+	// The names of the fields renamed by WithStorageNames.
+	overrides map[protoreflect.FullName]string
+	// How enum values are stored, unless overridden for the field.
+	enums      EnumStorage
+	enumFields map[protoreflect.FullName]EnumStorage
+	wrappers   WrapperStorage
+	// The names of the arrays of denormalized values, by the path of their
+	// field, such as "items.sku".
+	denormalized map[string]string
+}
+
+// Resolves the paths of the overridden fields of the message, checking that
+// each exists.
+func newFieldNames(msg protoreflect.MessageDescriptor, naming FieldNaming, overrides map[string]string, enums EnumStorage, enumFields map[string]EnumStorage) (fieldNames, error) {
+	n := fieldNames{
+		naming:     naming,
+		overrides:  map[protoreflect.FullName]string{},
+		enums:      enums,
+		enumFields: map[protoreflect.FullName]EnumStorage{},
+	}
+	for path, storage := range enumFields {
+		_, fd, err := resolvePath(msg, fieldNames{}, "enum storage", path)
+		if err != nil {
+			return fieldNames{}, fmt.Errorf("invalid enum storage field %q: %w", path, err)
+		}
+		if fd.Enum() == nil {
+			return fieldNames{}, fmt.Errorf("invalid enum storage field %q: not an enum", path)
+		}
+		n.enumFields[fd.FullName()] = storage
+	}
+	for path, name := range overrides {
+		_, fd, err := resolvePath(msg, fieldNames{}, "storage name", path)
+		if err != nil {
+			return fieldNames{}, fmt.Errorf("invalid storage name field %q: %w", path, err)
+		}
+		if fd.ContainingMessage().IsMapEntry() {
+			return fieldNames{}, fmt.Errorf("invalid storage name field %q: map keys are stored verbatim", path)
+		}
+		if name == "" {
+			return fieldNames{}, fmt.Errorf("invalid storage name for field %q: must not be empty", path)
+		}
+		n.overrides[fd.FullName()] = name
+	}
+	return n, nil
+}
+
+// Returns the name Firestore stores the field under.
+func (n fieldNames) name(fd protoreflect.FieldDescriptor) string {
+	if name, ok := n.overrides[fd.FullName()]; ok {
+		return name
+	}
+	if n.naming != nil {
+		return n.naming(fd)
+	}
+	return storedName(fd)
+}
+
+// Returns the stored form of the enum value of the field.
+func (n fieldNames) storedEnum(fd protoreflect.FieldDescriptor, value protoreflect.EnumValueDescriptor) interface{} {
+	storage, ok := n.enumFields[fd.FullName()]
+	if !ok {
+		storage = n.enums
+	}
+	if storage == EnumNames {
+		return string(value.Name())
+	}
+	return int64(value.Number())
+}
+
+// The names of the Go struct fields of generated messages, by message, which
+// are computed once per message.
+var goFieldNames sync.Map // protoreflect.FullName -> map[protoreflect.Name]string
+
+// Returns the name Firestore stores the field under.
+// The Firestore client stores messages like any other Go struct, so fields are
+// named by the Go struct field generated for them, which is found by its
+// protobuf struct tag. Fields without a generated Go struct field, such as
+// those of dynamic messages, are named by their JSON name, capitalized, which
+// is the name protoc-gen-go would generate for them.
+func storedName(fd protoreflect.FieldDescriptor) string {
+	if name, ok := structFieldNames(fd.ContainingMessage())[fd.Name()]; ok {
+		return name
+	}
+	name := fd.JSONName()
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// Returns the names of the Go struct fields of the generated message, by the
+// name of the field they hold, or nil if the message has no generated type.
+func structFieldNames(md protoreflect.MessageDescriptor) map[protoreflect.Name]string {
+	if names, ok := goFieldNames.Load(md.FullName()); ok {
+		return names.(map[protoreflect.Name]string)
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName())
+	if err != nil {
+		return nil
+	}
+	t := reflect.TypeOf(mt.Zero().Interface())
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	t = t.Elem()
+	names := map[protoreflect.Name]string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		// A protobuf tag is a comma separated list, such as
+		// "bytes,1,opt,name=display_name,json=displayName,proto3".
+		for _, part := range strings.Split(f.Tag.Get("protobuf"), ",") {
+			if name := strings.TrimPrefix(part, "name="); name != part {
+				names[protoreflect.Name(name)] = f.Name
+			}
+		}
+	}
+	goFieldNames.Store(md.FullName(), names)
+	return names
+}
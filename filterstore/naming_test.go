@@ -0,0 +1,145 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestStoredName(t *testing.T) {
+	optional, str := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("naming_test.proto"),
+		Package: proto.String("filterstore.test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Dynamic"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("ip_address"), Number: proto.Int32(1), Label: optional, Type: str, JsonName: proto.String("ipAddress")},
+				{Name: proto.String("display_name"), Number: proto.Int32(2), Label: optional, Type: str, JsonName: proto.String("dn")},
+			},
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("protodesc.NewFile() err = %v, want <nil>", err)
+	}
+	dynamic := file.Messages().ByName("Dynamic")
+	generated := (&test.TestFiltering{}).ProtoReflect().Descriptor()
+	for _, tc := range []struct {
+		fd   protoreflect.FieldDescriptor
+		want string
+	}{
+		{generated.Fields().ByName("filterable_primitive"), "FilterablePrimitive"},
+		{generated.Fields().ByName("filterable_submessage").Message().Fields().ByName("unfilterable_primitive"), "UnfilterablePrimitive"},
+		// Fields without a generated Go struct field are named by their JSON name.
+		{testDescriptor.Fields().ByName("tags"), "Tags"},
+		{dynamic.Fields().ByName("ip_address"), "IpAddress"},
+		{dynamic.Fields().ByName("display_name"), "Dn"},
+	} {
+		if got := storedName(tc.fd); got != tc.want {
+			t.Errorf("storedName(%s) = %q, want %q", tc.fd.FullName(), got, tc.want)
+		}
+	}
+}
+
+func TestStorageNames(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t), WithStorageNames(map[string]string{
+		"filterable_primitive":                       "fp",
+		"filterable_submessage.filterable_primitive": "s.fp",
+	}), WithDefaultOrder("filterable_submessage.filterable_primitive desc"))
+	plan, err := transpiler.Plan(&test.ListTestRequest{
+		Parent:   "parents/p",
+		PageSize: 5,
+		Filter:   `test_filtering.filterable_primitive = "a"`,
+	})
+	if err != nil {
+		t.Fatalf("Plan() err = %v, want <nil>", err)
+	}
+	want := []PlanQuery{{
+		Collection: "tests",
+		Clauses: []string{
+			`fp == "a"`,
+			"order by FilterableSubmessage.`s.fp` desc",
+			"order by __name__ desc",
+			"limit 6",
+		},
+	}}
+	if diff := cmp.Diff(want, plan.Queries); diff != "" {
+		t.Errorf("Plan() queries diff (-want +got):\n%s", diff)
+	}
+	for _, names := range []map[string]string{
+		{"missing": "m"},
+		{"filterable_primitive": ""},
+	} {
+		if _, err := New(testClient(t), listTestMethod, &test.TestFiltering{}, WithStorageNames(names)); err == nil {
+			t.Errorf("New(WithStorageNames(%v)) err = <nil>, want an error", names)
+		}
+	}
+}
+
+func TestFieldNaming(t *testing.T) {
+	for _, tc := range []struct {
+		naming FieldNaming
+		want   []string
+	}{
+		{CamelCaseNaming, []string{`FilterableSubmessage.FilterablePrimitive == 1`, "order by DefaultFloat asc"}},
+		{JSONNaming, []string{`filterableSubmessage.filterablePrimitive == 1`, "order by defaultFloat asc"}},
+		{SnakeCaseNaming, []string{`filterable_submessage.filterable_primitive == 1`, "order by default_float asc"}},
+		{func(fd protoreflect.FieldDescriptor) string { return "x_" + string(fd.Name()) }, []string{`x_filterable_submessage.x_filterable_primitive == 1`, "order by x_default_float asc"}},
+	} {
+		transpiler := newTestTranspiler(t, testClient(t),
+			WithFieldNaming(tc.naming), WithDefaultOrder("default_float"))
+		plan, err := transpiler.Plan(&test.ListTestRequest{Parent: "parents/p", PageSize: 5, Filter: `test_filtering.filterable_submessage.filterable_primitive = 1`})
+		if err != nil {
+			t.Fatalf("Plan() err = %v, want <nil>", err)
+		}
+		if diff := cmp.Diff(tc.want, plan.Queries[0].Clauses[:2]); diff != "" {
+			t.Errorf("Plan() clauses diff (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestEnumStorage(t *testing.T) {
+	for _, tc := range []struct {
+		opts []Option
+		want string
+	}{
+		{nil, "DefaultEnum == 1"},
+		{[]Option{WithEnumStorage(EnumNames)}, `DefaultEnum == "VALUE_1"`},
+		{[]Option{WithEnumStorage(EnumNames, "default_enum")}, `DefaultEnum == "VALUE_1"`},
+		{[]Option{WithEnumStorage(EnumNames), WithEnumStorage(EnumNumbers, "default_enum")}, "DefaultEnum == 1"},
+	} {
+		transpiler := newTestTranspiler(t, testClient(t), tc.opts...)
+		plan, err := transpiler.Plan(&test.ListTestRequest{Parent: "parents/p", Filter: `test_filtering.default_enum = VALUE_1`})
+		if err != nil {
+			t.Fatalf("Plan() err = %v, want <nil>", err)
+		}
+		if got := plan.Queries[0].Clauses[0]; got != tc.want {
+			t.Errorf("Plan() clause = %q, want %q", got, tc.want)
+		}
+	}
+	if _, err := New(testClient(t), listTestMethod, &test.TestFiltering{}, WithEnumStorage(EnumNames, "filterable_primitive")); err == nil {
+		t.Errorf("New(WithEnumStorage(EnumNames, %q)) err = <nil>, want an error", "filterable_primitive")
+	}
+}
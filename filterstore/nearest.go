@@ -0,0 +1,152 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"cloud.google.com/go/firestore"
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"go.einride.tech/aip/ordering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// DistanceMeasure is the measure FindNearest ranks vectors by, as in
+// Firestore vector search:
+// https://firebase.google.com/docs/firestore/vector-search#distance
+type DistanceMeasure int
+
+const (
+	// Euclidean ranks vectors by ascending euclidean distance.
+	Euclidean DistanceMeasure = iota
+	// Cosine ranks vectors by ascending cosine distance.
+	Cosine
+	// DotProduct ranks vectors by descending dot product.
+	DotProduct
+)
+
+// Firestore limits the number of documents a vector search can return:
+// https://firebase.google.com/docs/firestore/vector-search#limitations
+const maxNearest = 1000
+
+// FindNearest returns up to limit documents in the collection of the parent
+// of the request which match its filter, ranked by the distance of the
+// repeated float or double field to the vector. Documents whose vector has a
+// different dimension are skipped. The order_by, page size and page token of
+// the request are ignored.
+//
+// The vector search is emulated, as described in Emulated Queries in the
+// package documentation, so only the closest documents are held in memory.
+func (t *Transpiler[T]) FindNearest(ctx context.Context, req protoexpr.ListRequest, field string, vector []float64, limit int, measure DistanceMeasure, opts ...CallOption) ([]T, error) {
+	if len(vector) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "vector must not be empty")
+	}
+	if limit < 1 || limit > maxNearest {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d, got %d", maxNearest, limit)
+	}
+	path, fd, err := resolvePath(t.emptyMessage.ProtoReflect().Descriptor(), t.names, "vector", field)
+	if err != nil {
+		return nil, err
+	}
+	if !fd.IsList() || (fd.Kind() != protoreflect.DoubleKind && fd.Kind() != protoreflect.FloatKind) {
+		return nil, status.Errorf(codes.InvalidArgument, "vector field %q is not a repeated float or double", field)
+	}
+	o := t.callOptions(opts)
+	o.orderBy = &ordering.OrderBy{}
+	r, err := t.listRequest(ctx, firstPageRequest{req}, o)
+	if err != nil {
+		return nil, err
+	}
+	type ranked struct {
+		doc      *firestore.DocumentSnapshot
+		distance float64
+	}
+	closer := func(a, b float64) bool {
+		if measure == DotProduct {
+			return a > b
+		}
+		return a < b
+	}
+	// Only the closest documents are kept as they are streamed, ordered by
+	// distance and then by the order they were read in.
+	var ranks []ranked
+	err = eachMatching(ctx, t.reader(o).Collection(fmt.Sprintf("%s/%s", r.parent, t.collection)).Query, r, func(doc *firestore.DocumentSnapshot) error {
+		v, err := doc.DataAtPath(path)
+		if err != nil {
+			return nil
+		}
+		d, ok := distance(measure, vector, v)
+		if !ok {
+			return nil
+		}
+		i := sort.Search(len(ranks), func(i int) bool { return closer(d, ranks[i].distance) })
+		if i == limit {
+			return nil
+		}
+		ranks = append(ranks, ranked{})
+		copy(ranks[i+1:], ranks[i:])
+		ranks[i] = ranked{doc: doc, distance: d}
+		if len(ranks) > limit {
+			ranks = ranks[:limit]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]T, len(ranks))
+	for i, rank := range ranks {
+		items[i] = t.factory()
+		if err := rank.doc.DataTo(items[i]); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// Returns the distance of the stored vector to the query vector, or false if
+// the stored value is not a vector of the same dimension.
+func distance(measure DistanceMeasure, query []float64, stored interface{}) (float64, bool) {
+	values, ok := stored.([]interface{})
+	if !ok || len(values) != len(query) {
+		return 0, false
+	}
+	var dot, sq, qnorm, snorm float64
+	for i, v := range values {
+		f, ok := v.(float64)
+		if !ok {
+			return 0, false
+		}
+		dot += f * query[i]
+		sq += (f - query[i]) * (f - query[i])
+		qnorm += query[i] * query[i]
+		snorm += f * f
+	}
+	switch measure {
+	case Cosine:
+		if qnorm == 0 || snorm == 0 {
+			return 0, false
+		}
+		return 1 - dot/(math.Sqrt(qnorm)*math.Sqrt(snorm)), true
+	case DotProduct:
+		return dot, true
+	}
+	return math.Sqrt(sq), true
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/genproto/googleapis/cloud/aiplatform/v1/schema/predict/prediction"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestDistance(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		measure DistanceMeasure
+		stored  interface{}
+		want    float64
+		wantOK  bool
+	}{
+		{name: "euclidean", measure: Euclidean, stored: []interface{}{4.0, 4.0}, want: 5, wantOK: true},
+		{name: "cosine", measure: Cosine, stored: []interface{}{-2.0, 0.0}, want: 2, wantOK: true},
+		{name: "dot product", measure: DotProduct, stored: []interface{}{2.0, 1.0}, want: 2, wantOK: true},
+		{name: "zero cosine", measure: Cosine, stored: []interface{}{0.0, 0.0}},
+		{name: "dimension", measure: Euclidean, stored: []interface{}{1.0}},
+		{name: "not a vector", measure: Euclidean, stored: "a"},
+		{name: "not a number", measure: Euclidean, stored: []interface{}{1.0, "a"}},
+	} {
+		got, ok := distance(tc.measure, []float64{1, 0}, tc.stored)
+		if ok != tc.wantOK || math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: distance() = %v, %t, want %v, %t", tc.name, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestFindNearestInvalid(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	for _, tc := range []struct {
+		name   string
+		field  string
+		vector []float64
+		limit  int
+	}{
+		{name: "missing field", field: "missing", vector: []float64{1}, limit: 1},
+		{name: "singular field", field: "default_float", vector: []float64{1}, limit: 1},
+		{name: "message field", field: "filterable_submessage", vector: []float64{1}, limit: 1},
+		{name: "empty vector", field: "default_float", limit: 1},
+		{name: "zero limit", field: "default_float", vector: []float64{1}},
+		{name: "large limit", field: "default_float", vector: []float64{1}, limit: maxNearest + 1},
+	} {
+		if _, err := transpiler.FindNearest(context.Background(), &test.ListTestRequest{}, tc.field, tc.vector, tc.limit, Euclidean); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: FindNearest() err = %v, want code %v", tc.name, err, codes.InvalidArgument)
+		}
+	}
+}
+
+func TestFindNearest(t *testing.T) {
+	_, client := newFakeFirestore(t)
+	transpiler := newMessageTranspiler(t, client, &prediction.ClassificationPredictionResult{})
+	ctx := context.Background()
+	results := map[string]*prediction.ClassificationPredictionResult{
+		"a": {Confidences: []float32{0, 0}},
+		"b": {Confidences: []float32{3, 4}},
+		"c": {Confidences: []float32{1, 1}},
+		// Vectors of a different dimension are skipped.
+		"d": {Confidences: []float32{1}},
+		"e": {},
+	}
+	for id, result := range results {
+		if err := transpiler.Set(ctx, "parents/p", id, result); err != nil {
+			t.Fatalf("Set(%s) err = %v, want <nil>", id, err)
+		}
+	}
+	for _, tc := range []struct {
+		name    string
+		vector  []float64
+		limit   int
+		measure DistanceMeasure
+		want    []string
+	}{
+		{name: "euclidean", vector: []float64{0, 0}, limit: 2, measure: Euclidean, want: []string{"a", "c"}},
+		{name: "dot product", vector: []float64{1, 1}, limit: 2, measure: DotProduct, want: []string{"b", "c"}},
+		{name: "fewer matches than the limit", vector: []float64{0, 0}, limit: 10, measure: Euclidean, want: []string{"a", "c", "b"}},
+	} {
+		got, err := transpiler.FindNearest(ctx, &test.ListTestRequest{Parent: "parents/p"}, "confidences", tc.vector, tc.limit, tc.measure)
+		if err != nil {
+			t.Fatalf("%s: FindNearest() err = %v, want <nil>", tc.name, err)
+		}
+		var want []*prediction.ClassificationPredictionResult
+		for _, id := range tc.want {
+			want = append(want, results[id])
+		}
+		if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+			t.Errorf("%s: FindNearest() diff (-want +got):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestFindNearestDecodeError(t *testing.T) {
+	_, client := newFakeFirestore(t)
+	transpiler := newMessageTranspiler(t, client, &prediction.ClassificationPredictionResult{})
+	ctx := context.Background()
+	if _, err := client.Doc("parents/p/items/a").Set(ctx, map[string]interface{}{"Confidences": []float64{0, 0}, "DisplayNames": 1}); err != nil {
+		t.Fatalf("Set() err = %v, want <nil>", err)
+	}
+	if _, err := transpiler.FindNearest(ctx, &test.ListTestRequest{Parent: "parents/p"}, "confidences", []float64{0, 0}, 1, Euclidean); err == nil {
+		t.Error("FindNearest() err = <nil>, want decode error")
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"strings"
+
+	"go.einride.tech/aip/filtering"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// The comparison which matches exactly the values the comparison does not.
+var negatedComparisons = map[string]string{
+	filtering.FunctionEquals:        filtering.FunctionNotEquals,
+	filtering.FunctionNotEquals:     filtering.FunctionEquals,
+	filtering.FunctionLessThan:      filtering.FunctionGreaterEquals,
+	filtering.FunctionLessEquals:    filtering.FunctionGreaterThan,
+	filtering.FunctionGreaterThan:   filtering.FunctionLessEquals,
+	filtering.FunctionGreaterEquals: filtering.FunctionLessThan,
+}
+
+// Returns a copy of the expression with every NOT pushed down to the filters it
+// negates by De Morgan's laws, such as `NOT (a = 1 AND b = 2)` as
+// `a != 1 OR b != 2`, or negated if not is set.
+// Comparisons are negated by their operator, except to strings with a trailing
+// wildcard, which only `=` supports. Any other filter keeps its NOT, such as
+// `NOT starts_with(a, "x")`.
+// Filters are shared with the expression, and new expressions take the ID of
+// the expression they replace.
+func pushNegations(e *expr.Expr, not bool) *expr.Expr {
+	call := e.GetCallExpr()
+	switch call.GetFunction() {
+	case filtering.FunctionNot:
+		if len(call.Args) == 1 {
+			return pushNegations(call.Args[0], !not)
+		}
+	case filtering.FunctionAnd, filtering.FunctionOr:
+		function := call.Function
+		if not {
+			function = filtering.FunctionAnd
+			if call.Function == filtering.FunctionAnd {
+				function = filtering.FunctionOr
+			}
+		}
+		args := make([]*expr.Expr, len(call.Args))
+		for i, arg := range call.Args {
+			args[i] = pushNegations(arg, not)
+		}
+		return &expr.Expr{Id: e.Id, ExprKind: &expr.Expr_CallExpr{CallExpr: &expr.Expr_Call{Function: function, Args: args}}}
+	}
+	if !not {
+		return e
+	}
+	if negated, ok := negatedComparisons[call.GetFunction()]; ok && len(call.Args) == 2 && !strings.HasSuffix(call.Args[1].GetConstExpr().GetStringValue(), "*") {
+		return &expr.Expr{Id: e.Id, ExprKind: &expr.Expr_CallExpr{CallExpr: &expr.Expr_Call{Function: negated, Args: call.Args}}}
+	}
+	return &expr.Expr{Id: e.Id, ExprKind: &expr.Expr_CallExpr{CallExpr: &expr.Expr_Call{Function: filtering.FunctionNot, Args: []*expr.Expr{e}}}}
+}
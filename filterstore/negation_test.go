@@ -0,0 +1,95 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+	"go.einride.tech/aip/filtering"
+)
+
+func TestPushNegations(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		want   string
+	}{
+		{
+			filter: `NOT (test_filtering.default_float = 1.5 AND test_filtering.filterable_primitive < "b")`,
+			want:   `(test_filtering.default_float != 1.5 OR test_filtering.filterable_primitive >= "b")`,
+		},
+		{
+			filter: `NOT (test_filtering.default_float != 1.5 OR NOT test_filtering.filterable_primitive > "b")`,
+			want:   `(test_filtering.default_float = 1.5 AND test_filtering.filterable_primitive > "b")`,
+		},
+		{
+			filter: `NOT (NOT test_filtering.default_float <= 1.5)`,
+			want:   `test_filtering.default_float <= 1.5`,
+		},
+		{
+			filter: `NOT (test_filtering.tags:"a" OR test_filtering.filterable_primitive = "a*")`,
+			want:   `(NOT (test_filtering.filterable_primitive = "a*") AND NOT (test_filtering.tags:"a"))`,
+		},
+	} {
+		filter := parse(t, tc.filter)
+		before := canonical(filter.GetExpr(), false)
+		if got := canonical(pushNegations(filter.GetExpr(), false), false); got != tc.want {
+			t.Errorf("pushNegations(%q) = %s, want %s", tc.filter, got, tc.want)
+		}
+		if after := canonical(filter.GetExpr(), false); after != before {
+			t.Errorf("pushNegations(%q) modified the filter to %s", tc.filter, after)
+		}
+	}
+}
+
+func TestTranspileNegatedConjunction(t *testing.T) {
+	// NOT (a AND b) matches documents which fail either filter, so it cannot
+	// be transpiled as NOT a AND NOT b, nor split across queries of each `!=`.
+	filter := `NOT (test_filtering.default_float = 1.5 AND test_filtering.filterable_primitive = "b")`
+	_, err := transpile(t, filter)
+	if !errors.Is(err, ErrUnsupportedFunction) || !strings.Contains(err.Error(), "WithResidualFiltering") {
+		t.Errorf("transpile(%q) err = %v, want %v naming WithResidualFiltering", filter, err, ErrUnsupportedFunction)
+	}
+
+	transpiler := newTestTranspiler(t, testClient(t), WithResidualFiltering())
+	req := &test.ListTestRequest{Parent: "parents/p", PageSize: 10, Filter: `test_filtering.filterable_submessage.filterable_primitive = 2 AND ` + filter}
+	plan, err := transpiler.Plan(req)
+	if err != nil {
+		t.Fatalf("Plan(%q) err = %v, want <nil>", req.Filter, err)
+	}
+	wantWarnings := []Warning{{Kind: WarningClientFilter, Message: `NOT (test_filtering.default_float = 1.5 AND test_filtering.filterable_primitive = "b") is evaluated against each document once it is retrieved`}}
+	if diff := cmp.Diff(wantWarnings, plan.Warnings); diff != "" {
+		t.Errorf("Plan(%q) warnings diff (-want +got):\n%s", req.Filter, diff)
+	}
+	for _, tc := range []struct {
+		msg  *test.TestFiltering
+		want bool
+	}{
+		{msg: &test.TestFiltering{DefaultFloat: 1.5, FilterablePrimitive: "b"}, want: false},
+		{msg: &test.TestFiltering{DefaultFloat: 1.5, FilterablePrimitive: "a"}, want: true},
+		{msg: &test.TestFiltering{DefaultFloat: 2, FilterablePrimitive: "b"}, want: true},
+	} {
+		got, err := Evaluate(filtering.Filter{CheckedExpr: parse(t, filter)}, tc.msg)
+		if err != nil {
+			t.Fatalf("Evaluate(%q) err = %v, want <nil>", filter, err)
+		}
+		if got != tc.want {
+			t.Errorf("Evaluate(%q, %v) = %t, want %t", filter, tc.msg, got, tc.want)
+		}
+	}
+}
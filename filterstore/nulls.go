@@ -0,0 +1,90 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"sort"
+
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// The ident of null, which filters have no literal for.
+const identNull = "null"
+
+// The type of null.
+var typeNull = &expr.Type{TypeKind: &expr.Type_Null{}}
+
+// Returns the declarations of null, and of `=` and `!=` between null and the
+// types of the fields of the message, such as `parent = null`.
+func declareNull(msg protoreflect.MessageDescriptor) []filtering.DeclarationOption {
+	decls := []filtering.DeclarationOption{filtering.DeclareIdent(identNull, typeNull)}
+	types := map[string]*expr.Type{
+		typeName(filtering.TypeString):                filtering.TypeString,
+		typeName(filtering.TypeInt):                   filtering.TypeInt,
+		typeName(filtering.TypeFloat):                 filtering.TypeFloat,
+		typeName(filtering.TypeBool):                  filtering.TypeBool,
+		"timestamp":                                   filtering.TypeTimestamp,
+		"duration":                                    filtering.TypeDuration,
+		string(structName):                            structType,
+		string(structValueField.Message().FullName()): structValueType,
+	}
+	messageTypes(msg, types, map[protoreflect.FullName]bool{})
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := types[name]
+		for _, fn := range []string{filtering.FunctionEquals, filtering.FunctionNotEquals} {
+			decls = append(decls, filtering.DeclareFunction(fn, filtering.NewFunctionOverload(
+				fmt.Sprintf("%s_%s_null", fn, name), filtering.TypeBool, t, typeNull,
+			)))
+		}
+	}
+	return decls
+}
+
+// Adds the declared types of the singular message fields of the message, and
+// of the messages it contains, by name.
+func messageTypes(msg protoreflect.MessageDescriptor, types map[string]*expr.Type, seen map[protoreflect.FullName]bool) {
+	if seen[msg.FullName()] || dynamicValue(msg) {
+		return
+	}
+	seen[msg.FullName()] = true
+	for i := 0; i < msg.Fields().Len(); i++ {
+		fd := msg.Fields().Get(i)
+		if fd.Message() == nil || structField(fd) {
+			continue
+		}
+		if !fd.IsList() && !fd.IsMap() && !timestampField(fd) && !durationField(fd) {
+			types[string(fd.Message().FullName())] = declaredType(fd)
+		}
+		messageTypes(fd.Message(), types, seen)
+	}
+}
+
+// Reports whether the argument is null, whether the ident of null, or a null
+// constant, such as a bound parameter.
+func nullArg(e *expr.Expr) bool {
+	if _, ok := e.GetConstExpr().GetConstantKind().(*expr.Constant_NullValue); ok {
+		return true
+	}
+	return e.GetIdentExpr().GetName() == identNull
+}
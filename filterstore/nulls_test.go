@@ -0,0 +1,101 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	fspb "google.golang.org/genproto/googleapis/firestore/v1"
+	spb "google.golang.org/protobuf/types/known/structpb"
+	wpb "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestTranspileNull(t *testing.T) {
+	for _, filter := range []string{
+		`test_filtering.default_submessage = null`,
+		`NOT test_filtering.default_submessage != null`,
+	} {
+		got, err := transpile(t, filter)
+		if err != nil {
+			t.Fatalf("transpile(%q) err = %v, want <nil>", filter, err)
+		}
+		want := []*fspb.StructuredQuery_Filter{unaryFilter("DefaultSubmessage", fspb.StructuredQuery_UnaryFilter_IS_NULL)}
+		if diff := cmp.Diff(want, wheres(got), protocmp.Transform()); diff != "" {
+			t.Errorf("transpile(%q) where diff (-want +got):\n%s", filter, diff)
+		}
+	}
+	// Null can only be compared with == by the Firestore client, so fields
+	// which are not null are those ordered after null.
+	for _, filter := range []string{
+		`test_filtering.default_submessage != null`,
+		`NOT test_filtering.default_submessage = null`,
+	} {
+		got, err := transpile(t, filter)
+		if err != nil {
+			t.Fatalf("transpile(%q) err = %v, want <nil>", filter, err)
+		}
+		if len(got) != 1 || got[0].GetWhere() != nil || len(got[0].GetOrderBy()) == 0 {
+			t.Fatalf("transpile(%q) = %v, want a single ordered query", filter, got)
+		}
+		if path := got[0].GetOrderBy()[0].GetField().GetFieldPath(); path != "DefaultSubmessage" {
+			t.Errorf("transpile(%q) ordered by %q, want %q", filter, path, "DefaultSubmessage")
+		}
+	}
+}
+
+func TestEvaluateNull(t *testing.T) {
+	unset := dynamicpb.NewMessage(testDescriptor)
+	set := dynamicpb.NewMessage(testDescriptor)
+	set.Set(testDescriptor.Fields().ByName("count"), protoreflect.ValueOfMessage(wpb.Int64(0).ProtoReflect()))
+	metadata, err := spb.NewStruct(map[string]interface{}{"owner": nil, "team": "a"})
+	if err != nil {
+		t.Fatalf("structpb.NewStruct() err = %v, want <nil>", err)
+	}
+	set.Set(testDescriptor.Fields().ByName("metadata"), protoreflect.ValueOfMessage(metadata.ProtoReflect()))
+	for _, tc := range []struct {
+		filter string
+		msg    *dynamicpb.Message
+		want   bool
+	}{
+		{`test_filtering.count = null`, unset, true},
+		{`test_filtering.count = null`, set, false},
+		{`test_filtering.count != null`, set, true},
+		// Scalars without presence are always stored.
+		{`test_filtering.filterable_primitive = null`, unset, false},
+		{`test_filtering.filterable_primitive != null`, unset, true},
+		{`test_filtering.metadata.owner = null`, set, true},
+		{`test_filtering.metadata.team = null`, set, false},
+		// Missing keys are not stored, so are neither null nor not null.
+		{`test_filtering.metadata.other = null`, set, false},
+		{`test_filtering.metadata.other != null`, set, false},
+	} {
+		filter, err := parseFilter(tc.filter, testDeclarations(t), testDescriptor, nil, false)
+		if err != nil {
+			t.Fatalf("parseFilter(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		ev, err := newEvaluator(filter)
+		if err != nil {
+			t.Fatalf("newEvaluator(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if got := ev.matches(tc.msg); got != tc.want {
+			t.Errorf("matches(%q, %v) = %t, want %t", tc.filter, tc.msg, got, tc.want)
+		}
+	}
+}
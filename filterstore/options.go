@@ -0,0 +1,371 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.einride.tech/aip/ordering"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Option configures a Transpiler.
+type Option func(*options)
+
+type options struct {
+	slowQueries    *SlowQueryLog
+	logger         Logger
+	tracer         Tracer
+	metrics        Metrics
+	pageTokenKey   []byte
+	indexes        []string
+	defaultOrder   string
+	descending     []string
+	filterable     []string
+	denied         []string
+	behaviors      []annotations.FieldBehavior
+	defaultFilter  string
+	searchable     []string
+	text           TextSearcher
+	caps           capabilities
+	replica        *firestore.Client
+	residual       bool
+	notEquals      NotEquals
+	now            func() time.Time
+	rand           io.Reader
+	planCacheSize  int
+	audit          func(context.Context, AuditRecord)
+	policy         func(context.Context) ResultPolicy
+	legacyTokens   time.Time
+	storageNames   map[string]string
+	naming         FieldNaming
+	enumStorage    EnumStorage
+	enumFields     map[string]EnumStorage
+	wrapperStorage WrapperStorage
+	denormalized   map[string]string
+}
+
+// WithPlanCache caches the checked filters and resolved orders of up to size
+// distinct requests, keyed by their filter and order_by, so that repeated
+// requests are not parsed and checked again. The least recently used request
+// is evicted once the cache is full.
+func WithPlanCache(size int) Option {
+	return func(o *options) {
+		o.planCacheSize = size
+	}
+}
+
+// WithPageTokenKey signs page tokens with the provided HMAC key.
+// Every replica serving the same method must use the same key, otherwise page
+// tokens will be rejected by other replicas.
+// Defaults to a random key, so page tokens are only valid for the lifetime of
+// the Transpiler.
+func WithPageTokenKey(key []byte) Option {
+	return func(o *options) {
+		o.pageTokenKey = key
+	}
+}
+
+// WithResidualFiltering evaluates the terms of a filter joined by AND which
+// Firestore cannot evaluate, such as an inequality on a second field or a
+// comparison of two fields, such as `spent > budget`, against
+// each document once it is retrieved, rather than rejecting the filter. The
+// rest of the filter is still evaluated by Firestore.
+// Only List evaluates such terms. Pages are still filled, by retrieving more
+// documents, so a page may read many more documents than it returns.
+func WithResidualFiltering() Option {
+	return func(o *options) {
+		o.residual = true
+	}
+}
+
+// WithClock tells the time with the provided function, rather than time.Now,
+// so that time dependent behaviour, such as the latencies recorded by
+// WithSlowQueryLog, is deterministic in tests.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+// WithRandomness reads random bytes from the provided reader, rather than
+// crypto/rand, so that randomized behaviour, such as the default page token
+// key, is deterministic in tests.
+func WithRandomness(r io.Reader) Option {
+	return func(o *options) {
+		o.rand = r
+	}
+}
+
+// WithSlowQueryLog records the latency of every List call in the provided
+// SlowQueryLog. A single SlowQueryLog may be shared by multiple Transpilers.
+func WithSlowQueryLog(l *SlowQueryLog) Option {
+	return func(o *options) {
+		o.slowQueries = l
+	}
+}
+
+// WithRepeatedIndex enables filtering on the fields of messages in the
+// provided repeated message fields, such as `items.sku = "x"`.
+//
+// Firestore cannot query the fields of messages in an array, so each element
+// is denormalized into a document of the `<field>_index` subcollection of its
+// document, which must be maintained by writing documents with Set and
+// Delete. Filters on the field are resolved against the subcollection, and
+// match documents with at least one element matching every such filter.
+// Filters on an indexed field must be joined to the rest of the filter with
+// AND.
+func WithRepeatedIndex(fields ...string) Option {
+	return func(o *options) {
+		o.indexes = append(o.indexes, fields...)
+	}
+}
+
+// WithDefaultOrder orders documents by the provided order_by
+// (https://google.aip.dev/132#ordering), such as "create_time desc", when a
+// request does not specify one, so that results are deterministic.
+// Documents without a value for an ordered field are excluded by Firestore.
+func WithDefaultOrder(orderBy string) Option {
+	return func(o *options) {
+		o.defaultOrder = orderBy
+	}
+}
+
+// WithFilterableFields only allows the filters of requests to use the provided
+// fields, such as "submessage", and the fields they contain, such as
+// "submessage.value". Filtering other fields returns an INVALID_ARGUMENT
+// error naming the field. Default filters, and prepared filters, are not
+// restricted.
+func WithFilterableFields(fields ...string) Option {
+	return func(o *options) {
+		o.filterable = append(o.filterable, fields...)
+	}
+}
+
+// WithDeniedFields prevents the filters of requests from using the provided
+// fields, and the fields they contain, even if they are filterable.
+// Filtering them returns an INVALID_ARGUMENT error naming the field.
+func WithDeniedFields(fields ...string) Option {
+	return func(o *options) {
+		o.denied = append(o.denied, fields...)
+	}
+}
+
+// WithUnfilterableBehaviors prevents the filters of requests from using fields
+// annotated with any of the provided google.api.field_behavior values, such as
+// INPUT_ONLY, and the fields they contain. Filtering them returns an
+// INVALID_ARGUMENT error naming the field and its behavior.
+func WithUnfilterableBehaviors(behaviors ...annotations.FieldBehavior) Option {
+	return func(o *options) {
+		o.behaviors = append(o.behaviors, behaviors...)
+	}
+}
+
+// WithDescendingByDefault orders the provided fields, such as "create_time",
+// in descending order when an order_by lists them without a direction.
+// An explicit direction, such as "create_time asc", is always respected.
+func WithDescendingByDefault(fields ...string) Option {
+	return func(o *options) {
+		o.descending = append(o.descending, fields...)
+	}
+}
+
+// WithDefaultFilter applies the provided filter
+// (https://google.aip.dev/160), such as `state != "DELETED"`, when a request
+// does not specify one, so that every handler of the collection applies the
+// same default. The filter is validated by New.
+// A request which specifies a filter replaces the default, rather than being
+// combined with it.
+func WithDefaultFilter(filter string) Option {
+	return func(o *options) {
+		o.defaultFilter = filter
+	}
+}
+
+// WithMultipleInequalities allows inequalities on multiple fields, such as
+// `a > 1 AND b < 5`, which Firestore supports for databases with a composite
+// index for every combination of fields filtered:
+// https://firebase.google.com/docs/firestore/query-data/multiple-range-fields
+// At most 10 fields may be used in inequalities.
+// Without this option, inequalities are limited to a single field.
+func WithMultipleInequalities() Option {
+	return func(o *options) {
+		o.caps.multipleInequalities = true
+	}
+}
+
+// Edition is the edition of a Firestore database, which determines the
+// operators it can evaluate natively:
+// https://cloud.google.com/firestore/docs/editions-overview
+type Edition int
+
+const (
+	// StandardEdition databases require a composite index for every
+	// combination of fields filtered. This is the default.
+	StandardEdition Edition = iota
+	// EnterpriseEdition databases, including those with MongoDB compatibility,
+	// do not require composite indexes, so inequalities may be used on
+	// multiple fields.
+	EnterpriseEdition
+)
+
+// WithEdition uses the operators which the edition of the database can
+// evaluate natively. For EnterpriseEdition, this enables inequalities on
+// multiple fields, as WithMultipleInequalities does.
+// matches() is still evaluated once documents are retrieved, as regular
+// expressions cannot be expressed by queries of the Firestore client.
+func WithEdition(e Edition) Option {
+	return func(o *options) {
+		if e == EnterpriseEdition {
+			o.caps.multipleInequalities = true
+		}
+	}
+}
+
+// WithReadReplica runs queries against the provided client, such as a client
+// of a separate database kept in sync with the primary database, to keep
+// List traffic off the primary database. Documents are still written to the
+// primary database by Set and Delete.
+// A single call can be routed to the primary database with ReadPrimary.
+func WithReadReplica(c *firestore.Client) Option {
+	return func(o *options) {
+		o.replica = c
+	}
+}
+
+// WithSearchableFields searches the provided string fields, such as
+// "display_name", for the bare terms of a filter, so that `"foo"` matches
+// documents where any of the fields is equal to, or for repeated fields
+// contains, "foo". Each field is searched by its own query, and the documents
+// of the queries are merged.
+// Defaults to the fields annotated as searchable with the
+// (kagadar.protoexpr.options.filtering) field option.
+func WithSearchableFields(fields ...string) Option {
+	return func(o *options) {
+		o.searchable = append(o.searchable, fields...)
+	}
+}
+
+// WithTextSearcher searches the provided TextSearcher for the bare terms of a
+// filter, such as `"foo"`, rather than the searchable fields of the message.
+// The documents found by the TextSearcher are read from Firestore, and
+// filtered by the rest of the filter, which the terms must be joined to with
+// AND.
+func WithTextSearcher(s TextSearcher) Option {
+	return func(o *options) {
+		o.text = s
+	}
+}
+
+// CallOption configures a single call to a Transpiler.
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	unbounded bool
+	batchSize int32
+	prefetch  int
+	orderBy   *ordering.OrderBy
+	primary   bool
+	totalSize bool
+	countOnly bool
+	stats     bool
+	// The filter of a Prepared filter, used instead of the filter of the
+	// request.
+	prepared *expr.CheckedExpr
+}
+
+// Resolves the provided options against the defaults of the Transpiler.
+func (t *Transpiler[T]) callOptions(opts []CallOption) callOptions {
+	o := callOptions{batchSize: t.maxPageSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Unbounded retrieves every document matching the filter, ignoring the
+// requested page size and the maximum page size of the method.
+// Documents are retrieved from Firestore in batches (see BatchSize).
+//
+// This is intended for internal batch jobs, and should not be used when
+// serving API requests.
+func Unbounded() CallOption {
+	return func(o *callOptions) {
+		o.unbounded = true
+	}
+}
+
+// BatchSize sets the number of documents retrieved from Firestore by each
+// query of an Unbounded call or an Iterator.
+// Defaults to the maximum page size of the method.
+func BatchSize(n int32) CallOption {
+	return func(o *callOptions) {
+		o.batchSize = n
+	}
+}
+
+// Prefetch retrieves up to n batches of an Unbounded call, an Iterator or
+// TranspileChan in the background, ahead of the documents being consumed.
+// Retrieval pauses while n batches are waiting to be consumed.
+// Defaults to 0, which only retrieves a batch once the previous batch has
+// been consumed.
+func Prefetch(n int) CallOption {
+	return func(o *callOptions) {
+		o.prefetch = n
+	}
+}
+
+// OrderBy orders documents by the provided order_by, instead of the order_by
+// of the request. This allows an order_by already parsed with
+// ordering.ParseOrderBy to be used without being parsed again.
+func OrderBy(o ordering.OrderBy) CallOption {
+	return func(opts *callOptions) {
+		opts.orderBy = &o
+	}
+}
+
+// ReadPrimary runs the queries of the call against the primary database, even
+// if a read replica was provided with WithReadReplica, such as when a caller
+// must observe its own writes.
+func ReadPrimary() CallOption {
+	return func(o *callOptions) {
+		o.primary = true
+	}
+}
+
+// TotalSize counts every document matching the filter of a List call,
+// concurrently with the retrieval of the page, and returns it as the
+// TotalSize of the page (https://google.aip.dev/132#total-size).
+// The order_by and page token of the request do not affect the count.
+func TotalSize() CallOption {
+	return func(o *callOptions) {
+		o.totalSize = true
+	}
+}
+
+// CountOnly skips the retrieval of documents by List calls with TotalSize
+// whose request has a page size of 0, so that only the TotalSize of the page
+// is set, without the cost of reading a page of documents.
+// Without this option, a page size of 0 retrieves the default page size.
+func CountOnly() CallOption {
+	return func(o *callOptions) {
+		o.countOnly = true
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"go.einride.tech/aip/ordering"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// fieldOrder is a single field of an order_by.
+type fieldOrder struct {
+	path firestore.FieldPath
+	dir  firestore.Direction
+}
+
+// Returns the order as an order_by string, such as `a,b desc`.
+func formatOrderBy(orderBy ordering.OrderBy) string {
+	var fields []string
+	for _, field := range orderBy.Fields {
+		if field.Desc {
+			fields = append(fields, field.Path+" desc")
+		} else {
+			fields = append(fields, field.Path)
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// Parses the order_by (https://google.aip.dev/132#ordering) of the request,
+// which is empty if the request does not support ordering.
+// Fields in descending without a direction are ordered in descending order.
+func parseOrderBy(req interface{}, descending map[string]bool) (ordering.OrderBy, error) {
+	r, ok := req.(ordering.Request)
+	if !ok {
+		return ordering.OrderBy{}, nil
+	}
+	orderBy, err := ordering.ParseOrderBy(r)
+	if err != nil {
+		return ordering.OrderBy{}, invalidArgument(ErrInvalidOrderBy, "%v", err)
+	}
+	return defaultDirections(r.GetOrderBy(), orderBy, descending), nil
+}
+
+// Orders each field of the parsed order_by which is in descending, and has no
+// direction in the raw order_by, in descending order.
+func defaultDirections(raw string, orderBy ordering.OrderBy, descending map[string]bool) ordering.OrderBy {
+	if len(descending) == 0 {
+		return orderBy
+	}
+	fields := append([]ordering.Field(nil), orderBy.Fields...)
+	// The parsed fields are in the same order as the raw fields.
+	for i, field := range strings.Split(raw, ",") {
+		if i < len(fields) && len(strings.Fields(field)) == 1 && descending[fields[i].Path] {
+			fields[i].Desc = true
+		}
+	}
+	return ordering.OrderBy{Fields: fields}
+}
+
+// Resolves each field of the order_by to its Firestore path in the message.
+func resolveOrderBy(msg protoreflect.MessageDescriptor, names fieldNames, orderBy ordering.OrderBy) ([]fieldOrder, error) {
+	var orders []fieldOrder
+	for _, field := range orderBy.Fields {
+		path, _, err := namedPath(msg, names, "order_by", field.Path)
+		if err != nil {
+			return nil, err
+		}
+		o := fieldOrder{path: path, dir: firestore.Asc}
+		if field.Desc {
+			o.dir = firestore.Desc
+		}
+		orders = append(orders, o)
+	}
+	return orders, nil
+}
+
+// Returns the Firestore path and descriptor of a dot separated singular field
+// of the named parameter, relative to the message. Keys of map fields are used
+// verbatim.
+func namedPath(msg protoreflect.MessageDescriptor, names fieldNames, param, name string) (firestore.FieldPath, protoreflect.FieldDescriptor, error) {
+	path, fd, err := resolvePath(msg, names, param, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fd.IsList() || fd.IsMap() {
+		return nil, nil, invalidArgument(ErrRepeatedField, "%s field %q is repeated", param, name)
+	}
+	path, fd = names.unwrap(path, fd)
+	return path, fd, nil
+}
+
+// Returns the Firestore path and descriptor of a dot separated field of the
+// named parameter, which may be repeated.
+func resolvePath(msg protoreflect.MessageDescriptor, names fieldNames, param, name string) (firestore.FieldPath, protoreflect.FieldDescriptor, error) {
+	var path firestore.FieldPath
+	var fd protoreflect.FieldDescriptor
+	for _, segment := range strings.Split(name, ".") {
+		if fd != nil {
+			switch {
+			case fd.IsMap():
+				if err := checkMapKey(segment); err != nil {
+					return nil, nil, err
+				}
+				path = append(path, segment)
+				fd = fd.MapValue()
+				continue
+			case structField(fd) || structValue(fd):
+				if err := checkMapKey(segment); err != nil {
+					return nil, nil, err
+				}
+				path = append(path, segment)
+				fd = structValueField
+				continue
+			case wrapperField(fd) && names.wrappers == WrappersFlattened && segment == string(wrapperValueName):
+				// Flattened wrappers are stored as their value.
+				fd = fd.Message().Fields().ByName(wrapperValueName)
+				continue
+			case fd.IsList() || fd.Message() == nil:
+				return nil, nil, invalidArgument(ErrUnknownField, "%s field %q does not exist", param, name)
+			}
+			msg = fd.Message()
+		}
+		if fd = msg.Fields().ByName(protoreflect.Name(segment)); fd == nil {
+			return nil, nil, invalidArgument(ErrUnknownField, "%s field %q does not exist", param, name)
+		}
+		path = append(path, names.name(fd))
+	}
+	return path, fd, nil
+}
@@ -0,0 +1,326 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/go-cmp/cmp"
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"go.einride.tech/aip/ordering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+// orderedRequest is a ListTestRequest with an order_by.
+type orderedRequest struct {
+	*test.ListTestRequest
+	orderBy string
+}
+
+func (r orderedRequest) GetOrderBy() string { return r.orderBy }
+
+func TestParseOrderBy(t *testing.T) {
+	got, err := parseOrderBy(orderedRequest{orderBy: "default_float desc,  default_bool"}, nil)
+	if err != nil {
+		t.Fatalf("parseOrderBy() err = %v, want <nil>", err)
+	}
+	want := ordering.OrderBy{Fields: []ordering.Field{{Path: "default_float", Desc: true}, {Path: "default_bool"}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseOrderBy() diff (-want +got):\n%s", diff)
+	}
+	if got, err := parseOrderBy(filterRequest(""), nil); err != nil || len(got.Fields) != 0 {
+		t.Errorf("parseOrderBy() of unordered request = %v, %v, want empty, <nil>", got, err)
+	}
+	for _, orderBy := range []string{
+		"default_float sideways",
+		"default_float desc asc",
+		"default_float,",
+	} {
+		if _, err := parseOrderBy(orderedRequest{orderBy: orderBy}, nil); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("parseOrderBy(%q) err = %v, want code %v", orderBy, err, codes.InvalidArgument)
+		}
+	}
+}
+
+func TestParseOrderByDescending(t *testing.T) {
+	descending := map[string]bool{"default_float": true}
+	for _, tc := range []struct {
+		orderBy string
+		want    []ordering.Field
+	}{
+		{"default_float", []ordering.Field{{Path: "default_float", Desc: true}}},
+		{"default_float asc", []ordering.Field{{Path: "default_float"}}},
+		{"default_bool, default_float", []ordering.Field{{Path: "default_bool"}, {Path: "default_float", Desc: true}}},
+	} {
+		got, err := parseOrderBy(orderedRequest{orderBy: tc.orderBy}, descending)
+		if err != nil {
+			t.Fatalf("parseOrderBy(%q) err = %v, want <nil>", tc.orderBy, err)
+		}
+		if diff := cmp.Diff(ordering.OrderBy{Fields: tc.want}, got); diff != "" {
+			t.Errorf("parseOrderBy(%q) diff (-want +got):\n%s", tc.orderBy, diff)
+		}
+	}
+	transpiler := newTestTranspiler(t, testClient(t), WithDescendingByDefault("default_float"), WithDefaultOrder("default_float"))
+	if want := (ordering.OrderBy{Fields: []ordering.Field{{Path: "default_float", Desc: true}}}); !cmp.Equal(want, transpiler.defaultOrder) {
+		t.Errorf("New(WithDescendingByDefault()) default order = %v, want %v", transpiler.defaultOrder, want)
+	}
+	if _, err := New(testClient(t), listTestMethod, &test.TestFiltering{}, WithDescendingByDefault("missing")); err == nil {
+		t.Errorf("New(WithDescendingByDefault(%q)) err = <nil>, want error", "missing")
+	}
+}
+
+func TestResolveOrderBy(t *testing.T) {
+	msg := (&test.TestFiltering{}).ProtoReflect().Descriptor()
+	got, err := resolveOrderBy(msg, fieldNames{}, ordering.OrderBy{Fields: []ordering.Field{
+		{Path: "default_float", Desc: true},
+		{Path: "filterable_submessage.filterable_primitive"},
+	}})
+	if err != nil {
+		t.Fatalf("resolveOrderBy() err = %v, want <nil>", err)
+	}
+	want := []fieldOrder{
+		{path: firestore.FieldPath{"DefaultFloat"}, dir: firestore.Desc},
+		{path: firestore.FieldPath{"FilterableSubmessage", "FilterablePrimitive"}, dir: firestore.Asc},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(fieldOrder{})); diff != "" {
+		t.Errorf("resolveOrderBy() diff (-want +got):\n%s", diff)
+	}
+	for _, path := range []string{"missing", "filterable_submessage.missing", "default_float.missing"} {
+		_, err := resolveOrderBy(msg, fieldNames{}, ordering.OrderBy{Fields: []ordering.Field{{Path: path}}})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("resolveOrderBy(%q) err = %v, want code %v", path, err, codes.InvalidArgument)
+		}
+		if !errors.Is(err, ErrUnknownField) {
+			t.Errorf("resolveOrderBy(%q) err = %v, want %v", path, err, ErrUnknownField)
+		}
+	}
+}
+
+func TestListQueryOrderBy(t *testing.T) {
+	msg := (&test.TestFiltering{}).ProtoReflect().Descriptor()
+	orderBy, err := resolveOrderBy(msg, fieldNames{}, ordering.OrderBy{Fields: []ordering.Field{{Path: "default_float", Desc: true}}})
+	if err != nil {
+		t.Fatalf("resolveOrderBy() err = %v, want <nil>", err)
+	}
+	value := 1.5
+	token := &pageToken{Cursor: "b", Values: []cursorValue{{Double: &value}}}
+	q, err := listQuery(testClient(t), "tests", &listRequest{parent: "parents/p", orderBy: orderBy, token: token}, 10)
+	if err != nil {
+		t.Fatalf("listQuery() err = %v, want <nil>", err)
+	}
+	qs, err := q.build()
+	if err != nil {
+		t.Fatalf("build() err = %v, want <nil>", err)
+	}
+	got := serialize(t, qs)[0]
+	wantOrder := []string{"DefaultFloat DESCENDING", "__name__ DESCENDING"}
+	var gotOrder []string
+	for _, o := range got.GetOrderBy() {
+		gotOrder = append(gotOrder, o.GetField().GetFieldPath()+" "+o.GetDirection().String())
+	}
+	if diff := cmp.Diff(wantOrder, gotOrder); diff != "" {
+		t.Errorf("listQuery() order diff (-want +got):\n%s", diff)
+	}
+	if values := got.GetStartAt().GetValues(); len(values) != 2 || values[0].GetDoubleValue() != 1.5 || values[1].GetReferenceValue() != "projects/test/databases/(default)/documents/parents/p/tests/b" {
+		t.Errorf("listQuery() start at = %v, want [1.5, parents/p/tests/b]", values)
+	}
+	if got.GetStartAt().GetBefore() {
+		t.Errorf("listQuery() start at is inclusive, want exclusive")
+	}
+	// The token must have a value for every ordered field.
+	token.Values = nil
+	if _, err := listQuery(testClient(t), "tests", &listRequest{parent: "parents/p", orderBy: orderBy, token: token}, 10); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("listQuery() with missing cursor values err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestListOrderByOption(t *testing.T) {
+	transpiler := newTestTranspiler(t, nil)
+	orderBy := ordering.OrderBy{Fields: []ordering.Field{{Path: "missing"}}}
+	if _, err := transpiler.List(context.Background(), &test.ListTestRequest{}, OrderBy(orderBy)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("List(OrderBy(missing)) err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestDefaultOrder(t *testing.T) {
+	transpiler := newTestTranspiler(t, nil, WithDefaultOrder("default_float desc"))
+	for _, tc := range []struct {
+		name string
+		req  protoexpr.ListRequest
+		opts []CallOption
+		want []fieldOrder
+	}{
+		{
+			name: "default",
+			req:  &test.ListTestRequest{},
+			want: []fieldOrder{{path: firestore.FieldPath{"DefaultFloat"}, dir: firestore.Desc}},
+		},
+		{
+			name: "request",
+			req:  orderedRequest{ListTestRequest: &test.ListTestRequest{}, orderBy: "default_bool"},
+			want: []fieldOrder{{path: firestore.FieldPath{"DefaultBool"}, dir: firestore.Asc}},
+		},
+		{
+			name: "option",
+			req:  &test.ListTestRequest{},
+			opts: []CallOption{OrderBy(ordering.OrderBy{Fields: []ordering.Field{{Path: "default_bool"}}})},
+			want: []fieldOrder{{path: firestore.FieldPath{"DefaultBool"}, dir: firestore.Asc}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := transpiler.listRequest(context.Background(), tc.req, transpiler.callOptions(tc.opts))
+			if err != nil {
+				t.Fatalf("listRequest() err = %v, want <nil>", err)
+			}
+			if diff := cmp.Diff(tc.want, r.orderBy, cmp.AllowUnexported(fieldOrder{})); diff != "" {
+				t.Errorf("listRequest() order diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+	for _, orderBy := range []string{"missing", "default_float sideways"} {
+		if _, err := New(nil, listTestMethod, &test.TestFiltering{}, WithDefaultOrder(orderBy)); err == nil {
+			t.Errorf("New(WithDefaultOrder(%q)) err = <nil>, want error", orderBy)
+		}
+	}
+}
+
+func TestListQueryTieBreak(t *testing.T) {
+	cursor := "b"
+	for _, tc := range []struct {
+		name    string
+		orderBy []fieldOrder
+		filter  string
+		token   *pageToken
+		want    []string
+	}{
+		{
+			name: "unordered",
+		},
+		{
+			name:    "ascending",
+			orderBy: []fieldOrder{{path: firestore.FieldPath{"DefaultFloat"}, dir: firestore.Asc}},
+			want:    []string{"DefaultFloat", "__name__"},
+		},
+		{
+			name:    "descending",
+			orderBy: []fieldOrder{{path: firestore.FieldPath{"DefaultFloat"}, dir: firestore.Desc}},
+			want:    []string{"DefaultFloat desc", "__name__ desc"},
+		},
+		{
+			name:   "set",
+			filter: "test_filtering:filterable_submessage",
+			want:   []string{"FilterableSubmessage"},
+		},
+		{
+			name:   "set after page token",
+			filter: "test_filtering:filterable_submessage",
+			token:  &pageToken{Cursor: "a", Values: []cursorValue{{String: &cursor}}},
+			want:   []string{"FilterableSubmessage", "__name__"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &listRequest{parent: "parents/p", msg: testDescriptor, orderBy: tc.orderBy, token: tc.token}
+			if tc.filter != "" {
+				var err error
+				if r.rest, err = parseFilter(tc.filter, testDeclarations(t), testDescriptor, nil, false); err != nil {
+					t.Fatalf("parseFilter(%q) err = %v, want <nil>", tc.filter, err)
+				}
+			}
+			q, err := listQuery(testClient(t), "tests", r, 10)
+			if err != nil {
+				t.Fatalf("listQuery() err = %v, want <nil>", err)
+			}
+			if diff := cmp.Diff(tc.want, q.orderBy); diff != "" {
+				t.Errorf("listQuery() order diff (-want +got):\n%s", diff)
+			}
+			qs, err := q.build()
+			if err != nil {
+				t.Fatalf("build() err = %v, want <nil>", err)
+			}
+			serialize(t, qs)
+		})
+	}
+}
+
+func TestListQueryPresenceBeforeToken(t *testing.T) {
+	cursor := "b"
+	checked, err := parseFilter("test_filtering:filterable_submessage", testDeclarations(t), testDescriptor, nil, false)
+	if err != nil {
+		t.Fatalf("parseFilter() err = %v, want <nil>", err)
+	}
+	r := &listRequest{parent: "parents/p", msg: testDescriptor, rest: checked, token: &pageToken{Cursor: "a", Before: true, Values: []cursorValue{{String: &cursor}}}}
+	if _, err := listQuery(testClient(t), "tests", r, 10); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("listQuery() err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestListQueryInequalityOrder(t *testing.T) {
+	filter := parse(t, `test_filtering.default_float > 1.0`)
+	for _, tc := range []struct {
+		name    string
+		orderBy []fieldOrder
+		filter  string
+		token   *pageToken
+		want    []string
+	}{
+		{
+			name: "unordered",
+			want: []string{"DefaultFloat", "__name__"},
+		},
+		{
+			name:    "ordered by another field",
+			orderBy: []fieldOrder{{path: firestore.FieldPath{"DefaultBool"}, dir: firestore.Desc}},
+			want:    []string{"DefaultFloat", "DefaultBool desc", "__name__ desc"},
+		},
+		{
+			name: "ordered by the inequality field",
+			orderBy: []fieldOrder{
+				{path: firestore.FieldPath{"DefaultBool"}, dir: firestore.Asc},
+				{path: firestore.FieldPath{"DefaultFloat"}, dir: firestore.Desc},
+			},
+			want: []string{"DefaultFloat desc", "DefaultBool", "__name__"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &listRequest{parent: "parents/p", msg: testDescriptor, filter: filter, rest: filter, orderBy: tc.orderBy}
+			q, err := listQuery(testClient(t), "tests", r, 10)
+			if err != nil {
+				t.Fatalf("listQuery() err = %v, want <nil>", err)
+			}
+			if diff := cmp.Diff(tc.want, q.orderBy); diff != "" {
+				t.Errorf("listQuery() order diff (-want +got):\n%s", diff)
+			}
+			// The cursor of a page token holds a value for every ordered field.
+			var values []cursorValue
+			for range q.orders[:len(q.orders)-1] {
+				value := 1.5
+				values = append(values, cursorValue{Double: &value})
+			}
+			r.token = &pageToken{Cursor: "b", Values: values}
+			if q, err = listQuery(testClient(t), "tests", r, 10); err != nil {
+				t.Fatalf("listQuery() with page token err = %v, want <nil>", err)
+			}
+			if len(q.startAfter) != len(tc.want) {
+				t.Errorf("listQuery() with page token cursor = %v, want %d values", q.startAfter, len(tc.want))
+			}
+		})
+	}
+}
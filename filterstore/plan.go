@@ -0,0 +1,360 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"google.golang.org/protobuf/proto"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	fspb "google.golang.org/genproto/googleapis/firestore/v1"
+)
+
+// Plan describes the Firestore queries which would be run for a request.
+type Plan struct {
+	// The canonical form of the filter.
+	Filter string
+	// The queries of the index documents of repeated fields (see
+	// WithRepeatedIndex). Queries are restricted to the documents found by
+	// every lookup.
+	Lookups []PlanQuery
+	// The queries of the collection, whose documents are merged by name.
+	Queries []PlanQuery
+	// Warnings about the transpilation of the filter.
+	Warnings []Warning
+	// The filter, drawn as a tree by Graphviz and Mermaid.
+	expr *expr.Expr
+}
+
+// PlanQuery is a single Firestore query of a Plan.
+type PlanQuery struct {
+	// The collection, or collection group, the query is run against.
+	Collection string
+	// The clauses pushed down to Firestore, such as `FilterablePrimitive == "a"`.
+	Clauses []string
+}
+
+// Plan returns the Firestore queries which would be run by List for the
+// request, without running them.
+func (t *Transpiler[T]) Plan(req protoexpr.ListRequest, opts ...CallOption) (*Plan, error) {
+	o := t.callOptions(opts)
+	pageSize, err := t.pageSize(req, o)
+	if err != nil {
+		return nil, err
+	}
+	r, err := t.parseListRequest(req, o)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Filter: canonical(r.filter.GetExpr(), false), expr: r.filter.GetExpr()}
+	rest, indexed, err := t.splitIndexed(r.filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, fd := range t.indexes {
+		terms, ok := indexed[fd]
+		if !ok {
+			continue
+		}
+		q, err := t.indexQuery(t.reader(o), r.parent, fd, terms, r.filter.GetTypeMap())
+		if err != nil {
+			return nil, err
+		}
+		lookups, err := planQueries(q)
+		if err != nil {
+			return nil, err
+		}
+		plan.Lookups = append(plan.Lookups, lookups...)
+		plan.Warnings = append(plan.Warnings, Warning{
+			Kind:    WarningIndexLookup,
+			Field:   string(fd.Name()),
+			Message: "resolved against the index documents before the collection is queried",
+		})
+	}
+	if t.text != nil {
+		var terms []string
+		if rest, terms = splitTextSearch(rest); len(terms) > 0 {
+			plan.Warnings = append(plan.Warnings, Warning{
+				Kind:    WarningTextSearch,
+				Message: fmt.Sprintf("%d terms resolved by the text searcher before the collection is queried", len(terms)),
+			})
+		}
+	}
+	r.rest = rest
+	var post *evaluator
+	if rest, post, err = t.splitPostFilters(r, o); err != nil {
+		return nil, err
+	}
+	if post != nil {
+		plan.Warnings = append(plan.Warnings, Warning{
+			Kind:    WarningClientFilter,
+			Message: fmt.Sprintf("%s is evaluated against each document once it is retrieved", canonical(post.e, false)),
+		})
+	}
+	r.rest = rest
+	limit := int(pageSize) + 1
+	if o.unbounded {
+		limit = int(o.batchSize)
+	}
+	q, err := listQuery(t.reader(o), t.collection, r, limit)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Queries, err = planQueries(q); err != nil {
+		return nil, err
+	}
+	plan.Warnings = append(plan.Warnings, q.warnings...)
+	return plan, nil
+}
+
+// Returns the clauses of each Firestore query of the query.
+func planQueries(q *query) ([]PlanQuery, error) {
+	qs, err := q.build()
+	if err != nil {
+		return nil, err
+	}
+	var plans []PlanQuery
+	for _, q := range qs {
+		b, err := q.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		req := &fspb.RunQueryRequest{}
+		if err := proto.Unmarshal(b, req); err != nil {
+			return nil, err
+		}
+		plans = append(plans, planQuery(req.GetStructuredQuery()))
+	}
+	return plans, nil
+}
+
+var planOperators = map[fspb.StructuredQuery_FieldFilter_Operator]string{
+	fspb.StructuredQuery_FieldFilter_LESS_THAN:             "<",
+	fspb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL:    "<=",
+	fspb.StructuredQuery_FieldFilter_GREATER_THAN:          ">",
+	fspb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL: ">=",
+	fspb.StructuredQuery_FieldFilter_EQUAL:                 "==",
+	fspb.StructuredQuery_FieldFilter_NOT_EQUAL:             "!=",
+	fspb.StructuredQuery_FieldFilter_ARRAY_CONTAINS:        "array-contains",
+	fspb.StructuredQuery_FieldFilter_IN:                    "in",
+	fspb.StructuredQuery_FieldFilter_ARRAY_CONTAINS_ANY:    "array-contains-any",
+	fspb.StructuredQuery_FieldFilter_NOT_IN:                "not-in",
+}
+
+var planUnaryOperators = map[fspb.StructuredQuery_UnaryFilter_Operator]string{
+	fspb.StructuredQuery_UnaryFilter_IS_NAN:      "is NaN",
+	fspb.StructuredQuery_UnaryFilter_IS_NULL:     "is null",
+	fspb.StructuredQuery_UnaryFilter_IS_NOT_NAN:  "is not NaN",
+	fspb.StructuredQuery_UnaryFilter_IS_NOT_NULL: "is not null",
+}
+
+// Returns the clauses of the structured query.
+func planQuery(sq *fspb.StructuredQuery) PlanQuery {
+	var p PlanQuery
+	for _, from := range sq.GetFrom() {
+		p.Collection = from.GetCollectionId()
+	}
+	p.Clauses = planFilter(sq.GetWhere())
+	for _, o := range sq.GetOrderBy() {
+		dir := "asc"
+		if o.GetDirection() == fspb.StructuredQuery_DESCENDING {
+			dir = "desc"
+		}
+		p.Clauses = append(p.Clauses, fmt.Sprintf("order by %s %s", o.GetField().GetFieldPath(), dir))
+	}
+	if c := sq.GetStartAt(); c != nil {
+		position := "start after"
+		if c.GetBefore() {
+			position = "start at"
+		}
+		p.Clauses = append(p.Clauses, fmt.Sprintf("%s %s", position, planValues(c.GetValues())))
+	}
+	if c := sq.GetEndAt(); c != nil {
+		position := "end at"
+		if c.GetBefore() {
+			position = "end before"
+		}
+		p.Clauses = append(p.Clauses, fmt.Sprintf("%s %s", position, planValues(c.GetValues())))
+	}
+	if sq.GetLimit() != nil {
+		p.Clauses = append(p.Clauses, fmt.Sprintf("limit %d", sq.GetLimit().GetValue()))
+	}
+	return p
+}
+
+// Returns the conditions of the filter, which are joined by AND.
+func planFilter(f *fspb.StructuredQuery_Filter) []string {
+	switch f.GetFilterType().(type) {
+	case *fspb.StructuredQuery_Filter_CompositeFilter:
+		var clauses []string
+		for _, f := range f.GetCompositeFilter().GetFilters() {
+			clauses = append(clauses, planFilter(f)...)
+		}
+		return clauses
+	case *fspb.StructuredQuery_Filter_FieldFilter:
+		ff := f.GetFieldFilter()
+		return []string{fmt.Sprintf("%s %s %s", ff.GetField().GetFieldPath(), planOperators[ff.GetOp()], planValue(ff.GetValue()))}
+	case *fspb.StructuredQuery_Filter_UnaryFilter:
+		uf := f.GetUnaryFilter()
+		return []string{fmt.Sprintf("%s %s", uf.GetField().GetFieldPath(), planUnaryOperators[uf.GetOp()])}
+	}
+	return nil
+}
+
+func planValues(values []*fspb.Value) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = planValue(v)
+	}
+	return fmt.Sprintf("[%s]", strings.Join(s, ", "))
+}
+
+func planValue(v *fspb.Value) string {
+	switch v.GetValueType().(type) {
+	case *fspb.Value_BooleanValue:
+		return strconv.FormatBool(v.GetBooleanValue())
+	case *fspb.Value_IntegerValue:
+		return strconv.FormatInt(v.GetIntegerValue(), 10)
+	case *fspb.Value_DoubleValue:
+		return strconv.FormatFloat(v.GetDoubleValue(), 'g', -1, 64)
+	case *fspb.Value_TimestampValue:
+		return v.GetTimestampValue().AsTime().Format(time.RFC3339Nano)
+	case *fspb.Value_StringValue:
+		return strconv.Quote(v.GetStringValue())
+	case *fspb.Value_BytesValue:
+		return strconv.Quote(string(v.GetBytesValue()))
+	case *fspb.Value_ReferenceValue:
+		return relativeName(v.GetReferenceValue())
+	case *fspb.Value_ArrayValue:
+		return planValues(v.GetArrayValue().GetValues())
+	case *fspb.Value_MapValue:
+		var fields []string
+		for k, v := range v.GetMapValue().GetFields() {
+			fields = append(fields, fmt.Sprintf("%s: %s", k, planValue(v)))
+		}
+		sort.Strings(fields)
+		return fmt.Sprintf("{%s}", strings.Join(fields, ", "))
+	}
+	return "null"
+}
+
+// A node of the drawing of a Plan.
+type planNode struct {
+	id    string
+	label string
+}
+
+// A directed edge of the drawing of a Plan.
+type planEdge struct {
+	from, to, label string
+}
+
+// Returns the nodes and edges of the drawing of the plan: the filter as a
+// tree, the filter pushed down to each query, and each lookup restricting
+// each query.
+func (p *Plan) graph() ([]planNode, []planEdge) {
+	var nodes []planNode
+	var edges []planEdge
+	var walk func(e *expr.Expr) string
+	walk = func(e *expr.Expr) string {
+		id := fmt.Sprintf("e%d", len(nodes))
+		// Fields and constants are drawn as leaves.
+		label := canonical(e, false)
+		call := e.GetCallExpr()
+		if call != nil {
+			label = call.GetFunction()
+		}
+		nodes = append(nodes, planNode{id: id, label: label})
+		for _, child := range call.GetArgs() {
+			edges = append(edges, planEdge{from: id, to: walk(child)})
+		}
+		return id
+	}
+	root := ""
+	if p.expr != nil {
+		root = walk(p.expr)
+	}
+	var lookups []string
+	for i, l := range p.Lookups {
+		id := fmt.Sprintf("l%d", i)
+		nodes = append(nodes, planNode{id: id, label: l.label(fmt.Sprintf("lookup %d", i))})
+		lookups = append(lookups, id)
+	}
+	for i, q := range p.Queries {
+		id := fmt.Sprintf("q%d", i)
+		nodes = append(nodes, planNode{id: id, label: q.label(fmt.Sprintf("query %d", i))})
+		if root != "" {
+			edges = append(edges, planEdge{from: root, to: id, label: "pushed down"})
+		}
+		for _, l := range lookups {
+			edges = append(edges, planEdge{from: l, to: id, label: "restricts"})
+		}
+	}
+	return nodes, edges
+}
+
+// Returns the lines describing the query.
+func (q PlanQuery) label(title string) string {
+	return strings.Join(append([]string{fmt.Sprintf("%s: %s", title, q.Collection)}, q.Clauses...), "\n")
+}
+
+// Graphviz returns the plan as a Graphviz DOT digraph.
+func (p *Plan) Graphviz() string {
+	nodes, edges := p.graph()
+	var b strings.Builder
+	b.WriteString("digraph plan {\n\tnode [shape=box];\n")
+	for _, n := range nodes {
+		// Each line is left aligned by terminating it with \l.
+		label := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(n.label)
+		if strings.Contains(label, "\n") {
+			label = strings.ReplaceAll(label, "\n", `\l`) + `\l`
+		}
+		fmt.Fprintf(&b, "\t%s [label=\"%s\"];\n", n.id, label)
+	}
+	for _, e := range edges {
+		if e.label == "" {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", e.from, e.to)
+		} else {
+			fmt.Fprintf(&b, "\t%s -> %s [label=%q];\n", e.from, e.to, e.label)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid returns the plan as a Mermaid flowchart.
+func (p *Plan) Mermaid() string {
+	nodes, edges := p.graph()
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	for _, n := range nodes {
+		label := strings.NewReplacer(`"`, "#quot;", "\n", "<br/>").Replace(n.label)
+		fmt.Fprintf(&b, "\t%s[\"%s\"]\n", n.id, label)
+	}
+	for _, e := range edges {
+		if e.label == "" {
+			fmt.Fprintf(&b, "\t%s --> %s\n", e.from, e.to)
+		} else {
+			fmt.Fprintf(&b, "\t%s -->|%s| %s\n", e.from, e.label, e.to)
+		}
+	}
+	return b.String()
+}
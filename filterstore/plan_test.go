@@ -0,0 +1,90 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestPlan(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	plan, err := transpiler.Plan(&test.ListTestRequest{
+		Parent:   "parents/p",
+		PageSize: 5,
+		Filter:   `test_filtering.filterable_primitive = "a" AND test_filtering.default_float > 1.5`,
+	})
+	if err != nil {
+		t.Fatalf("Plan() err = %v, want <nil>", err)
+	}
+	want := []PlanQuery{{
+		Collection: "tests",
+		Clauses: []string{
+			`FilterablePrimitive == "a"`,
+			`DefaultFloat > 1.5`,
+			"order by DefaultFloat asc",
+			"order by __name__ asc",
+			"limit 6",
+		},
+	}}
+	if diff := cmp.Diff(want, plan.Queries); diff != "" {
+		t.Errorf("Plan() queries diff (-want +got):\n%s", diff)
+	}
+	wantGraphviz := `digraph plan {
+	node [shape=box];
+	e0 [label="AND"];
+	e1 [label="="];
+	e2 [label="test_filtering.filterable_primitive"];
+	e3 [label="\"a\""];
+	e4 [label=">"];
+	e5 [label="test_filtering.default_float"];
+	e6 [label="1.5"];
+	q0 [label="query 0: tests\lFilterablePrimitive == \"a\"\lDefaultFloat > 1.5\lorder by DefaultFloat asc\lorder by __name__ asc\llimit 6\l"];
+	e1 -> e2;
+	e1 -> e3;
+	e0 -> e1;
+	e4 -> e5;
+	e4 -> e6;
+	e0 -> e4;
+	e0 -> q0 [label="pushed down"];
+}
+`
+	if diff := cmp.Diff(wantGraphviz, plan.Graphviz()); diff != "" {
+		t.Errorf("Graphviz() diff (-want +got):\n%s", diff)
+	}
+	wantMermaid := `flowchart TD
+	e0["AND"]
+	e1["="]
+	e2["test_filtering.filterable_primitive"]
+	e3["#quot;a#quot;"]
+	e4[">"]
+	e5["test_filtering.default_float"]
+	e6["1.5"]
+	q0["query 0: tests<br/>FilterablePrimitive == #quot;a#quot;<br/>DefaultFloat > 1.5<br/>order by DefaultFloat asc<br/>order by __name__ asc<br/>limit 6"]
+	e1 --> e2
+	e1 --> e3
+	e0 --> e1
+	e4 --> e5
+	e4 --> e6
+	e0 --> e4
+	e0 -->|pushed down| q0
+`
+	if diff := cmp.Diff(wantMermaid, plan.Mermaid()); diff != "" {
+		t.Errorf("Mermaid() diff (-want +got):\n%s", diff)
+	}
+}
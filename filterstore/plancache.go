@@ -0,0 +1,104 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	lru "container/list"
+	"sync"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// planCache is a least recently used cache of the checked filters and
+// resolved orders of requests, keyed by their filter and order_by, so that
+// repeated requests are not parsed again. A nil planCache caches nothing.
+// Cached filters are shared by every request, so must not be modified.
+type planCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[planKey]*lru.Element
+	// The most recently used entry is at the front.
+	recent *lru.List
+	hits   int64
+	misses int64
+}
+
+type planKey struct {
+	filter  string
+	orderBy string
+}
+
+type plannedRequest struct {
+	key     planKey
+	filter  *expr.CheckedExpr
+	orderBy []fieldOrder
+}
+
+// Returns a cache of up to size requests, or nil if size is not positive.
+func newPlanCache(size int) *planCache {
+	if size <= 0 {
+		return nil
+	}
+	return &planCache{size: size, entries: map[planKey]*lru.Element{}, recent: lru.New()}
+}
+
+// Returns the cached request with the provided key, if any.
+func (c *planCache) get(key planKey) (*plannedRequest, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.recent.MoveToFront(e)
+	return e.Value.(*plannedRequest), true
+}
+
+// Caches the request, evicting the least recently used request if the cache
+// is full.
+func (c *planCache) add(p *plannedRequest) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[p.key]; ok {
+		e.Value = p
+		c.recent.MoveToFront(e)
+		return
+	}
+	c.entries[p.key] = c.recent.PushFront(p)
+	if c.recent.Len() > c.size {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*plannedRequest).key)
+	}
+}
+
+// Returns the number of cached requests, and of lookups which found and did
+// not find a cached request.
+func (c *planCache) stats() (size int, hits, misses int64) {
+	if c == nil {
+		return 0, 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.recent.Len(), c.hits, c.misses
+}
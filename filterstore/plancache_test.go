@@ -0,0 +1,74 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestPlanCacheEviction(t *testing.T) {
+	c := newPlanCache(2)
+	a, b, d := planKey{filter: "a"}, planKey{filter: "b"}, planKey{filter: "d"}
+	c.add(&plannedRequest{key: a})
+	c.add(&plannedRequest{key: b})
+	// a becomes the most recently used, so b is evicted.
+	if _, ok := c.get(a); !ok {
+		t.Errorf("get(a) = false, want true")
+	}
+	c.add(&plannedRequest{key: d})
+	for _, tc := range []struct {
+		key  planKey
+		want bool
+	}{{a, true}, {b, false}, {d, true}} {
+		if _, ok := c.get(tc.key); ok != tc.want {
+			t.Errorf("get(%q) = %t, want %t", tc.key.filter, ok, tc.want)
+		}
+	}
+	if size, hits, misses := c.stats(); size != 2 || hits != 3 || misses != 1 {
+		t.Errorf("stats() = %d, %d, %d, want 2, 3, 1", size, hits, misses)
+	}
+	if c := newPlanCache(0); c != nil {
+		t.Errorf("newPlanCache(0) = %v, want <nil>", c)
+	}
+}
+
+func TestWithPlanCache(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t), WithPlanCache(10))
+	req := &test.ListTestRequest{Parent: "parents/p", Filter: `test_filtering.filterable_primitive = "a"`}
+	first, err := transpiler.Plan(req)
+	if err != nil {
+		t.Fatalf("Plan() err = %v, want <nil>", err)
+	}
+	second, err := transpiler.Plan(req)
+	if err != nil {
+		t.Fatalf("Plan() err = %v, want <nil>", err)
+	}
+	if first.Filter != second.Filter {
+		t.Errorf("Plan() of cached request Filter = %q, want %q", second.Filter, first.Filter)
+	}
+	// Invalid requests are not cached.
+	invalid := &test.ListTestRequest{Parent: "parents/p", Filter: "test_filtering.missing = 1"}
+	for i := 0; i < 2; i++ {
+		if _, err := transpiler.Plan(invalid); err == nil {
+			t.Errorf("Plan(%q) err = <nil>, want error", invalid.Filter)
+		}
+	}
+	state := transpiler.DebugState()
+	if state.PlanCacheSize != 1 || state.PlanCacheHits != 1 || state.PlanCacheMisses != 3 {
+		t.Errorf("DebugState() plan cache = %d, %d hits, %d misses, want 1, 1 hits, 3 misses", state.PlanCacheSize, state.PlanCacheHits, state.PlanCacheMisses)
+	}
+}
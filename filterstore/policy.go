@@ -0,0 +1,73 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ResultPolicy limits the results which a call may return, such as for calls
+// made on behalf of external API keys.
+type ResultPolicy struct {
+	// If positive, the most documents a page may contain, regardless of the
+	// page size of the request. Calls which may return more than one page,
+	// such as Unbounded calls and iterators, are not permitted.
+	MaxPageSize int32
+	// If positive, the fewest documents which Count, Aggregate and the
+	// TotalSize of a page may be computed over, so that the results cannot
+	// identify individual documents.
+	MinAggregationCount int64
+}
+
+// WithResultPolicy limits the results of each call by the policy returned for
+// its context, such as the policy of the principal which made the request.
+func WithResultPolicy(policy func(ctx context.Context) ResultPolicy) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+// Returns the policy of the context of a call.
+func (t *Transpiler[T]) resultPolicy(ctx context.Context) ResultPolicy {
+	if t.options.policy == nil {
+		return ResultPolicy{}
+	}
+	return t.options.policy(ctx)
+}
+
+// Limits the page size of a call by its policy.
+func (p ResultPolicy) limitPageSize(pageSize int32, o callOptions) (int32, error) {
+	if p.MaxPageSize <= 0 {
+		return pageSize, nil
+	}
+	if o.unbounded {
+		return 0, status.Error(codes.PermissionDenied, "unbounded calls are not permitted")
+	}
+	if pageSize > p.MaxPageSize {
+		return p.MaxPageSize, nil
+	}
+	return pageSize, nil
+}
+
+// Checks that an aggregation over n documents is permitted by the policy.
+func (p ResultPolicy) checkAggregation(n int64) error {
+	if n < p.MinAggregationCount {
+		return status.Errorf(codes.PermissionDenied, "fewer than %d documents match the filter, so they cannot be aggregated", p.MinAggregationCount)
+	}
+	return nil
+}
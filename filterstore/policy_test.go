@@ -0,0 +1,73 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+type principalKey struct{}
+
+func TestResultPolicy(t *testing.T) {
+	policy := func(ctx context.Context) ResultPolicy {
+		if ctx.Value(principalKey{}) == "external" {
+			return ResultPolicy{MaxPageSize: 3, MinAggregationCount: 5}
+		}
+		return ResultPolicy{}
+	}
+	transpiler := newTestTranspiler(t, testClient(t), WithResultPolicy(policy))
+	external := context.WithValue(context.Background(), principalKey{}, "external")
+	req := &test.ListTestRequest{Parent: "parents/p", PageSize: 5}
+	for _, tc := range []struct {
+		ctx  context.Context
+		want int64
+	}{
+		{context.Background(), 5},
+		{external, 3},
+	} {
+		built, err := transpiler.Build(tc.ctx, req)
+		if err != nil {
+			t.Fatalf("Build() err = %v, want <nil>", err)
+		}
+		if got := serialize(t, []firestore.Query{built.Query})[0].GetLimit().GetValue(); int64(got) != tc.want {
+			t.Errorf("Build() limit = %d, want %d", got, tc.want)
+		}
+	}
+	if _, err := transpiler.Build(external, req, Unbounded()); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Build(Unbounded()) err = %v, want code %v", err, codes.PermissionDenied)
+	}
+	if _, err := transpiler.TranspileChan(external, req); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("TranspileChan() err = %v, want code %v", err, codes.PermissionDenied)
+	}
+	p := policy(external)
+	for _, tc := range []struct {
+		n    int64
+		code codes.Code
+	}{
+		{4, codes.PermissionDenied},
+		{5, codes.OK},
+	} {
+		if err := p.checkAggregation(tc.n); status.Code(err) != tc.code {
+			t.Errorf("checkAggregation(%d) err = %v, want code %v", tc.n, err, tc.code)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"cloud.google.com/go/firestore"
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Firestore cannot search within strings, so these functions are evaluated
+// against each document once it is retrieved.
+const (
+	// Filters a string field to values containing a substring, such as
+	// `contains(a, "abc")`.
+	functionContains = "contains"
+	// Filters a string field to values matching an RE2 regular expression,
+	// such as `matches(a, "^abc[0-9]+$")`.
+	functionMatches = "matches"
+)
+
+// Reports whether the function is evaluated once documents are retrieved.
+func postFilterFunction(function string) bool {
+	return function == functionContains || function == functionMatches
+}
+
+// NotEquals is how `!=` treats documents without the compared field.
+type NotEquals int
+
+const (
+	// NotEqualsExcludeMissing matches only documents with the field, as
+	// Firestore does, so `a != 1` does not match documents without a. It is the
+	// default.
+	NotEqualsExcludeMissing NotEquals = iota
+	// NotEqualsIncludeMissing matches documents without the field as if it had
+	// its default value, as AIP-160 filters of messages do, so `a != 1` matches
+	// documents without a. Firestore cannot query missing fields, so `!=` terms
+	// joined to the rest of the filter by AND are evaluated against each
+	// document once it is retrieved, while the rest of the filter is still
+	// evaluated by Firestore. Terms nested in other functions, such as
+	// `NOT (a = 1 OR a = 2)`, are still evaluated by Firestore.
+	NotEqualsIncludeMissing
+)
+
+// WithNotEquals sets how `!=` treats documents without the compared field.
+// Like WithResidualFiltering, only List evaluates terms in memory.
+func WithNotEquals(notEquals NotEquals) Option {
+	return func(o *options) {
+		o.notEquals = notEquals
+	}
+}
+
+// Reports whether the term compares a field with `!=`, such as `a != 1` or
+// `NOT a = 1`, rather than with null.
+func notEqualsTerm(term *expr.Expr) bool {
+	call := term.GetCallExpr()
+	function := call.GetFunction()
+	if function == filtering.FunctionNot {
+		call = call.GetArgs()[0].GetCallExpr()
+		if call.GetFunction() != filtering.FunctionEquals {
+			return false
+		}
+		function = filtering.FunctionNotEquals
+	}
+	return function == filtering.FunctionNotEquals && len(call.GetArgs()) == 2 && call.Args[0].GetSelectExpr() != nil && constantArg(call.Args[1]) && !nullArg(call.Args[1])
+}
+
+// Compiles the pattern of a matches() call.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s() pattern %q: %v", functionMatches, pattern, err)
+	}
+	return re, nil
+}
+
+// Splits the terms joined to the rest of the filter by AND which are
+// evaluated once documents are retrieved from the filter, returning the
+// remaining filter and an evaluator of the split terms, which is nil if there
+// are none.
+// contains(), matches() and all() terms are always split, as are `!=` terms if
+// notEquals is NotEqualsIncludeMissing, and any() terms which, according to
+// fits, cannot be transpiled. If residual is set, so is every term which,
+// according to fits, cannot be transpiled along with the terms kept before it.
+// Terms nested in other functions remain in the filter, and are rejected when
+// it is transpiled.
+func splitPostFilters(filter *expr.CheckedExpr, residual bool, notEquals NotEquals, fits func(*expr.CheckedExpr) bool) (*expr.CheckedExpr, *evaluator, error) {
+	if filter.GetExpr() == nil {
+		return filter, nil, nil
+	}
+	var rest, post []*expr.Expr
+	for _, term := range conjuncts(filter.GetExpr()) {
+		if call := term.GetCallExpr(); postFilterFunction(call.GetFunction()) && len(call.Args) == 2 && call.Args[1].GetConstExpr() != nil {
+			post = append(post, term)
+		} else if notEquals == NotEqualsIncludeMissing && notEqualsTerm(term) {
+			post = append(post, term)
+		} else if call.GetFunction() == functionAll {
+			post = append(post, term)
+		} else if call.GetFunction() == functionAny && !fits(&expr.CheckedExpr{Expr: term, TypeMap: filter.GetTypeMap()}) {
+			// any() is only transpiled for denormalized fields.
+			post = append(post, term)
+		} else {
+			rest = append(rest, term)
+		}
+	}
+	if residual && !fits(&expr.CheckedExpr{Expr: conjunction(rest), TypeMap: filter.GetTypeMap()}) {
+		terms := rest
+		rest = nil
+		for _, term := range terms {
+			if fits(&expr.CheckedExpr{Expr: conjunction(append(rest[:len(rest):len(rest)], term)), TypeMap: filter.GetTypeMap()}) {
+				rest = append(rest, term)
+			} else {
+				post = append(post, term)
+			}
+		}
+	}
+	if len(post) == 0 {
+		return filter, nil, nil
+	}
+	ev, err := newEvaluator(&expr.CheckedExpr{Expr: conjunction(post), TypeMap: filter.GetTypeMap()})
+	if err != nil {
+		return nil, nil, invalidField("filter", err)
+	}
+	return &expr.CheckedExpr{Expr: conjunction(rest), TypeMap: filter.GetTypeMap(), SourceInfo: filter.GetSourceInfo()}, ev, nil
+}
+
+// Splits the post filters from the remaining filter of the request.
+func (t *Transpiler[T]) splitPostFilters(r *listRequest, o callOptions) (*expr.CheckedExpr, *evaluator, error) {
+	return splitPostFilters(r.rest, t.options.residual, t.options.notEquals, func(filter *expr.CheckedExpr) bool {
+		q, err := newQuery(t.reader(o).Collection(t.collection).Query, r.msg, r.names, filter, r.caps)
+		if err == nil {
+			_, err = q.build()
+		}
+		return err == nil
+	})
+}
+
+// Retrieves a page of the documents matching the request which also match
+// the evaluator of its post filters.
+// Documents are retrieved in batches until the page is filled, so the page
+// token is positioned after the last document of the page, rather than the
+// last document retrieved. Pages cannot be reached backwards, so no previous
+// page token is returned.
+func (t *Transpiler[T]) listPostFiltered(ctx context.Context, r *listRequest, post *evaluator, o callOptions, pageSize int32) (*ListPage[T], error) {
+	if r.token != nil && r.token.Before {
+		return nil, status.Error(codes.InvalidArgument, "filters evaluated in memory cannot be used with a previous page token")
+	}
+	if !o.unbounded {
+		// An extra matching document determines whether there is another page.
+		o.batchSize = pageSize + 1
+	}
+	it, err := t.iterate(ctx, r, o)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Stop()
+	page := &ListPage[T]{EffectiveOrderBy: it.orderBy, Warnings: it.warnings}
+	var last *firestore.DocumentSnapshot
+	retrieved := 0
+	for {
+		msg, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		retrieved++
+		if !post.matches(msg.ProtoReflect()) {
+			recordFilteredOut(ctx)
+			continue
+		}
+		if !o.unbounded && len(page.Items) == int(pageSize) {
+			if page.NextPageToken, err = encodeCursor(t.tokens, last, it.orders, false, r.checksum); err != nil {
+				return nil, err
+			}
+			break
+		}
+		page.Items = append(page.Items, msg)
+		last = it.last
+		if last.ReadTime.After(page.ReadTime) {
+			page.ReadTime = last.ReadTime
+		}
+	}
+	page.Warnings = append(page.Warnings, Warning{
+		Kind:    WarningClientFilter,
+		Message: fmt.Sprintf("%d documents were retrieved to find %d matching the terms evaluated in memory", retrieved, len(page.Items)),
+	})
+	return page, nil
+}
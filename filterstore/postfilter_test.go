@@ -0,0 +1,153 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestPlanPostFilters(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	plan, err := transpiler.Plan(&test.ListTestRequest{
+		Parent:   "parents/p",
+		PageSize: 10,
+		Filter:   `contains(test_filtering.filterable_primitive, "b") AND test_filtering.default_float > 1.5 AND matches(test_filtering.filterable_primitive, "^a")`,
+	})
+	if err != nil {
+		t.Fatalf("Plan() err = %v, want <nil>", err)
+	}
+	want := []PlanQuery{{
+		Collection: "tests",
+		Clauses:    []string{"DefaultFloat > 1.5", "order by DefaultFloat asc", "order by __name__ asc", "limit 11"},
+	}}
+	if diff := cmp.Diff(want, plan.Queries); diff != "" {
+		t.Errorf("Plan() queries diff (-want +got):\n%s", diff)
+	}
+	if len(plan.Warnings) != 1 || plan.Warnings[0].Kind != WarningClientFilter {
+		t.Errorf("Plan() warnings = %v, want a single %s warning", plan.Warnings, WarningClientFilter)
+	}
+	for _, filter := range []string{
+		`NOT contains(test_filtering.filterable_primitive, "b")`,
+		`contains(test_filtering.filterable_primitive, "b") OR test_filtering.filterable_primitive = "a"`,
+		`NOT matches(test_filtering.filterable_primitive, "^a")`,
+		`matches(test_filtering.filterable_primitive, "(")`,
+	} {
+		if _, err := transpiler.Plan(&test.ListTestRequest{Parent: "parents/p", Filter: filter}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Plan(%q) err = %v, want code %v", filter, err, codes.InvalidArgument)
+		}
+	}
+}
+
+func TestPlanResidualFiltering(t *testing.T) {
+	req := &test.ListTestRequest{
+		Parent:   "parents/p",
+		PageSize: 10,
+		Filter:   `test_filtering.filterable_primitive > "a" AND test_filtering.default_float < 5.0`,
+	}
+	transpiler := newTestTranspiler(t, testClient(t))
+	if _, err := transpiler.Plan(req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Plan() err = %v, want code %v", err, codes.InvalidArgument)
+	}
+	transpiler = newTestTranspiler(t, testClient(t), WithResidualFiltering())
+	plan, err := transpiler.Plan(req)
+	if err != nil {
+		t.Fatalf("Plan(WithResidualFiltering()) err = %v, want <nil>", err)
+	}
+	want := []PlanQuery{{
+		Collection: "tests",
+		Clauses:    []string{`FilterablePrimitive > "a"`, "order by FilterablePrimitive asc", "order by __name__ asc", "limit 11"},
+	}}
+	if diff := cmp.Diff(want, plan.Queries); diff != "" {
+		t.Errorf("Plan(WithResidualFiltering()) queries diff (-want +got):\n%s", diff)
+	}
+	wantWarnings := []Warning{{Kind: WarningClientFilter, Message: "test_filtering.default_float < 5 is evaluated against each document once it is retrieved"}}
+	if diff := cmp.Diff(wantWarnings, plan.Warnings); diff != "" {
+		t.Errorf("Plan(WithResidualFiltering()) warnings diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestPlanFieldComparison(t *testing.T) {
+	req := &test.ListTestRequest{
+		Parent:   "parents/p",
+		PageSize: 10,
+		Filter:   `test_filtering.filterable_primitive = "a" AND test_filtering.default_float < test_filtering.default_float`,
+	}
+	transpiler := newTestTranspiler(t, testClient(t))
+	if _, err := transpiler.Plan(req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Plan() err = %v, want code %v", err, codes.InvalidArgument)
+	}
+	transpiler = newTestTranspiler(t, testClient(t), WithResidualFiltering())
+	plan, err := transpiler.Plan(req)
+	if err != nil {
+		t.Fatalf("Plan(WithResidualFiltering()) err = %v, want <nil>", err)
+	}
+	wantWarnings := []Warning{{Kind: WarningClientFilter, Message: "test_filtering.default_float < test_filtering.default_float is evaluated against each document once it is retrieved"}}
+	if diff := cmp.Diff(wantWarnings, plan.Warnings); diff != "" {
+		t.Errorf("Plan(WithResidualFiltering()) warnings diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestPlanNotEquals(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t), WithNotEquals(NotEqualsIncludeMissing))
+	plan, err := transpiler.Plan(&test.ListTestRequest{
+		Parent:   "parents/p",
+		PageSize: 10,
+		Filter:   `test_filtering.filterable_primitive != "a" AND test_filtering.default_float > 1.5 AND NOT test_filtering.filterable_primitive = "c"`,
+	})
+	if err != nil {
+		t.Fatalf("Plan() err = %v, want <nil>", err)
+	}
+	want := []PlanQuery{{
+		Collection: "tests",
+		Clauses:    []string{"DefaultFloat > 1.5", "order by DefaultFloat asc", "order by __name__ asc", "limit 11"},
+	}}
+	if diff := cmp.Diff(want, plan.Queries); diff != "" {
+		t.Errorf("Plan() queries diff (-want +got):\n%s", diff)
+	}
+	if len(plan.Warnings) != 1 || plan.Warnings[0].Kind != WarningClientFilter {
+		t.Errorf("Plan() warnings = %v, want a single %s warning", plan.Warnings, WarningClientFilter)
+	}
+	// Terms nested in other functions are still evaluated by Firestore.
+	plan, err = transpiler.Plan(&test.ListTestRequest{
+		Parent:   "parents/p",
+		PageSize: 10,
+		Filter:   `NOT (test_filtering.filterable_primitive = "a" OR test_filtering.filterable_primitive = "b")`,
+	})
+	if err != nil {
+		t.Fatalf("Plan() err = %v, want <nil>", err)
+	}
+	if len(plan.Warnings) != 0 {
+		t.Errorf("Plan() warnings = %v, want none", plan.Warnings)
+	}
+}
+
+func TestListPostFilteredErrors(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	req := &test.ListTestRequest{Parent: "parents/p", Filter: `contains(test_filtering.filterable_primitive, "b")`}
+	if _, err := transpiler.List(context.Background(), req, TotalSize()); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("List(TotalSize()) err = %v, want code %v", err, codes.InvalidArgument)
+	}
+	r := &listRequest{parent: "parents/p", token: &pageToken{Cursor: "b", Before: true}}
+	if _, err := transpiler.listPostFiltered(context.Background(), r, nil, transpiler.callOptions(nil), 10); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("listPostFiltered(previous page token) err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
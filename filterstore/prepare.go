@@ -0,0 +1,163 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Prepared is a filter which is parsed and checked once, then run with
+// different values bound to its parameters, such as a filter built from a
+// template.
+type Prepared[T proto.Message] struct {
+	transpiler *Transpiler[T]
+	filter     *expr.CheckedExpr
+	params     map[string]*expr.Type
+}
+
+// Prepare parses and checks a filter which refers to the provided
+// parameters by name, such as `owner = user AND priority > min_priority`.
+// Parameters may be strings, integers, floats or booleans, such as
+// filtering.TypeString.
+func (t *Transpiler[T]) Prepare(filter string, params map[string]*expr.Type) (*Prepared[T], error) {
+	if err := t.lifecycle.check(); err != nil {
+		return nil, err
+	}
+	if filter == "" {
+		return nil, status.Error(codes.InvalidArgument, "prepared filter cannot be empty")
+	}
+	var declOpts []filtering.DeclarationOption
+	if t.text != nil {
+		declOpts = append(declOpts, declareTextSearch())
+	}
+	for name, typ := range params {
+		switch typ.GetPrimitive() {
+		case expr.Type_STRING, expr.Type_INT64, expr.Type_DOUBLE, expr.Type_BOOL:
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "parameter %q has unsupported type %v", name, typ)
+		}
+		declOpts = append(declOpts, filtering.DeclareIdent(name, typ))
+	}
+	msg := t.emptyMessage.ProtoReflect().Descriptor()
+	decls, err := NewDeclarations(msg, declOpts...)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid parameters: %v", err)
+	}
+	checked, err := parseFilter(filter, decls, msg, t.search, t.text != nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTypes(msg, checked.GetExpr(), t.options.residual); err != nil {
+		return nil, err
+	}
+	return &Prepared[T]{transpiler: t, filter: checked, params: params}, nil
+}
+
+// Execute lists the documents matching the prepared filter with the provided
+// values bound to its parameters. The filter of the request must be empty.
+func (p *Prepared[T]) Execute(ctx context.Context, req protoexpr.ListRequest, params map[string]interface{}, opts ...CallOption) (*ListPage[T], error) {
+	if req.GetFilter() != "" {
+		return nil, status.Error(codes.InvalidArgument, "request of a prepared filter cannot have a filter")
+	}
+	filter, err := p.bind(params)
+	if err != nil {
+		return nil, err
+	}
+	return p.transpiler.List(ctx, req, append(opts, func(o *callOptions) { o.prepared = filter })...)
+}
+
+// Returns a copy of the prepared filter with the provided values in place
+// of its parameters.
+func (p *Prepared[T]) bind(params map[string]interface{}) (*expr.CheckedExpr, error) {
+	consts := make(map[string]*expr.Constant, len(params))
+	for name, v := range params {
+		typ, ok := p.params[name]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown parameter %q", name)
+		}
+		c, err := parameterConstant(name, typ, v)
+		if err != nil {
+			return nil, err
+		}
+		consts[name] = c
+	}
+	for name := range p.params {
+		if _, ok := consts[name]; !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "parameter %q is not bound", name)
+		}
+	}
+	filter := proto.Clone(p.filter).(*expr.CheckedExpr)
+	bindParameters(filter.GetExpr(), consts)
+	// Values may still be invalid for the fields they are compared to.
+	if err := checkTypes(p.transpiler.emptyMessage.ProtoReflect().Descriptor(), filter.GetExpr(), p.transpiler.options.residual); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// Replaces each parameter within the expression with its value.
+func bindParameters(e *expr.Expr, consts map[string]*expr.Constant) {
+	switch kind := e.GetExprKind().(type) {
+	case *expr.Expr_IdentExpr:
+		if c, ok := consts[kind.IdentExpr.GetName()]; ok {
+			e.ExprKind = &expr.Expr_ConstExpr{ConstExpr: c}
+		}
+	case *expr.Expr_SelectExpr:
+		bindParameters(kind.SelectExpr.GetOperand(), consts)
+	case *expr.Expr_CallExpr:
+		for _, arg := range kind.CallExpr.GetArgs() {
+			bindParameters(arg, consts)
+		}
+	}
+}
+
+// Returns the constant of the value of a parameter of the provided type.
+func parameterConstant(name string, typ *expr.Type, v interface{}) (*expr.Constant, error) {
+	switch typ.GetPrimitive() {
+	case expr.Type_STRING:
+		if s, ok := v.(string); ok {
+			return &expr.Constant{ConstantKind: &expr.Constant_StringValue{StringValue: s}}, nil
+		}
+	case expr.Type_INT64:
+		switch i := v.(type) {
+		case int:
+			return &expr.Constant{ConstantKind: &expr.Constant_Int64Value{Int64Value: int64(i)}}, nil
+		case int32:
+			return &expr.Constant{ConstantKind: &expr.Constant_Int64Value{Int64Value: int64(i)}}, nil
+		case int64:
+			return &expr.Constant{ConstantKind: &expr.Constant_Int64Value{Int64Value: i}}, nil
+		}
+	case expr.Type_DOUBLE:
+		switch f := v.(type) {
+		case float32:
+			return &expr.Constant{ConstantKind: &expr.Constant_DoubleValue{DoubleValue: float64(f)}}, nil
+		case float64:
+			return &expr.Constant{ConstantKind: &expr.Constant_DoubleValue{DoubleValue: f}}, nil
+		}
+	case expr.Type_BOOL:
+		if b, ok := v.(bool); ok {
+			return &expr.Constant{ConstantKind: &expr.Constant_BoolValue{BoolValue: b}}, nil
+		}
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "parameter %q of type %v cannot be bound to %T", name, typ, v)
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"testing"
+
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestPrepare(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	params := map[string]*expr.Type{"name": filtering.TypeString, "min": filtering.TypeFloat}
+	prepared, err := transpiler.Prepare(`test_filtering.filterable_primitive = name AND test_filtering.default_float > min`, params)
+	if err != nil {
+		t.Fatalf("Prepare() err = %v, want <nil>", err)
+	}
+	for _, tc := range []struct {
+		params map[string]interface{}
+		want   string
+	}{
+		{map[string]interface{}{"name": "a", "min": 1.5}, `(test_filtering.default_float > 1.5 AND test_filtering.filterable_primitive = "a")`},
+		{map[string]interface{}{"name": "b", "min": float32(2)}, `(test_filtering.default_float > 2 AND test_filtering.filterable_primitive = "b")`},
+	} {
+		filter, err := prepared.bind(tc.params)
+		if err != nil {
+			t.Fatalf("bind(%v) err = %v, want <nil>", tc.params, err)
+		}
+		if got := canonical(filter.GetExpr(), false); got != tc.want {
+			t.Errorf("bind(%v) = %q, want %q", tc.params, got, tc.want)
+		}
+		plan, err := transpiler.Plan(&test.ListTestRequest{Parent: "parents/p"}, func(o *callOptions) { o.prepared = filter })
+		if err != nil {
+			t.Fatalf("Plan(bind(%v)) err = %v, want <nil>", tc.params, err)
+		}
+		if plan.Filter != tc.want {
+			t.Errorf("Plan(bind(%v)).Filter = %q, want %q", tc.params, plan.Filter, tc.want)
+		}
+	}
+	// The prepared filter is not changed by binding.
+	if got, want := canonical(prepared.filter.GetExpr(), false), "(test_filtering.default_float > min AND test_filtering.filterable_primitive = name)"; got != want {
+		t.Errorf("prepared filter = %q, want %q", got, want)
+	}
+	for _, params := range []map[string]interface{}{
+		{"name": "a"},
+		{"name": "a", "min": 1.5, "max": 2.5},
+		{"name": 1, "min": 1.5},
+	} {
+		if _, err := prepared.bind(params); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("bind(%v) err = %v, want code %v", params, err, codes.InvalidArgument)
+		}
+	}
+	if _, err := prepared.Execute(context.Background(), &test.ListTestRequest{Filter: `test_filtering.filterable_primitive = "a"`}, map[string]interface{}{"name": "a", "min": 1.5}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Execute(filtered request) err = %v, want code %v", err, codes.InvalidArgument)
+	}
+	for _, tc := range []struct {
+		filter string
+		params map[string]*expr.Type
+	}{
+		{"", nil},
+		{`test_filtering.filterable_primitive = name`, nil},
+		{`test_filtering.filterable_primitive = name`, map[string]*expr.Type{"name": filtering.TypeTimestamp}},
+	} {
+		if _, err := transpiler.Prepare(tc.filter, tc.params); err == nil {
+			t.Errorf("Prepare(%q, %v) err = <nil>, want error", tc.filter, tc.params)
+		}
+	}
+}
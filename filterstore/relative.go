@@ -0,0 +1,148 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"time"
+
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// The functions of relative times, such as `expire_time < now()` or
+// `create_time > sub(now(), duration("24h"))`, as filters have no arithmetic
+// operators.
+const (
+	functionNow = "now"
+	functionAdd = "add"
+	functionSub = "sub"
+)
+
+// Returns the declarations of now(), and of adding durations to and
+// subtracting them from timestamps.
+func declareRelativeTimes() []filtering.DeclarationOption {
+	decls := []filtering.DeclarationOption{
+		filtering.DeclareFunction(functionNow, filtering.NewFunctionOverload(
+			functionNow+"_timestamp", filtering.TypeTimestamp,
+		)),
+	}
+	for _, fn := range []string{functionAdd, functionSub} {
+		decls = append(decls, filtering.DeclareFunction(fn, filtering.NewFunctionOverload(
+			fmt.Sprintf("%s_timestamp_duration", fn), filtering.TypeTimestamp, filtering.TypeTimestamp, filtering.TypeDuration,
+		)))
+	}
+	return decls
+}
+
+// Returns a copy of the filter with each relative time replaced by the
+// timestamp it is at the provided time, such as `timestamp("...")` in place
+// of `sub(now(), duration("24h"))`, and each arithmetic of constants by the
+// constant it evaluates to, or the filter itself if it has neither.
+// Relative times are resolved per request, so that the filter may be cached.
+func resolveRelativeTimes(filter *expr.CheckedExpr, now time.Time) (*expr.CheckedExpr, error) {
+	if !hasRelativeTime(filter.GetExpr()) {
+		return filter, nil
+	}
+	filter = proto.Clone(filter).(*expr.CheckedExpr)
+	if err := replaceRelativeTimes(filter.GetExpr(), now); err != nil {
+		return nil, invalidField("filter", err)
+	}
+	return filter, nil
+}
+
+// Reports whether the expression calls now(), or an arithmetic or concat()
+// function.
+func hasRelativeTime(e *expr.Expr) bool {
+	call := e.GetCallExpr()
+	switch call.GetFunction() {
+	case functionNow, functionAdd, functionSub, functionMul, functionDiv, functionConcat:
+		return true
+	}
+	for _, arg := range call.GetArgs() {
+		if hasRelativeTime(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces each relative time within the expression with a call of
+// timestamp(), and each arithmetic of constants with a constant.
+func replaceRelativeTimes(e *expr.Expr, now time.Time) error {
+	c, ok, err := foldConstant(e)
+	if err != nil {
+		return err
+	}
+	if ok {
+		e.ExprKind = &expr.Expr_ConstExpr{ConstExpr: c}
+		return nil
+	}
+	call := e.GetCallExpr()
+	switch call.GetFunction() {
+	case functionNow, functionAdd, functionSub:
+		t, err := relativeTime(e, now)
+		if err != nil {
+			return err
+		}
+		e.ExprKind = &expr.Expr_CallExpr{CallExpr: &expr.Expr_Call{
+			Function: filtering.FunctionTimestamp,
+			Args:     []*expr.Expr{filtering.String(t.UTC().Format(time.RFC3339Nano))},
+		}}
+		return nil
+	}
+	for _, arg := range call.GetArgs() {
+		if err := replaceRelativeTimes(arg, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the time of a timestamp constant, which may be relative to the
+// provided time. Fields cannot be used, as the time must be known before the
+// documents are retrieved.
+func relativeTime(e *expr.Expr, now time.Time) (time.Time, error) {
+	call := e.GetCallExpr()
+	switch call.GetFunction() {
+	case functionNow:
+		return now, nil
+	case functionAdd, functionSub:
+		if len(call.GetArgs()) != 2 || !timeCall(call.Args[1]) || call.Args[1].GetCallExpr().GetFunction() != filtering.FunctionDuration {
+			break
+		}
+		t, err := relativeTime(call.Args[0], now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		d, err := time.ParseDuration(call.Args[1].GetCallExpr().Args[0].GetConstExpr().GetStringValue())
+		if err != nil {
+			return time.Time{}, status.Errorf(codes.InvalidArgument, "%s is not a duration", canonical(call.Args[1], false))
+		}
+		if call.GetFunction() == functionSub {
+			d = -d
+		}
+		return t.Add(d), nil
+	case filtering.FunctionTimestamp:
+		if timeCall(e) {
+			return parseTimestamp(call.Args[0].GetConstExpr().GetStringValue())
+		}
+	}
+	return time.Time{}, status.Errorf(codes.InvalidArgument, "%s is not a constant time", canonical(e, false))
+}
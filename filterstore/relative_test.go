@@ -0,0 +1,74 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	fspb "google.golang.org/genproto/googleapis/firestore/v1"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestResolveRelativeTimes(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		filter string
+		want   time.Time
+	}{
+		{`test_filtering.create_time < now()`, now},
+		{`test_filtering.create_time > sub(now(), duration("24h"))`, now.Add(-24 * time.Hour)},
+		{`test_filtering.create_time < add(sub(now(), duration("1h")), duration("90s"))`, now.Add(-time.Hour + 90*time.Second)},
+		{`test_filtering.create_time > add(timestamp("2024-01-01T00:00:00Z"), duration("1h"))`, time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)},
+	} {
+		filter := parse(t, tc.filter)
+		original := canonical(filter.GetExpr(), false)
+		resolved, err := resolveRelativeTimes(filter, now)
+		if err != nil {
+			t.Fatalf("resolveRelativeTimes(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if got := canonical(filter.GetExpr(), false); got != original {
+			t.Errorf("resolveRelativeTimes(%q) modified the filter to %s", tc.filter, got)
+		}
+		q, err := newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{}, resolved, capabilities{})
+		if err != nil {
+			t.Fatalf("newQuery(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		qs, err := q.build()
+		if err != nil {
+			t.Fatalf("build(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		got := wheres(serialize(t, qs))
+		if len(got) != 1 {
+			t.Fatalf("transpile(%q) = %d filters, want 1", tc.filter, len(got))
+		}
+		want := &fspb.Value{ValueType: &fspb.Value_TimestampValue{TimestampValue: tspb.New(tc.want)}}
+		if diff := cmp.Diff(want, got[0].GetFieldFilter().GetValue(), protocmp.Transform()); diff != "" {
+			t.Errorf("transpile(%q) value diff (-want +got):\n%s", tc.filter, diff)
+		}
+	}
+}
+
+func TestResolveRelativeTimesOfFields(t *testing.T) {
+	filter := `test_filtering.create_time > add(test_filtering.create_time, duration("1h"))`
+	if _, err := resolveRelativeTimes(parse(t, filter), time.Now()); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("resolveRelativeTimes(%q) err = %v, want code %v", filter, err, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iancoleman/strcase"
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// fieldRestrictions limit which of the filterable fields of the message the
+// filters of requests may use.
+type fieldRestrictions struct {
+	msg protoreflect.MessageDescriptor
+	// The ident of the message in filters.
+	root string
+	// If not empty, only these fields, and the fields they contain, may be
+	// filtered.
+	allowed []string
+	// These fields, and the fields they contain, may not be filtered.
+	denied []string
+	// Fields with any of these google.api.field_behavior annotations, and the
+	// fields they contain, may not be filtered.
+	behaviors []annotations.FieldBehavior
+}
+
+// Resolves the restrictions of the message, checking that each named field
+// exists.
+func newFieldRestrictions(msg protoreflect.MessageDescriptor, allowed, denied []string, behaviors []annotations.FieldBehavior) (fieldRestrictions, error) {
+	for _, names := range [][]string{allowed, denied} {
+		for _, name := range names {
+			if _, _, err := resolvePath(msg, fieldNames{}, "filter", name); err != nil {
+				return fieldRestrictions{}, fmt.Errorf("invalid restricted field %q: %w", name, err)
+			}
+		}
+	}
+	return fieldRestrictions{
+		msg:       msg,
+		root:      strcase.ToSnake(string(msg.Name())),
+		allowed:   allowed,
+		denied:    denied,
+		behaviors: behaviors,
+	}, nil
+}
+
+// Checks that every field in the filter may be filtered.
+func (r fieldRestrictions) check(e *expr.Expr) error {
+	if len(r.allowed) == 0 && len(r.denied) == 0 && len(r.behaviors) == 0 {
+		return nil
+	}
+	var err error
+	filtering.Walk(func(e, parent *expr.Expr) bool {
+		if err != nil || e.GetSelectExpr() == nil {
+			return err == nil
+		}
+		if fd, name, ok := resolveField(r.msg, e); ok {
+			if behavior, ok := r.behavior(fd); ok {
+				err = status.Errorf(codes.InvalidArgument, "%s cannot be filtered, as it is %s", name, behavior)
+				return false
+			}
+		}
+		// Only the outermost select of a path names the whole field.
+		if parent.GetSelectExpr() != nil {
+			return true
+		}
+		name, ok := selectName(e)
+		if !ok {
+			return true
+		}
+		field := strings.TrimPrefix(name, r.root+".")
+		if len(r.allowed) > 0 && !containsField(r.allowed, field) || containsField(r.denied, field) {
+			err = status.Errorf(codes.InvalidArgument, "%s cannot be filtered", name)
+			return false
+		}
+		return true
+	}, e)
+	return err
+}
+
+// Returns the restricted google.api.field_behavior of the field, if any.
+func (r fieldRestrictions) behavior(fd protoreflect.FieldDescriptor) (annotations.FieldBehavior, bool) {
+	if len(r.behaviors) == 0 {
+		return 0, false
+	}
+	for _, b := range proto.GetExtension(fd.Options(), annotations.E_FieldBehavior).([]annotations.FieldBehavior) {
+		for _, restricted := range r.behaviors {
+			if b == restricted {
+				return b, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Reports whether the field is one of the provided fields, or is contained by
+// one of them.
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if field == f || strings.HasPrefix(field, f+".") {
+			return true
+		}
+	}
+	return false
+}
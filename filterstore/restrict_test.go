@@ -0,0 +1,103 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestFieldRestrictions(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t),
+		WithFilterableFields("filterable_primitive", "filterable_submessage", "default_float"),
+		WithDeniedFields("default_float"),
+		WithDefaultFilter("test_filtering.default_float > 1.5"),
+	)
+	for _, filter := range []string{
+		`test_filtering.filterable_primitive = "a"`,
+		`test_filtering.filterable_submessage.filterable_primitive = 1`,
+		// The default filter is not restricted.
+		"",
+	} {
+		if _, err := transpiler.parse(filterRequest(filter)); err != nil {
+			t.Errorf("parse(%q) err = %v, want <nil>", filter, err)
+		}
+	}
+	for _, tc := range []struct {
+		filter, field string
+	}{
+		{`test_filtering.default_float > 1.5`, "test_filtering.default_float"},
+		{`test_filtering.filterable_primitive = "a" OR test_filtering.default_submessage.filterable_primitive = 1`, "test_filtering.default_submessage.filterable_primitive"},
+	} {
+		_, err := transpiler.parse(filterRequest(tc.filter))
+		if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), tc.field) {
+			t.Errorf("parse(%q) err = %v, want code %v naming %s", tc.filter, err, codes.InvalidArgument, tc.field)
+		}
+	}
+	if _, err := New(testClient(t), listTestMethod, &test.TestFiltering{}, WithDeniedFields("missing")); err == nil {
+		t.Errorf("New(WithDeniedFields(%q)) err = <nil>, want error", "missing")
+	}
+}
+
+func TestUnfilterableBehaviors(t *testing.T) {
+	opts := &descriptorpb.FieldOptions{}
+	proto.SetExtension(opts, annotations.E_FieldBehavior, []annotations.FieldBehavior{annotations.FieldBehavior_INPUT_ONLY})
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("behavior.proto"),
+		Package: proto.String("behavior"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Behavior"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("secret"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Options: opts},
+				{Name: proto.String("name"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+			},
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("protodesc.NewFile() err = %v, want <nil>", err)
+	}
+	r, err := newFieldRestrictions(fd.Messages().ByName("Behavior"), nil, nil, []annotations.FieldBehavior{annotations.FieldBehavior_INPUT_ONLY})
+	if err != nil {
+		t.Fatalf("newFieldRestrictions() err = %v, want <nil>", err)
+	}
+	equals := func(field string) *expr.Expr {
+		return &expr.Expr{ExprKind: &expr.Expr_CallExpr{CallExpr: &expr.Expr_Call{Function: "=", Args: []*expr.Expr{
+			{ExprKind: &expr.Expr_SelectExpr{SelectExpr: &expr.Expr_Select{
+				Operand: &expr.Expr{ExprKind: &expr.Expr_IdentExpr{IdentExpr: &expr.Expr_Ident{Name: "behavior"}}},
+				Field:   field,
+			}}},
+			{ExprKind: &expr.Expr_ConstExpr{ConstExpr: &expr.Constant{ConstantKind: &expr.Constant_StringValue{StringValue: "a"}}}},
+		}}}}
+	}
+	if err := r.check(equals("name")); err != nil {
+		t.Errorf("check(behavior.name) err = %v, want <nil>", err)
+	}
+	err = r.check(equals("secret"))
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "behavior.secret") || !strings.Contains(err.Error(), "INPUT_ONLY") {
+		t.Errorf("check(behavior.secret) err = %v, want code %v naming behavior.secret and INPUT_ONLY", err, codes.InvalidArgument)
+	}
+}
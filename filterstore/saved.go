@@ -0,0 +1,190 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"go.einride.tech/aip/ordering"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SavedFilter is a named filter of a collection, such as a saved search,
+// validated when it was saved.
+type SavedFilter struct {
+	// The name of the filter, unique within the parent of the collection.
+	Name string `firestore:"-"`
+	// The canonical form of the filter.
+	Filter string `firestore:"filter"`
+	// A hash of the queries the filter was planned as when it was saved, which
+	// changes if the filter would now be run differently.
+	PlanHash string `firestore:"plan_hash"`
+	// The owner of the filter, such as the user who saved it.
+	Owner string `firestore:"owner"`
+	// The time the filter was saved, set by Firestore.
+	CreateTime time.Time `firestore:"create_time,serverTimestamp"`
+}
+
+// Returns the path of the collection the saved filters of the parent are
+// stored in.
+func (t *Transpiler[T]) savedFilters(parent string) string {
+	return fmt.Sprintf("%s/%s_filters", parent, t.collection)
+}
+
+// Returns a hash of the queries of the plan.
+func planHash(p *Plan) string {
+	h := crc32.NewIEEE()
+	for _, qs := range [][]PlanQuery{p.Lookups, p.Queries} {
+		for _, q := range qs {
+			fmt.Fprintf(h, "%s\n%s\n\n", q.Collection, strings.Join(q.Clauses, "\n"))
+		}
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// SaveFilter validates the filter of the request and saves its canonical form
+// with the provided name and owner, replacing any filter saved with the same
+// name in the parent of the request.
+func (t *Transpiler[T]) SaveFilter(ctx context.Context, req protoexpr.ListRequest, name, owner string) (*SavedFilter, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid saved filter name %q", name)
+	}
+	plan, err := t.Plan(planRequest{ListRequest: req, filter: req.GetFilter()})
+	if err != nil {
+		return nil, err
+	}
+	f := &SavedFilter{Name: name, Filter: plan.Filter, PlanHash: planHash(plan), Owner: owner}
+	res, err := t.client.Collection(t.savedFilters(req.GetParent())).Doc(name).Set(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	f.CreateTime = res.UpdateTime
+	return f, nil
+}
+
+// SavedFilter returns the filter saved with the provided name in the parent.
+func (t *Transpiler[T]) SavedFilter(ctx context.Context, parent, name string) (*SavedFilter, error) {
+	if err := t.lifecycle.check(); err != nil {
+		return nil, err
+	}
+	doc, err := t.client.Collection(t.savedFilters(parent)).Doc(name).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, status.Errorf(codes.NotFound, "saved filter %q does not exist", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := &SavedFilter{Name: doc.Ref.ID}
+	if err := doc.DataTo(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SavedFilters returns the filters saved in the parent, ordered by name.
+// If owner is not empty, only the filters of the owner are returned.
+func (t *Transpiler[T]) SavedFilters(ctx context.Context, parent, owner string) ([]*SavedFilter, error) {
+	if err := t.lifecycle.check(); err != nil {
+		return nil, err
+	}
+	q := t.client.Collection(t.savedFilters(parent)).OrderBy(firestore.DocumentID, firestore.Asc)
+	if owner != "" {
+		q = q.Where("owner", "==", owner)
+	}
+	it := q.Documents(ctx)
+	defer it.Stop()
+	var filters []*SavedFilter
+	for {
+		doc, err := it.Next()
+		if err == iterator.Done {
+			return filters, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		f := &SavedFilter{Name: doc.Ref.ID}
+		if err := doc.DataTo(f); err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+}
+
+// ListSaved lists the documents matching the filter saved with the provided
+// name in the parent of the request, combined with the filter of the request.
+// If the saved filter would now be run differently than when it was saved,
+// such as after the message or options changed, a WarningPlanChanged warning
+// is added to the page.
+func (t *Transpiler[T]) ListSaved(ctx context.Context, req protoexpr.ListRequest, name string, opts ...CallOption) (*ListPage[T], error) {
+	f, err := t.SavedFilter(ctx, req.GetParent(), name)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := t.Plan(planRequest{ListRequest: req, filter: f.Filter})
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "saved filter %q is no longer valid: %v", name, err)
+	}
+	saved := savedRequest{ListRequest: req, filter: f.Filter}
+	if req.GetFilter() != "" {
+		saved.filter = fmt.Sprintf("(%s) AND (%s)", f.Filter, req.GetFilter())
+	}
+	page, err := t.List(ctx, saved, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if planHash(plan) != f.PlanHash {
+		page.Warnings = append(page.Warnings, Warning{
+			Kind:    WarningPlanChanged,
+			Message: fmt.Sprintf("saved filter %q is planned differently than when it was saved", name),
+		})
+	}
+	return page, nil
+}
+
+// savedRequest replaces the filter of a request.
+type savedRequest struct {
+	protoexpr.ListRequest
+	filter string
+}
+
+func (r savedRequest) GetFilter() string { return r.filter }
+
+func (r savedRequest) GetOrderBy() string {
+	if o, ok := r.ListRequest.(ordering.Request); ok {
+		return o.GetOrderBy()
+	}
+	return ""
+}
+
+// planRequest is a request for the first page of documents matching the
+// filter, in the default order, so that the plan of a saved filter does not
+// depend on the request it was saved from.
+type planRequest struct {
+	protoexpr.ListRequest
+	filter string
+}
+
+func (r planRequest) GetFilter() string  { return r.filter }
+func (planRequest) GetPageSize() int32   { return 0 }
+func (planRequest) GetPageToken() string { return "" }
+func (planRequest) GetOrderBy() string   { return "" }
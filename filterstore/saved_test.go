@@ -0,0 +1,111 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestPlanHash(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	hash := func(req *test.ListTestRequest) string {
+		t.Helper()
+		plan, err := transpiler.Plan(planRequest{ListRequest: req, filter: req.GetFilter()})
+		if err != nil {
+			t.Fatalf("Plan(%q) err = %v, want <nil>", req.GetFilter(), err)
+		}
+		return planHash(plan)
+	}
+	a := hash(&test.ListTestRequest{Parent: "parents/p", Filter: `test_filtering.filterable_primitive = "a"`})
+	if b := hash(&test.ListTestRequest{Parent: "parents/q", PageSize: 3, Filter: `test_filtering.filterable_primitive="a"`}); a != b {
+		t.Errorf("planHash() of equivalent requests = %s, %s, want equal", a, b)
+	}
+	if b := hash(&test.ListTestRequest{Parent: "parents/p", Filter: `test_filtering.filterable_primitive = "b"`}); a == b {
+		t.Errorf("planHash() of different filters = %s, %s, want different", a, b)
+	}
+}
+
+func TestSaveFilterInvalid(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	for _, tc := range []struct {
+		name   string
+		filter string
+	}{
+		{name: "", filter: `test_filtering.filterable_primitive = "a"`},
+		{name: "a/b", filter: `test_filtering.filterable_primitive = "a"`},
+		{name: "a", filter: `test_filtering.missing = "a"`},
+	} {
+		if _, err := transpiler.SaveFilter(context.Background(), &test.ListTestRequest{Parent: "parents/p", Filter: tc.filter}, tc.name, "owner"); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("SaveFilter(%q, %q) err = %v, want code %v", tc.name, tc.filter, err, codes.InvalidArgument)
+		}
+	}
+}
+
+// Saves and applies a filter against the Firestore emulator, if one is
+// configured.
+func TestSavedFilters(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	ctx := context.Background()
+	c, err := firestore.NewClient(ctx, "test")
+	if err != nil {
+		t.Fatalf("firestore.NewClient() err = %v, want <nil>", err)
+	}
+	defer c.Close()
+	transpiler := newTestTranspiler(t, c)
+	parent := fmt.Sprintf("runs/%d", time.Now().UnixNano())
+	for id, doc := range map[string]*test.TestFiltering{
+		"a": {FilterablePrimitive: "a", DefaultFloat: 1},
+		"b": {FilterablePrimitive: "a", DefaultFloat: 2},
+		"c": {FilterablePrimitive: "c", DefaultFloat: 2},
+	} {
+		if err := transpiler.Set(ctx, parent, id, doc); err != nil {
+			t.Fatalf("Set(%q) err = %v, want <nil>", id, err)
+		}
+	}
+	saved, err := transpiler.SaveFilter(ctx, &test.ListTestRequest{Parent: parent, Filter: `test_filtering.filterable_primitive = "a"`}, "mine", "owner")
+	if err != nil {
+		t.Fatalf("SaveFilter() err = %v, want <nil>", err)
+	}
+	filters, err := transpiler.SavedFilters(ctx, parent, "owner")
+	if err != nil {
+		t.Fatalf("SavedFilters() err = %v, want <nil>", err)
+	}
+	if diff := cmp.Diff([]*SavedFilter{saved}, filters); diff != "" {
+		t.Errorf("SavedFilters() diff (-want +got):\n%s", diff)
+	}
+	page, err := transpiler.ListSaved(ctx, &test.ListTestRequest{Parent: parent, Filter: "test_filtering.default_float = 2.0"}, "mine")
+	if err != nil {
+		t.Fatalf("ListSaved() err = %v, want <nil>", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].GetFilterablePrimitive() != "a" || page.Items[0].GetDefaultFloat() != 2 {
+		t.Errorf("ListSaved() = %v, want document b", page.Items)
+	}
+	if _, err := transpiler.ListSaved(ctx, &test.ListTestRequest{Parent: parent}, "missing"); status.Code(err) != codes.NotFound {
+		t.Errorf("ListSaved(missing) err = %v, want code %v", err, codes.NotFound)
+	}
+}
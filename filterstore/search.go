@@ -0,0 +1,246 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iancoleman/strcase"
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// A field which bare terms of a filter, such as `"foo"`, are searched for.
+type searchField struct {
+	// The dot separated path of the field, relative to the message.
+	name string
+	// Whether the field is repeated, so is searched with `:` rather than `=`.
+	list bool
+}
+
+// Resolves the searchable fields of the message. If no fields are named, the
+// fields annotated as searchable are searched.
+func searchFields(msg protoreflect.MessageDescriptor, names []string) ([]searchField, error) {
+	if len(names) == 0 {
+		return annotatedSearchFields(msg, "", map[protoreflect.FullName]bool{})
+	}
+	var fields []searchField
+	for _, name := range names {
+		_, fd, err := resolvePath(msg, fieldNames{}, "searchable", name)
+		if err != nil || !searchableType(fd) {
+			return nil, fmt.Errorf("%s is not a filterable string field of %s", name, msg.FullName())
+		}
+		fields = append(fields, searchField{name: name, list: fd.IsList()})
+	}
+	return fields, nil
+}
+
+// Returns the fields of the message, and of the messages it contains, which
+// are annotated as searchable.
+func annotatedSearchFields(msg protoreflect.MessageDescriptor, prefix string, seen map[protoreflect.FullName]bool) ([]searchField, error) {
+	if seen[msg.FullName()] {
+		return nil, nil
+	}
+	seen[msg.FullName()] = true
+	defer delete(seen, msg.FullName())
+	var fields []searchField
+	for i := 0; i < msg.Fields().Len(); i++ {
+		fd := msg.Fields().Get(i)
+		name := prefix + string(fd.Name())
+		if searchable(fd) {
+			if !searchableType(fd) {
+				return nil, fmt.Errorf("%s is searchable, but is not a filterable string field of %s", name, msg.FullName())
+			}
+			fields = append(fields, searchField{name: name, list: fd.IsList()})
+		}
+		if fd.Message() != nil && !fd.IsList() && !fd.IsMap() && filterable(fd) {
+			nested, err := annotatedSearchFields(fd.Message(), name+".", seen)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, nested...)
+		}
+	}
+	return fields, nil
+}
+
+// Reports whether the field can be searched, which requires a filterable
+// singular or repeated string field.
+func searchableType(fd protoreflect.FieldDescriptor) bool {
+	return fd.Kind() == protoreflect.StringKind && !fd.IsMap() && filterable(fd)
+}
+
+// Parses and checks the filter, after replacing each bare string term, such as
+// `"foo"`, with an OR of its equality to every searchable field, and each
+// sequence of terms, such as `"foo" "bar"`, with an AND of the terms.
+// If text is set, bare terms are instead replaced with a text search of the
+// term, to be resolved by a TextSearcher.
+// Comparisons of the checked filter are oriented with the field on the left.
+func parseFilter(filter string, decls *filtering.Declarations, msg protoreflect.MessageDescriptor, fields []searchField, text bool) (*expr.CheckedExpr, error) {
+	var parser filtering.Parser
+	parser.Init(unbracketValueLists(filter))
+	parsed, err := parser.Parse()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	s := &searcher{root: strcase.ToSnake(string(msg.Name())), fields: fields, text: text}
+	filtering.Walk(func(e, _ *expr.Expr) bool {
+		if e.Id >= s.nextID {
+			s.nextID = e.Id + 1
+		}
+		return true
+	}, parsed.Expr)
+	e, err := s.rewrite(parsed.Expr)
+	if err != nil {
+		return nil, err
+	}
+	if err := expandMethods(e, s.expr); err != nil {
+		return nil, err
+	}
+	if err := expandValueLists(e, s.expr); err != nil {
+		return nil, err
+	}
+	restore := collapseStructKeys(e, msg)
+	var checker filtering.Checker
+	checker.Init(e, parsed.SourceInfo, decls)
+	checked, err := checker.Check()
+	restore()
+	if err != nil {
+		return nil, checkError(err, e, decls)
+	}
+	orientComparisons(checked.GetExpr())
+	return checked, nil
+}
+
+// Returns an INVALID_ARGUMENT error for the error of the checker, which wraps
+// ErrUnknownField or ErrUnsupportedFunction if the filter refers to an
+// undeclared field or function.
+func checkError(err error, e *expr.Expr, decls *filtering.Declarations) error {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "undeclared identifier"):
+		return invalidArgument(ErrUnknownField, "%s", msg)
+	case strings.Contains(msg, "undeclared function"):
+		return invalidArgument(ErrUnsupportedFunction, "%s", msg)
+	}
+	if name, ok := undeclaredField(e, decls); ok {
+		return invalidArgument(ErrUnknownField, "%s is not a field: %s", name, msg)
+	}
+	return status.Error(codes.InvalidArgument, msg)
+}
+
+// Returns the name of a field selected from a message within the expression,
+// which is not declared.
+func undeclaredField(e *expr.Expr, decls *filtering.Declarations) (string, bool) {
+	var undeclared string
+	filtering.Walk(func(e, _ *expr.Expr) bool {
+		name, ok := selectName(e)
+		if !ok || undeclared != "" {
+			return undeclared == ""
+		}
+		if _, ok := decls.LookupIdent(name); ok {
+			return true
+		}
+		operand, ok := selectName(e.GetSelectExpr().GetOperand())
+		if !ok {
+			operand = e.GetSelectExpr().GetOperand().GetIdentExpr().GetName()
+		}
+		if decl, ok := decls.LookupIdent(operand); ok && decl.GetIdent().GetType().GetMessageType() != "" {
+			undeclared = name
+		}
+		return undeclared == ""
+	}, e)
+	return undeclared, undeclared != ""
+}
+
+// Rewrites the bare terms of a filter.
+type searcher struct {
+	root   string
+	fields []searchField
+	// Whether bare terms are searched by a TextSearcher, rather than compared
+	// to the fields.
+	text   bool
+	nextID int64
+}
+
+func (s *searcher) rewrite(e *expr.Expr) (*expr.Expr, error) {
+	if c := e.GetConstExpr(); c != nil {
+		if _, ok := c.ConstantKind.(*expr.Constant_StringValue); !ok {
+			return e, nil
+		}
+		return s.search(c)
+	}
+	call := e.GetCallExpr()
+	switch call.GetFunction() {
+	case filtering.FunctionFuzzyAnd:
+		call.Function = filtering.FunctionAnd
+	case filtering.FunctionAnd, filtering.FunctionOr, filtering.FunctionNot:
+	default:
+		return e, nil
+	}
+	for i, arg := range call.Args {
+		var err error
+		if call.Args[i], err = s.rewrite(arg); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Returns an OR of the term's equality to every searchable field, or a text
+// search of the term.
+func (s *searcher) search(term *expr.Constant) (*expr.Expr, error) {
+	if s.text {
+		return s.call(functionTextSearch, s.expr(&expr.Expr{ExprKind: &expr.Expr_ConstExpr{ConstExpr: term}})), nil
+	}
+	if len(s.fields) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot search for %s, as no fields are searchable", formatConst(term))
+	}
+	var result *expr.Expr
+	for _, f := range s.fields {
+		field := s.expr(&expr.Expr{ExprKind: &expr.Expr_IdentExpr{IdentExpr: &expr.Expr_Ident{Name: s.root}}})
+		for _, segment := range strings.Split(f.name, ".") {
+			field = s.expr(&expr.Expr{ExprKind: &expr.Expr_SelectExpr{SelectExpr: &expr.Expr_Select{Operand: field, Field: segment}}})
+		}
+		function := filtering.FunctionEquals
+		if f.list {
+			function = filtering.FunctionHas
+		}
+		value := s.expr(&expr.Expr{ExprKind: &expr.Expr_ConstExpr{ConstExpr: term}})
+		equals := s.call(function, field, value)
+		if result == nil {
+			result = equals
+		} else {
+			result = s.call(filtering.FunctionOr, result, equals)
+		}
+	}
+	return result, nil
+}
+
+func (s *searcher) call(function string, args ...*expr.Expr) *expr.Expr {
+	return s.expr(&expr.Expr{ExprKind: &expr.Expr_CallExpr{CallExpr: &expr.Expr_Call{Function: function, Args: args}}})
+}
+
+// Assigns the next unused ID to the expression.
+func (s *searcher) expr(e *expr.Expr) *expr.Expr {
+	e.Id = s.nextID
+	s.nextID++
+	return e
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	opb "github.com/kagadar/go_proto_expression/genproto/options"
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestSearch(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   []Option
+		filter string
+		want   []PlanQuery
+	}{
+		{
+			name:   "searchable fields",
+			opts:   []Option{WithSearchableFields("filterable_primitive")},
+			filter: `"foo" AND test_filtering.default_float = 1.5`,
+			want: []PlanQuery{
+				{Collection: "tests", Clauses: []string{`FilterablePrimitive == "foo"`, "DefaultFloat == 1.5", "limit 11"}},
+			},
+		},
+	} {
+		transpiler := newTestTranspiler(t, testClient(t), tc.opts...)
+		plan, err := transpiler.Plan(&test.ListTestRequest{Parent: "parents/p", PageSize: 10, Filter: tc.filter})
+		if err != nil {
+			t.Fatalf("%s: Plan(%q) err = %v, want <nil>", tc.name, tc.filter, err)
+		}
+		if diff := cmp.Diff(tc.want, plan.Queries); diff != "" {
+			t.Errorf("%s: Plan(%q) queries diff (-want +got):\n%s", tc.name, tc.filter, diff)
+		}
+	}
+}
+
+func TestParseFilterSearch(t *testing.T) {
+	fields := []searchField{{name: "filterable_primitive"}, {name: "tags", list: true}}
+	filter := `"foo" "bar"`
+	checked, err := parseFilter(filter, testDeclarations(t), (&test.TestFiltering{}).ProtoReflect().Descriptor(), fields, false)
+	if err != nil {
+		t.Fatalf("parseFilter(%q) err = %v, want <nil>", filter, err)
+	}
+	want := `((test_filtering.filterable_primitive = "bar" OR test_filtering.tags:"bar") AND (test_filtering.filterable_primitive = "foo" OR test_filtering.tags:"foo"))`
+	if got := canonical(checked.GetExpr(), false); got != want {
+		t.Errorf("parseFilter(%q) = %s, want %s", filter, got, want)
+	}
+	q, err := newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{}, checked, capabilities{})
+	if err != nil {
+		t.Fatalf("newQuery() err = %v, want <nil>", err)
+	}
+	qs, err := q.build()
+	if err != nil {
+		t.Fatalf("build() err = %v, want <nil>", err)
+	}
+	if len(qs) != 4 {
+		t.Errorf("build() returned %d queries, want 4", len(qs))
+	}
+	if _, err := parseFilter(filter, testDeclarations(t), (&test.TestFiltering{}).ProtoReflect().Descriptor(), nil, false); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("parseFilter(%q) without searchable fields err = %v, want code %v", filter, err, codes.InvalidArgument)
+	}
+}
+
+func TestAnnotatedSearchFields(t *testing.T) {
+	searchable := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+		f := fieldProto(name, number, typ, typeName, repeated)
+		f.Options = &descriptorpb.FieldOptions{}
+		proto.SetExtension(f.Options, opb.E_Filtering, &opb.FieldFilteringOptions{Searchable: proto.Bool(true)})
+		return f
+	}
+	msg := itemDescriptor(t, []*descriptorpb.FieldDescriptorProto{
+		searchable("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+		fieldProto("description", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+		searchable("tags", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", true),
+		fieldProto("item", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Item", false),
+	}, "UNKNOWN")
+	got, err := searchFields(msg, nil)
+	if err != nil {
+		t.Fatalf("searchFields() err = %v, want <nil>", err)
+	}
+	want := []searchField{{name: "name"}, {name: "tags", list: true}}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(searchField{})); diff != "" {
+		t.Errorf("searchFields() diff (-want +got):\n%s", diff)
+	}
+	if got, err := searchFields(msg, []string{"description"}); err != nil || len(got) != 1 || got[0].name != "description" {
+		t.Errorf("searchFields(description) = %v, %v, want [description], <nil>", got, err)
+	}
+	invalid := itemDescriptor(t, []*descriptorpb.FieldDescriptorProto{
+		searchable("count", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, "", false),
+	}, "UNKNOWN")
+	if _, err := searchFields(invalid, nil); err == nil {
+		t.Errorf("searchFields(searchable int64) err = <nil>, want error")
+	}
+}
+
+func TestSearchInvalid(t *testing.T) {
+	for _, field := range []string{"missing", "default_float", "unfilterable_submessage"} {
+		if _, err := New(testClient(t), listTestMethod, &test.TestFiltering{}, WithSearchableFields(field)); err == nil {
+			t.Errorf("New(WithSearchableFields(%q)) err = <nil>, want error", field)
+		}
+	}
+	transpiler := newTestTranspiler(t, testClient(t))
+	// No fields of the test message are annotated as searchable.
+	for _, filter := range []string{`"foo"`, `1`, `test_filtering.filterable_primitive`} {
+		if _, err := transpiler.Plan(&test.ListTestRequest{Parent: "parents/p", Filter: filter}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Plan(%q) err = %v, want code %v", filter, err, codes.InvalidArgument)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+
+package filterstore
+
+import (
+	"context"
+	"iter"
+
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/proto"
+)
+
+// All returns every document matching the request, as Iterate does, for use
+// with a range statement:
+//
+//	for msg, err := range transpiler.All(ctx, req) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// Documents are retrieved in batches as the loop advances. The sequence ends
+// after yielding an error, and stops the Iterator if the loop exits early.
+// Parse errors are yielded by the first iteration.
+func (t *Transpiler[T]) All(ctx context.Context, req protoexpr.ListRequest, opts ...CallOption) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		seq(t.Iterate(ctx, req, opts...))(yield)
+	}
+}
+
+// Yields every document of the Iterator, stopping it once done.
+func seq[T proto.Message](it *Iterator[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		defer it.Stop()
+		for {
+			msg, err := it.Next()
+			if err == iterator.Done {
+				return
+			}
+			if !yield(msg, err) || err != nil {
+				return
+			}
+		}
+	}
+}
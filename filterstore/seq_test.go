@@ -0,0 +1,54 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+
+package filterstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestSeq(t *testing.T) {
+	want := errors.New("failed")
+	var got []error
+	for _, err := range seq(&Iterator[*test.TestFiltering]{err: want}) {
+		got = append(got, err)
+	}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("seq() = %v, want a single err %v", got, want)
+	}
+	it := &Iterator[*test.TestFiltering]{batchSize: 10, done: true}
+	for range seq(it) {
+		t.Errorf("seq() of an exhausted Iterator yielded, want none")
+	}
+}
+
+func TestAllParseError(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t))
+	n := 0
+	for _, err := range transpiler.All(context.Background(), &test.ListTestRequest{Parent: "parents/p", Filter: "("}) {
+		if err == nil {
+			t.Errorf("All() err = <nil>, want parse error")
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("All() yielded %d times, want 1", n)
+	}
+}
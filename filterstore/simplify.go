@@ -0,0 +1,90 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"go.einride.tech/aip/filtering"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Returns the terms of a conjunction without those implied by the others:
+// repeated terms, such as the second `a = 1` of `a = 1 AND a = 1`, and bounds
+// of a field looser than another on the same side, such as `x > 1` of
+// `x > 1 AND x > 5`.
+// Firestore limits the number of filters in a query, and each filter may
+// require an index.
+func (q *query) simplify(terms []*expr.Expr) []*expr.Expr {
+	seen := map[string]bool{}
+	// The tightest lower and upper bounds of each field, by path.
+	lower, upper := map[string]*expr.Expr{}, map[string]*expr.Expr{}
+	redundant := map[*expr.Expr]bool{}
+	for _, term := range terms {
+		key := canonical(term, false)
+		if seen[key] {
+			redundant[term] = true
+			continue
+		}
+		seen[key] = true
+		call := term.GetCallExpr()
+		path, v, ok := q.comparison(call)
+		if !ok {
+			continue
+		}
+		// The sign of the comparison of a tighter bound to a looser one.
+		var bounds map[string]*expr.Expr
+		var tighter int
+		switch call.Function {
+		case filtering.FunctionGreaterThan, filtering.FunctionGreaterEquals:
+			bounds, tighter = lower, 1
+		case filtering.FunctionLessThan, filtering.FunctionLessEquals:
+			bounds, tighter = upper, -1
+		default:
+			continue
+		}
+		other, ok := bounds[pathString(path)]
+		if !ok {
+			bounds[pathString(path)] = term
+			continue
+		}
+		_, w, _ := q.comparison(other.GetCallExpr())
+		c, ok := compareValues(v, w)
+		if !ok {
+			continue
+		}
+		// Of bounds at the same value, the exclusive bound is tighter.
+		if c == 0 && exclusiveBound(call.Function) && !exclusiveBound(other.GetCallExpr().GetFunction()) {
+			c = tighter
+		}
+		if c == tighter {
+			redundant[other] = true
+			bounds[pathString(path)] = term
+		} else {
+			redundant[term] = true
+		}
+	}
+	kept := make([]*expr.Expr, 0, len(terms))
+	for _, term := range terms {
+		if !redundant[term] {
+			kept = append(kept, term)
+		}
+	}
+	return kept
+}
+
+// Reports whether the comparison excludes the value it compares to.
+func exclusiveBound(function string) bool {
+	return function == filtering.FunctionGreaterThan || function == filtering.FunctionLessThan
+}
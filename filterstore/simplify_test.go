@@ -0,0 +1,47 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import "testing"
+
+func TestSimplify(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		want   string
+	}{
+		{
+			filter: `test_filtering.default_float > 1.0 AND test_filtering.default_float > 5.0`,
+			want:   `test_filtering.default_float > 5`,
+		},
+		{
+			filter: `test_filtering.default_float <= 5.0 AND test_filtering.default_float > 1.0 AND test_filtering.default_float < 5.0 AND test_filtering.default_float >= 1.0`,
+			want:   `(test_filtering.default_float < 5 AND test_filtering.default_float > 1)`,
+		},
+		{
+			filter: `test_filtering.filterable_primitive = "a" AND test_filtering.default_float > 1.0 AND test_filtering.filterable_primitive = "a"`,
+			want:   `(test_filtering.default_float > 1 AND test_filtering.filterable_primitive = "a")`,
+		},
+		{
+			filter: `test_filtering.default_float > 1.0 AND test_filtering.default_float < 5.0`,
+			want:   `(test_filtering.default_float < 5 AND test_filtering.default_float > 1)`,
+		},
+	} {
+		filter := parse(t, tc.filter)
+		q := &query{msg: testDescriptor, types: filter.GetTypeMap()}
+		if got := canonical(conjunction(q.simplify(conjuncts(filter.GetExpr()))), false); got != tc.want {
+			t.Errorf("simplify(%q) = %s, want %s", tc.filter, got, tc.want)
+		}
+	}
+}
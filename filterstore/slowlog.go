@@ -0,0 +1,105 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SlowQuery describes the slowest execution of a filter.
+type SlowQuery struct {
+	// The canonical filter, with constants redacted as `?`.
+	Filter string
+	// The collection that was filtered.
+	Collection string
+	// How many times the filter has been executed.
+	Count int
+	// The latency of the slowest execution.
+	Latency time.Duration
+	// The number of documents read by the slowest execution.
+	Reads int
+	// When the slowest execution occurred.
+	Time time.Time
+}
+
+// SlowQueryLog retains the slowest filters executed by Transpilers.
+// Filters are grouped by their canonical form, so that filters which differ
+// only in their constants share an entry.
+//
+// SlowQueryLog implements expvar.Var, so it can be published with
+// expvar.Publish.
+type SlowQueryLog struct {
+	mu      sync.Mutex
+	size    int
+	queries []*SlowQuery
+}
+
+// NewSlowQueryLog creates a SlowQueryLog which retains the size slowest
+// filters.
+func NewSlowQueryLog(size int) *SlowQueryLog {
+	return &SlowQueryLog{size: size}
+}
+
+// Records an execution of the canonical filter, which started and ended at the
+// provided times.
+func (l *SlowQueryLog) record(collection, filter string, start, end time.Time, reads int) {
+	latency := end.Sub(start)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var q *SlowQuery
+	for _, existing := range l.queries {
+		if existing.Collection == collection && existing.Filter == filter {
+			q = existing
+			break
+		}
+	}
+	if q == nil {
+		q = &SlowQuery{Collection: collection, Filter: filter}
+		l.queries = append(l.queries, q)
+	}
+	q.Count++
+	if latency >= q.Latency {
+		q.Latency = latency
+		q.Reads = reads
+		q.Time = end
+	}
+	sort.SliceStable(l.queries, func(i, j int) bool { return l.queries[i].Latency > l.queries[j].Latency })
+	if len(l.queries) > l.size {
+		l.queries = l.queries[:l.size]
+	}
+}
+
+// Queries returns the slowest filters, ordered by descending latency.
+func (l *SlowQueryLog) Queries() []SlowQuery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	queries := make([]SlowQuery, len(l.queries))
+	for i, q := range l.queries {
+		queries[i] = *q
+	}
+	return queries
+}
+
+// String returns the slowest filters as JSON.
+func (l *SlowQueryLog) String() string {
+	b, err := json.Marshal(l.Queries())
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
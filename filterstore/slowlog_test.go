@@ -0,0 +1,42 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	l := NewSlowQueryLog(2)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.record("tests", "a = ?", start, start.Add(time.Second), 1)
+	l.record("tests", "b = ?", start, start.Add(3*time.Second), 3)
+	l.record("tests", "a = ?", start, start.Add(2*time.Second), 2)
+	l.record("tests", "c = ?", start, start.Add(time.Millisecond), 1)
+	got := l.Queries()
+	if len(got) != 2 {
+		t.Fatalf("len(Queries()) = %d, want 2", len(got))
+	}
+	if got[0].Filter != "b = ?" || got[0].Latency != 3*time.Second {
+		t.Errorf("Queries()[0] = %+v, want b = ? with latency 3s", got[0])
+	}
+	if got[1].Filter != "a = ?" || got[1].Count != 2 || got[1].Latency != 2*time.Second || got[1].Reads != 2 {
+		t.Errorf("Queries()[1] = %+v, want a = ? with count 2, latency 2s and reads 2", got[1])
+	}
+	if want := start.Add(2 * time.Second); !got[1].Time.Equal(want) {
+		t.Errorf("Queries()[1].Time = %v, want %v", got[1].Time, want)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// ListStats describes the reads of a List call, so that the read
+// amplification of complex filters can be understood.
+type ListStats struct {
+	// The number of documents retrieved from Firestore, including documents
+	// retrieved by multiple queries, and those of index lookups and counts.
+	DocumentsFetched int64
+	// The number of documents retrieved which did not match the terms of the
+	// filter evaluated in memory (see WarningClientFilter).
+	DocumentsFilteredOut int64
+	// The number of Firestore queries run.
+	Queries int64
+	// The number of Firestore queries positioned at a cursor, either of the page
+	// token or of the last document of a previous batch.
+	Cursors int64
+}
+
+// Stats reports the ListStats of a List call as the Stats of its page.
+func Stats() CallOption {
+	return func(o *callOptions) {
+		o.stats = true
+	}
+}
+
+// Collects the ListStats of a call, which may run queries concurrently.
+type statsCollector struct {
+	fetched, filteredOut, queries, cursors int64
+}
+
+type statsKey struct{}
+
+// Returns a context whose queries are collected by the returned collector.
+func withStats(ctx context.Context) (context.Context, *statsCollector) {
+	c := &statsCollector{}
+	return context.WithValue(ctx, statsKey{}, c), c
+}
+
+// Returns the collector of the context, or nil if it has none.
+func collectedStats(ctx context.Context) *statsCollector {
+	c, _ := ctx.Value(statsKey{}).(*statsCollector)
+	return c
+}
+
+// Records queries run by the context, which retrieved the documents.
+func recordFetch(ctx context.Context, queries, documents int) {
+	if c := collectedStats(ctx); c != nil {
+		atomic.AddInt64(&c.queries, int64(queries))
+		atomic.AddInt64(&c.fetched, int64(documents))
+	}
+}
+
+// Records queries of the context positioned at a cursor.
+func recordCursors(ctx context.Context, queries int) {
+	if c := collectedStats(ctx); c != nil {
+		atomic.AddInt64(&c.cursors, int64(queries))
+	}
+}
+
+// Records a document retrieved by the context which was filtered out in
+// memory.
+func recordFilteredOut(ctx context.Context) {
+	if c := collectedStats(ctx); c != nil {
+		atomic.AddInt64(&c.filteredOut, 1)
+	}
+}
+
+// Returns the stats collected so far.
+func (c *statsCollector) stats() *ListStats {
+	return &ListStats{
+		DocumentsFetched:     atomic.LoadInt64(&c.fetched),
+		DocumentsFilteredOut: atomic.LoadInt64(&c.filteredOut),
+		Queries:              atomic.LoadInt64(&c.queries),
+		Cursors:              atomic.LoadInt64(&c.cursors),
+	}
+}
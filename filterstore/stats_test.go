@@ -0,0 +1,42 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestStatsCollector(t *testing.T) {
+	// Queries of a context without a collector are not recorded.
+	recordFetch(context.Background(), 1, 1)
+	ctx, c := withStats(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recordFetch(ctx, 1, 3)
+			recordCursors(ctx, 1)
+			recordFilteredOut(ctx)
+		}()
+	}
+	wg.Wait()
+	want := ListStats{DocumentsFetched: 30, DocumentsFilteredOut: 10, Queries: 10, Cursors: 10}
+	if got := *c.stats(); got != want {
+		t.Errorf("stats() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	spb "google.golang.org/protobuf/types/known/structpb"
+)
+
+// google.protobuf.Struct fields cannot be stored by the Firestore client, so
+// are expected to be stored as nested maps, as returned by Struct.AsMap, such
+// that `metadata.owner = "x"` filters the path Metadata.owner.
+var (
+	structName    = (&spb.Struct{}).ProtoReflect().Descriptor().FullName()
+	listValueName = (&spb.ListValue{}).ProtoReflect().Descriptor().FullName()
+	// The field of the values of a Struct, which are google.protobuf.Value
+	// messages.
+	structValueField = (&spb.Struct{}).ProtoReflect().Descriptor().Fields().ByName("fields").MapValue()
+	// The type of the values of a Struct.
+	structValueType = &expr.Type{TypeKind: &expr.Type_MessageType{MessageType: string(structValueField.Message().FullName())}}
+	// The declared type of Struct fields, which the checker can only select
+	// keys of as a map.
+	structType = filtering.TypeMap(filtering.TypeString, structValueType)
+)
+
+// Reports whether the message is a google.protobuf.Value or ListValue, which
+// hold values of any type.
+func dynamicValue(msg protoreflect.MessageDescriptor) bool {
+	return msg.FullName() == structValueField.Message().FullName() || msg.FullName() == listValueName
+}
+
+// Reports whether the field is a singular google.protobuf.Struct.
+func structField(fd protoreflect.FieldDescriptor) bool {
+	return fd != nil && !fd.IsList() && !fd.IsMap() && fd.Message() != nil && fd.Message().FullName() == structName
+}
+
+// Reports whether the field is a value of a google.protobuf.Struct, which may
+// itself be a Struct.
+func structValue(fd protoreflect.FieldDescriptor) bool {
+	return fd == structValueField
+}
+
+// Returns the declarations of comparisons between the values of Structs and
+// constants, such as `metadata.owner = "x"`, and of whether they have keys,
+// such as `metadata.owner:name`.
+func structComparisons() []filtering.DeclarationOption {
+	var decls []filtering.DeclarationOption
+	for _, t := range []*expr.Type{filtering.TypeString, filtering.TypeInt, filtering.TypeFloat, filtering.TypeBool} {
+		for _, fn := range []string{
+			filtering.FunctionEquals, filtering.FunctionNotEquals,
+			filtering.FunctionLessThan, filtering.FunctionLessEquals,
+			filtering.FunctionGreaterThan, filtering.FunctionGreaterEquals,
+		} {
+			decls = append(decls, filtering.DeclareFunction(fn, filtering.NewFunctionOverload(
+				fmt.Sprintf("%s_%s_%s", fn, structValueField.Message().FullName(), typeName(t)), filtering.TypeBool, structValueType, t,
+			)))
+		}
+	}
+	return append(decls, filtering.DeclareFunction(filtering.FunctionHas,
+		filtering.NewFunctionOverload(
+			fmt.Sprintf("%s_%s_string", filtering.FunctionHas, structName), filtering.TypeBool, structType, filtering.TypeString,
+		),
+		filtering.NewFunctionOverload(
+			fmt.Sprintf("%s_%s_string", filtering.FunctionHas, structValueField.Message().FullName()), filtering.TypeBool, structValueType, filtering.TypeString,
+		),
+	))
+}
+
+// Collapses the selections of nested keys of Struct fields, such as
+// `metadata.a.b`, to their first key, such as `metadata.a`, as the checker can
+// only type the values of maps, returning a function which restores them once
+// the expression is checked.
+func collapseStructKeys(e *expr.Expr, msg protoreflect.MessageDescriptor) func() {
+	var restores []func()
+	filtering.Walk(func(e, _ *expr.Expr) bool {
+		if e.GetSelectExpr() == nil {
+			return true
+		}
+		first := e
+		for operand := e.GetSelectExpr().GetOperand(); operand.GetSelectExpr() != nil; operand = operand.GetSelectExpr().GetOperand() {
+			if fd, _, ok := resolveField(msg, operand); ok && structField(fd) {
+				if first != e {
+					original := e.ExprKind
+					e.ExprKind = &expr.Expr_SelectExpr{SelectExpr: &expr.Expr_Select{Operand: operand, Field: first.GetSelectExpr().GetField()}}
+					restores = append(restores, func() { e.ExprKind = original })
+				}
+				return false
+			}
+			first = operand
+		}
+		return true
+	}, e)
+	return func() {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+}
+
+// Returns the value of the key of a Struct, or of a Value holding a Struct,
+// reporting whether it is set.
+func structKey(m protoreflect.Message, key string) (protoreflect.Value, bool) {
+	if m.Descriptor().FullName() != structName {
+		sv := m.Descriptor().Fields().ByName("struct_value")
+		if !m.Has(sv) {
+			return protoreflect.Value{}, false
+		}
+		m = m.Get(sv).Message()
+	}
+	v := m.Get(m.Descriptor().Fields().ByName("fields")).Map().Get(protoreflect.ValueOfString(key).MapKey())
+	return v, v.IsValid()
+}
+
+// Returns the Go value of a google.protobuf.Value, or nil if it is not a
+// scalar.
+func structScalar(m protoreflect.Message) interface{} {
+	fd := m.WhichOneof(m.Descriptor().Oneofs().ByName("kind"))
+	if fd == nil {
+		return nil
+	}
+	switch fd.Name() {
+	case "string_value":
+		return m.Get(fd).String()
+	case "number_value":
+		return m.Get(fd).Float()
+	case "bool_value":
+		return m.Get(fd).Bool()
+	}
+	return nil
+}
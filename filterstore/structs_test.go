@@ -0,0 +1,110 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	fspb "google.golang.org/genproto/googleapis/firestore/v1"
+	spb "google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestTranspileStructs(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		want   *fspb.StructuredQuery_Filter
+	}{
+		{
+			filter: `test_filtering.metadata.owner = "x"`,
+			want:   fieldFilter("Metadata.owner", fspb.StructuredQuery_FieldFilter_EQUAL, stringValue("x")),
+		},
+		{
+			filter: `test_filtering.metadata.a.b > 1`,
+			want:   fieldFilter("Metadata.a.b", fspb.StructuredQuery_FieldFilter_GREATER_THAN, &fspb.Value{ValueType: &fspb.Value_IntegerValue{IntegerValue: 1}}),
+		},
+		{
+			filter: `NOT test_filtering.metadata:owner`,
+			want:   unaryFilter("Metadata.owner", fspb.StructuredQuery_UnaryFilter_IS_NULL),
+		},
+		{
+			filter: `NOT test_filtering.metadata.a:b`,
+			want:   unaryFilter("Metadata.a.b", fspb.StructuredQuery_UnaryFilter_IS_NULL),
+		},
+	} {
+		filter, err := parseFilter(tc.filter, testDeclarations(t), testDescriptor, nil, false)
+		if err != nil {
+			t.Fatalf("parseFilter(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		q, err := newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{}, filter, capabilities{})
+		if err != nil {
+			t.Fatalf("newQuery(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		qs, err := q.build()
+		if err != nil {
+			t.Fatalf("build(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if diff := cmp.Diff([]*fspb.StructuredQuery_Filter{tc.want}, wheres(serialize(t, qs)), protocmp.Transform()); diff != "" {
+			t.Errorf("transpile(%q) where diff (-want +got):\n%s", tc.filter, diff)
+		}
+	}
+}
+
+func TestEvaluateStructs(t *testing.T) {
+	metadata, err := spb.NewStruct(map[string]interface{}{
+		"owner": "x",
+		"a":     map[string]interface{}{"b": 2},
+	})
+	if err != nil {
+		t.Fatalf("structpb.NewStruct() err = %v, want <nil>", err)
+	}
+	msg := dynamicpb.NewMessage(testDescriptor)
+	msg.Set(testDescriptor.Fields().ByName("metadata"), protoreflect.ValueOfMessage(metadata.ProtoReflect()))
+	for _, tc := range []struct {
+		filter string
+		want   bool
+	}{
+		{`test_filtering.metadata.owner = "x"`, true},
+		{`test_filtering.metadata.owner = "y"`, false},
+		{`test_filtering.metadata.a.b > 1`, true},
+		{`test_filtering.metadata.a.c > 1`, false},
+		{`test_filtering.metadata:owner`, true},
+		{`test_filtering.metadata.a:b`, true},
+		{`test_filtering.metadata.a:c`, false},
+		{`test_filtering.metadata.owner:*`, true},
+	} {
+		filter, err := parseFilter(tc.filter, testDeclarations(t), testDescriptor, nil, false)
+		if err != nil {
+			t.Fatalf("parseFilter(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		ev, err := newEvaluator(filter)
+		if err != nil {
+			t.Fatalf("newEvaluator(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if got := ev.matches(msg); got != tc.want {
+			t.Errorf("matches(%q) = %t, want %t", tc.filter, got, tc.want)
+		}
+	}
+}
+
+func TestNewDeclarationsStruct(t *testing.T) {
+	if _, err := NewDeclarations(testDescriptor); err != nil {
+		t.Errorf("NewDeclarations() err = %v, want <nil>", err)
+	}
+}
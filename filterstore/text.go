@@ -0,0 +1,100 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"go.einride.tech/aip/filtering"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// TextSearcher searches an external full-text index of a collection, such as
+// one maintained in Algolia, Typesense or Elasticsearch, for the terms of a
+// filter which cannot be pushed down to Firestore.
+type TextSearcher interface {
+	// Search returns the IDs of the documents in the collection of the parent
+	// which match the term.
+	Search(ctx context.Context, parent, collection, term string) ([]string, error)
+}
+
+// The function bare terms of a filter are rewritten to when a TextSearcher is
+// configured, such as `search("foo")`.
+const functionTextSearch = "search"
+
+// Declares the function of text search terms.
+func declareTextSearch() filtering.DeclarationOption {
+	return filtering.DeclareFunction(functionTextSearch, filtering.NewFunctionOverload(
+		functionTextSearch+"_string", filtering.TypeBool, filtering.TypeString,
+	))
+}
+
+// Splits the text search terms joined to the rest of the filter by AND from
+// the filter, returning the remaining filter and the terms.
+// Terms nested in other functions remain in the filter, and are rejected when
+// it is transpiled.
+func splitTextSearch(filter *expr.CheckedExpr) (*expr.CheckedExpr, []string) {
+	if filter.GetExpr() == nil {
+		return filter, nil
+	}
+	var terms []string
+	var rest []*expr.Expr
+	for _, term := range conjuncts(filter.GetExpr()) {
+		if call := term.GetCallExpr(); call.GetFunction() == functionTextSearch && len(call.Args) == 1 {
+			terms = append(terms, call.Args[0].GetConstExpr().GetStringValue())
+		} else {
+			rest = append(rest, term)
+		}
+	}
+	if len(terms) == 0 {
+		return filter, nil
+	}
+	return &expr.CheckedExpr{Expr: conjunction(rest), TypeMap: filter.GetTypeMap(), SourceInfo: filter.GetSourceInfo()}, terms
+}
+
+// Resolves the text search terms to the documents in the collection of the
+// parent which match every term, and are among refs if it is not nil.
+func (t *Transpiler[T]) resolveTextSearch(ctx context.Context, c *firestore.Client, parent string, terms []string, refs []interface{}) ([]interface{}, error) {
+	var matches map[string]*firestore.DocumentRef
+	if refs != nil {
+		matches = map[string]*firestore.DocumentRef{}
+		for _, ref := range refs {
+			matches[ref.(*firestore.DocumentRef).Path] = ref.(*firestore.DocumentRef)
+		}
+	}
+	collection := c.Collection(fmt.Sprintf("%s/%s", parent, t.collection))
+	for _, term := range terms {
+		ids, err := t.text.Search(ctx, parent, t.collection, term)
+		if err != nil {
+			return nil, err
+		}
+		found := map[string]*firestore.DocumentRef{}
+		for _, id := range ids {
+			// Documents must match every term.
+			if ref := collection.Doc(id); ref != nil && (matches == nil || matches[ref.Path] != nil) {
+				found[ref.Path] = ref
+			}
+		}
+		matches = found
+	}
+	refs = []interface{}{}
+	for _, ref := range matches {
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+// fakeSearcher returns the IDs of each term, and fails for unknown terms.
+type fakeSearcher map[string][]string
+
+func (s fakeSearcher) Search(_ context.Context, parent, collection, term string) ([]string, error) {
+	ids, ok := s[term]
+	if !ok {
+		return nil, errors.New("unknown term")
+	}
+	return ids, nil
+}
+
+func TestTextSearch(t *testing.T) {
+	searcher := fakeSearcher{"foo": {"a", "b", "c"}, "bar": {"b", "c", "d"}}
+	transpiler := newTestTranspiler(t, testClient(t), WithTextSearcher(searcher))
+	filter := `"foo" "bar" AND test_filtering.filterable_primitive = "a"`
+	r, err := transpiler.listRequest(context.Background(), &test.ListTestRequest{Parent: "parents/p", Filter: filter}, callOptions{})
+	if err != nil {
+		t.Fatalf("listRequest(%q) err = %v, want <nil>", filter, err)
+	}
+	var got []string
+	for _, ref := range r.refs {
+		got = append(got, ref.(*firestore.DocumentRef).ID)
+	}
+	sort.Strings(got)
+	if diff := cmp.Diff([]string{"b", "c"}, got); diff != "" {
+		t.Errorf("listRequest(%q) refs diff (-want +got):\n%s", filter, diff)
+	}
+	if got, want := canonical(r.rest.GetExpr(), false), `test_filtering.filterable_primitive = "a"`; got != want {
+		t.Errorf("listRequest(%q) rest = %s, want %s", filter, got, want)
+	}
+	if len(r.warnings) != 1 || r.warnings[0].Kind != WarningTextSearch {
+		t.Errorf("listRequest(%q) warnings = %v, want a %s warning", filter, r.warnings, WarningTextSearch)
+	}
+	plan, err := transpiler.Plan(&test.ListTestRequest{Parent: "parents/p", PageSize: 10, Filter: filter})
+	if err != nil {
+		t.Fatalf("Plan(%q) err = %v, want <nil>", filter, err)
+	}
+	want := []PlanQuery{{Collection: "tests", Clauses: []string{`FilterablePrimitive == "a"`, "limit 11"}}}
+	if diff := cmp.Diff(want, plan.Queries); diff != "" {
+		t.Errorf("Plan(%q) queries diff (-want +got):\n%s", filter, diff)
+	}
+	for _, filter := range []string{`"foo" OR test_filtering.filterable_primitive = "a"`, `NOT "foo"`} {
+		if _, err := transpiler.Plan(&test.ListTestRequest{Parent: "parents/p", Filter: filter}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Plan(%q) err = %v, want code %v", filter, err, codes.InvalidArgument)
+		}
+	}
+	if _, err := transpiler.listRequest(context.Background(), &test.ListTestRequest{Parent: "parents/p", Filter: `"baz"`}, callOptions{}); err == nil {
+		t.Errorf("listRequest(unknown term) err = <nil>, want error")
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"time"
+
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	timestampName = (&tspb.Timestamp{}).ProtoReflect().Descriptor().FullName()
+	durationName  = (&dpb.Duration{}).ProtoReflect().Descriptor().FullName()
+)
+
+// Returns the declarations of comparisons between timestamp fields and
+// RFC 3339 strings, such as `create_time > "2024-01-01T00:00:00Z"`.
+func declareTimestampStrings() []filtering.DeclarationOption {
+	var decls []filtering.DeclarationOption
+	for _, fn := range []string{
+		filtering.FunctionEquals, filtering.FunctionNotEquals,
+		filtering.FunctionLessThan, filtering.FunctionLessEquals,
+		filtering.FunctionGreaterThan, filtering.FunctionGreaterEquals,
+	} {
+		decls = append(decls, filtering.DeclareFunction(fn, filtering.NewFunctionOverload(
+			fmt.Sprintf("%s_timestamp_string", fn), filtering.TypeBool, filtering.TypeTimestamp, filtering.TypeString,
+		)))
+	}
+	return decls
+}
+
+// Reports whether the field is a google.protobuf.Timestamp, which Firestore
+// stores as a timestamp.
+func timestampField(fd protoreflect.FieldDescriptor) bool {
+	return fd != nil && fd.Message() != nil && fd.Message().FullName() == timestampName
+}
+
+// Reports whether the field is a google.protobuf.Duration.
+// Firestore has no duration type, so durations are compared as a number of
+// seconds, such as 1.5 for `duration("1.5s")`.
+func durationField(fd protoreflect.FieldDescriptor) bool {
+	return fd != nil && fd.Message() != nil && fd.Message().FullName() == durationName
+}
+
+// Reports whether the expression is a call of timestamp() or duration() on a
+// string, such as `timestamp("2024-01-01T00:00:00Z")` or `duration("24h")`.
+func timeCall(e *expr.Expr) bool {
+	call := e.GetCallExpr()
+	switch call.GetFunction() {
+	case filtering.FunctionTimestamp, filtering.FunctionDuration:
+		if len(call.GetArgs()) == 1 {
+			_, ok := call.Args[0].GetConstExpr().GetConstantKind().(*expr.Constant_StringValue)
+			return ok
+		}
+	}
+	return false
+}
+
+// Returns the value of a call of timestamp() or duration(), as it is compared
+// to a field: a time, or a number of seconds.
+func timeConstant(e *expr.Expr) (interface{}, error) {
+	call := e.GetCallExpr()
+	s := call.Args[0].GetConstExpr().GetStringValue()
+	if call.GetFunction() == filtering.FunctionTimestamp {
+		return parseTimestamp(s)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%q is not a duration", s)
+	}
+	return d.Seconds(), nil
+}
+
+// Returns the number of seconds of a google.protobuf.Duration message, which
+// may be dynamic.
+func durationValue(m protoreflect.Message) float64 {
+	fields := m.Descriptor().Fields()
+	return (time.Duration(m.Get(fields.ByName("seconds")).Int())*time.Second + time.Duration(m.Get(fields.ByName("nanos")).Int())).Seconds()
+}
+
+// Returns the time of the RFC 3339 string compared to a timestamp field.
+func parseTimestamp(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, status.Errorf(codes.InvalidArgument, "%q is not an RFC 3339 timestamp", s)
+	}
+	return t, nil
+}
+
+// Returns the time of a google.protobuf.Timestamp message, which may be
+// dynamic.
+func timestampValue(m protoreflect.Message) time.Time {
+	fields := m.Descriptor().Fields()
+	return time.Unix(m.Get(fields.ByName("seconds")).Int(), m.Get(fields.ByName("nanos")).Int()).UTC()
+}
+
+// Returns the time of a constant compared to a timestamp field.
+func timestampConstant(c *expr.Constant) (time.Time, error) {
+	if _, ok := c.GetConstantKind().(*expr.Constant_StringValue); !ok {
+		return time.Time{}, status.Errorf(codes.InvalidArgument, "%s is not an RFC 3339 timestamp", formatConst(c))
+	}
+	return parseTimestamp(c.GetStringValue())
+}
@@ -0,0 +1,263 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"go.einride.tech/aip/ordering"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// pageToken is the content of an opaque page token
+// (https://google.aip.dev/158).
+type pageToken struct {
+	// The ID of the document the page starts after, which is the last document
+	// of the previous page.
+	Cursor string `json:"l"`
+	// Whether the page ends before the cursor instead, in which case the cursor
+	// is the first document of the next page.
+	Before bool `json:"b,omitempty"`
+	// The values of the ordered fields of the cursor document.
+	Values []cursorValue `json:"v,omitempty"`
+	// The checksum of the request which produced the token.
+	Checksum uint32 `json:"c"`
+	// Whether the token is a legacy page token, which is only the ID of its
+	// cursor document.
+	legacy bool
+	// The cursor document of a legacy page token, once it is read to recover
+	// its values.
+	doc *firestore.DocumentSnapshot
+}
+
+// cursorValue is the encoding of a value of a cursor document, which
+// preserves its type. Only one field is set, or none if the value is null.
+type cursorValue struct {
+	Bool   *bool    `json:"b,omitempty"`
+	Int    *int64   `json:"i,omitempty"`
+	Double *float64 `json:"d,omitempty"`
+	// NaN and infinite doubles, which JSON cannot represent, as formatted by
+	// strconv.
+	NonFinite *string    `json:"f,omitempty"`
+	String    *string    `json:"s,omitempty"`
+	Bytes     *[]byte    `json:"y,omitempty"`
+	Time      *time.Time `json:"t,omitempty"`
+}
+
+// Returns the values of the ordered fields of the document.
+func cursorValues(doc *firestore.DocumentSnapshot, orders []fieldOrder) ([]cursorValue, error) {
+	var values []cursorValue
+	for _, o := range orders {
+		v, err := doc.DataAtPath(o.path)
+		if err != nil {
+			return nil, err
+		}
+		c, err := newCursorValue(v)
+		if err != nil {
+			return nil, status.Errorf(codes.Unimplemented, "documents cannot be paged by %s, which is a %T", pathString(o.path), v)
+		}
+		values = append(values, c)
+	}
+	return values, nil
+}
+
+// Returns the encoding of a Firestore value.
+// Integer and floating point values of any size are accepted, as they are by
+// Firestore queries.
+func newCursorValue(v interface{}) (cursorValue, error) {
+	var c cursorValue
+	switch v := v.(type) {
+	case nil:
+	case bool:
+		c.Bool = &v
+	case int:
+		i := int64(v)
+		c.Int = &i
+	case int32:
+		i := int64(v)
+		c.Int = &i
+	case int64:
+		c.Int = &v
+	case float32:
+		c.setDouble(float64(v))
+	case float64:
+		c.setDouble(v)
+	case string:
+		c.String = &v
+	case []byte:
+		c.Bytes = &v
+	case time.Time:
+		c.Time = &v
+	default:
+		return c, fmt.Errorf("unsupported cursor value type %T", v)
+	}
+	return c, nil
+}
+
+// Sets the double, or its formatted form if it is NaN or infinite.
+func (c *cursorValue) setDouble(f float64) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		c.NonFinite = &s
+		return
+	}
+	c.Double = &f
+}
+
+func (c cursorValue) value() interface{} {
+	switch {
+	case c.Bool != nil:
+		return *c.Bool
+	case c.Int != nil:
+		return *c.Int
+	case c.Double != nil:
+		return *c.Double
+	case c.NonFinite != nil:
+		// Tokens are signed, so the value was formatted by setDouble.
+		f, _ := strconv.ParseFloat(*c.NonFinite, 64)
+		return f
+	case c.String != nil:
+		return *c.String
+	case c.Bytes != nil:
+		return *c.Bytes
+	case c.Time != nil:
+		return *c.Time
+	}
+	return nil
+}
+
+// Returns the cursor of the token, for a query with the provided orderings
+// followed by the document ID.
+func (t *pageToken) cursor(orders []fieldOrder) ([]interface{}, error) {
+	values := t.Values
+	if t.legacy {
+		if t.doc == nil {
+			return nil, invalidArgument(ErrInvalidPageToken, "legacy page tokens are only accepted by List and Iterate")
+		}
+		var err error
+		if values, err = cursorValues(t.doc, orders); err != nil {
+			return nil, err
+		}
+	}
+	if len(values) != len(orders) {
+		return nil, invalidArgument(ErrInvalidPageToken, "invalid page token")
+	}
+	var cursor []interface{}
+	for _, v := range values {
+		cursor = append(cursor, v.value())
+	}
+	return append(cursor, t.Cursor), nil
+}
+
+// firstPageRequest ignores the page token of a request.
+type firstPageRequest struct {
+	protoexpr.ListRequest
+}
+
+func (firstPageRequest) GetPageToken() string { return "" }
+
+func (r firstPageRequest) GetOrderBy() string {
+	if o, ok := r.ListRequest.(ordering.Request); ok {
+		return o.GetOrderBy()
+	}
+	return ""
+}
+
+// Returns a checksum of the parts of a request which must not change between
+// pages (https://google.aip.dev/158#request-changes).
+// The page size may change between pages, so it is not included.
+func requestChecksum(parent string, filter *expr.CheckedExpr, orderBy ordering.OrderBy) uint32 {
+	h := crc32.NewIEEE()
+	// Equivalent filters produce the same checksum, regardless of formatting.
+	for _, part := range []string{parent, canonical(filter.GetExpr(), false), formatOrderBy(orderBy)} {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	return h.Sum32()
+}
+
+// pageTokens encodes page tokens, which are signed to detect tampering.
+type pageTokens struct {
+	key []byte
+}
+
+// Returns pageTokens signed with a key read from r.
+func randomPageTokens(r io.Reader) (pageTokens, error) {
+	key := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return pageTokens{}, err
+	}
+	return pageTokens{key: key}, nil
+}
+
+func (p pageTokens) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (p pageTokens) encode(t *pageToken) (string, error) {
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(append(payload, p.sign(payload)...)), nil
+}
+
+// Returns the payload of the page token, if it was signed with the same key.
+func (p pageTokens) verify(s string) ([]byte, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) < sha256.Size {
+		return nil, false
+	}
+	payload, mac := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	return payload, hmac.Equal(mac, p.sign(payload))
+}
+
+// Decodes the page token, which is nil if s is empty.
+// Returns INVALID_ARGUMENT if the token was not encoded with the same key, or
+// was produced by a request with a different checksum.
+func (p pageTokens) decode(s string, checksum uint32) (*pageToken, error) {
+	if s == "" {
+		return nil, nil
+	}
+	payload, ok := p.verify(s)
+	if !ok {
+		return nil, invalidArgument(ErrInvalidPageToken, "invalid page token")
+	}
+	t := &pageToken{}
+	if err := json.Unmarshal(payload, t); err != nil {
+		return nil, invalidArgument(ErrInvalidPageToken, "invalid page token")
+	}
+	if t.Checksum != checksum {
+		return nil, invalidArgument(ErrInvalidPageToken, "page token was produced by a request with a different parent, filter or order_by")
+	}
+	return t, nil
+}
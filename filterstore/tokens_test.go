@@ -0,0 +1,129 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"go.einride.tech/aip/ordering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+func TestPageTokens(t *testing.T) {
+	tokens := pageTokens{key: []byte("key")}
+	s, err := tokens.encode(&pageToken{Cursor: "a", Checksum: 1})
+	if err != nil {
+		t.Fatalf("encode() err = %v, want <nil>", err)
+	}
+	got, err := tokens.decode(s, 1)
+	if err != nil {
+		t.Fatalf("decode(%q) err = %v, want <nil>", s, err)
+	}
+	if got.Cursor != "a" {
+		t.Errorf("decode(%q).Cursor = %q, want %q", s, got.Cursor, "a")
+	}
+	for _, tc := range []struct {
+		name, token string
+	}{
+		{"raw document ID", "a"},
+		{"not base64", "!!!"},
+		{"tampered", s[:len(s)-1] + "A"},
+		{"other key", func() string {
+			s, _ := pageTokens{key: []byte("other")}.encode(&pageToken{Cursor: "a", Checksum: 1})
+			return s
+		}()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tokens.decode(tc.token, 1); status.Code(err) != codes.InvalidArgument {
+				t.Errorf("decode(%q) err = %v, want code %v", tc.token, err, codes.InvalidArgument)
+			}
+		})
+	}
+	if _, err := tokens.decode(s, 2); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("decode(%q, 2) err = %v, want code %v", s, err, codes.InvalidArgument)
+	}
+}
+
+func TestPageTokenNonFiniteValues(t *testing.T) {
+	tokens := pageTokens{key: []byte("key")}
+	want := []interface{}{math.NaN(), math.Inf(1), math.Inf(-1), float32(math.Inf(1)), 1.5}
+	token := &pageToken{Cursor: "a", Checksum: 1}
+	for _, v := range want {
+		c, err := newCursorValue(v)
+		if err != nil {
+			t.Fatalf("newCursorValue(%v) err = %v, want <nil>", v, err)
+		}
+		token.Values = append(token.Values, c)
+	}
+	s, err := tokens.encode(token)
+	if err != nil {
+		t.Fatalf("encode() err = %v, want <nil>", err)
+	}
+	decoded, err := tokens.decode(s, 1)
+	if err != nil {
+		t.Fatalf("decode(%q) err = %v, want <nil>", s, err)
+	}
+	var got []interface{}
+	for _, c := range decoded.Values {
+		got = append(got, c.value())
+	}
+	// Doubles of either size are decoded as float64.
+	want[3] = math.Inf(1)
+	if diff := cmp.Diff(want, got, cmpopts.EquateNaNs()); diff != "" {
+		t.Errorf("decoded values diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestRequestChecksum(t *testing.T) {
+	want := requestChecksum("parents/a", parse(t, `test_filtering.filterable_primitive = "a"`), ordering.OrderBy{})
+	for _, tc := range []struct {
+		name    string
+		orderBy ordering.OrderBy
+		parent  string
+		filter  string
+		same    bool
+	}{
+		{"reformatted filter", ordering.OrderBy{}, "parents/a", `test_filtering.filterable_primitive="a"`, true},
+		{"different filter", ordering.OrderBy{}, "parents/a", `test_filtering.filterable_primitive = "b"`, false},
+		{"different parent", ordering.OrderBy{}, "parents/b", `test_filtering.filterable_primitive = "a"`, false},
+		{"ordered", ordering.OrderBy{Fields: []ordering.Field{{Path: "default_float"}}}, "parents/a", `test_filtering.filterable_primitive = "a"`, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := requestChecksum(tc.parent, parse(t, tc.filter), tc.orderBy); (got == want) != tc.same {
+				t.Errorf("requestChecksum() = %d, want same as %d: %t", got, want, tc.same)
+			}
+		})
+	}
+}
+
+func TestListInvalidPageToken(t *testing.T) {
+	transpiler := newTestTranspiler(t, nil, WithPageTokenKey([]byte("key")))
+	if _, err := transpiler.List(context.Background(), &test.ListTestRequest{PageToken: "a"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("List() err = %v, want code %v", err, codes.InvalidArgument)
+	}
+	if err := transpiler.Iterate(context.Background(), &test.ListTestRequest{PageToken: "a"}).err; status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Iterate().err = %v, want code %v", err, codes.InvalidArgument)
+	}
+	if _, err := transpiler.TranspileChan(context.Background(), &test.ListTestRequest{PageToken: "a"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("TranspileChan() err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
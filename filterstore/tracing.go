@@ -0,0 +1,101 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+
+	"go.einride.tech/aip/filtering"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Tracer starts the spans of List calls, so that their latency can be broken
+// down in traces. It can be backed by an OpenTelemetry trace.Tracer, by
+// starting a span with the name, and setting its attributes with
+// attribute.String or attribute.Int64.
+type Tracer interface {
+	// Start starts a span with the name, as a child of any span of ctx, and
+	// returns a context containing it.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute records an attribute of the span, whose value is a string
+	// or an int64.
+	SetAttribute(key string, value interface{})
+	// End ends the span, recording the error if it is not nil.
+	End(err error)
+}
+
+// WithTracer traces List calls with the provided Tracer. Each call is traced
+// by a "filterstore.List" span, with a child span for each stage:
+//   - "filterstore.parse", which parses, checks and plans the request
+//   - "filterstore.transpile", which transpiles the filter into queries
+//   - "filterstore.fetch", which runs the queries against Firestore
+//
+// Spans are annotated with the collection, clause count, page size and result
+// count, as they are known.
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+type tracerKey struct{}
+
+// Returns a context whose spans are started by the tracer, or ctx if the
+// tracer is nil.
+func withTracer(ctx context.Context, t Tracer) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// Starts a span with the Tracer of the context, or a span which records
+// nothing if it has none.
+func startSpan(ctx context.Context, name string) (context.Context, Span) {
+	t, ok := ctx.Value(tracerKey{}).(Tracer)
+	if !ok {
+		return ctx, noopSpan{}
+	}
+	return t.Start(ctx, name)
+}
+
+// A Span which records nothing.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+
+func (noopSpan) End(error) {}
+
+// Returns the number of clauses in the filter, which are the terms joined by
+// AND, OR and NOT.
+func clauseCount(e *expr.Expr) int64 {
+	if e == nil {
+		return 0
+	}
+	switch call := e.GetCallExpr(); call.GetFunction() {
+	case filtering.FunctionAnd, filtering.FunctionOr, filtering.FunctionNot:
+		var n int64
+		for _, arg := range call.GetArgs() {
+			n += clauseCount(arg)
+		}
+		return n
+	}
+	return 1
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+type recordedSpan struct {
+	Name       string
+	Attributes map[string]interface{}
+	Failed     bool
+}
+
+// Records each span once it ends.
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, &recordingSpan{tracer: t, span: &recordedSpan{Name: name, Attributes: map[string]interface{}{}}}
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+	span   *recordedSpan
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) {
+	s.span.Attributes[key] = value
+}
+
+func (s *recordingSpan) End(err error) {
+	s.span.Failed = err != nil
+	s.tracer.spans = append(s.tracer.spans, s.span)
+}
+
+func TestTraceList(t *testing.T) {
+	tracer := &recordingTracer{}
+	transpiler := newTestTranspiler(t, testClient(t), WithTracer(tracer))
+	req := &test.ListTestRequest{Filter: "test_filtering.missing = 1", PageSize: 5}
+	if _, err := transpiler.List(context.Background(), req); err == nil {
+		t.Fatalf("List(%v) err = <nil>, want error", req)
+	}
+	want := []*recordedSpan{
+		{Name: "filterstore.parse", Attributes: map[string]interface{}{}, Failed: true},
+		{Name: "filterstore.List", Attributes: map[string]interface{}{"collection": "tests", "page_size": int64(5)}, Failed: true},
+	}
+	if diff := cmp.Diff(want, tracer.spans); diff != "" {
+		t.Errorf("List(%v) spans diff (-want +got):\n%s", req, diff)
+	}
+}
+
+func TestClauseCount(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		want   int64
+	}{
+		{filter: `test_filtering.size = 1`, want: 1},
+		{filter: `test_filtering.size = 1 AND (test_filtering.priority = 2 OR NOT test_filtering.title = "a")`, want: 3},
+	} {
+		if got := clauseCount(parse(t, tc.filter).GetExpr()); got != tc.want {
+			t.Errorf("clauseCount(%q) = %d, want %d", tc.filter, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,1005 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"go.einride.tech/aip/filtering"
+	"go.einride.tech/aip/ordering"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+
+	opb "github.com/kagadar/go_proto_expression/genproto/options"
+)
+
+// ListPage is a single page of results for a List request.
+type ListPage[T proto.Message] struct {
+	// The documents on this page.
+	Items []T
+	// The token to retrieve the next page, or empty if there are no more pages.
+	NextPageToken string
+	// The token to retrieve the previous page, or empty if this is the first
+	// page, or the filter checks that a field is set, such as `parent:*`. It is
+	// used as the page token of a subsequent request.
+	PreviousPageToken string
+	// The total number of documents matching the filter, if it was calculated.
+	TotalSize *int64
+	// The time at which the documents were read.
+	ReadTime time.Time
+	// Warnings about the transpilation of the filter.
+	Warnings []Warning
+	// The order_by (https://google.aip.dev/132#ordering) equivalent of the
+	// ordering used to retrieve the documents.
+	EffectiveOrderBy []string
+	// The reads of the call, if it was made with the Stats option.
+	Stats *ListStats
+	// The number of Items which could not be fully decoded.
+	decodeErrors int
+}
+
+// client is the protoexpr.Client implementation for Firestore.
+type client[T proto.Message] struct {
+	client *firestore.Client
+	tokens pageTokens
+}
+
+func (c client[T]) Transpile(ctx context.Context, factory func() T, parent, collection, pageToken string, pageSize int32, filter *expr.CheckedExpr) ([]T, string, error) {
+	r := &listRequest{parent: parent, msg: factory().ProtoReflect().Descriptor(), checksum: requestChecksum(parent, filter, ordering.OrderBy{})}
+	var err error
+	if r.token, err = c.tokens.decode(pageToken, r.checksum); err != nil {
+		return nil, "", err
+	}
+	if r.filter, err = resolveRelativeTimes(filter, time.Now()); err != nil {
+		return nil, "", err
+	}
+	r.rest = r.filter
+	page, err := list(ctx, c.client, c.tokens, factory, collection, r, pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+	return page.Items, page.NextPageToken, nil
+}
+
+// listRequest is a List request which has been parsed and validated.
+type listRequest struct {
+	parent string
+	// The message of the collection.
+	msg protoreflect.MessageDescriptor
+	// The names its fields are stored under.
+	names fieldNames
+	// The filter of the request.
+	filter *expr.CheckedExpr
+	// The remainder of the filter once indexed fields are resolved.
+	rest    *expr.CheckedExpr
+	orderBy []fieldOrder
+	// The page token, which is nil for the first page.
+	token *pageToken
+	// The checksum of the request, which page tokens must match.
+	checksum uint32
+	// If not nil, the documents the filter is restricted to.
+	refs []interface{}
+	caps capabilities
+	// Warnings about the resolution of indexed fields.
+	warnings []Warning
+}
+
+// Transpiles the request onto a query of the collection, positioned by the
+// page token, and limited to limit documents unless it is 0.
+func listQuery(client *firestore.Client, collection string, r *listRequest, limit int) (*query, error) {
+	base := client.Collection(fmt.Sprintf("%s/%s", r.parent, collection)).Query
+	if limit > 0 {
+		base = base.Limit(limit)
+	}
+	q, err := newQuery(base, r.msg, r.names, r.rest, r.caps)
+	if err != nil {
+		return nil, err
+	}
+	// The documents before the cursor of a previous page token are retrieved by
+	// running the query in reverse from it, rather than with LimitToLast, which
+	// the Firestore client positions at, rather than before, the cursor.
+	q.reverse = r.token != nil && r.token.Before
+	if r.refs != nil {
+		if err := q.transpileDocuments(r.refs); err != nil {
+			return nil, err
+		}
+	}
+	// Firestore requires the inequality fields to be ordered first, so they
+	// precede the order_by, in its direction if they are part of it.
+	for _, path := range q.inequalities {
+		dir := firestore.Asc
+		for _, o := range r.orderBy {
+			if pathString(o.path) == pathString(path) {
+				dir = o.dir
+			}
+		}
+		q.order(path, dir)
+	}
+	for _, o := range r.orderBy {
+		q.order(o.path, o.dir)
+	}
+	var cursor []interface{}
+	if r.token != nil {
+		var err error
+		if cursor, err = r.token.cursor(q.orders); err != nil {
+			return nil, err
+		}
+	}
+	// A check that a field is set positions the query after null, and cursors
+	// must have a value for every ordering, so the name cannot be ordered by
+	// unless the cursor is replaced by that of the page token.
+	presence := q.afterNull
+	if presence && r.token != nil && r.token.Before {
+		return nil, invalidArgument(ErrInvalidPageToken, "previous page tokens cannot be used with a check that a field is set")
+	}
+	if (len(q.orders) > 0 || r.token != nil) && (!presence || r.token != nil) {
+		// Documents with equal values are ordered by name, in the direction of the
+		// last ordering, so that cursors are unambiguous. Firestore does the same
+		// implicitly, but the ordering is made explicit so that it is reported in
+		// the EffectiveOrderBy.
+		dir := firestore.Asc
+		if len(q.orders) > 0 {
+			dir = q.orders[len(q.orders)-1].dir
+		}
+		q.order(firestore.FieldPath{firestore.DocumentID}, dir)
+	}
+	if r.token != nil {
+		q.startAfter = cursor
+	}
+	return q, nil
+}
+
+// Returns the orders of the cursors of page tokens, which exclude the name of
+// the cursor document.
+func tokenOrders(orders []fieldOrder) []fieldOrder {
+	if n := len(orders); n > 0 && pathString(orders[n-1].path) == pathString(firestore.FieldPath{firestore.DocumentID}) {
+		return orders[:n-1]
+	}
+	return orders
+}
+
+// Retrieves a single page of documents matching the request from the
+// collection.
+func list[T proto.Message](ctx context.Context, client *firestore.Client, tokens pageTokens, factory func() T, collection string, r *listRequest, pageSize int32) (*ListPage[T], error) {
+	_, span := startSpan(ctx, "filterstore.transpile")
+	span.SetAttribute("clauses", clauseCount(r.rest.GetExpr()))
+	// An extra document is retrieved to determine whether there is another page.
+	q, err := listQuery(client, collection, r, int(pageSize)+1)
+	var qs []firestore.Query
+	if err == nil {
+		qs, err = q.build()
+	}
+	if err == nil {
+		span.SetAttribute("queries", int64(len(qs)))
+	}
+	span.End(err)
+	if err != nil {
+		return nil, err
+	}
+	if r.token != nil {
+		recordCursors(ctx, len(qs))
+	}
+	orders := tokenOrders(q.orders)
+	backward := r.token != nil && r.token.Before
+	limit := int(pageSize) + 1
+	if backward {
+		// Merged queries are truncated from the start, rather than the end.
+		limit = 0
+	}
+	docs, err := getAll(ctx, qs, limit)
+	if err != nil {
+		return nil, err
+	}
+	if backward && len(qs) == 1 {
+		// The documents of a reversed query are returned to the requested order.
+		// Merged queries are already ordered by name.
+		for i, j := 0, len(docs)-1; i < j; i, j = i+1, j-1 {
+			docs[i], docs[j] = docs[j], docs[i]
+		}
+	}
+	more := len(docs) > int(pageSize)
+	if more && backward {
+		docs = docs[len(docs)-int(pageSize):]
+	} else if more {
+		docs = docs[:pageSize]
+	}
+	page := &ListPage[T]{
+		Items:            make([]T, len(docs)),
+		EffectiveOrderBy: q.orderBy,
+		Warnings:         append(r.warnings[:len(r.warnings):len(r.warnings)], q.warnings...),
+	}
+	if len(docs) > 0 {
+		// A page reached backwards is always followed by the page it was reached
+		// from, and a page reached forwards is always preceded by one, unless a
+		// check that a field is set prevents going backwards.
+		if more || backward {
+			if page.NextPageToken, err = encodeCursor(tokens, docs[len(docs)-1], orders, false, r.checksum); err != nil {
+				return nil, err
+			}
+		}
+		if (more && backward) || (r.token != nil && !backward && !q.afterNull) {
+			if page.PreviousPageToken, err = encodeCursor(tokens, docs[0], orders, true, r.checksum); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i, doc := range docs {
+		page.Items[i] = factory()
+		if err := doc.DataTo(page.Items[i]); err != nil {
+			page.decodeErrors++
+		}
+		if doc.ReadTime.After(page.ReadTime) {
+			page.ReadTime = doc.ReadTime
+		}
+	}
+	return page, nil
+}
+
+// Returns a page token positioned at the document.
+func encodeCursor(tokens pageTokens, doc *firestore.DocumentSnapshot, orders []fieldOrder, before bool, checksum uint32) (string, error) {
+	values, err := cursorValues(doc, orders)
+	if err != nil {
+		return "", err
+	}
+	return tokens.encode(&pageToken{Cursor: doc.Ref.ID, Before: before, Values: values, Checksum: checksum})
+}
+
+// Retrieves the documents matching any of the queries.
+// If there are multiple queries, their documents are merged by name, and at
+// most limit documents are returned (unless limit is 0).
+func getAll(ctx context.Context, qs []firestore.Query, limit int) ([]*firestore.DocumentSnapshot, error) {
+	ctx, span := startSpan(ctx, "filterstore.fetch")
+	span.SetAttribute("queries", int64(len(qs)))
+	docs, err := fetchAll(ctx, qs, limit)
+	if err == nil {
+		span.SetAttribute("documents", int64(len(docs)))
+	}
+	span.End(err)
+	return docs, err
+}
+
+// Retrieves the documents matching any of the queries, without tracing.
+func fetchAll(ctx context.Context, qs []firestore.Query, limit int) ([]*firestore.DocumentSnapshot, error) {
+	if len(qs) == 1 {
+		docs, err := qs[0].Documents(ctx).GetAll()
+		recordFetch(ctx, 1, len(docs))
+		return docs, err
+	}
+	seen := map[string]bool{}
+	var docs []*firestore.DocumentSnapshot
+	for _, q := range qs {
+		batch, err := q.Documents(ctx).GetAll()
+		recordFetch(ctx, 1, len(batch))
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range batch {
+			if !seen[doc.Ref.Path] {
+				seen[doc.Ref.Path] = true
+				docs = append(docs, doc)
+			}
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Ref.Path < docs[j].Ref.Path })
+	if limit > 0 && len(docs) > limit {
+		docs = docs[:limit]
+	}
+	return docs, nil
+}
+
+// Calls fn with each document matching any of the queries as it is streamed,
+// so that the documents are never all held in memory. If there are multiple
+// queries, the names of the documents are held instead, so that a document
+// matching more than one of them is only passed to fn once.
+func forEach(ctx context.Context, qs []firestore.Query, fn func(*firestore.DocumentSnapshot) error) error {
+	ctx, span := startSpan(ctx, "filterstore.fetch")
+	span.SetAttribute("queries", int64(len(qs)))
+	var seen map[string]bool
+	if len(qs) > 1 {
+		seen = map[string]bool{}
+	}
+	n := 0
+	for _, q := range qs {
+		fetched, err := eachDocument(ctx, q, func(doc *firestore.DocumentSnapshot) error {
+			if seen != nil {
+				if seen[doc.Ref.Path] {
+					return nil
+				}
+				seen[doc.Ref.Path] = true
+			}
+			n++
+			return fn(doc)
+		})
+		recordFetch(ctx, 1, fetched)
+		if err != nil {
+			span.End(err)
+			return err
+		}
+	}
+	span.SetAttribute("documents", int64(n))
+	span.End(nil)
+	return nil
+}
+
+// Calls fn with each document of the query as it is streamed, returning the
+// number of documents retrieved.
+func eachDocument(ctx context.Context, q firestore.Query, fn func(*firestore.DocumentSnapshot) error) (int, error) {
+	it := q.Documents(ctx)
+	defer it.Stop()
+	n := 0
+	for {
+		doc, err := it.Next()
+		if err == iterator.Done {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+		if err := fn(doc); err != nil {
+			return n, err
+		}
+	}
+}
+
+// Transpiler is a Firestore backed protoexpr.Transpiler, which additionally
+// provides Firestore specific queries over the filtered collection.
+//
+// A Transpiler is safe for concurrent use by multiple goroutines. Its
+// configuration cannot be changed once it is created, and the state of each
+// call is kept separate from every other call. It should be closed with Close
+// once it is no longer used.
+type Transpiler[T proto.Message] struct {
+	client          *firestore.Client
+	replica         *firestore.Client
+	collection      string
+	decls           *filtering.Declarations
+	defaultPageSize int32
+	maxPageSize     int32
+	emptyMessage    proto.Message
+	tokens          pageTokens
+	names           fieldNames
+	indexes         []protoreflect.FieldDescriptor
+	defaultOrder    ordering.OrderBy
+	descending      map[string]bool
+	restrictions    fieldRestrictions
+	defaultFilter   string
+	search          []searchField
+	text            TextSearcher
+	caps            capabilities
+	options         options
+	counters        counters
+	lifecycle       lifecycle
+	plans           *planCache
+}
+
+// Creates a new Firestore transpiler for requests to the specified List method.
+func New[T proto.Message](c *firestore.Client, mtd protoreflect.MethodDescriptor, msg T, opts ...Option) (*Transpiler[T], error) {
+	o := options{now: time.Now, rand: rand.Reader}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	tokens := pageTokens{key: o.pageTokenKey}
+	if tokens.key == nil {
+		var err error
+		if tokens, err = randomPageTokens(o.rand); err != nil {
+			return nil, err
+		}
+	}
+	// protoexpr validates that the method is compliant with AIP-132 and AIP-160.
+	if _, err := protoexpr.New[T](client[T]{client: c, tokens: tokens}, mtd, msg); err != nil {
+		return nil, err
+	}
+	var declOpts []filtering.DeclarationOption
+	if o.text != nil {
+		declOpts = append(declOpts, declareTextSearch())
+	}
+	decls, err := NewDeclarations(msg.ProtoReflect().Descriptor(), declOpts...)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := indexedFields(msg.ProtoReflect().Descriptor(), o.indexes)
+	if err != nil {
+		return nil, err
+	}
+	names, err := newFieldNames(msg.ProtoReflect().Descriptor(), o.naming, o.storageNames, o.enumStorage, o.enumFields)
+	if err != nil {
+		return nil, err
+	}
+	names.wrappers = o.wrapperStorage
+	if err := denormalizedFields(msg.ProtoReflect().Descriptor(), o.denormalized); err != nil {
+		return nil, err
+	}
+	names.denormalized = o.denormalized
+	descending := map[string]bool{}
+	for _, field := range o.descending {
+		if _, _, err := namedPath(msg.ProtoReflect().Descriptor(), names, "order_by", field); err != nil {
+			return nil, fmt.Errorf("invalid descending field %q: %w", field, err)
+		}
+		descending[field] = true
+	}
+	var defaultOrder ordering.OrderBy
+	if err := defaultOrder.UnmarshalString(o.defaultOrder); err != nil {
+		return nil, fmt.Errorf("invalid default order %q: %w", o.defaultOrder, err)
+	}
+	defaultOrder = defaultDirections(o.defaultOrder, defaultOrder, descending)
+	if _, err := resolveOrderBy(msg.ProtoReflect().Descriptor(), names, defaultOrder); err != nil {
+		return nil, fmt.Errorf("invalid default order %q: %w", o.defaultOrder, err)
+	}
+	search, err := searchFields(msg.ProtoReflect().Descriptor(), o.searchable)
+	if err != nil {
+		return nil, err
+	}
+	restrictions, err := newFieldRestrictions(msg.ProtoReflect().Descriptor(), o.filterable, o.denied, o.behaviors)
+	if err != nil {
+		return nil, err
+	}
+	t := &Transpiler[T]{
+		client:          c,
+		replica:         o.replica,
+		collection:      collectionName(mtd),
+		decls:           decls,
+		defaultPageSize: 10,
+		maxPageSize:     100,
+		emptyMessage:    proto.Clone(msg),
+		tokens:          tokens,
+		names:           names,
+		indexes:         indexes,
+		defaultOrder:    defaultOrder,
+		descending:      descending,
+		restrictions:    restrictions,
+		defaultFilter:   o.defaultFilter,
+		search:          search,
+		text:            o.text,
+		caps:            o.caps,
+		options:         o,
+		plans:           newPlanCache(o.planCacheSize),
+	}
+	proto.Reset(t.emptyMessage)
+	if _, err := t.checkFilter(t.defaultFilter); err != nil {
+		return nil, fmt.Errorf("invalid default filter %q: %w", t.defaultFilter, err)
+	}
+	if proto.HasExtension(mtd.Options(), opb.E_Pagination) {
+		options := proto.GetExtension(mtd.Options(), opb.E_Pagination).(*opb.MethodPaginationOptions)
+		if options.DefaultPageSize != nil {
+			t.defaultPageSize = options.GetDefaultPageSize()
+		}
+		if options.MaxPageSize != nil {
+			t.maxPageSize = options.GetMaxPageSize()
+		}
+	}
+	return t, nil
+}
+
+// Returns the name of the collection field in the response of the specified
+// List method. The field must already have been validated by protoexpr.New.
+func collectionName(mtd protoreflect.MethodDescriptor) string {
+	var num int32 = 1
+	if proto.HasExtension(mtd.Output().Options(), opb.E_Collection) {
+		options := proto.GetExtension(mtd.Output().Options(), opb.E_Collection).(*opb.MessageCollectionOptions)
+		if options.CollectionFieldNumber != nil {
+			num = options.GetCollectionFieldNumber()
+		}
+	}
+	return string(mtd.Output().Fields().ByNumber(protowire.Number(num)).Name())
+}
+
+// Transpile retrieves a single page of documents matching the request.
+func (t *Transpiler[T]) Transpile(ctx context.Context, req protoexpr.ListRequest) ([]T, string, error) {
+	page, err := t.List(ctx, req)
+	if err != nil {
+		return nil, "", err
+	}
+	return page.Items, page.NextPageToken, nil
+}
+
+// List retrieves a single page of documents matching the request, along with
+// metadata describing how the page was retrieved.
+// If the request implements ordering.Request, or the OrderBy option is
+// provided, documents are ordered by its order_by
+// (https://google.aip.dev/132#ordering). Documents without a value for an
+// ordered field are excluded by Firestore.
+// If the Unbounded option is provided, every matching document is retrieved.
+// Filters using contains() or matches(), and terms split by
+// WithResidualFiltering, are evaluated once documents are retrieved, so their
+// total size can only be reported by Unbounded calls.
+func (t *Transpiler[T]) List(ctx context.Context, req protoexpr.ListRequest, opts ...CallOption) (*ListPage[T], error) {
+	ctx, span := startSpan(withTracer(ctx, t.options.tracer), "filterstore.List")
+	span.SetAttribute("collection", t.collection)
+	span.SetAttribute("page_size", int64(req.GetPageSize()))
+	page, err := t.listPage(ctx, req, opts)
+	if err == nil {
+		span.SetAttribute("documents", int64(len(page.Items)))
+	}
+	span.End(err)
+	return page, err
+}
+
+// Retrieves a single page of documents matching the request, within the span
+// of the List call.
+func (t *Transpiler[T]) listPage(ctx context.Context, req protoexpr.ListRequest, opts []CallOption) (*ListPage[T], error) {
+	o := t.callOptions(opts)
+	var stats *statsCollector
+	if o.stats {
+		ctx, stats = withStats(ctx)
+	}
+	policy := t.resultPolicy(ctx)
+	pageSize, err := t.pageSize(req, o)
+	if err == nil {
+		pageSize, err = policy.limitPageSize(pageSize, o)
+	}
+	if err != nil {
+		return nil, err
+	}
+	start := t.options.now()
+	parseCtx, span := startSpan(ctx, "filterstore.parse")
+	r, err := t.listRequest(parseCtx, req, o)
+	var post *evaluator
+	if err == nil {
+		r.rest, post, err = t.splitPostFilters(r, o)
+	}
+	if err == nil {
+		span.SetAttribute("clauses", clauseCount(r.filter.GetExpr()))
+	}
+	span.End(err)
+	if post != nil && o.totalSize && !o.unbounded {
+		err = invalidArgument(ErrInvalidCallOption, "total size cannot be counted for filters evaluated in memory")
+	}
+	type total struct {
+		size int64
+		err  error
+	}
+	// A count only request retrieves no documents, so needs no page.
+	countOnly := o.countOnly && o.totalSize && !o.unbounded && req.GetPageSize() == 0
+	var totals chan total
+	if err == nil && o.totalSize && !o.unbounded && !countOnly {
+		// The count is abandoned if the page cannot be retrieved.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		totals = make(chan total, 1)
+		go func() {
+			size, err := count(ctx, t.reader(o), t.collection, r)
+			totals <- total{size: size, err: err}
+		}()
+	}
+	var page *ListPage[T]
+	switch {
+	case err != nil:
+	case countOnly:
+		var size int64
+		if size, err = count(ctx, t.reader(o), t.collection, r); err == nil {
+			page = &ListPage[T]{TotalSize: &size, Warnings: r.warnings}
+		}
+	case post != nil:
+		page, err = t.listPostFiltered(ctx, r, post, o, pageSize)
+	case o.unbounded:
+		page, err = t.listAll(ctx, r, o)
+	default:
+		page, err = list(ctx, t.reader(o), t.tokens, t.factory, t.collection, r, pageSize)
+	}
+	switch {
+	case err != nil, countOnly:
+	case totals != nil:
+		total := <-totals
+		page.TotalSize, err = &total.size, total.err
+	case o.totalSize:
+		// Every document was retrieved.
+		size := int64(len(page.Items))
+		page.TotalSize = &size
+	}
+	if err == nil && page.TotalSize != nil {
+		err = policy.checkAggregation(*page.TotalSize)
+	}
+	if err == nil && stats != nil {
+		page.Stats = stats.stats()
+	}
+	t.logList(ctx, r, page, start, err)
+	t.recordQuery(ctx, "List", start, page, err)
+	if err != nil {
+		t.counters.list(0, err)
+		return nil, err
+	}
+	t.counters.list(len(page.Items), nil)
+	if t.options.slowQueries != nil {
+		t.options.slowQueries.record(t.collection, canonical(r.filter.GetExpr(), true), start, t.options.now(), len(page.Items))
+	}
+	if t.options.audit != nil {
+		t.options.audit(ctx, t.auditRecord(req, r, o, start))
+	}
+	return page, nil
+}
+
+// Returns the client which runs the queries of a call.
+func (t *Transpiler[T]) reader(o callOptions) *firestore.Client {
+	if t.replica != nil && !o.primary {
+		return t.replica
+	}
+	return t.client
+}
+
+// Returns the number of documents to retrieve for the request, which is
+// ignored by Unbounded calls.
+func (t *Transpiler[T]) pageSize(req protoexpr.ListRequest, o callOptions) (int32, error) {
+	pageSize := req.GetPageSize()
+	switch {
+	case o.unbounded:
+	case pageSize < 0:
+		return 0, invalidArgument(ErrInvalidPageSize, "page size cannot be negative")
+	case pageSize == 0:
+		pageSize = t.defaultPageSize
+	case pageSize > t.maxPageSize:
+		pageSize = t.maxPageSize
+	}
+	return pageSize, nil
+}
+
+// Retrieves every document matching the request as a single page.
+func (t *Transpiler[T]) listAll(ctx context.Context, r *listRequest, o callOptions) (*ListPage[T], error) {
+	it, err := t.iterate(ctx, r, o)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Stop()
+	page := &ListPage[T]{EffectiveOrderBy: it.orderBy, Warnings: it.warnings}
+	for {
+		msg, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, msg)
+		if it.last.ReadTime.After(page.ReadTime) {
+			page.ReadTime = it.last.ReadTime
+		}
+	}
+	return page, nil
+}
+
+// Iterate streams every document matching the request, starting after the
+// page token of the request. The page size of the request is ignored.
+// Parse errors are returned by the first call to Next.
+func (t *Transpiler[T]) Iterate(ctx context.Context, req protoexpr.ListRequest, opts ...CallOption) *Iterator[T] {
+	it, err := t.newIterator(ctx, req, opts)
+	if err != nil {
+		return &Iterator[T]{err: err}
+	}
+	return it
+}
+
+// Result is a single document streamed by TranspileChan, or the error which
+// ended the stream.
+type Result[T proto.Message] struct {
+	Item T
+	Err  error
+}
+
+// TranspileChan streams every document matching the request to the returned
+// channel as it is retrieved, as Iterate does. The channel is closed once
+// every document has been sent, after a Result with an error, or once the
+// context is done, so the duration of the stream can be bounded with
+// context.WithTimeout.
+// Parse errors are returned immediately.
+func (t *Transpiler[T]) TranspileChan(ctx context.Context, req protoexpr.ListRequest, opts ...CallOption) (<-chan Result[T], error) {
+	ctx, release, err := t.lifecycle.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	it, err := t.newIterator(ctx, req, opts)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return stream(ctx, it, release), nil
+}
+
+// Sends every document of the Iterator to the returned channel, calling done
+// once the stream ends.
+func stream[T proto.Message](ctx context.Context, it *Iterator[T], done func()) <-chan Result[T] {
+	results := make(chan Result[T])
+	go func() {
+		defer done()
+		defer close(results)
+		defer it.Stop()
+		for ctx.Err() == nil {
+			msg, err := it.Next()
+			if err == iterator.Done {
+				return
+			}
+			select {
+			case results <- Result[T]{Item: msg, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return results
+}
+
+// Creates an Iterator over every document matching the request.
+func (t *Transpiler[T]) newIterator(ctx context.Context, req protoexpr.ListRequest, opts []CallOption) (*Iterator[T], error) {
+	o := t.callOptions(opts)
+	if t.resultPolicy(ctx).MaxPageSize > 0 {
+		return nil, status.Error(codes.PermissionDenied, "iterating documents is not permitted")
+	}
+	r, err := t.listRequest(ctx, req, o)
+	if err != nil {
+		return nil, err
+	}
+	return t.iterate(ctx, r, o)
+}
+
+func (t *Transpiler[T]) iterate(ctx context.Context, r *listRequest, o callOptions) (*Iterator[T], error) {
+	if o.batchSize <= 0 {
+		return nil, invalidArgument(ErrInvalidCallOption, "batch size must be positive")
+	}
+	if r.token != nil && r.token.Before {
+		return nil, invalidArgument(ErrInvalidPageToken, "documents cannot be iterated from a previous page token")
+	}
+	if o.prefetch < 0 {
+		return nil, invalidArgument(ErrInvalidCallOption, "prefetch cannot be negative")
+	}
+	q, err := listQuery(t.reader(o), t.collection, r, int(o.batchSize))
+	if err != nil {
+		return nil, err
+	}
+	first, err := q.build()
+	if err != nil {
+		return nil, err
+	}
+	// Subsequent batches are positioned after the last document instead.
+	q.startAfter = nil
+	next, err := q.build()
+	if err != nil {
+		return nil, err
+	}
+	orders := tokenOrders(q.orders)
+	it := &Iterator[T]{
+		ctx:        ctx,
+		factory:    t.factory,
+		batchSize:  int(o.batchSize),
+		first:      first,
+		next:       next,
+		orderBy:    q.orderBy,
+		orders:     orders,
+		lifecycle:  &t.lifecycle,
+		warnings:   append(r.warnings[:len(r.warnings):len(r.warnings)], q.warnings...),
+		prefetch:   o.prefetch,
+		positioned: r.token != nil,
+	}
+	if o.prefetch > 0 {
+		it.ctx, it.stop = context.WithCancel(ctx)
+	}
+	return it, nil
+}
+
+// Parses and validates the request, and resolves the documents matching the
+// filters on indexed fields.
+func (t *Transpiler[T]) listRequest(ctx context.Context, req protoexpr.ListRequest, o callOptions) (*listRequest, error) {
+	r, err := t.parseListRequest(req, o)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.readLegacyToken(ctx, t.reader(o), r); err != nil {
+		return nil, err
+	}
+	if r.rest, r.refs, err = t.resolveIndexed(ctx, t.reader(o), r.parent, r.filter); err != nil {
+		return nil, err
+	}
+	if r.refs != nil {
+		r.warnings = append(r.warnings, Warning{
+			Kind:    WarningIndexLookup,
+			Message: fmt.Sprintf("restricted to %d documents found by the index lookups", len(r.refs)),
+		})
+	}
+	if t.text != nil {
+		var terms []string
+		if r.rest, terms = splitTextSearch(r.rest); len(terms) > 0 {
+			if r.refs, err = t.resolveTextSearch(ctx, t.reader(o), r.parent, terms, r.refs); err != nil {
+				return nil, err
+			}
+			r.warnings = append(r.warnings, Warning{
+				Kind:    WarningTextSearch,
+				Message: fmt.Sprintf("restricted to %d documents found by the text searcher", len(r.refs)),
+			})
+		}
+	}
+	return r, nil
+}
+
+// Parses and validates the request.
+// The order_by of the request is overridden by the OrderBy option, and
+// defaults to the order of WithDefaultOrder.
+func (t *Transpiler[T]) parseListRequest(req protoexpr.ListRequest, o callOptions) (*listRequest, error) {
+	orderBy := o.orderBy
+	if orderBy == nil {
+		parsed, err := parseOrderBy(req, t.descending)
+		if err != nil {
+			return nil, invalidField("order_by", err)
+		}
+		if len(parsed.Fields) == 0 {
+			parsed = t.defaultOrder
+		}
+		orderBy = &parsed
+	}
+	planned, err := t.plan(req, *orderBy, o)
+	if err != nil {
+		return nil, err
+	}
+	r := &listRequest{
+		parent:   req.GetParent(),
+		msg:      t.emptyMessage.ProtoReflect().Descriptor(),
+		names:    t.names,
+		filter:   planned.filter,
+		orderBy:  planned.orderBy,
+		checksum: requestChecksum(req.GetParent(), planned.filter, *orderBy),
+		caps:     t.caps,
+	}
+	if r.token, err = t.decodePageToken(req.GetPageToken(), r.checksum); err != nil {
+		return nil, invalidField("page_token", err)
+	}
+	// Relative times are resolved after the checksum, so that page tokens
+	// remain valid as time passes.
+	if r.filter, err = resolveRelativeTimes(r.filter, t.options.now()); err != nil {
+		return nil, err
+	}
+	r.rest = r.filter
+	return r, nil
+}
+
+// Checks the filter and resolves the order of the request, or returns them
+// from the plan cache.
+func (t *Transpiler[T]) plan(req protoexpr.ListRequest, orderBy ordering.OrderBy, o callOptions) (*plannedRequest, error) {
+	key := planKey{filter: req.GetFilter(), orderBy: formatOrderBy(orderBy)}
+	if o.prepared == nil {
+		if p, ok := t.plans.get(key); ok {
+			return p, t.lifecycle.check()
+		}
+	}
+	p := &plannedRequest{key: key, filter: o.prepared}
+	if o.prepared != nil {
+		if err := t.lifecycle.check(); err != nil {
+			return nil, err
+		}
+	} else {
+		filter, err := t.parse(req)
+		if err != nil {
+			return nil, err
+		}
+		p.filter = filter.CheckedExpr
+	}
+	var err error
+	if p.orderBy, err = resolveOrderBy(t.emptyMessage.ProtoReflect().Descriptor(), t.names, orderBy); err != nil {
+		return nil, invalidField("order_by", err)
+	}
+	if o.prepared == nil {
+		t.plans.add(p)
+	}
+	return p, nil
+}
+
+// Parses the filter of the request, or the default filter if the request has
+// none, and checks it against the collection message. Only the filter of the
+// request is subject to the field restrictions.
+func (t *Transpiler[T]) parse(req filtering.Request) (filtering.Filter, error) {
+	if err := t.lifecycle.check(); err != nil {
+		return filtering.Filter{}, err
+	}
+	if req.GetFilter() == "" {
+		return t.checkFilter(t.defaultFilter)
+	}
+	filter, err := t.checkFilter(req.GetFilter())
+	if err != nil {
+		return filtering.Filter{}, err
+	}
+	if err := t.restrictions.check(filter.CheckedExpr.GetExpr()); err != nil {
+		return filtering.Filter{}, invalidField("filter", err)
+	}
+	return filter, nil
+}
+
+// Parses the filter, and checks it against the collection message.
+func (t *Transpiler[T]) checkFilter(filter string) (filtering.Filter, error) {
+	if filter == "" {
+		return filtering.Filter{}, nil
+	}
+	checked, err := parseFilter(filter, t.decls, t.emptyMessage.ProtoReflect().Descriptor(), t.search, t.text != nil)
+	if err != nil {
+		return filtering.Filter{}, invalidField("filter", err)
+	}
+	if err := checkTypes(t.emptyMessage.ProtoReflect().Descriptor(), checked.GetExpr(), t.options.residual); err != nil {
+		return filtering.Filter{}, invalidField("filter", err)
+	}
+	return filtering.Filter{CheckedExpr: checked}, nil
+}
+
+// Creates a new, empty, message of the collection type.
+func (t *Transpiler[T]) factory() T {
+	return proto.Clone(t.emptyMessage).(T)
+}
+
+// DistinctParents returns the sorted names of every parent with at least one
+// child in the collection that matches the filter of the provided request.
+// Only document names are read, so no child data is transferred.
+func (t *Transpiler[T]) DistinctParents(ctx context.Context, req filtering.Request, opts ...CallOption) ([]string, error) {
+	filter, err := t.parse(req)
+	if err != nil {
+		return nil, err
+	}
+	if filter.CheckedExpr, err = resolveRelativeTimes(filter.CheckedExpr, t.options.now()); err != nil {
+		return nil, err
+	}
+	// Index documents are only resolved within a single parent.
+	_, indexed, err := t.splitIndexed(filter.CheckedExpr)
+	if err != nil {
+		return nil, err
+	}
+	if indexed != nil {
+		return nil, invalidArgument(ErrUnsupportedFilter, "indexed repeated fields cannot be filtered across parents")
+	}
+	q, err := newQuery(t.reader(t.callOptions(opts)).CollectionGroup(t.collection).Select(), t.emptyMessage.ProtoReflect().Descriptor(), t.names, filter.CheckedExpr, t.caps)
+	if err != nil {
+		return nil, err
+	}
+	qs, err := q.build()
+	if err != nil {
+		return nil, err
+	}
+	docs, err := getAll(ctx, qs, 0)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var parents []string
+	for _, doc := range docs {
+		// Children of a root collection have no parent document.
+		if doc.Ref.Parent.Parent == nil {
+			continue
+		}
+		if parent := relativeName(doc.Ref.Parent.Parent.Path); !seen[parent] {
+			seen[parent] = true
+			parents = append(parents, parent)
+		}
+	}
+	sort.Strings(parents)
+	return parents, nil
+}
+
+// Returns the resource name of a Firestore path, relative to the database root.
+func relativeName(path string) string {
+	const root = "/documents/"
+	if i := strings.Index(path, root); i >= 0 {
+		return path[i+len(root):]
+	}
+	return path
+}
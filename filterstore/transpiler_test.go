@@ -0,0 +1,366 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/go-cmp/cmp"
+	"github.com/kagadar/go_proto_expression/protoexpr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	fspb "google.golang.org/genproto/googleapis/firestore/v1"
+
+	"github.com/kagadar/go_proto_expression/protoexpr/test"
+)
+
+var _ protoexpr.Transpiler[*test.TestFiltering] = (*Transpiler[*test.TestFiltering])(nil)
+
+func TestNew(t *testing.T) {
+	transpiler := newTestTranspiler(t, nil)
+	if transpiler.collection != "tests" {
+		t.Errorf("transpiler.collection = %q, want %q", transpiler.collection, "tests")
+	}
+	if transpiler.defaultPageSize != 1000 || transpiler.maxPageSize != 10000 {
+		t.Errorf("transpiler page sizes = %d, %d, want %d, %d", transpiler.defaultPageSize, transpiler.maxPageSize, 1000, 10000)
+	}
+}
+
+func TestRelativeName(t *testing.T) {
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"projects/p/databases/(default)/documents/projects/a", "projects/a"},
+		{"projects/p/databases/(default)/documents/projects/a/things/b", "projects/a/things/b"},
+		{"projects/a", "projects/a"},
+	} {
+		if got := relativeName(tc.path); got != tc.want {
+			t.Errorf("relativeName(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestListQueryPreviousPage(t *testing.T) {
+	filter := parse(t, `test_filtering.filterable_primitive = "a"`)
+	q, err := listQuery(testClient(t), "tests", &listRequest{parent: "parents/p", msg: testDescriptor, filter: filter, rest: filter, token: &pageToken{Cursor: "b", Before: true}}, 10)
+	if err != nil {
+		t.Fatalf("listQuery() err = %v, want <nil>", err)
+	}
+	qs, err := q.build()
+	if err != nil {
+		t.Fatalf("build() err = %v, want <nil>", err)
+	}
+	got := serialize(t, qs)
+	if len(got) != 1 {
+		t.Fatalf("len(queries) = %d, want 1", len(got))
+	}
+	// The query is run in reverse from the cursor, so that the documents before
+	// it are retrieved, but the ordering is reported as requested.
+	want := &fspb.StructuredQuery{
+		From:  []*fspb.StructuredQuery_CollectionSelector{{CollectionId: "tests"}},
+		Where: fieldFilter("FilterablePrimitive", fspb.StructuredQuery_FieldFilter_EQUAL, stringValue("a")),
+		OrderBy: []*fspb.StructuredQuery_Order{{
+			Field:     &fspb.StructuredQuery_FieldReference{FieldPath: firestore.DocumentID},
+			Direction: fspb.StructuredQuery_DESCENDING,
+		}},
+		StartAt: &fspb.Cursor{
+			Values: []*fspb.Value{{ValueType: &fspb.Value_ReferenceValue{ReferenceValue: "projects/test/databases/(default)/documents/parents/p/tests/b"}}},
+		},
+		Limit: wrapperspb.Int32(10),
+	}
+	if diff := cmp.Diff(want, got[0], protocmp.Transform()); diff != "" {
+		t.Errorf("listQuery() query diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{firestore.DocumentID}, q.orderBy); diff != "" {
+		t.Errorf("listQuery() orderBy diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestListPreviousPage(t *testing.T) {
+	transpiler, fake, ids := fakeTranspiler(t, 10)
+	ctx := context.Background()
+	pageIDs := func(page *ListPage[*test.TestFiltering]) []string {
+		var got []string
+		for _, item := range page.Items {
+			got = append(got, item.GetFilterablePrimitive())
+		}
+		return got
+	}
+	req := &test.ListTestRequest{Parent: "parents/p", PageSize: 3}
+	var page *ListPage[*test.TestFiltering]
+	for i := 0; i < 3; i++ {
+		var err error
+		if page, err = transpiler.List(ctx, req); err != nil {
+			t.Fatalf("List(%q) err = %v, want <nil>", req.PageToken, err)
+		}
+		req.PageToken = page.NextPageToken
+	}
+	if diff := cmp.Diff(ids[6:9], pageIDs(page)); diff != "" {
+		t.Fatalf("List() third page diff (-want +got):\n%s", diff)
+	}
+
+	req.PageToken = page.PreviousPageToken
+	previous, err := transpiler.List(ctx, req)
+	if err != nil {
+		t.Fatalf("List(%q) err = %v, want <nil>", req.PageToken, err)
+	}
+	// The query is run in reverse, starting after the first document of the
+	// third page, and the documents are returned in the requested order.
+	qs := fake.queries()
+	want := &fspb.StructuredQuery{
+		From: []*fspb.StructuredQuery_CollectionSelector{{CollectionId: "tests"}},
+		OrderBy: []*fspb.StructuredQuery_Order{{
+			Field:     &fspb.StructuredQuery_FieldReference{FieldPath: firestore.DocumentID},
+			Direction: fspb.StructuredQuery_DESCENDING,
+		}},
+		StartAt: &fspb.Cursor{
+			Values: []*fspb.Value{{ValueType: &fspb.Value_ReferenceValue{ReferenceValue: "projects/test/databases/(default)/documents/parents/p/tests/d06"}}},
+		},
+		Limit: wrapperspb.Int32(4),
+	}
+	if diff := cmp.Diff(want, qs[len(qs)-1], protocmp.Transform()); diff != "" {
+		t.Errorf("List() previous page query diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(ids[3:6], pageIDs(previous)); diff != "" {
+		t.Errorf("List() previous page diff (-want +got):\n%s", diff)
+	}
+	if previous.NextPageToken == "" || previous.PreviousPageToken == "" {
+		t.Fatalf("List() previous page tokens = %q, %q, want both", previous.NextPageToken, previous.PreviousPageToken)
+	}
+
+	// The tokens of the previous page lead back to the third page, and on to the
+	// first page.
+	req.PageToken = previous.NextPageToken
+	next, err := transpiler.List(ctx, req)
+	if err != nil {
+		t.Fatalf("List(%q) err = %v, want <nil>", req.PageToken, err)
+	}
+	if diff := cmp.Diff(ids[6:9], pageIDs(next)); diff != "" {
+		t.Errorf("List() next page diff (-want +got):\n%s", diff)
+	}
+	req.PageToken = previous.PreviousPageToken
+	first, err := transpiler.List(ctx, req)
+	if err != nil {
+		t.Fatalf("List(%q) err = %v, want <nil>", req.PageToken, err)
+	}
+	if diff := cmp.Diff(ids[:3], pageIDs(first)); diff != "" {
+		t.Errorf("List() first page diff (-want +got):\n%s", diff)
+	}
+	if first.PreviousPageToken != "" {
+		t.Errorf("List() first page PreviousPageToken = %q, want empty", first.PreviousPageToken)
+	}
+}
+
+func TestListPresencePages(t *testing.T) {
+	_, client := newFakeFirestore(t)
+	transpiler := newMessageTranspiler(t, client, &descriptorpb.EnumValueDescriptorProto{})
+	ctx := context.Background()
+	// The number of a is unset, so is stored as null.
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		msg := &descriptorpb.EnumValueDescriptorProto{Name: proto.String(id)}
+		if i > 0 {
+			msg.Number = proto.Int32(int32(i))
+		}
+		if err := transpiler.Set(ctx, "parents/p", id, msg); err != nil {
+			t.Fatalf("Set(%s) err = %v, want <nil>", id, err)
+		}
+	}
+	req := &test.ListTestRequest{Parent: "parents/p", PageSize: 2, Filter: "enum_value_descriptor_proto.number:*"}
+	var got [][]string
+	for {
+		page, err := transpiler.List(ctx, req)
+		if err != nil {
+			t.Fatalf("List(%q) err = %v, want <nil>", req.PageToken, err)
+		}
+		var names []string
+		for _, item := range page.Items {
+			names = append(names, item.GetName())
+		}
+		got = append(got, names)
+		// The documents where the field is null precede the check, so the query
+		// cannot go backwards from a cursor to reach the previous page.
+		if page.PreviousPageToken != "" {
+			t.Errorf("List(%q) PreviousPageToken = %q, want empty", req.PageToken, page.PreviousPageToken)
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		req.PageToken = page.NextPageToken
+	}
+	if diff := cmp.Diff([][]string{{"b", "c"}, {"d", "e"}}, got); diff != "" {
+		t.Errorf("List() pages diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestListTotalSize(t *testing.T) {
+	transpiler, _, _ := fakeTranspiler(t, 10)
+	ctx := context.Background()
+	req := &test.ListTestRequest{Parent: "parents/p", PageSize: 2, Filter: `test_filtering.filterable_primitive > "d04"`}
+	var got []string
+	for {
+		page, err := transpiler.List(ctx, req, TotalSize())
+		if err != nil {
+			t.Fatalf("List(%q) err = %v, want <nil>", req.PageToken, err)
+		}
+		// Every matching document is counted, regardless of the page.
+		if page.TotalSize == nil || *page.TotalSize != 5 {
+			t.Errorf("List(%q) TotalSize = %v, want 5", req.PageToken, page.TotalSize)
+		}
+		for _, item := range page.Items {
+			got = append(got, item.GetFilterablePrimitive())
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		req.PageToken = page.NextPageToken
+	}
+	if diff := cmp.Diff([]string{"d05", "d06", "d07", "d08", "d09"}, got); diff != "" {
+		t.Errorf("List() items diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestListCountOnly(t *testing.T) {
+	transpiler, fake, _ := fakeTranspiler(t, 10)
+	req := &test.ListTestRequest{Parent: "parents/p", Filter: `test_filtering.filterable_primitive > "d04"`}
+	page, err := transpiler.List(context.Background(), req, TotalSize(), CountOnly())
+	if err != nil {
+		t.Fatalf("List() err = %v, want <nil>", err)
+	}
+	if len(page.Items) != 0 || page.NextPageToken != "" {
+		t.Errorf("List() = %d items with next page token %q, want none", len(page.Items), page.NextPageToken)
+	}
+	if page.TotalSize == nil || *page.TotalSize != 5 {
+		t.Errorf("List() TotalSize = %v, want 5", page.TotalSize)
+	}
+	// Only the names of the documents are read to count them.
+	for _, q := range fake.queries() {
+		want := &fspb.StructuredQuery_Projection{Fields: []*fspb.StructuredQuery_FieldReference{{FieldPath: firestore.DocumentID}}}
+		if diff := cmp.Diff(want, q.GetSelect(), protocmp.Transform()); diff != "" {
+			t.Errorf("List() projection diff (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestIteratePreviousPageToken(t *testing.T) {
+	if _, err := (&Transpiler[*test.TestFiltering]{}).iterate(context.Background(), &listRequest{parent: "parents/p", token: &pageToken{Cursor: "b", Before: true}}, callOptions{batchSize: 10}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("iterate() err = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestStream(t *testing.T) {
+	want := errors.New("failed")
+	var got []Result[*test.TestFiltering]
+	for result := range stream(context.Background(), &Iterator[*test.TestFiltering]{err: want}, func() {}) {
+		got = append(got, result)
+	}
+	if len(got) != 1 || got[0].Err != want {
+		t.Errorf("stream() = %v, want a single Result with err %v", got, want)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := <-stream(ctx, &Iterator[*test.TestFiltering]{err: want}, func() {}); ok {
+		t.Errorf("stream() with a done context sent a Result, want closed channel")
+	}
+}
+
+func TestDeterministicOptions(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		transpiler := newTestTranspiler(t, testClient(t),
+			WithClock(func() time.Time { return now }),
+			WithRandomness(bytes.NewReader(make([]byte, 32))),
+		)
+		if got := transpiler.options.now(); !got.Equal(now) {
+			t.Errorf("options.now() = %v, want %v", got, now)
+		}
+		token, err := transpiler.tokens.encode(&pageToken{Cursor: "a"})
+		if err != nil {
+			t.Fatalf("encode() err = %v, want <nil>", err)
+		}
+		tokens = append(tokens, token)
+	}
+	if tokens[0] != tokens[1] {
+		t.Errorf("encode() with the same randomness = %q and %q, want equal", tokens[0], tokens[1])
+	}
+	if _, err := New(testClient(t), listTestMethod, &test.TestFiltering{}, WithRandomness(bytes.NewReader(nil))); err == nil {
+		t.Errorf("New(WithRandomness(empty)) err = <nil>, want error")
+	}
+}
+
+func TestEdition(t *testing.T) {
+	for _, tc := range []struct {
+		edition Edition
+		want    capabilities
+	}{
+		{StandardEdition, capabilities{}},
+		{EnterpriseEdition, capabilities{multipleInequalities: true}},
+	} {
+		transpiler, err := New(testClient(t), listTestMethod, &test.TestFiltering{}, WithEdition(tc.edition))
+		if err != nil {
+			t.Fatalf("New(WithEdition(%d)) err = %v, want <nil>", tc.edition, err)
+		}
+		if transpiler.caps != tc.want {
+			t.Errorf("New(WithEdition(%d)) capabilities = %+v, want %+v", tc.edition, transpiler.caps, tc.want)
+		}
+	}
+}
+
+func TestReadReplica(t *testing.T) {
+	primary, replica := testClient(t), testClient(t)
+	transpiler := newTestTranspiler(t, primary, WithReadReplica(replica))
+	if got := transpiler.reader(transpiler.callOptions(nil)); got != replica {
+		t.Errorf("reader() = %p, want replica %p", got, replica)
+	}
+	if got := transpiler.reader(transpiler.callOptions([]CallOption{ReadPrimary()})); got != primary {
+		t.Errorf("reader(ReadPrimary()) = %p, want primary %p", got, primary)
+	}
+	transpiler = newTestTranspiler(t, primary)
+	if got := transpiler.reader(transpiler.callOptions(nil)); got != primary {
+		t.Errorf("reader() without replica = %p, want primary %p", got, primary)
+	}
+}
+
+func TestDefaultFilter(t *testing.T) {
+	transpiler := newTestTranspiler(t, testClient(t), WithDefaultFilter(`test_filtering.filterable_primitive != "deleted"`))
+	for _, tc := range []struct {
+		filter string
+		want   string
+	}{
+		{filter: "", want: `test_filtering.filterable_primitive != "deleted"`},
+		{filter: `test_filtering.filterable_primitive = "a"`, want: `test_filtering.filterable_primitive = "a"`},
+	} {
+		plan, err := transpiler.Plan(&test.ListTestRequest{Parent: "parents/p", Filter: tc.filter})
+		if err != nil {
+			t.Fatalf("Plan(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if plan.Filter != tc.want {
+			t.Errorf("Plan(%q).Filter = %s, want %s", tc.filter, plan.Filter, tc.want)
+		}
+	}
+	if _, err := New(testClient(t), listTestMethod, &test.TestFiltering{}, WithDefaultFilter("test_filtering.missing = 1")); err == nil {
+		t.Errorf("New(WithDefaultFilter(invalid)) err = <nil>, want error")
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// WarningKind identifies the strategy which caused a Warning.
+type WarningKind string
+
+const (
+	// The filter was split across multiple Firestore queries, whose documents
+	// were merged in memory.
+	WarningChunked WarningKind = "CHUNKED"
+	// Filters on an indexed repeated field were resolved against its index
+	// documents, before the collection was queried.
+	WarningIndexLookup WarningKind = "INDEX_LOOKUP"
+	// An OR of filters on different fields was split across multiple Firestore
+	// queries, whose documents were merged in memory.
+	WarningDisjunction WarningKind = "DISJUNCTION"
+	// A saved filter is planned differently than when it was saved.
+	WarningPlanChanged WarningKind = "PLAN_CHANGED"
+	// Bare terms of the filter were resolved by a TextSearcher, before the
+	// collection was queried.
+	WarningTextSearch WarningKind = "TEXT_SEARCH"
+	// Terms of the filter which Firestore cannot evaluate were evaluated
+	// against each document once it was retrieved, so more documents were
+	// retrieved than returned.
+	WarningClientFilter WarningKind = "CLIENT_FILTER"
+	// The filter contradicts itself, so no Firestore queries were run.
+	WarningContradiction WarningKind = "CONTRADICTION"
+)
+
+// Warning describes a lossy or approximate strategy used to transpile a
+// filter, which may affect the cost or consistency of the results.
+type Warning struct {
+	Kind WarningKind
+	// The field the warning applies to.
+	Field   string
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Field == "" {
+		return fmt.Sprintf("%s: %s", w.Kind, w.Message)
+	}
+	return fmt.Sprintf("%s(%s): %s", w.Kind, w.Field, w.Message)
+}
+
+// WarningMetadataKey is the metadata key used by WarningMetadata.
+const WarningMetadataKey = "x-filter-warning"
+
+// WarningMetadata returns the warnings as gRPC metadata, which can be sent to
+// clients with grpc.SetHeader.
+func WarningMetadata(warnings []Warning) metadata.MD {
+	md := metadata.MD{}
+	for _, w := range warnings {
+		md.Append(WarningMetadataKey, w.String())
+	}
+	return md
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestChunkedWarning(t *testing.T) {
+	var terms []string
+	for i := 0; i < maxDisjunctions+1; i++ {
+		terms = append(terms, fmt.Sprintf("test_filtering.tags:%q", fmt.Sprintf("v%d", i)))
+	}
+	q, err := newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{}, parse(t, strings.Join(terms, " OR ")), capabilities{})
+	if err != nil {
+		t.Fatalf("newQuery() err = %v, want <nil>", err)
+	}
+	want := []Warning{{Kind: WarningChunked, Field: "Tags", Message: "compared to 31 values, which requires 2 queries"}}
+	if diff := cmp.Diff(want, q.warnings); diff != "" {
+		t.Errorf("newQuery() warnings diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWarningMetadata(t *testing.T) {
+	md := WarningMetadata([]Warning{
+		{Kind: WarningChunked, Field: "Tags", Message: "a"},
+		{Kind: WarningIndexLookup, Message: "b"},
+	})
+	want := []string{"CHUNKED(Tags): a", "INDEX_LOOKUP: b"}
+	if diff := cmp.Diff(want, md.Get(WarningMetadataKey)); diff != "" {
+		t.Errorf("WarningMetadata() diff (-want +got):\n%s", diff)
+	}
+}
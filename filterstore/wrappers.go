@@ -0,0 +1,108 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"go.einride.tech/aip/filtering"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	wpb "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// WrapperStorage is how the values of well-known wrapper fields, such as
+// google.protobuf.Int64Value, are stored.
+type WrapperStorage int
+
+const (
+	// WrappersNested stores wrappers as messages with a single value field,
+	// such as {Value: 1}, which is how the Firestore client stores messages. It
+	// is the default.
+	WrappersNested WrapperStorage = iota
+	// WrappersFlattened stores wrappers as the value they wrap, such as 1,
+	// which is how protojson stores messages.
+	WrappersFlattened
+)
+
+// WithWrapperStorage stores the values of wrapper fields with the provided
+// WrapperStorage. Either way, wrapper fields are compared by the value they
+// wrap, such as `count > 1`, and are set if they hold any value, including
+// their zero value.
+func WithWrapperStorage(storage WrapperStorage) Option {
+	return func(o *options) {
+		o.wrapperStorage = storage
+	}
+}
+
+// The names of the well-known wrapper messages.
+var wrapperNames = func() map[protoreflect.FullName]bool {
+	names := map[protoreflect.FullName]bool{}
+	for _, m := range []protoreflect.ProtoMessage{
+		&wpb.DoubleValue{}, &wpb.FloatValue{}, &wpb.Int64Value{}, &wpb.UInt64Value{},
+		&wpb.Int32Value{}, &wpb.UInt32Value{}, &wpb.BoolValue{}, &wpb.StringValue{}, &wpb.BytesValue{},
+	} {
+		names[m.ProtoReflect().Descriptor().FullName()] = true
+	}
+	return names
+}()
+
+// The name of the field which holds the value of a wrapper.
+const wrapperValueName protoreflect.Name = "value"
+
+// Reports whether the field is a singular well-known wrapper, such as
+// google.protobuf.Int64Value.
+func wrapperField(fd protoreflect.FieldDescriptor) bool {
+	return fd != nil && !fd.IsList() && !fd.IsMap() && fd.Message() != nil && wrapperNames[fd.Message().FullName()]
+}
+
+// Reports whether the field is the value of a well-known wrapper.
+func wrapperValue(fd protoreflect.FieldDescriptor) bool {
+	return fd != nil && wrapperNames[fd.ContainingMessage().FullName()]
+}
+
+// Returns the path and descriptor of the value of a wrapper field, as it is
+// stored, or the path and descriptor of any other field unchanged.
+func (n fieldNames) unwrap(path firestore.FieldPath, fd protoreflect.FieldDescriptor) (firestore.FieldPath, protoreflect.FieldDescriptor) {
+	if !wrapperField(fd) {
+		return path, fd
+	}
+	value := fd.Message().Fields().ByName(wrapperValueName)
+	if n.wrappers == WrappersFlattened {
+		return path, value
+	}
+	return append(path[:len(path):len(path)], n.name(value)), value
+}
+
+// Returns the declarations of comparisons between the wrapper field and the
+// type it wraps, such as `count > 1`.
+func wrapperComparisons(fd protoreflect.FieldDescriptor) []filtering.DeclarationOption {
+	value := fd.Message().Fields().ByName(wrapperValueName)
+	t := elementType(value)
+	wrapper := &expr.Type{TypeKind: &expr.Type_MessageType{MessageType: string(fd.Message().FullName())}}
+	var decls []filtering.DeclarationOption
+	for _, fn := range []string{
+		filtering.FunctionEquals, filtering.FunctionNotEquals,
+		filtering.FunctionLessThan, filtering.FunctionLessEquals,
+		filtering.FunctionGreaterThan, filtering.FunctionGreaterEquals,
+	} {
+		decls = append(decls, filtering.DeclareFunction(fn, filtering.NewFunctionOverload(
+			fmt.Sprintf("%s_%s_%s", fn, fd.Message().FullName(), typeName(t)), filtering.TypeBool, wrapper, t,
+		)))
+	}
+	return decls
+}
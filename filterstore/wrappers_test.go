@@ -0,0 +1,126 @@
+// Copyright 2022 The Go Firestore Filtering Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterstore
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	fspb "google.golang.org/genproto/googleapis/firestore/v1"
+	wpb "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestTranspileWrappers(t *testing.T) {
+	int64Value := func(i int64) *fspb.Value { return &fspb.Value{ValueType: &fspb.Value_IntegerValue{IntegerValue: i}} }
+	for _, tc := range []struct {
+		filter  string
+		storage WrapperStorage
+		want    *fspb.StructuredQuery_Filter
+	}{
+		{
+			filter: `test_filtering.count > 1`,
+			want:   fieldFilter("Count.Value", fspb.StructuredQuery_FieldFilter_GREATER_THAN, int64Value(1)),
+		},
+		{
+			filter:  `test_filtering.count > 1`,
+			storage: WrappersFlattened,
+			want:    fieldFilter("Count", fspb.StructuredQuery_FieldFilter_GREATER_THAN, int64Value(1)),
+		},
+		{
+			filter: `test_filtering.count.value = 2`,
+			want:   fieldFilter("Count.Value", fspb.StructuredQuery_FieldFilter_EQUAL, int64Value(2)),
+		},
+		{
+			filter:  `test_filtering.count.value = 2`,
+			storage: WrappersFlattened,
+			want:    fieldFilter("Count", fspb.StructuredQuery_FieldFilter_EQUAL, int64Value(2)),
+		},
+		{
+			filter: `NOT test_filtering.count:*`,
+			want:   unaryFilter("Count", fspb.StructuredQuery_UnaryFilter_IS_NULL),
+		},
+		{
+			filter:  `NOT test_filtering.count:*`,
+			storage: WrappersFlattened,
+			want:    unaryFilter("Count", fspb.StructuredQuery_UnaryFilter_IS_NULL),
+		},
+	} {
+		q, err := newQuery(testClient(t).Collection("tests").Query, testDescriptor, fieldNames{wrappers: tc.storage}, parse(t, tc.filter), capabilities{})
+		if err != nil {
+			t.Fatalf("newQuery(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		qs, err := q.build()
+		if err != nil {
+			t.Fatalf("build(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if diff := cmp.Diff([]*fspb.StructuredQuery_Filter{tc.want}, wheres(serialize(t, qs)), protocmp.Transform()); diff != "" {
+			t.Errorf("transpile(%q) with storage %v where diff (-want +got):\n%s", tc.filter, tc.storage, diff)
+		}
+	}
+}
+
+func TestEvaluateWrappers(t *testing.T) {
+	count := testDescriptor.Fields().ByName("count")
+	unset := dynamicpb.NewMessage(testDescriptor)
+	zero := dynamicpb.NewMessage(testDescriptor)
+	zero.Set(count, protoreflect.ValueOfMessage(wpb.Int64(0).ProtoReflect()))
+	three := dynamicpb.NewMessage(testDescriptor)
+	three.Set(count, protoreflect.ValueOfMessage(wpb.Int64(3).ProtoReflect()))
+	for _, tc := range []struct {
+		filter string
+		msg    *dynamicpb.Message
+		want   bool
+	}{
+		{`test_filtering.count > 1`, three, true},
+		{`test_filtering.count > 1`, zero, false},
+		{`test_filtering.count = 0`, unset, false},
+		{`test_filtering.count = 0`, zero, true},
+		{`test_filtering.count:*`, zero, true},
+		{`test_filtering.count:*`, unset, false},
+	} {
+		ev, err := newEvaluator(parse(t, tc.filter))
+		if err != nil {
+			t.Fatalf("newEvaluator(%q) err = %v, want <nil>", tc.filter, err)
+		}
+		if got := ev.matches(tc.msg); got != tc.want {
+			t.Errorf("matches(%q, %v) = %t, want %t", tc.filter, tc.msg, got, tc.want)
+		}
+	}
+}
+
+func TestNamedPathWrappers(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		storage WrapperStorage
+		want    string
+	}{
+		{"count", WrappersNested, "Count.Value"},
+		{"count.value", WrappersNested, "Count.Value"},
+		{"count", WrappersFlattened, "Count"},
+		{"count.value", WrappersFlattened, "Count"},
+	} {
+		path, _, err := namedPath(testDescriptor, fieldNames{wrappers: tc.storage}, "order_by", tc.name)
+		if err != nil {
+			t.Fatalf("namedPath(%q) err = %v, want <nil>", tc.name, err)
+		}
+		if got := pathString(path); got != tc.want {
+			t.Errorf("namedPath(%q) with storage %v = %s, want %s", tc.name, tc.storage, got, tc.want)
+		}
+	}
+}
@@ -4,9 +4,12 @@ go 1.18
 
 require (
 	cloud.google.com/go/firestore v1.6.1
+	github.com/google/go-cmp v0.5.6
 	github.com/iancoleman/strcase v0.2.0
 	github.com/kagadar/go_proto_expression v0.0.0-20220517040121-f84996e05ab2
+	github.com/kagadar/go_proto_expression/genproto v0.0.0-20220517034032-ec941c062282
 	go.einride.tech/aip v0.54.1
+	google.golang.org/api v0.59.0
 	google.golang.org/genproto v0.0.0-20220426171045-31bebdecfb46
 	google.golang.org/grpc v1.46.0
 	google.golang.org/protobuf v1.28.0
@@ -16,15 +19,12 @@ require (
 	cloud.google.com/go v0.97.0 // indirect
 	github.com/golang/groupcache v0.0.0-20200121045136-8c9f03a8e57e // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/google/go-cmp v0.5.6 // indirect
 	github.com/googleapis/gax-go/v2 v2.1.1 // indirect
-	github.com/kagadar/go_proto_expression/genproto v0.0.0-20220517034032-ec941c062282 // indirect
 	go.opencensus.io v0.23.0 // indirect
 	golang.org/x/net v0.0.0-20210503060351-7fd8e65b6420 // indirect
 	golang.org/x/oauth2 v0.0.0-20211005180243-6b3c2da341f1 // indirect
 	golang.org/x/sys v0.0.0-20211007075335-d3039528d8ac // indirect
 	golang.org/x/text v0.3.6 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
-	google.golang.org/api v0.59.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 )